@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// accessLog writes a line for every request in one of the supported formats
+type accessLog struct {
+	format string
+	mu     sync.Mutex
+	w      io.Writer
+}
+
+// accessLogEntry is an access log line in the json format
+type accessLogEntry struct {
+	Time        time.Time `json:"time"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Method      string    `json:"method"`
+	Host        string    `json:"host"`
+	Path        string    `json:"path"`
+	Query       string    `json:"query,omitempty"`
+	Route       string    `json:"route,omitempty"`
+	Status      int       `json:"status"`
+	Destination string    `json:"destination,omitempty"`
+	DurationMS  float64   `json:"duration_ms"`
+	Referer     string    `json:"referer,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"`
+
+	// for the combined format
+	proto string
+	uri   string
+	bytes int
+}
+
+// newAccessLog creates an access log in format ("json" or "combined") that writes to output: "stdout", "stderr", or a
+// file that's appended to
+func newAccessLog(format, output string) (*accessLog, error) {
+	if format != "json" && format != "combined" {
+		return nil, fmt.Errorf("unknown log format %q, must be json or combined", format)
+	}
+	l := &accessLog{format: format}
+	switch output {
+	case "", "stdout":
+		l.w = os.Stdout
+	case "stderr":
+		l.w = os.Stderr
+	default:
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		l.w = f
+	}
+	return l, nil
+}
+
+// handler logs every request that h handles
+func (l *accessLog) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		req, info := redirector.WithRequestInfo(req)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, req)
+
+		entry := accessLogEntry{
+			Time:        start,
+			RemoteAddr:  req.RemoteAddr,
+			Method:      req.Method,
+			Host:        req.Host,
+			Path:        req.URL.Path,
+			Query:       req.URL.RawQuery,
+			Status:      rec.status,
+			Destination: rec.Header().Get("Location"),
+			DurationMS:  float64(time.Since(start).Microseconds()) / 1000,
+			Referer:     req.Referer(),
+			UserAgent:   req.UserAgent(),
+			RequestID:   req.Header.Get(requestIDHeader),
+			proto:       req.Proto,
+			uri:         req.RequestURI,
+			bytes:       rec.bytes,
+		}
+		if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			entry.RemoteAddr = host
+		}
+		if info.Route != nil {
+			entry.Route = info.Route.Pattern
+		}
+		l.write(entry)
+	})
+}
+
+func (l *accessLog) write(e accessLogEntry) {
+	var line []byte
+	if l.format == "json" {
+		line, _ = json.Marshal(e)
+	} else {
+		size := "-"
+		if e.bytes > 0 {
+			size = strconv.Itoa(e.bytes)
+		}
+		line = []byte(fmt.Sprintf("%s - - [%s] %q %d %s %q %q",
+			e.RemoteAddr, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method+" "+e.uri+" "+e.proto, e.Status, size,
+			orDash(e.Referer), orDash(e.UserAgent)))
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+// statusRecorder records the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush implements http.Flusher so that proxied responses can still be streamed
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker so that websockets and other upgraded connections can still be proxied. The
+// upgrade response is written to the connection directly, so it's recorded as 101.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection can't be hijacked")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
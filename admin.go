@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// overlayStore is a redirector.RouteStore of the routes from other stores with changes made at runtime on top of them.
+// Put adds a route or replaces the one with the same pattern, and Delete hides a route even if it comes from one of
+// the other stores. The changes are kept in memory and survive reloads of the other stores. Routes are keyed by their
+// pattern and conditions, and Delete hides every route with a pattern. The changes can also be persisted to a
+// database with persistTo, so that they survive restarts.
+type overlayStore struct {
+	redirector.Broadcaster
+	base []redirector.RouteSource
+	// putting serializes Put, so that each route is checked against the routes that it's added to
+	putting sync.Mutex
+
+	mu      sync.Mutex
+	puts    map[string]*redirector.Route
+	deletes map[string]bool
+	db      *bolt.DB
+}
+
+var _ redirector.RouteStore = new(overlayStore)
+
+func newOverlayStore(base ...redirector.RouteSource) *overlayStore {
+	return &overlayStore{
+		base:    base,
+		puts:    make(map[string]*redirector.Route),
+		deletes: make(map[string]bool),
+	}
+}
+
+// List implements redirector.RouteSource.List
+func (s *overlayStore) List(ctx context.Context) ([]*redirector.Route, error) {
+	return s.list(ctx, nil)
+}
+
+// list returns the routes like List does, as if put had been put too if it isn't nil
+func (s *overlayStore) list(ctx context.Context, put *redirector.Route) ([]*redirector.Route, error) {
+	var routes []*redirector.Route
+	for _, store := range s.base {
+		rs, err := store.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, rs...)
+	}
+
+	s.mu.Lock()
+	puts, deletes := s.puts, s.deletes
+	if put != nil {
+		puts = make(map[string]*redirector.Route, len(s.puts)+1)
+		for k, r := range s.puts {
+			puts[k] = r
+		}
+		puts[routeKey(put)] = put
+		deletes = make(map[string]bool, len(s.deletes))
+		for pattern := range s.deletes {
+			deletes[pattern] = pattern != put.Pattern
+		}
+	}
+	s.mu.Unlock()
+	var (
+		out  []*redirector.Route
+		seen = make(map[string]bool)
+	)
+	for _, r := range routes {
+		if deletes[r.Pattern] {
+			continue
+		}
+		key := routeKey(r)
+		if put, ok := puts[key]; ok {
+			if !seen[key] {
+				out = append(out, put)
+			}
+		} else {
+			out = append(out, r)
+		}
+		seen[key] = true
+	}
+	var added []*redirector.Route
+	for key, r := range puts {
+		if !seen[key] {
+			added = append(added, r)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return routeKey(added[i]) < routeKey(added[j]) })
+	return append(out, added...), nil
+}
+
+// Watch implements redirector.RouteSource.Watch, notifying of changes to any of the other stores too
+func (s *overlayStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	for _, store := range s.base {
+		ch, err := store.Watch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			for range ch {
+				s.Notify()
+			}
+		}()
+	}
+	return s.Broadcaster.Watch(ctx)
+}
+
+// invalidRouteError is returned by Put for routes that can't be used along with the others, such as ones that would
+// make clients redirect in a loop
+type invalidRouteError struct{ err error }
+
+func (e invalidRouteError) Error() string { return e.err.Error() }
+
+// check returns an invalidRouteError if route can't be used along with the other routes, the same way that the
+// routes are checked when they're swapped in
+func (s *overlayStore) check(ctx context.Context, route *redirector.Route) error {
+	routes, err := s.list(ctx, route)
+	if err != nil {
+		return err
+	}
+	if err := redirector.New(nil).SetRoutes(routes); err != nil {
+		return invalidRouteError{err}
+	}
+	return nil
+}
+
+// Put implements redirector.RouteStore.Put. The route is checked along with the other routes before it's persisted.
+func (s *overlayStore) Put(ctx context.Context, route *redirector.Route) error {
+	if err := route.Validate(); err != nil {
+		return err
+	}
+	s.putting.Lock()
+	defer s.putting.Unlock()
+	if err := s.check(ctx, route); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if err := s.persistPut(route); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.puts[routeKey(route)] = route
+	delete(s.deletes, route.Pattern)
+	s.mu.Unlock()
+	s.Notify()
+	return nil
+}
+
+// Delete implements redirector.RouteStore.Delete
+func (s *overlayStore) Delete(ctx context.Context, pattern string) error {
+	routes, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, r := range routes {
+		if r.Pattern == pattern {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return redirector.ErrNotFound
+	}
+
+	s.mu.Lock()
+	if err := s.persistDelete(pattern); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	for key, r := range s.puts {
+		if r.Pattern == pattern {
+			delete(s.puts, key)
+		}
+	}
+	s.deletes[pattern] = true
+	s.mu.Unlock()
+	s.Notify()
+	return nil
+}
+
+// unredact gives route the Auth of the route in store that it replaces, if its Auth is redacted, such as when a route
+// listed by the admin api is edited
+func unredact(ctx context.Context, store redirector.RouteStore, route *redirector.Route) error {
+	if route.Auth == "" || route.Redacted().Auth != route.Auth {
+		return nil
+	}
+	routes, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range routes {
+		if routeKey(r) == routeKey(route) && r.Redacted().Auth == route.Auth {
+			route.Auth = r.Auth
+			return nil
+		}
+	}
+	return errors.New("the route's auth is redacted, and there's no route with the same pattern and conditions to keep it from")
+}
+
+// routeKey identifies a route by its pattern and conditions
+func routeKey(r *redirector.Route) string {
+	return r.Pattern + " " + r.Conditions()
+}
+
+// adminAuth is how admin api requests authenticate, with a bearer token or basic auth. Requests only need to pass one
+// of the configured methods, and if none are configured, none are required.
+type adminAuth struct {
+	token string
+	// basic is the user:password pair for basic auth
+	basic string
+}
+
+func (a adminAuth) enabled() bool {
+	return a.token != "" || a.basic != ""
+}
+
+// check reports whether req authenticates with any of the configured methods. The credentials are compared in
+// constant time.
+func (a adminAuth) check(req *http.Request) bool {
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+a.token)) == 1 {
+		return true
+	}
+	if user, password, ok := req.BasicAuth(); ok && a.basic != "" {
+		return subtle.ConstantTimeCompare([]byte(user+":"+password), []byte(a.basic)) == 1
+	}
+	return false
+}
+
+// adminHosts returns the hosts that requests to an admin api listening on addr may be for, so that pages that a
+// browser visits can't reach it by pointing a hostname of theirs at 127.0.0.1. It's nil, for any host, if auth is
+// required or addr is a unix socket, which browsers can't connect to.
+func adminHosts(addr string, auth adminAuth) []string {
+	if auth.enabled() || strings.HasPrefix(addr, "unix:") {
+		return nil
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// sameOrigin refuses the requests to h that a browser could have sent on behalf of another site: requests for a host
+// that isn't one of hosts, unless hosts is nil, changes from a page on another origin, and POSTs and PUTs without a json
+// Content-Type, which browsers can't send across origins without asking the api first.
+func sameOrigin(h http.Handler, hosts []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if hosts != nil && !allowsHost(hosts, req.Host) {
+			adminError(w, http.StatusForbidden, fmt.Errorf("requests for %q aren't allowed", req.Host))
+			return
+		}
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if origin := req.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, req.Host) {
+				adminError(w, http.StatusForbidden, fmt.Errorf("requests from %q aren't allowed", origin))
+				return
+			}
+		}
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			if t, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || t != "application/json" {
+				adminError(w, http.StatusUnsupportedMediaType, errors.New("the Content-Type must be application/json"))
+				return
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// allowsHost reports whether host, which may have a port, is one of hosts
+func allowsHost(hosts []string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	for _, allowed := range hosts {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminPage is the admin web ui, which uses the admin api
+//
+//go:embed admin.html
+var adminPage []byte
+
+// adminHandler serves the admin api for listing and changing the routes in store, and the web ui at /. If auth is
+// enabled, api requests must pass it, and requests must be for one of hosts unless it's nil. The hit counts of routes
+// come from stats and reload reloads the routes from the flags and config file, short adds short links, and maint is
+// maintenance mode; any of them may be nil, in which case their endpoints are disabled.
+func adminHandler(store redirector.RouteStore, auth adminAuth, hosts []string, stats *promCollector, reload func() (int, []error), short *shortener, maint *maintenance) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			routes, err := store.List(req.Context())
+			if err != nil {
+				adminError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if req.URL.Query().Get("format") == "string" {
+				// routes in the -route syntax
+				lines := make([]string, 0, len(routes))
+				for _, r := range routes {
+					lines = append(lines, r.Redacted().String())
+				}
+				adminJSON(w, http.StatusOK, lines)
+				return
+			}
+			redacted := make([]*redirector.Route, len(routes))
+			for i, r := range routes {
+				redacted[i] = r.Redacted()
+			}
+			adminJSON(w, http.StatusOK, redacted)
+		case http.MethodPost, http.MethodPut:
+			var raw json.RawMessage
+			if err := json.NewDecoder(io.LimitReader(req.Body, 1<<20)).Decode(&raw); err != nil {
+				adminError(w, http.StatusBadRequest, fmt.Errorf("parsing route: %v", err))
+				return
+			}
+			var route redirector.Route
+			if err := route.UnmarshalJSONStrict(raw); err != nil {
+				adminError(w, http.StatusBadRequest, fmt.Errorf("parsing route: %v", err))
+				return
+			}
+			route.Source = "admin api"
+			if err := unredact(req.Context(), store, &route); err != nil {
+				adminError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err := store.Put(req.Context(), &route); err != nil {
+				status := http.StatusUnprocessableEntity
+				if errors.As(err, new(invalidRouteError)) {
+					status = http.StatusBadRequest
+				}
+				adminError(w, status, err)
+				return
+			}
+			adminJSON(w, http.StatusOK, route.Redacted())
+		case http.MethodDelete:
+			pattern := req.URL.Query().Get("pattern")
+			if pattern == "" {
+				adminError(w, http.StatusBadRequest, errors.New("the pattern query parameter must be set"))
+				return
+			}
+			err := store.Delete(req.Context(), pattern)
+			switch {
+			case errors.Is(err, redirector.ErrNotFound):
+				adminError(w, http.StatusNotFound, err)
+			case err != nil:
+				adminError(w, http.StatusInternalServerError, err)
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+			adminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+
+	mux.HandleFunc("/hits", func(w http.ResponseWriter, req *http.Request) {
+		if stats == nil {
+			adminError(w, http.StatusNotFound, errors.New("hit counts aren't being collected"))
+			return
+		}
+		adminJSON(w, http.StatusOK, stats.hits())
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, req *http.Request) {
+		if stats == nil {
+			adminError(w, http.StatusNotFound, errors.New("hit counts aren't being collected"))
+			return
+		}
+		routes, err := store.List(req.Context())
+		if err != nil {
+			adminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		counts, misses, proxyErrors := stats.stats(routes)
+		adminJSON(w, http.StatusOK, map[string]interface{}{
+			"since":        stats.started,
+			"routes":       counts,
+			"misses":       misses,
+			"proxy_errors": proxyErrors,
+		})
+	})
+	mux.HandleFunc("/shorten", func(w http.ResponseWriter, req *http.Request) {
+		if short == nil {
+			adminError(w, http.StatusNotFound, errors.New("short links are disabled, set -shorten-host to enable them"))
+			return
+		}
+		short.handler(w, req)
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			adminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		if reload == nil {
+			adminError(w, http.StatusNotFound, errors.New("there is nothing to reload"))
+			return
+		}
+		n, errs := reload()
+		if len(errs) > 0 {
+			msgs := make([]string, len(errs))
+			for i, err := range errs {
+				msgs[i] = err.Error()
+			}
+			adminJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": "keeping the previous routes", "errors": msgs})
+			return
+		}
+		adminJSON(w, http.StatusOK, map[string]int{"routes": n})
+	})
+	mux.HandleFunc("/maintenance", func(w http.ResponseWriter, req *http.Request) {
+		if maint == nil {
+			adminError(w, http.StatusNotFound, errors.New("maintenance mode is disabled"))
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			maint.set(true)
+		case http.MethodDelete:
+			maint.set(false)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			adminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		adminJSON(w, http.StatusOK, map[string]bool{"enabled": maint.active()})
+	})
+
+	api := http.Handler(mux)
+	if auth.enabled() {
+		api = auth.handler(mux)
+	}
+	return sameOrigin(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			// the page itself has nothing to protect, and asks for credentials when the api wants them
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(adminPage)
+			return
+		}
+		api.ServeHTTP(w, req)
+	}), hosts)
+}
+
+// handler only lets requests that pass auth through to h
+func (a adminAuth) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !a.check(req) {
+			if a.basic != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="redirector admin"`)
+			}
+			adminError(w, http.StatusUnauthorized, errors.New("missing or invalid credentials"))
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+func adminJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func adminError(w http.ResponseWriter, code int, err error) {
+	adminJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+const testAdminRoute = `"www.example.com/* example.com path"`
+
+func TestAdminRefusesCrossSiteRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		origin      string
+		auth        adminAuth
+		want        int
+	}{
+		{"json", http.MethodPost, "http://127.0.0.1:9000/routes", "application/json", "", adminAuth{}, http.StatusOK},
+		{"json with a charset", http.MethodPut, "http://localhost:9000/routes", "application/json; charset=utf-8", "", adminAuth{}, http.StatusOK},
+		{"same origin", http.MethodPost, "http://127.0.0.1:9000/routes", "application/json", "http://127.0.0.1:9000", adminAuth{}, http.StatusOK},
+		{"text", http.MethodPost, "http://127.0.0.1:9000/routes", "text/plain", "", adminAuth{}, http.StatusUnsupportedMediaType},
+		{"form", http.MethodPost, "http://127.0.0.1:9000/routes", "application/x-www-form-urlencoded", "", adminAuth{}, http.StatusUnsupportedMediaType},
+		{"no content type", http.MethodPost, "http://127.0.0.1:9000/routes", "", "", adminAuth{}, http.StatusUnsupportedMediaType},
+		{"no content type for reload", http.MethodPost, "http://127.0.0.1:9000/reload", "", "", adminAuth{}, http.StatusUnsupportedMediaType},
+		{"other origin", http.MethodPost, "http://127.0.0.1:9000/routes", "application/json", "https://evil.example", adminAuth{}, http.StatusForbidden},
+		{"null origin", http.MethodPost, "http://127.0.0.1:9000/routes", "application/json", "null", adminAuth{}, http.StatusForbidden},
+		{"other origin deleting", http.MethodDelete, "http://127.0.0.1:9000/routes?pattern=example.com/*", "", "https://evil.example", adminAuth{}, http.StatusForbidden},
+		{"rebound host", http.MethodPost, "http://evil.example:9000/routes", "application/json", "http://evil.example:9000", adminAuth{}, http.StatusForbidden},
+		{"rebound host reading", http.MethodGet, "http://evil.example:9000/routes", "", "", adminAuth{}, http.StatusForbidden},
+		{"any host with auth", http.MethodPost, "http://admin.example.com/routes", "application/json", "", adminAuth{token: "secret"}, http.StatusOK},
+		{"text with auth", http.MethodPost, "http://admin.example.com/routes", "text/plain", "", adminAuth{token: "secret"}, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newOverlayStore(redirector.NewMemoryStore())
+			addr := "127.0.0.1:9000"
+			if tt.auth.enabled() {
+				addr = ":9000"
+			}
+			h := adminHandler(store, tt.auth, adminHosts(addr, tt.auth), nil, nil, nil, nil)
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(testAdminRoute))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.auth.enabled() {
+				req.Header.Set("Authorization", "Bearer "+tt.auth.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			routes, err := store.List(req.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			added := tt.method != http.MethodDelete && strings.HasSuffix(req.URL.Path, "/routes") && tt.want == http.StatusOK
+			if added != (len(routes) == 1) {
+				t.Errorf("got %d routes after the request", len(routes))
+			}
+		})
+	}
+}
+
+func TestAdminHosts(t *testing.T) {
+	if hosts := adminHosts("unix:/run/redirector/admin.sock", adminAuth{}); hosts != nil {
+		t.Errorf("got hosts %q for a unix socket, want any", hosts)
+	}
+	if hosts := adminHosts(":9000", adminAuth{basic: "admin:secret"}); hosts != nil {
+		t.Errorf("got hosts %q with auth, want any", hosts)
+	}
+	hosts := adminHosts("10.0.0.5:9000", adminAuth{})
+	for _, host := range []string{"10.0.0.5:9000", "localhost:9000", "127.0.0.1", "[::1]:9000", "LOCALHOST"} {
+		if !allowsHost(hosts, host) {
+			t.Errorf("%q isn't allowed", host)
+		}
+	}
+	for _, host := range []string{"evil.example", "evil.example:9000", "10.0.0.6:9000", ""} {
+		if allowsHost(hosts, host) {
+			t.Errorf("%q is allowed", host)
+		}
+	}
+}
+
+func TestAdminRefusesUnknownFields(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       int
+	}{
+		{"object", `{"pattern": "www.example.com/*", "destination": "https://example.com", "path": true}`, http.StatusOK},
+		{"string", testAdminRoute, http.StatusOK},
+		{"misspelled field", `{"pattern": "www.example.com/*", "destiantion": "https://example.com"}`, http.StatusBadRequest},
+		{"misspelled split field", `{"pattern": "www.example.com/*", "destination": "split", "split": [{"weight": 1, "dest": "https://example.com"}]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newOverlayStore(redirector.NewMemoryStore())
+			h := adminHandler(store, adminAuth{}, nil, nil, nil, nil, nil)
+			req := httptest.NewRequest(http.MethodPost, "/routes", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			routes, _ := store.List(req.Context())
+			if added := tt.want == http.StatusOK; added != (len(routes) == 1) {
+				t.Errorf("got %d routes after the request", len(routes))
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+const (
+	// analyticsBuffer is how many events are buffered before new ones are dropped, so that a slow disk or endpoint
+	// doesn't slow down redirects
+	analyticsBuffer = 10000
+	// analyticsBatchSize and analyticsFlushInterval are how many events are sent to an endpoint at once, and how
+	// often a partial batch is sent
+	analyticsBatchSize     = 500
+	analyticsFlushInterval = 10 * time.Second
+)
+
+// analyticsEvent is a redirect recorded by -analytics. Clients are anonymized: only the network of their address is
+// kept, and their user agent is reduced to a class.
+type analyticsEvent struct {
+	Time        time.Time `json:"time"`
+	Route       string    `json:"route"`
+	Host        string    `json:"host"`
+	Path        string    `json:"path"`
+	Query       string    `json:"query,omitempty"`
+	Destination string    `json:"destination"`
+	Status      int       `json:"status"`
+	Referer     string    `json:"referer,omitempty"`
+	// Network is the client's /24 for ipv4 or /48 for ipv6
+	Network   string `json:"network,omitempty"`
+	Country   string `json:"country,omitempty"`
+	UserAgent string `json:"user_agent"`
+}
+
+// analytics records redirect events as ndjson, appending them to a file that's rotated once it grows past maxSize,
+// or sending them in batches to an http endpoint
+type analytics struct {
+	dest    string
+	maxSize int64
+	events  chan analyticsEvent
+	// country finds the countries of clients, if -geoip-db is set
+	country func(*http.Request) string
+
+	f    *os.File
+	size int64
+}
+
+func newAnalytics(dest string, maxSize int64, country func(*http.Request) string) (*analytics, error) {
+	a := &analytics{dest: dest, maxSize: maxSize, country: country, events: make(chan analyticsEvent, analyticsBuffer)}
+	if !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") {
+		if err := a.open(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// middleware records the requests that the redirector redirects. It's used with redirector.WithMiddleware.
+func (a *analytics) middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, req)
+		route := redirector.MatchedRoute(req)
+		dest := rec.Header().Get("Location")
+		if route == nil || dest == "" {
+			return
+		}
+		e := analyticsEvent{
+			Time:        time.Now().UTC(),
+			Route:       route.Pattern,
+			Host:        req.Host,
+			Path:        req.URL.Path,
+			Query:       req.URL.RawQuery,
+			Destination: dest,
+			Status:      rec.status,
+			Referer:     req.Referer(),
+			Network:     anonymizeAddr(req.RemoteAddr),
+			UserAgent:   redirector.ClassifyUserAgent(req.UserAgent()),
+		}
+		if a.country != nil {
+			e.Country = a.country(req)
+		}
+		select {
+		case a.events <- e:
+		default:
+		}
+	})
+}
+
+// anonymizeAddr returns the network of a client's address, /24 for ipv4 and /48 for ipv6
+func anonymizeAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// run writes or sends the recorded events until the process exits
+func (a *analytics) run() {
+	ticker := time.NewTicker(analyticsFlushInterval)
+	defer ticker.Stop()
+	var batch bytes.Buffer
+	n := 0
+	for {
+		select {
+		case e := <-a.events:
+			line, _ := json.Marshal(e)
+			batch.Write(append(line, '\n'))
+			n++
+			if a.f != nil {
+				// the file is written to as events come in, so nothing's lost on a crash
+				if err := a.write(batch.Bytes()); err != nil {
+					fmt.Printf("❌ -analytics: %v\n", err)
+				}
+				batch.Reset()
+				n = 0
+				continue
+			}
+			if n < analyticsBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if n == 0 {
+				continue
+			}
+		}
+		if err := a.send(batch.Bytes()); err != nil {
+			fmt.Printf("❌ -analytics: sending %d event(s): %v\n", n, err)
+		}
+		batch.Reset()
+		n = 0
+	}
+}
+
+// open opens the file for appending
+func (a *analytics) open() error {
+	f, err := os.OpenFile(a.dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size = f, info.Size()
+	return nil
+}
+
+// write appends lines to the file, first rotating it to <file>.<timestamp> if they would grow it past maxSize
+func (a *analytics) write(lines []byte) error {
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(lines)) > a.maxSize {
+		a.f.Close()
+		if err := os.Rename(a.dest, a.dest+"."+time.Now().UTC().Format("20060102T150405.000")); err != nil {
+			return err
+		}
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+	n, err := a.f.Write(lines)
+	a.size += int64(n)
+	return err
+}
+
+// send POSTs a batch of lines to the endpoint
+func (a *analytics) send(lines []byte) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	res, err := client.Post(a.dest, "application/x-ndjson", bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", a.dest, res.Status)
+	}
+	return nil
+}
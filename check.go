@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// checkCommand is the `check` command. It returns the process's exit code.
+func checkCommand(rf *routeFlags, args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	rf.register(fs)
+	head := fs.Bool("head", false, "also send a HEAD request to each destination and upstream, and report the ones that fail or respond with\nan error status.")
+	timeout := fs.Duration("timeout", 10*time.Second, "the timeout for each HEAD request.")
+	concurrency := fs.Int("concurrency", 8, "the number of HEAD requests to send at once.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+🩺⛳ check flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *concurrency < 1 {
+		fmt.Printf("🚨 -concurrency must be at least 1\n")
+		return 1
+	}
+
+	routes, problems := rf.load()
+	problems = append(problems, routeProblems(routes)...)
+	for _, p := range problems {
+		fmt.Printf("❌ %v\n", p)
+	}
+	if *head {
+		problems = append(problems, checkDestinations(routes, *timeout, *concurrency)...)
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("\n🚨 found %d problem(s) in %d route(s)\n", len(problems), len(routes))
+		return 1
+	}
+	fmt.Printf("✅ %d route(s) passed\n", len(routes))
+	return 0
+}
+
+// checkDestinations sends a HEAD request to the destinations and upstreams of routes, printing the result of each one.
+// Destinations with placeholders are skipped since they depend on the request. It returns the broken destinations.
+func checkDestinations(routes []*redirector.Route, timeout time.Duration, concurrency int) []error {
+	var (
+		targets []string
+		pattern = make(map[string]string)
+		skipped int
+	)
+	add := func(r *redirector.Route, u *url.URL) {
+		if u == nil {
+			return
+		}
+		target := u.String()
+		if strings.Contains(target, "{") || strings.Contains(target, "%7B") {
+			skipped++
+			return
+		}
+		if _, ok := pattern[target]; !ok {
+			targets = append(targets, target)
+			pattern[target] = r.Pattern
+		}
+	}
+	for _, r := range routes {
+		add(r, r.Destination)
+		add(r, r.Upstream)
+		for _, s := range r.Split {
+			add(r, s.Destination)
+		}
+	}
+
+	client := &http.Client{Timeout: timeout}
+	results := make([]error, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, target string) {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = checkDestination(client, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	var problems []error
+	for i, target := range targets {
+		if err := results[i]; err != nil {
+			fmt.Printf("❌ %s → %s: %v\n", pattern[target], target, err)
+			problems = append(problems, err)
+			continue
+		}
+		fmt.Printf("✅ %s → %s\n", pattern[target], target)
+	}
+	if skipped > 0 {
+		fmt.Printf("⚠️  skipped %d destination(s) with placeholders\n", skipped)
+	}
+	return problems
+}
+
+// checkDestination sends a HEAD request to target, following redirects, and returns an error if it fails or the final
+// response has an error status. Servers that don't allow HEAD requests are sent a GET request instead.
+func checkDestination(client *http.Client, target string) error {
+	res, err := client.Head(target)
+	if err == nil && (res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented) {
+		res.Body.Close()
+		res, err = client.Get(target)
+	}
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<20))
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("responded with %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// cloudflareRedirect is an item of a Cloudflare Bulk Redirect list
+type cloudflareRedirect struct {
+	SourceURL           string `json:"source_url"`
+	TargetURL           string `json:"target_url"`
+	StatusCode          int    `json:"status_code,omitempty"`
+	PreserveQueryString bool   `json:"preserve_query_string"`
+	IncludeSubdomains   bool   `json:"include_subdomains"`
+	SubpathMatching     bool   `json:"subpath_matching"`
+	PreservePathSuffix  bool   `json:"preserve_path_suffix"`
+}
+
+// cloudflareListItem wraps a redirect the way the Cloudflare lists API expects it
+type cloudflareListItem struct {
+	Redirect cloudflareRedirect `json:"redirect"`
+}
+
+// cloudflareCommand is the `cloudflare` command. It returns the process's exit code.
+func cloudflareCommand(rf *routeFlags, args []string) int {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Printf("🚨 usage: redirector [global flags] cloudflare export|import [flags]\n")
+		return 1
+	}
+	if args[0] == "export" {
+		return cloudflareExport(rf, args[1:])
+	}
+	return cloudflareImport(args[1:])
+}
+
+func cloudflareExport(rf *routeFlags, args []string) int {
+	fs := flag.NewFlagSet("cloudflare export", flag.ExitOnError)
+	format := fs.String("format", "csv", `the format to export: "csv" for uploading in the dashboard, or "json" for the lists api.`)
+	output := fs.String("o", "-", `the file to write the list to, or "-" for stdout.`)
+	rf.register(fs)
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+☁️⛳ cloudflare export flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *format != "csv" && *format != "json" {
+		fmt.Printf("🚨 unknown format %q\n", *format)
+		return 1
+	}
+
+	routes, errs := rf.load()
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+
+	var items []cloudflareListItem
+	exitCode := 0
+	for _, r := range routes {
+		// cloudflare has no lists of hosts, so routes with one become a redirect per host
+		for _, pattern := range r.Patterns() {
+			route := *r
+			route.Pattern = pattern
+			cr, err := toCloudflare(&route)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %s: %v\n", pattern, err)
+				exitCode = 1
+				continue
+			}
+			items = append(items, cloudflareListItem{Redirect: cr})
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Printf("🚨 %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	var err error
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(items)
+	} else {
+		err = writeCloudflareCSV(w, items)
+	}
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	if *output != "-" {
+		fmt.Printf("✅ wrote %d redirects to %s\n", len(items), *output)
+	}
+	return exitCode
+}
+
+func cloudflareImport(args []string) int {
+	fs := flag.NewFlagSet("cloudflare import", flag.ExitOnError)
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+☁️⛳ cloudflare import flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Printf("🚨 usage: redirector cloudflare import <file>\n")
+		return 1
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if path := fs.Arg(0); path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	items, err := parseCloudflareList(data)
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+
+	exitCode := 0
+	for _, item := range items {
+		routes, err := fromCloudflare(item.Redirect)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n", item.Redirect.SourceURL, err)
+			exitCode = 1
+			continue
+		}
+		for _, r := range routes {
+			fmt.Println(formatRoute(r))
+		}
+	}
+	return exitCode
+}
+
+// toCloudflare converts a route to a Cloudflare redirect. Not every route can be expressed as one.
+func toCloudflare(r *redirector.Route) (cloudflareRedirect, error) {
+	if r.Response != nil || r.Upstream != nil || r.Files != "" {
+		return cloudflareRedirect{}, errors.New("routes that don't redirect can't be exported")
+	}
+	if len(r.Split) > 0 {
+		return cloudflareRedirect{}, errors.New("split routes can't be exported")
+	}
+	if r.LogOnly {
+		return cloudflareRedirect{}, errors.New("log-only routes can't be exported")
+	}
+	if r.Destination == nil {
+		return cloudflareRedirect{}, errors.New("routes with a resolver can't be exported")
+	}
+	if strings.HasPrefix(r.Pattern, "~") {
+		return cloudflareRedirect{}, errors.New("routes with a regular expression pattern can't be exported")
+	}
+	if r.MergeQuery || len(r.AddQuery) > 0 || len(r.DropQuery) > 0 {
+		return cloudflareRedirect{}, errors.New("cloudflare doesn't support merge_query, add_query, or drop_query")
+	}
+	if c := r.Conditions(); c != "" {
+		return cloudflareRedirect{}, fmt.Errorf("cloudflare doesn't support conditions such as %s", c)
+	}
+	if len(r.Allow) > 0 || len(r.Deny) > 0 {
+		return cloudflareRedirect{}, errors.New("cloudflare doesn't support allow or deny lists on bulk redirects")
+	}
+	if r.Auth != "" {
+		return cloudflareRedirect{}, errors.New("cloudflare doesn't support auth on bulk redirects")
+	}
+	if r.Priority != 0 {
+		return cloudflareRedirect{}, errors.New("cloudflare doesn't support route priorities")
+	}
+	if len(r.Headers) > 0 {
+		return cloudflareRedirect{}, errors.New("cloudflare doesn't support custom headers on bulk redirects")
+	}
+	switch r.Code {
+	case 301, 302, 307, 308:
+	default:
+		return cloudflareRedirect{}, fmt.Errorf("cloudflare doesn't support code %d", r.Code)
+	}
+
+	i := strings.IndexByte(r.Pattern, '/')
+	host, path := r.Pattern[:i], strings.Trim(r.Pattern[i:], "/")
+	cr := cloudflareRedirect{
+		TargetURL:           r.Destination.String(),
+		StatusCode:          r.Code,
+		PreserveQueryString: r.CarryQuery,
+	}
+	if strings.HasPrefix(host, "*.") {
+		host = host[2:]
+		cr.IncludeSubdomains = true
+	}
+	if path == "*" || strings.HasSuffix(path, "/*") {
+		path = strings.TrimSuffix(strings.TrimSuffix(path, "*"), "/")
+		cr.SubpathMatching = true
+	}
+	if strings.ContainsAny(host, "*{") || strings.ContainsAny(path, "*{") {
+		return cloudflareRedirect{}, errors.New("cloudflare only supports wildcards at the start of the hostname and the end of the path")
+	}
+	if r.CarryPath {
+		// redirector appends the whole request path, unless the source path is stripped from it, while cloudflare only
+		// appends what follows the source path
+		if path != "" && strings.Trim(r.StripPrefix, "/") != path {
+			return cloudflareRedirect{}, fmt.Errorf("routes with a sub-path that carry the path can't be exported unless they strip it: add strip=/%s", path)
+		}
+		cr.PreservePathSuffix = true
+	} else if r.StripPrefix != "" {
+		return cloudflareRedirect{}, errors.New("strip only applies to routes that carry the path")
+	}
+	cr.SourceURL = host + "/" + path
+	return cr, nil
+}
+
+// fromCloudflare converts a Cloudflare redirect to routes. include_subdomains also matches the host itself, so it
+// results in two routes.
+func fromCloudflare(cr cloudflareRedirect) ([]*redirector.Route, error) {
+	source := cr.SourceURL
+	if i := strings.Index(source, "://"); i != -1 {
+		source = source[i+3:]
+	}
+	host, path := source, ""
+	if i := strings.IndexByte(source, '/'); i != -1 {
+		host, path = source[:i], strings.Trim(source[i:], "/")
+	}
+
+	pattern := "/" + path
+	if cr.SubpathMatching {
+		pattern = strings.TrimSuffix(pattern, "/") + "/*"
+	}
+	hosts := []string{host}
+	if cr.IncludeSubdomains {
+		hosts = append(hosts, "*."+host)
+	}
+
+	code := cr.StatusCode
+	if code == 0 {
+		code = 301
+	}
+	var routes []*redirector.Route
+	for _, h := range hosts {
+		b := redirector.To(cr.TargetURL).From(h + pattern).Code(code)
+		if cr.PreservePathSuffix && cr.SubpathMatching {
+			b.CarryPath()
+			if path != "" {
+				b.StripPrefix("/" + path)
+			}
+		}
+		if cr.PreserveQueryString {
+			b.CarryQuery()
+		}
+		r, err := b.Build()
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+func writeCloudflareCSV(w io.Writer, items []cloudflareListItem) error {
+	cw := csv.NewWriter(w)
+	for _, item := range items {
+		cr := item.Redirect
+		cw.Write([]string{
+			cr.SourceURL,
+			cr.TargetURL,
+			strconv.Itoa(cr.StatusCode),
+			csvBool(cr.PreserveQueryString),
+			csvBool(cr.IncludeSubdomains),
+			csvBool(cr.SubpathMatching),
+			csvBool(cr.PreservePathSuffix),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvBool(b bool) string {
+	return strings.ToUpper(strconv.FormatBool(b))
+}
+
+// parseCloudflareList parses a Bulk Redirect list, either as CSV or as JSON: the items sent to or returned by the lists
+// api, optionally wrapped in an api response
+func parseCloudflareList(data []byte) ([]cloudflareListItem, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		var items []cloudflareListItem
+		if strings.HasPrefix(trimmed, "{") {
+			var res struct {
+				Result []cloudflareListItem `json:"result"`
+			}
+			if err := json.Unmarshal(data, &res); err != nil {
+				return nil, err
+			}
+			items = res.Result
+		} else if err := json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	cr := csv.NewReader(strings.NewReader(trimmed))
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var items []cloudflareListItem
+	for i, rec := range records {
+		if i == 0 && rec[0] == "source_url" {
+			// header
+			continue
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: expected at least a source and target url", i+1)
+		}
+		cr := cloudflareRedirect{SourceURL: rec[0], TargetURL: rec[1]}
+		if len(rec) > 2 && rec[2] != "" {
+			if cr.StatusCode, err = strconv.Atoi(rec[2]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid status code %q", i+1, rec[2])
+			}
+		}
+		for j, b := range []*bool{&cr.PreserveQueryString, &cr.IncludeSubdomains, &cr.SubpathMatching, &cr.PreservePathSuffix} {
+			if len(rec) > j+3 && rec[j+3] != "" {
+				if *b, err = strconv.ParseBool(rec[j+3]); err != nil {
+					return nil, fmt.Errorf("line %d: invalid boolean %q", i+1, rec[j+3])
+				}
+			}
+		}
+		items = append(items, cloudflareListItem{Redirect: cr})
+	}
+	return items, nil
+}
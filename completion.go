@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// commands are the names of redirector's commands, for completion
+var commands = []string{
+	"check", "cloudflare", "completion", "export", "fmt", "healthcheck", "import", "init", "self-update", "shorten",
+	"sign", "systemd", "test", "trace", "validate", "version", "wrap",
+}
+
+// routeKeywords are the destination keywords and options of the -route syntax, for completion. options that take a
+// value end with =, except for code, whose common values are listed instead.
+var routeKeywords = []string{
+	"gone", "respond", "proxy", "split", "files",
+	"path", "query", "keep-trailing-slash", "sticky", "sticky=", "refresh", "log_only", "merge_query",
+	"add_query=", "drop_query=", "allow=", "deny=", "auth=", "strip=", "header=", "body=", "content-type=", "method=",
+	"match_header=", "match_query=", "cookie=", "ua=", "country=", "from=", "until=", "scheme=", "cors", "cors=", "priority=",
+	"code=301", "code=302", "code=307", "code=308", "code=permanent", "code=temporary", "code=permanent-preserve",
+	"code=temporary-preserve",
+}
+
+// completionFlag is a global flag, for completion
+type completionFlag struct {
+	name, usage string
+	// value is whether the flag takes a value
+	value bool
+}
+
+// completionCommand is the `completion` command, which prints a completion script for a shell. It returns the
+// process's exit code.
+func completionCommand(global *flag.FlagSet, args []string) int {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+🐚 completion
+
+  redirector completion bash|zsh|fish
+`)
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Printf("🚨 exactly one shell must be set: bash, zsh, or fish\n")
+		return 1
+	}
+
+	var flags []completionFlag
+	global.VisitAll(func(f *flag.Flag) {
+		bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+		// the first sentence is enough to tell flags apart
+		usage, _, _ := strings.Cut(f.Usage, "\n")
+		usage, _, _ = strings.Cut(usage, ". ")
+		flags = append(flags, completionFlag{name: f.Name, usage: usage, value: !ok || !bf.IsBoolFlag()})
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+
+	switch shell := fs.Arg(0); shell {
+	case "bash":
+		fmt.Print(bashCompletion(flags))
+	case "zsh":
+		fmt.Print(zshCompletion(flags))
+	case "fish":
+		fmt.Print(fishCompletion(flags))
+	default:
+		fmt.Printf("🚨 unsupported shell %q: must be bash, zsh, or fish\n", shell)
+		return 1
+	}
+	return 0
+}
+
+// valueFlags returns the names of the flags that take a value, other than -route, with a dash in front
+func valueFlags(flags []completionFlag) []string {
+	var names []string
+	for _, f := range flags {
+		if f.value && f.name != "route" {
+			names = append(names, "-"+f.name)
+		}
+	}
+	return names
+}
+
+// bashCompletion returns the bash completion script. values of flags other than -route complete as file names.
+func bashCompletion(flags []completionFlag) string {
+	names := make([]string, len(flags))
+	for i, f := range flags {
+		names[i] = "-" + f.name
+	}
+	return fmt.Sprintf(`# bash completion for redirector. load it in the current shell with
+#
+#	source <(redirector completion bash)
+#
+# or save it to /etc/bash_completion.d/redirector
+_redirector() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	local IFS=$'\n' w
+	case "$prev" in
+	-route)
+		# complete the last word of the route, inside its quotes
+		local head="" last="$cur"
+		if [[ "$cur" == *" "* ]]; then
+			head="${cur%% *} " last="${cur##* }"
+		fi
+		COMPREPLY=($(compgen -P "$head" -W %s -- "$last"))
+		if [[ ${#COMPREPLY[@]} -eq 1 && "${COMPREPLY[0]}" == *= ]]; then
+			compopt -o nospace
+		fi
+		return
+		;;
+	%s)
+		return
+		;;
+	esac
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W %s -- "$cur"))
+		return
+	fi
+	for w in "${COMP_WORDS[@]:1:COMP_CWORD-1}"; do
+		case "$w" in
+		%s)
+			return
+			;;
+		esac
+	done
+	COMPREPLY=($(compgen -W %s -- "$cur"))
+}
+complete -o default -F _redirector redirector
+`, bashWords(routeKeywords), strings.Join(valueFlags(flags), "|"), bashWords(names), strings.Join(commands, "|"), bashWords(commands))
+}
+
+// bashWords quotes words as a newline-separated word list for compgen -W
+func bashWords(words []string) string {
+	return "$'" + strings.Join(words, `\n`) + "'"
+}
+
+// zshCompletion returns the zsh completion script
+func zshCompletion(flags []completionFlag) string {
+	var specs []string
+	for _, f := range flags {
+		specs = append(specs, zshQuote("-"+f.name+":"+strings.ReplaceAll(f.usage, ":", `\:`)))
+	}
+	return fmt.Sprintf(`#compdef redirector
+# zsh completion for redirector. load it in the current shell with
+#
+#	source <(redirector completion zsh)
+#
+# or save it as _redirector in a directory in $fpath
+
+_redirector() {
+	local -a flags value_flags commands route_keywords
+	flags=(
+		%s
+	)
+	value_flags=(%s)
+	commands=(%s)
+	route_keywords=(%s)
+
+	if [[ ${words[CURRENT-1]} == -route ]]; then
+		# complete the last word of the route, inside its quotes
+		compset -P '* '
+		compadd -S '' -- ${(M)route_keywords:#*=}
+		compadd -- ${route_keywords:#*=}
+		return
+	fi
+	if (( ${value_flags[(Ie)${words[CURRENT-1]}]} )); then
+		_files
+		return
+	fi
+	if [[ $PREFIX == -* ]]; then
+		_describe -t flags 'flag' flags
+		return
+	fi
+	local w
+	for w in ${words[2,CURRENT-1]}; do
+		if (( ${commands[(Ie)$w]} )); then
+			_files
+			return
+		fi
+	done
+	_describe -t commands 'command' commands
+}
+
+if [[ $funcstack[1] == _redirector ]]; then
+	_redirector "$@"
+else
+	compdef _redirector redirector
+fi
+`, strings.Join(specs, "\n\t\t"), strings.Join(valueFlags(flags), " "), strings.Join(commands, " "), zshWords(routeKeywords))
+}
+
+// zshQuote quotes s for zsh
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshWords quotes each of words for zsh
+func zshWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = zshQuote(w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// fishCompletion returns the fish completion script
+func fishCompletion(flags []completionFlag) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `# fish completion for redirector. load it in the current shell with
+#
+#	redirector completion fish | source
+#
+# or save it to ~/.config/fish/completions/redirector.fish
+
+# complete the last word of a -route, inside its quotes
+function __redirector_route
+	set -l head (string replace -r '[^ ]*$' '' -- (commandline -ct | string trim -l -c '"\''))
+	printf '%%s\n' "$head"%s
+end
+
+complete -c redirector -f
+complete -c redirector -n __fish_use_subcommand -a %s
+`, fishList(routeKeywords), fishQuote(strings.Join(commands, " ")))
+	for _, f := range flags {
+		switch {
+		case f.name == "route":
+			fmt.Fprintf(&b, "complete -c redirector -o route -x -a '(__redirector_route)' -d %s\n", fishQuote(f.usage))
+		case f.value:
+			fmt.Fprintf(&b, "complete -c redirector -o %s -r -F -d %s\n", f.name, fishQuote(f.usage))
+		default:
+			fmt.Fprintf(&b, "complete -c redirector -o %s -d %s\n", f.name, fishQuote(f.usage))
+		}
+	}
+	return b.String()
+}
+
+// fishQuote quotes s for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// fishList returns words as a fish brace expansion, so that "$head" followed by it puts $head in front of every word
+func fishList(words []string) string {
+	return "{" + strings.Join(words, ",") + "}"
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// config is a config file. Routes may be strings in the -route syntax or objects with the same options.
+type config struct {
+	Port          int    `json:"port"`
+	CacheSize     int    `json:"cache_size"`
+	VersionHeader bool   `json:"version_header"`
+	DebugHeaders  bool   `json:"debug_headers"`
+	Preview       bool   `json:"preview"`
+	DNSRoutes     bool   `json:"dns_routes"`
+	HealthPath    string `json:"health_path"`
+	ReadyPath     string `json:"ready_path"`
+	ProbePort     int    `json:"probe_port"`
+	LogFormat     string `json:"log_format"`
+	LogOutput     string `json:"log_output"`
+	Metrics       bool   `json:"metrics"`
+	MetricsPort   int    `json:"metrics_port"`
+	DebugAddr     string `json:"debug_addr"`
+	AdminPort     int    `json:"admin_port"`
+	AdminListen   string `json:"admin_listen"`
+	Store         string `json:"store"`
+	ShortenHost   string `json:"shorten_host"`
+	QR            bool   `json:"qr"`
+	H2C           bool   `json:"h2c"`
+	// Listen and ListenMode are addresses to serve on instead of Port and the permissions of unix sockets, like -listen
+	// and -listen-mode
+	Listen     []string `json:"listen"`
+	ListenMode string   `json:"listen_mode"`
+	// ShutdownTimeout is how long to drain requests for on shutdown, as a duration such as 30s
+	ShutdownTimeout string `json:"shutdown_timeout"`
+	TLSCert         string `json:"tls_cert"`
+	TLSKey          string `json:"tls_key"`
+	// TLSCerts are more certificates to pick from by sni, like several -tls-cert and -tls-key flags, and TLSDir a
+	// directory of them, like -tls-dir
+	TLSCerts []struct {
+		Cert string `json:"cert"`
+		Key  string `json:"key"`
+	} `json:"tls_certs"`
+	TLSDir string `json:"tls_dir"`
+	// HTTPRedirectPort is a port to redirect http requests to https on
+	HTTPRedirectPort int `json:"http_redirect_port"`
+	AutoTLS          *struct {
+		Cache string `json:"cache"`
+		Email string `json:"email"`
+	} `json:"auto_tls"`
+	// DefaultProxy is a url to forward requests that don't match any routes to
+	DefaultProxy string `json:"default_proxy"`
+	// Default is a url to redirect requests that don't match any routes to, with DefaultCode
+	Default     string `json:"default"`
+	DefaultCode int    `json:"default_code"`
+	// GeoIPDB is a maxmind database to find the countries of requests in
+	GeoIPDB string `json:"geoip_db"`
+	// TrustForwardedProto takes the scheme of requests from X-Forwarded-Proto
+	TrustForwardedProto bool `json:"trust_forwarded_proto"`
+	// TrustedProxies are the cidrs or ip addresses of proxies whose X-Forwarded-* headers are trusted
+	TrustedProxies []string `json:"trusted_proxies"`
+	// StripForwarded and ForwardedHeader change the forwarded headers of proxied requests, like -strip-forwarded and
+	// -forwarded-header
+	StripForwarded  bool `json:"strip_forwarded"`
+	ForwardedHeader bool `json:"forwarded_header"`
+	// Allow and Deny are the cidrs or ip addresses that clients must and must not come from, like -allow and -deny
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	// DestinationHosts are the hosts that redirects may send clients to, like -destination-hosts
+	DestinationHosts []string `json:"destination_hosts"`
+	// CORS answers CORS preflights, like -cors, with the methods, headers, max age, and credentials that it allows
+	CORS *redirector.CORS `json:"cors"`
+	// CollapseSlashes and TrailingSlash normalize paths, like -collapse-slashes and -trailing-slash
+	CollapseSlashes bool   `json:"collapse_slashes"`
+	TrailingSlash   string `json:"trailing_slash"`
+	// NotFoundPage is an html template to render for requests that don't match any routes
+	NotFoundPage string `json:"not_found_page"`
+	// Robots and Favicon serve /robots.txt and /favicon.ico on every host, like -robots and -favicon
+	Robots  string `json:"robots"`
+	Favicon string `json:"favicon"`
+	// Maintenance, MaintenanceFile, MaintenancePage, MaintenanceHosts, and MaintenanceRetryAfter configure maintenance
+	// mode, like -maintenance and the other -maintenance flags, with MaintenanceRetryAfter as a duration such as 5m
+	Maintenance           bool     `json:"maintenance"`
+	MaintenanceFile       string   `json:"maintenance_file"`
+	MaintenancePage       string   `json:"maintenance_page"`
+	MaintenanceHosts      []string `json:"maintenance_hosts"`
+	MaintenanceRetryAfter string   `json:"maintenance_retry_after"`
+	// MissWebhook is a url to send the requests that don't match any routes to, like -miss-webhook
+	MissWebhook string `json:"miss_webhook"`
+	// Analytics and AnalyticsMaxSize record redirects, like -analytics and -analytics-max-size
+	Analytics        string `json:"analytics"`
+	AnalyticsMaxSize int64  `json:"analytics_max_size"`
+	// RefreshBody and RefreshTemplate add meta refresh bodies to redirects, like -refresh-body and -refresh-template
+	RefreshBody     bool   `json:"refresh_body"`
+	RefreshTemplate string `json:"refresh_template"`
+	// ServeDir is a directory to serve files from for requests that don't match any routes
+	ServeDir   string      `json:"serve_dir"`
+	Wrap       *wrapConfig `json:"wrap"`
+	Kubernetes *struct {
+		Server    string `json:"server"`
+		Namespace string `json:"namespace"`
+		// Redirects also loads routes from Redirect custom resources, like -kubernetes-redirects
+		Redirects bool `json:"redirects"`
+	} `json:"kubernetes"`
+	Consul *struct {
+		Address string `json:"address"`
+		Prefix  string `json:"prefix"`
+	} `json:"consul"`
+	// Platform loads routes from the cloud instance's metadata, like -platform
+	Platform string `json:"platform"`
+	// CanonicalHost adds the standard routes for a host, like -canonical-host
+	CanonicalHost string `json:"canonical_host"`
+	// Flatten flattens internal redirect chains, like -flatten
+	Flatten bool                `json:"flatten"`
+	Routes  []*redirector.Route `json:"routes"`
+	// Groups are routes that share a host, a code, path and query carrying, or headers
+	Groups []*routeGroup `json:"groups"`
+	// Wraps are several commands to wrap, like wrap with -match for each of them
+	Wraps []*wrapConfig `json:"wraps"`
+}
+
+// routeGroup is a group of routes in a config file, with its shared settings next to its routes
+type routeGroup struct {
+	redirector.Group
+	Routes []json.RawMessage `json:"routes"`
+}
+
+// routes returns the routes of cfg, including the routes of its groups, validated and with source, the path or url of
+// the config file, as their source
+func (cfg *config) routes(source string) ([]*redirector.Route, []error) {
+	var (
+		routes []*redirector.Route
+		errs   []error
+	)
+	for i, r := range cfg.Routes {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid route #%d: %v", source, i+1, err))
+			continue
+		}
+		r.Source = fmt.Sprintf("%s: route #%d", source, i+1)
+		routes = append(routes, r)
+	}
+	for i, g := range cfg.Groups {
+		for j, data := range g.Routes {
+			r, err := g.UnmarshalRoute(data)
+			if err == nil {
+				err = r.Validate()
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: group #%d: invalid route #%d: %v", source, i+1, j+1, err))
+				continue
+			}
+			r.Source = fmt.Sprintf("%s: group #%d route #%d", source, i+1, j+1)
+			routes = append(routes, r)
+		}
+	}
+	return routes, errs
+}
+
+// readConfig reads a YAML, TOML, or JSON config file, depending on its extension
+func readConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(path, data)
+}
+
+// parseConfig parses a config file read from path, which may also be a url
+func parseConfig(path string, data []byte) (*config, error) {
+	// YAML and TOML documents are converted to JSON so that all three share one set of field names and route parsing
+	var (
+		doc interface{}
+		err error
+	)
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("%s: unknown config format %q, must be .yaml, .yml, .toml, or .json", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	cfg := &config{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+type wrapConfig struct {
+	Command []string `json:"command"`
+	Port    *uint    `json:"port"`
+	Socket  string   `json:"socket"`
+	Match   string   `json:"match"`
+	H2C     bool     `json:"h2c"`
+	TTY     bool     `json:"tty"`
+	// HealthURL and Wait are like wrap -health-url and -wait, with Wait as a duration such as 10s
+	HealthURL string `json:"health_url"`
+	Wait      string `json:"wait"`
+	// Restart and MaxRestarts are like wrap -restart and -max-restarts
+	Restart     string `json:"restart"`
+	MaxRestarts int    `json:"max_restarts"`
+	// HealthInterval and HealthFailures are like wrap -health-interval and -health-failures
+	HealthInterval string `json:"health_interval"`
+	HealthFailures int    `json:"health_failures"`
+	// ForwardSignals, StopSignal, and StopTimeout are like wrap -forward-signals, -stop-signal, and -stop-timeout
+	ForwardSignals string `json:"forward_signals"`
+	StopSignal     string `json:"stop_signal"`
+	StopTimeout    string `json:"stop_timeout"`
+	// Cache and CacheTTL are like wrap -cache and -cache-ttl
+	Cache    int64  `json:"cache"`
+	CacheTTL string `json:"cache_ttl"`
+}
+
+// args returns the equivalent arguments to the wrap command
+func (wc *wrapConfig) args() []string {
+	args := []string{}
+	if wc.Port != nil {
+		args = append(args, "-port", strconv.FormatUint(uint64(*wc.Port), 10))
+	}
+	if wc.Socket != "" {
+		args = append(args, "-socket", wc.Socket)
+	}
+	if wc.Match != "" {
+		args = append(args, "-match", wc.Match)
+	}
+	if wc.H2C {
+		args = append(args, "-h2c")
+	}
+	if wc.TTY {
+		args = append(args, "-tty")
+	}
+	if wc.HealthURL != "" {
+		args = append(args, "-health-url", wc.HealthURL)
+	}
+	if wc.Wait != "" {
+		args = append(args, "-wait", wc.Wait)
+	}
+	if wc.HealthInterval != "" {
+		args = append(args, "-health-interval", wc.HealthInterval)
+	}
+	if wc.HealthFailures != 0 {
+		args = append(args, "-health-failures", strconv.Itoa(wc.HealthFailures))
+	}
+	if wc.ForwardSignals != "" {
+		args = append(args, "-forward-signals", wc.ForwardSignals)
+	}
+	if wc.StopSignal != "" {
+		args = append(args, "-stop-signal", wc.StopSignal)
+	}
+	if wc.StopTimeout != "" {
+		args = append(args, "-stop-timeout", wc.StopTimeout)
+	}
+	if wc.Cache != 0 {
+		args = append(args, "-cache", strconv.FormatInt(wc.Cache, 10))
+	}
+	if wc.CacheTTL != "" {
+		args = append(args, "-cache-ttl", wc.CacheTTL)
+	}
+	if wc.Restart != "" {
+		args = append(args, "-restart", wc.Restart)
+	}
+	if wc.MaxRestarts != 0 {
+		args = append(args, "-max-restarts", strconv.Itoa(wc.MaxRestarts))
+	}
+	return append(append(args, "--"), wc.Command...)
+}
+
+// wrapArgs returns the equivalent arguments to the wrap command for the commands that cfg wraps
+func (cfg *config) wrapArgs() []string {
+	wcs := cfg.Wraps
+	if cfg.Wrap != nil {
+		wcs = append([]*wrapConfig{cfg.Wrap}, wcs...)
+	}
+	var args []string
+	for i, wc := range wcs {
+		if i > 0 {
+			args = append(args, wrapSeparator, "wrap")
+		}
+		args = append(args, wc.args()...)
+	}
+	return args
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// robotsTxt are the robots.txt files that -robots can name instead of a file
+var robotsTxt = map[string]string{
+	"disallow": "User-agent: *\nDisallow: /\n",
+	"allow":    "User-agent: *\nDisallow:\n",
+}
+
+// crawlerFile is a file that crawlers and browsers ask every host for, served by -robots and -favicon
+type crawlerFile struct {
+	contentType string
+	// body is nil for the file to be answered with 204 No Content
+	body []byte
+}
+
+// crawlerFiles returns the files for -robots and -favicon by path. robots is disallow, allow, or a file, and favicon is
+// none or a file, and either may be empty for that file not to be served.
+func crawlerFiles(robots, favicon string) (map[string]crawlerFile, error) {
+	files := make(map[string]crawlerFile)
+	if robots != "" {
+		body, ok := robotsTxt[robots]
+		if !ok {
+			data, err := os.ReadFile(robots)
+			if err != nil {
+				return nil, fmt.Errorf("-robots: %v", err)
+			}
+			body = string(data)
+		}
+		files["/robots.txt"] = crawlerFile{contentType: "text/plain; charset=utf-8", body: []byte(body)}
+	}
+	if favicon == "none" {
+		files["/favicon.ico"] = crawlerFile{}
+	} else if favicon != "" {
+		data, err := os.ReadFile(favicon)
+		if err != nil {
+			return nil, fmt.Errorf("-favicon: %v", err)
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(favicon))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		files["/favicon.ico"] = crawlerFile{contentType: contentType, body: data}
+	}
+	return files, nil
+}
+
+// crawlerFilesHandler serves files to the GET and HEAD requests for their paths on every host, and passes every other
+// request to next. They take precedence over wildcard routes, so that a host that redirects everything still keeps
+// crawlers out, but not over routes for their exact paths. They're served before the requests reach the redirector, so
+// they aren't counted or logged as misses.
+func crawlerFilesHandler(re *redirector.Redirector, files map[string]crawlerFile, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		f, ok := files[req.URL.Path]
+		if !ok || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if route, ok := re.Match(req); ok && strings.HasSuffix(route.Pattern, req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		if f.body == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", f.contentType)
+		w.Write(f.body)
+	})
+}
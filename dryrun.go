@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// printDryRun prints the effective routes and listeners for -dry-run
+func printDryRun(routes []*redirector.Route, listeners []string, wc wrapped) {
+	fmt.Printf("📋 routes (%d):\n", len(routes))
+	for _, r := range routes {
+		fmt.Printf("   %s\n", r.Redacted())
+	}
+	fmt.Printf("📡 listeners:\n")
+	for _, l := range listeners {
+		fmt.Printf("   %s\n", l)
+	}
+	for _, c := range wc {
+		fmt.Printf("🌯 wrapped command: %s\n", c)
+		if c.match != "" {
+			fmt.Printf("   unmatched requests for %s are forwarded to http://%s\n", c.match, c.addr())
+		} else {
+			fmt.Printf("   unmatched requests are forwarded to http://%s\n", c.addr())
+		}
+	}
+	fmt.Printf("✅ dry run complete, exiting without serving\n")
+}
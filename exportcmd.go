@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// exportCommand is the `export` command. It returns the process's exit code. storePath is the -store database, if
+// any, whose changes are applied on top of the configured routes.
+func exportCommand(rf *routeFlags, storePath string, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "json", `the format to export: "json", "csv", or "netlify" for a _redirects file.`)
+	output := fs.String("o", "-", `the file to write the routes to, or "-" for stdout.`)
+	from := fs.String("from", "", "export the routes of a running redirector from its admin api at this url, e.g. http://127.0.0.1:8081, instead\nof loading them. $REDIRECTOR_ADMIN_TOKEN is sent as its bearer token.")
+	rf.register(fs)
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+📤⛳ export flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *format != "json" && *format != "csv" && *format != "netlify" {
+		fmt.Printf("🚨 unknown format %q\n", *format)
+		return 1
+	}
+
+	var (
+		routes []*redirector.Route
+		err    error
+	)
+	if *from != "" {
+		routes, err = fetchAdminRoutes(*from, os.Getenv("REDIRECTOR_ADMIN_TOKEN"))
+	} else {
+		var ok bool
+		if routes, ok = loadEffectiveRoutes(rf, storePath); !ok {
+			return 1
+		}
+	}
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Printf("🚨 %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	exitCode := 0
+	switch *format {
+	case "json":
+		if routes == nil {
+			routes = []*redirector.Route{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(routes)
+	case "csv":
+		err = writeRoutesCSV(w, routes)
+	case "netlify":
+		for _, r := range routes {
+			// netlify has no lists of hosts, so routes with one become a rule per host
+			for _, pattern := range r.Patterns() {
+				route := *r
+				route.Pattern = pattern
+				rule, err := toNetlify(&route)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "❌ %s: %v\n", pattern, err)
+					exitCode = 1
+					continue
+				}
+				fmt.Fprintln(w, rule)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	if *output != "-" {
+		fmt.Printf("✅ wrote %d route(s) to %s\n", len(routes), *output)
+	}
+	return exitCode
+}
+
+// loadEffectiveRoutes loads the routes from the flags, files, dynamic sources, and the -store database, the way the
+// server would, printing any errors
+func loadEffectiveRoutes(rf *routeFlags, storePath string) ([]*redirector.Route, bool) {
+	routes, errs := rf.load()
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+	}
+	if len(errs) > 0 {
+		return nil, false
+	}
+	stores, err := rf.stores(newReloadStore(rf, routes))
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return nil, false
+	}
+	if storePath != "" {
+		db, err := openStore(storePath)
+		if err != nil {
+			fmt.Printf("🚨 -store: %v (is redirector running? use -from to export from its admin api)\n", err)
+			return nil, false
+		}
+		defer db.Close()
+		overlay := newOverlayStore(stores...)
+		if err := overlay.persistTo(context.Background(), db); err != nil {
+			fmt.Printf("🚨 -store: %v\n", err)
+			return nil, false
+		}
+		stores = []redirector.RouteSource{overlay}
+	}
+
+	re := redirector.New(nil)
+	if err := redirector.Load(context.Background(), re, stores...); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return nil, false
+	}
+	return re.Routes(), true
+}
+
+// fetchAdminRoutes lists the routes of a running redirector through its admin api
+func fetchAdminRoutes(base, token string) ([]*redirector.Route, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(base, "/")+"/routes", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		return nil, fmt.Errorf("admin api: %s: %s", res.Status, e.Error)
+	}
+	var routes []*redirector.Route
+	if err := json.NewDecoder(res.Body).Decode(&routes); err != nil {
+		return nil, fmt.Errorf("admin api: %v", err)
+	}
+	return routes, nil
+}
+
+// writeRoutesCSV writes a row per route with its pattern, the rest of the route in the -route syntax, its code, and
+// where it was configured
+func writeRoutesCSV(w io.Writer, routes []*redirector.Route) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"pattern", "route", "code", "source"})
+	for _, r := range routes {
+		code := ""
+		if r.Code != 0 {
+			code = strconv.Itoa(r.Code)
+		}
+		cw.Write([]string{r.Pattern, strings.TrimPrefix(r.String(), r.Pattern+" "), code, r.Source})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// netlifyWildcard is a named wildcard in a pattern or a placeholder in a destination, such as {year} or {*}
+var netlifyWildcard = regexp.MustCompile(`\{([a-z0-9_*]+)\}`)
+
+// toNetlify converts a route to a rule of a Netlify _redirects file. Not every route can be expressed as one.
+func toNetlify(r *redirector.Route) (string, error) {
+	if r.Response != nil || r.Upstream != nil || r.Files != "" {
+		return "", errors.New("routes that don't redirect can't be exported")
+	}
+	if len(r.Split) > 0 {
+		return "", errors.New("split routes can't be exported")
+	}
+	if r.Destination == nil {
+		return "", errors.New("routes with a resolver can't be exported")
+	}
+	if strings.HasPrefix(r.Pattern, "~") {
+		return "", errors.New("routes with a regular expression pattern can't be exported")
+	}
+	if r.MergeQuery || len(r.AddQuery) > 0 || len(r.DropQuery) > 0 {
+		return "", errors.New("netlify doesn't support merge_query, add_query, or drop_query")
+	}
+	if len(r.Headers) > 0 || r.Priority != 0 || r.LogOnly || r.Refresh || len(r.Allow) > 0 || len(r.Deny) > 0 {
+		return "", errors.New("netlify doesn't support headers, priorities, log-only or refresh routes, or allow and deny lists")
+	}
+	if r.Auth != "" {
+		return "", errors.New("netlify doesn't support auth on redirects")
+	}
+	rest := *r
+	rest.Countries, rest.MatchQuery = nil, nil
+	var cookie string
+	if len(r.MatchCookies) == 1 {
+		// netlify only checks whether a cookie is set
+		for name, values := range r.MatchCookies {
+			if len(values) == 1 && values[0] == "" {
+				cookie, rest.MatchCookies = name, nil
+			}
+		}
+	}
+	if c := rest.Conditions(); c != "" {
+		return "", fmt.Errorf("netlify doesn't support conditions such as %s", c)
+	}
+	switch r.Code {
+	case 301, 302, 303, 307, 308:
+	default:
+		return "", fmt.Errorf("netlify doesn't support code %d", r.Code)
+	}
+
+	i := strings.IndexByte(r.Pattern, '/')
+	host, path := r.Pattern[:i], r.Pattern[i:]
+	if strings.Contains(host, "*") || strings.Contains(host, "{") {
+		if host != "*" {
+			return "", errors.New("netlify doesn't support wildcards in hostnames")
+		}
+		host = ""
+	}
+	segments := strings.Split(path, "/")
+	for j, seg := range segments {
+		if seg == "**" {
+			return "", errors.New("netlify doesn't support /** patterns, which only match when no other pattern does")
+		}
+		if seg == "*" && j != len(segments)-1 {
+			return "", errors.New("netlify only supports wildcards at the end of the path")
+		}
+	}
+	from := netlifyWildcard.ReplaceAllString(path, ":$1")
+	if host != "" {
+		from = "https://" + host + from
+	}
+
+	// the json form of a route has its destination with the placeholders unescaped
+	var doc struct {
+		Destination string `json:"destination"`
+	}
+	if b, err := json.Marshal(r); err == nil {
+		json.Unmarshal(b, &doc)
+	}
+	dest := doc.Destination
+	var unsupported string
+	dest = netlifyWildcard.ReplaceAllStringFunc(dest, func(p string) string {
+		name := p[1 : len(p)-1]
+		switch {
+		case name == "*":
+			return ":splat"
+		case name == "host" || name == "path" || name == "query" || (len(name) == 1 && name[0] >= '1' && name[0] <= '9'):
+			unsupported = p
+		}
+		return ":" + name
+	})
+	if unsupported != "" {
+		return "", fmt.Errorf("netlify doesn't support the %s placeholder", unsupported)
+	}
+	if r.CarryPath {
+		// netlify can only append what the splat matched, which is the carried path when the rest of the pattern is
+		// stripped from it
+		prefix := strings.TrimSuffix(path, "/*")
+		if !strings.HasSuffix(path, "/*") || strings.Contains(prefix, "*") || strings.Contains(prefix, "{") || strings.TrimSuffix(r.StripPrefix, "/") != prefix {
+			return "", errors.New("routes that carry the path can only be exported if it's stripped down to the final wildcard")
+		}
+		dest = strings.TrimSuffix(dest, "/") + "/:splat"
+	}
+
+	names := make([]string, 0, len(r.MatchQuery))
+	for name := range r.MatchQuery {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{from}
+	for _, name := range names {
+		for _, v := range r.MatchQuery[name] {
+			if v == "" {
+				parts = append(parts, name+"=:"+name)
+			} else {
+				parts = append(parts, name+"="+v)
+			}
+		}
+	}
+	parts = append(parts, dest, strconv.Itoa(r.Code)+"!")
+	if len(r.Countries) > 0 {
+		parts = append(parts, "Country="+strings.ToLower(strings.Join(r.Countries, ",")))
+	}
+	if cookie != "" {
+		parts = append(parts, "Cookie="+cookie)
+	}
+	return strings.Join(parts, " "), nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var _ flag.Value = new(strslice)
+
+type strslice []string
+
+func (i *strslice) String() string {
+	return fmt.Sprint([]string(*i))
+}
+
+func (i *strslice) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
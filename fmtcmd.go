@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// fmtCommand is the `fmt` command. It returns the process's exit code.
+func fmtCommand(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result to the file instead of stdout.")
+	list := fs.Bool("l", false, "list files whose formatting differs instead of printing them.")
+	lint := fs.Bool("lint", false, "report suspicious routes instead of formatting. exits with a non-zero code if any are found.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+🧹⛳ fmt flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Printf("🚨 at least one routes file must be set\n")
+		return 1
+	}
+
+	exitCode := 0
+	for _, path := range fs.Args() {
+		lines, err := readRoutesFile(path)
+		if err != nil {
+			fmt.Printf("🚨 %v\n", err)
+			exitCode = 1
+			continue
+		}
+		hasErr := false
+		for _, l := range lines {
+			if l.err != nil {
+				fmt.Printf("❌ %s:%d: %v\n", path, l.n, l.err)
+				hasErr = true
+			}
+		}
+		if hasErr {
+			exitCode = 1
+			continue
+		}
+
+		if *lint {
+			for _, l := range lines {
+				if l.route == nil {
+					continue
+				}
+				for _, warning := range lintRoute(l.route) {
+					fmt.Printf("⚠️  %s:%d: %s\n", path, l.n, warning)
+					exitCode = 1
+				}
+			}
+			continue
+		}
+
+		var buf bytes.Buffer
+		for _, l := range lines {
+			if l.route != nil {
+				buf.WriteString(formatRoute(l.route))
+			} else {
+				buf.WriteString(strings.TrimSpace(l.text))
+			}
+			buf.WriteByte('\n')
+		}
+		original, _ := os.ReadFile(path)
+		switch {
+		case *list:
+			if !bytes.Equal(original, buf.Bytes()) {
+				fmt.Println(path)
+			}
+		case *write:
+			if !bytes.Equal(original, buf.Bytes()) {
+				if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+					fmt.Printf("🚨 %v\n", err)
+					exitCode = 1
+				}
+			}
+		default:
+			os.Stdout.Write(buf.Bytes())
+		}
+	}
+	return exitCode
+}
+
+// formatRoute returns the canonical form of a route: lowercase hostnames, options in canonical order, and an explicit
+// code
+func formatRoute(r *redirector.Route) string {
+	route := *r
+	if i := strings.IndexByte(route.Pattern, '/'); i != -1 && !strings.HasPrefix(route.Pattern, "~") {
+		route.Pattern = strings.ToLower(route.Pattern[:i]) + route.Pattern[i:]
+	}
+	if route.Destination != nil {
+		u := *route.Destination
+		u.Host = strings.ToLower(u.Host)
+		route.Destination = &u
+	}
+	if len(r.Split) > 0 {
+		route.Split = make([]redirector.SplitDestination, len(r.Split))
+		for i, split := range r.Split {
+			u := *split.Destination
+			u.Host = strings.ToLower(u.Host)
+			route.Split[i] = redirector.SplitDestination{Weight: split.Weight, Destination: &u}
+		}
+	}
+	return route.String()
+}
+
+// lintRoute returns warnings about suspicious but valid routes
+func lintRoute(r *redirector.Route) []string {
+	var warnings []string
+	i := strings.IndexByte(r.Pattern, '/')
+	if i == -1 || r.Destination == nil || strings.HasPrefix(r.Pattern, "~") {
+		return nil
+	}
+	host, path := r.Pattern[:i], r.Pattern[i:]
+
+	if strings.HasSuffix(path, "/*") && !r.CarryPath {
+		warnings = append(warnings, fmt.Sprintf("%q matches any path but doesn't carry it over; add \"path\" unless every request should land on %s", r.Pattern, r.Destination))
+	}
+	if path == "/*" && r.Code == 302 && !strings.EqualFold(host, r.Destination.Host) {
+		warnings = append(warnings, fmt.Sprintf("%q moves a whole host with a temporary 302; use code=301 if the move is permanent", r.Pattern))
+	}
+	if r.Destination.Scheme == "http" && !isLocal(r.Destination) {
+		warnings = append(warnings, fmt.Sprintf("%q redirects to plain http; use https unless %s doesn't support it", r.Pattern, r.Destination.Host))
+	}
+	return warnings
+}
+
+func isLocal(u *url.URL) bool {
+	h := u.Hostname()
+	return h == "localhost" || h == "127.0.0.1" || h == "::1"
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// forwardedScheme returns the scheme of req from its X-Forwarded-Proto header, as set by a load balancer that
+// terminates tls, or from its connection if the header isn't set
+func forwardedScheme(req *http.Request) string {
+	proto := req.Header.Get("X-Forwarded-Proto")
+	if i := strings.IndexByte(proto, ','); i != -1 {
+		// the proxy closest to the client comes first
+		proto = proto[:i]
+	}
+	switch proto = strings.ToLower(strings.TrimSpace(proto)); proto {
+	case "http", "https":
+		return proto
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// unixPeer is the remote address of requests over a unix socket
+const unixPeer = "@"
+
+// trustedProxies are the networks of proxies whose X-Forwarded-* headers are trusted
+type trustedProxies redirector.IPList
+
+// parseTrustedProxies parses a comma-separated list of CIDRs or ip addresses
+func parseTrustedProxies(s string) (trustedProxies, error) {
+	l, err := redirector.ParseIPList(strings.Split(s, ","))
+	return trustedProxies(l), err
+}
+
+func (tp trustedProxies) trusted(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	return ip != nil && redirector.IPList(tp).Contains(ip)
+}
+
+// handler takes the host and client address of requests from trusted proxies from their X-Forwarded-Host and
+// X-Forwarded-For headers, and removes the X-Forwarded-* headers of requests from anyone else so that they can't be
+// spoofed. Requests over a unix socket are always from a trusted proxy, since only local processes can connect to it.
+func (tp trustedProxies) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		peer, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			peer = req.RemoteAddr
+		}
+		if !tp.trusted(peer) && peer != unixPeer {
+			for _, name := range []string{"X-Forwarded-Host", "X-Forwarded-For", "X-Forwarded-Proto"} {
+				req.Header.Del(name)
+			}
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		req = req.Clone(req.Context())
+		if host := req.Header.Get("X-Forwarded-Host"); host != "" {
+			if i := strings.IndexByte(host, ','); i != -1 {
+				host = host[:i]
+			}
+			req.Host = strings.TrimSpace(host)
+		}
+		// the client is the last address that isn't one of the proxies, since each proxy appends the address it got
+		// the request from
+		var addrs []string
+		for _, v := range req.Header.Values("X-Forwarded-For") {
+			addrs = append(addrs, strings.Split(v, ",")...)
+		}
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(addrs[i])
+			if net.ParseIP(addr) == nil {
+				break
+			}
+			req.RemoteAddr = addr
+			if !tp.trusted(addr) {
+				break
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoIP finds the countries of requests in a MaxMind GeoIP2 or GeoLite2 country or city database
+type geoIP struct {
+	db *maxminddb.Reader
+}
+
+func openGeoIP(path string) (*geoIP, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &geoIP{db: db}, nil
+}
+
+// country returns the ISO code of the country that req came from, or an empty string if it isn't known
+func (g *geoIP) country(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := g.db.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
@@ -1,10 +1,18 @@
 module github.com/kamaln7/redirector
 
-go 1.13
+go 1.19
 
 require (
-	github.com/armon/go-radix v1.0.0 // indirect
-	github.com/fanyang01/radix v0.0.0-20160415095728-e1747dd9eeac
+	github.com/BurntSushi/toml v1.6.0
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
-	github.com/stretchr/testify v1.6.1 // indirect
+	github.com/oschwald/maxminddb-golang v1.12.0
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.14.0
+	golang.org/x/net v0.10.0
+	golang.org/x/sys v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
+	rsc.io/qr v0.2.0
 )
+
+require golang.org/x/text v0.13.0 // indirect
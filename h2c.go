@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2cHandler serves h over http/2 without tls too, for clients that speak it in cleartext such as grpc, alongside
+// http/1.1
+func h2cHandler(h http.Handler) http.Handler {
+	return h2c.NewHandler(h, &http2.Server{})
+}
+
+// h2cTransport speaks http/2 without tls, with prior knowledge, to upstreams that only serve h2c, connecting to them
+// with dial
+func h2cTransport(dial func(ctx context.Context, network, addr string) (net.Conn, error)) http.RoundTripper {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}
+}
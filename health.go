@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthHandler responds to health checks
+func healthHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyHandler responds to readiness checks. When wrapping commands, redirector is only ready once each command
+// accepts connections on its port, or passes its health check, and with -health-interval until it fails too many checks.
+func readyHandler(wc wrapped) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if wc != nil {
+			if err := wc.healthy(); err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintln(w, err)
+				return
+			}
+		}
+		fmt.Fprintln(w, "ready")
+	}
+}
+
+// healthcheckCommand is the `healthcheck` command. It returns the process's exit code.
+func healthcheckCommand(args []string) int {
+	port := "8080"
+	if p := os.Getenv("PORT"); p != "" {
+		port = p
+	}
+
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	fs.StringVar(&port, "port", port, "the port that redirector is running on. defaults to $PORT or 8080.")
+	path := fs.String("path", "/healthz", "the health endpoint's path, as set with -health-path.")
+	timeout := fs.Duration("timeout", 3*time.Second, "how long to wait for a response.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+🩺⛳ healthcheck flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: *timeout}
+	res, err := client.Get(fmt.Sprintf("http://127.0.0.1:%s%s", port, *path))
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		fmt.Printf("🚨 got status %d\n", res.StatusCode)
+		return 1
+	}
+	return 0
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
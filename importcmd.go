@@ -0,0 +1,863 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// importedRoute is a route translated from a directive of another server's config, or the reason it couldn't be
+type importedRoute struct {
+	line      int
+	directive string
+	route     string
+	err       error
+}
+
+// importCommand is the `import` command. It returns the process's exit code.
+func importCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "nginx" && args[0] != "htaccess") {
+		fmt.Printf("🚨 usage: redirector import nginx|htaccess [flags] <file>\n")
+		return 1
+	}
+	format := args[0]
+	fs := flag.NewFlagSet("import "+format, flag.ExitOnError)
+	host := fs.String("host", "", "the hostname that the rules are on, for .htaccess files and nginx servers without a server_name.\nthey match any hostname by default.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+📥⛳ import flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fmt.Printf("🚨 usage: redirector import %s [flags] <file>\n", format)
+		return 1
+	}
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+
+	var imported []importedRoute
+	if format == "nginx" {
+		imported, err = importNginx(string(data), *host)
+	} else {
+		imported = importHtaccess(string(data), *host)
+	}
+	if err != nil {
+		fmt.Printf("🚨 %s: %v\n", path, err)
+		return 1
+	}
+
+	exitCode := 0
+	for _, ir := range imported {
+		if ir.err == nil {
+			var r *redirector.Route
+			if r, ir.err = redirector.NewRoute(ir.route); ir.err == nil {
+				ir.err = r.Validate()
+			}
+			if ir.err == nil {
+				fmt.Println(formatRoute(r))
+				continue
+			}
+		}
+		fmt.Fprintf(os.Stderr, "❌ %s:%d: %s: %v\n", path, ir.line, ir.directive, ir.err)
+		exitCode = 1
+	}
+	return exitCode
+}
+
+// importPattern returns the pattern of the requests for path on host, which may be empty for any host. Prefixes match
+// the path and anything under it.
+func importPattern(host, path string, prefix bool) string {
+	if host == "" {
+		host = "*"
+	}
+	if !prefix {
+		return host + path
+	}
+	return host + strings.TrimSuffix(path, "/") + "/*"
+}
+
+// importRegexp converts a regular expression matched against request paths to a regular expression pattern, which is
+// matched against the whole {hostname}/{path} of requests without leading or trailing slashes in the path
+func importRegexp(host, re string, caseInsensitive bool) (string, error) {
+	if strings.HasPrefix(re, "^") {
+		re = strings.TrimPrefix(strings.TrimPrefix(re, "^"), "/")
+	} else {
+		re = "(?:.*/)?" + strings.TrimPrefix(re, "/")
+	}
+	if strings.HasSuffix(re, "$") && !strings.HasSuffix(re, `\$`) {
+		re = strings.TrimSuffix(re, "$")
+	} else {
+		re += ".*"
+	}
+	if strings.HasSuffix(re, "/") {
+		re = strings.TrimSuffix(re, "/") + "/?"
+	}
+
+	h := `[^/]+`
+	if host != "" {
+		h = regexp.QuoteMeta(host)
+		if strings.HasPrefix(host, "*.") {
+			h = `[^/]+\.` + regexp.QuoteMeta(host[2:])
+		}
+	}
+	pattern := h + "/" + re
+	if caseInsensitive {
+		pattern = "(?i)" + pattern
+	}
+	if strings.ContainsAny(pattern, " \t") {
+		return "", errors.New("regular expressions with whitespace aren't supported")
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return "", err
+	}
+	return "~" + pattern, nil
+}
+
+// importDestination converts a destination with variables to one with placeholders, returning it and the options it
+// needs. vars maps the variables that can be filled in to their values, and variables such as $request_uri that
+// carry the request's path and query are turned into options when they end the destination. $1 through $9 are kept
+// for regular expression patterns.
+func importDestination(dest, host string, captures bool, vars map[string]string, carry []importCarry) (string, []string, error) {
+	var options []string
+	for _, c := range carry {
+		if strings.HasSuffix(dest, c.variable) {
+			dest, options = strings.TrimSuffix(dest, c.variable), c.options
+			break
+		}
+	}
+	for v, value := range vars {
+		if !strings.Contains(dest, v) {
+			continue
+		}
+		if value == "" || strings.HasPrefix(value, "*") {
+			return "", nil, fmt.Errorf("%s needs a single hostname to fill in; set -host or server_name", v)
+		}
+		dest = strings.ReplaceAll(dest, v, value)
+	}
+	if strings.HasPrefix(dest, "/") {
+		if host == "" || strings.HasPrefix(host, "*") {
+			return "", nil, errors.New("destinations without a hostname need a single hostname to redirect to; set -host or server_name")
+		}
+		dest = host + dest
+	}
+	if dest == "" {
+		return "", nil, errors.New("missing destination")
+	}
+	for i := 0; i < len(dest); i++ {
+		if dest[i] != '$' && dest[i] != '%' {
+			continue
+		}
+		if dest[i] == '$' && i+1 < len(dest) && dest[i+1] >= '1' && dest[i+1] <= '9' && captures {
+			continue
+		}
+		if dest[i] == '%' && i+2 < len(dest) && isHex(dest[i+1]) && isHex(dest[i+2]) {
+			// an escaped character
+			continue
+		}
+		end := i + 1
+		for end < len(dest) && (dest[end] == '_' || dest[end] == '{' || dest[end] == '}' || isAlnum(dest[end])) {
+			end++
+		}
+		return "", nil, fmt.Errorf("the variable %s isn't supported", dest[i:end])
+	}
+	if strings.ContainsAny(dest, " \t") {
+		return "", nil, errors.New("destinations with whitespace aren't supported")
+	}
+	return dest, options, nil
+}
+
+// importCarry is a variable that carries the request's path or query when it ends a destination, and the options
+// that do the same. The longest variables come first, since they may end with shorter ones.
+type importCarry struct {
+	variable string
+	options  []string
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isAlnum(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// importRedirectCode checks that code is a redirect
+func importRedirectCode(code int) error {
+	switch code {
+	case 301, 302, 303, 307, 308:
+		return nil
+	}
+	return fmt.Errorf("status %d isn't a redirect", code)
+}
+
+// importRoute joins a route's parts in the -route syntax
+func importRoute(pattern, dest string, options []string, code int) string {
+	return strings.Join(append(append([]string{pattern, dest}, options...), "code="+strconv.Itoa(code)), " ")
+}
+
+// nginxDirective is a directive in an nginx config, with the directives of its block if it has one
+type nginxDirective struct {
+	line     int
+	args     []string
+	children []*nginxDirective
+}
+
+func (d *nginxDirective) String() string {
+	return strings.Join(d.args, " ")
+}
+
+// parseNginx parses an nginx config into its directives
+func parseNginx(data string) ([]*nginxDirective, error) {
+	var (
+		root  = &nginxDirective{}
+		stack = []*nginxDirective{root}
+		cur   *nginxDirective
+		line  = 1
+	)
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+		case c == ' ' || c == '\t' || c == '\r':
+		case c == '#':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+		case c == ';' || c == '{':
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: unexpected %q", line, c)
+			}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, cur)
+			if c == '{' {
+				stack = append(stack, cur)
+			}
+			cur = nil
+		case c == '}':
+			if cur != nil || len(stack) == 1 {
+				return nil, fmt.Errorf("line %d: unexpected }", line)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if cur == nil {
+				cur = &nginxDirective{line: line}
+			}
+			var word strings.Builder
+			if c == '"' || c == '\'' {
+				quote := c
+				for i++; i < len(data) && data[i] != quote; i++ {
+					if data[i] == '\\' && i+1 < len(data) {
+						i++
+					}
+					if data[i] == '\n' {
+						line++
+					}
+					word.WriteByte(data[i])
+				}
+			} else {
+				for ; i < len(data) && !strings.ContainsRune(" \t\r\n;{}", rune(data[i])); i++ {
+					word.WriteByte(data[i])
+				}
+				i--
+			}
+			cur.args = append(cur.args, word.String())
+		}
+	}
+	if cur != nil || len(stack) != 1 {
+		return nil, errors.New("unexpected end of file")
+	}
+	return root.children, nil
+}
+
+var (
+	nginxVars = func(host string) map[string]string {
+		return map[string]string{"$scheme": "https", "$host": host, "$http_host": host, "$server_name": host}
+	}
+	nginxCarry = []importCarry{
+		{"$uri$is_args$query_string", []string{"path", "query"}},
+		{"$uri$is_args$args", []string{"path", "query"}},
+		{"$is_args$query_string", []string{"query"}},
+		{"$is_args$args", []string{"query"}},
+		{"?$query_string", []string{"query"}},
+		{"$request_uri", []string{"path", "query"}},
+		{"?$args", []string{"query"}},
+		{"$uri", []string{"path"}},
+	}
+)
+
+// importNginx translates the return and rewrite directives of an nginx config to routes. Routes are made for each of
+// the server_names of a server, or host if it has none.
+func importNginx(data, host string) ([]importedRoute, error) {
+	directives, err := parseNginx(data)
+	if err != nil {
+		return nil, err
+	}
+	var imported []importedRoute
+	var walk func(ds []*nginxDirective)
+	walk = func(ds []*nginxDirective) {
+		for _, d := range ds {
+			switch d.args[0] {
+			case "http":
+				walk(d.children)
+			case "server":
+				hosts, scheme := []string{host}, nginxScheme(d.children)
+				for _, c := range d.children {
+					if c.args[0] == "server_name" && len(c.args) > 1 {
+						hosts = nil
+						for _, h := range c.args[1:] {
+							if h == "_" || h == "" {
+								h = host
+							}
+							hosts = append(hosts, strings.TrimPrefix(h, "."))
+						}
+					}
+				}
+				for _, h := range hosts {
+					imported = append(imported, importNginxBlock(d.children, h, nginxLocation{prefix: true, path: "/", scheme: scheme})...)
+				}
+			}
+		}
+	}
+	walk(directives)
+	if len(imported) == 0 {
+		// the file may be a server block's contents, such as an include
+		imported = importNginxBlock(directives, host, nginxLocation{prefix: true, path: "/"})
+	}
+	return imported, nil
+}
+
+// nginxLocation is the location block that directives are in
+type nginxLocation struct {
+	path            string
+	prefix          bool
+	regexp          bool
+	caseInsensitive bool
+	// scheme is the scheme that the server block is limited to, if it only listens for http or https
+	scheme string
+}
+
+// nginxScheme returns the scheme that a server block listens for: http if none of its listen directives use ssl,
+// which includes servers without any, https if all of them do, and nothing if it listens for both
+func nginxScheme(ds []*nginxDirective) string {
+	plain, ssl := false, false
+	for _, d := range ds {
+		if d.args[0] != "listen" {
+			continue
+		}
+		if containsString(d.args[1:], "ssl") || containsString(d.args[1:], "quic") {
+			ssl = true
+		} else {
+			plain = true
+		}
+	}
+	switch {
+	case ssl && plain:
+		return ""
+	case ssl:
+		return "https"
+	}
+	return "http"
+}
+
+func importNginxBlock(ds []*nginxDirective, host string, loc nginxLocation) []importedRoute {
+	var imported []importedRoute
+	for _, d := range ds {
+		fail := func(err error) {
+			imported = append(imported, importedRoute{line: d.line, directive: d.String(), err: err})
+		}
+		if strings.HasPrefix(host, "~") {
+			if d.args[0] == "return" || d.args[0] == "rewrite" {
+				fail(errors.New("regular expression server names aren't supported"))
+			}
+			continue
+		}
+		switch d.args[0] {
+		case "location":
+			l, err := nginxParseLocation(d.args[1:])
+			if err != nil {
+				fail(err)
+				continue
+			}
+			if l.path != "" {
+				l.scheme = loc.scheme
+				imported = append(imported, importNginxBlock(d.children, host, l)...)
+			}
+		case "if":
+			// only conditions on the host are supported, such as sending www to the apex domain
+			cond := strings.Trim(strings.Join(d.args[1:], " "), "()")
+			f := strings.Fields(cond)
+			if len(f) == 3 && (f[0] == "$host" || f[0] == "$http_host") && f[1] == "=" {
+				imported = append(imported, importNginxBlock(d.children, strings.Trim(f[2], `"'`), loc)...)
+			} else {
+				for _, c := range d.children {
+					if c.args[0] == "return" || c.args[0] == "rewrite" {
+						imported = append(imported, importedRoute{line: c.line, directive: c.String(), err: fmt.Errorf("the condition %q isn't supported", cond)})
+					}
+				}
+			}
+		case "return":
+			route, err := nginxReturn(d.args[1:], host, loc)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			imported = append(imported, importedRoute{line: d.line, directive: d.String(), route: route})
+		case "rewrite":
+			route, err := nginxRewrite(d.args[1:], host, loc.scheme)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			imported = append(imported, importedRoute{line: d.line, directive: d.String(), route: route})
+		}
+	}
+	return imported
+}
+
+// nginxParseLocation parses the arguments of a location directive. Named locations have no path, since requests can't
+// reach them directly.
+func nginxParseLocation(args []string) (nginxLocation, error) {
+	if len(args) == 0 {
+		return nginxLocation{}, errors.New("location is missing a path")
+	}
+	if len(args) == 1 {
+		if strings.HasPrefix(args[0], "@") {
+			return nginxLocation{}, nil
+		}
+		return nginxLocation{path: args[0], prefix: true}, nil
+	}
+	switch args[0] {
+	case "=":
+		return nginxLocation{path: args[1]}, nil
+	case "^~":
+		return nginxLocation{path: args[1], prefix: true}, nil
+	case "~":
+		return nginxLocation{path: args[1], regexp: true}, nil
+	case "~*":
+		return nginxLocation{path: args[1], regexp: true, caseInsensitive: true}, nil
+	}
+	return nginxLocation{}, fmt.Errorf("unknown location modifier %q", args[0])
+}
+
+// nginxReturn translates `return [code] <url>`
+func nginxReturn(args []string, host string, loc nginxLocation) (string, error) {
+	code, dest := 302, ""
+	switch len(args) {
+	case 1:
+		dest = args[0]
+		if c, err := strconv.Atoi(dest); err == nil {
+			if c != 410 {
+				return "", errors.New("only returns that redirect are supported")
+			}
+			code = c
+		}
+	case 2:
+		c, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", fmt.Errorf("invalid code %q", args[0])
+		}
+		code, dest = c, args[1]
+	default:
+		return "", errors.New("expected a code and a url")
+	}
+	pattern, err := nginxPattern(host, loc)
+	if err != nil {
+		return "", err
+	}
+	if code == 410 {
+		return joinNonEmpty(pattern, "gone", schemeOption(loc.scheme)), nil
+	}
+	if err := importRedirectCode(code); err != nil {
+		return "", err
+	}
+	dest, options, err := importDestination(dest, host, loc.regexp, nginxVars(host), nginxCarry)
+	if err != nil {
+		return "", err
+	}
+	if loc.scheme != "" {
+		options = append(options, schemeOption(loc.scheme))
+	}
+	return importRoute(pattern, dest, options, code), nil
+}
+
+// nginxRewrite translates `rewrite <regex> <replacement> [flag]`, which redirects with the permanent and redirect
+// flags, or when the replacement is a url
+func nginxRewrite(args []string, host, scheme string) (string, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return "", errors.New("expected a regular expression, a replacement, and a flag")
+	}
+	re, dest := args[0], args[1]
+	code := 0
+	if len(args) == 3 {
+		switch args[2] {
+		case "permanent":
+			code = 301
+		case "redirect":
+			code = 302
+		}
+	}
+	if code == 0 {
+		if !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") && !strings.HasPrefix(dest, "$scheme://") {
+			return "", errors.New("rewrites that don't redirect aren't supported")
+		}
+		code = 302
+	}
+
+	pattern, err := importRegexp(host, re, false)
+	if err != nil {
+		return "", err
+	}
+	// nginx appends the query unless the replacement ends with ?
+	keepQuery := !strings.HasSuffix(dest, "?")
+	dest = strings.TrimSuffix(dest, "?")
+	dest, options, err := importDestination(dest, host, true, nginxVars(host), nginxCarry)
+	if err != nil {
+		return "", err
+	}
+	if keepQuery && !containsString(options, "query") {
+		options = append(options, "query")
+	}
+	if scheme != "" {
+		options = append(options, schemeOption(scheme))
+	}
+	return importRoute(pattern, dest, options, code), nil
+}
+
+func schemeOption(scheme string) string {
+	if scheme == "" {
+		return ""
+	}
+	return "scheme=" + scheme
+}
+
+func nginxPattern(host string, loc nginxLocation) (string, error) {
+	if loc.regexp {
+		return importRegexp(host, loc.path, loc.caseInsensitive)
+	}
+	return importPattern(host, loc.path, loc.prefix), nil
+}
+
+func joinNonEmpty(parts ...string) string {
+	var out []string
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	htaccessVars = func(host string) map[string]string {
+		return map[string]string{"%{HTTP_HOST}": host, "%{SERVER_NAME}": host}
+	}
+	htaccessCarry = []importCarry{
+		{"%{REQUEST_URI}", []string{"path"}},
+	}
+	// htaccessHost matches a RewriteCond on the host, such as `%{HTTP_HOST} ^www\.example\.com$ [NC]`
+	htaccessHost = regexp.MustCompile(`^\^((?:[A-Za-z0-9-]|\\\.)+)\$$`)
+)
+
+// importHtaccess translates the Redirect, RedirectMatch, and RewriteRule directives of an .htaccess file to routes on
+// host
+func importHtaccess(data, host string) []importedRoute {
+	var (
+		imported   []importedRoute
+		condHost   string
+		condScheme string
+		conds      []string
+	)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		args := htaccessFields(text)
+		fail := func(err error) {
+			imported = append(imported, importedRoute{line: n, directive: text, err: err})
+		}
+		add := func(route string, err error) {
+			if err != nil {
+				fail(err)
+				return
+			}
+			imported = append(imported, importedRoute{line: n, directive: text, route: route})
+		}
+
+		switch strings.ToLower(args[0]) {
+		case "redirect":
+			add(htaccessRedirect(args[1:], host, 0))
+		case "redirectpermanent":
+			add(htaccessRedirect(args[1:], host, 301))
+		case "redirecttemp":
+			add(htaccessRedirect(args[1:], host, 302))
+		case "redirectmatch":
+			add(htaccessRedirectMatch(args[1:], host))
+		case "rewritebase":
+			if len(args) > 1 && args[1] != "/" {
+				fail(errors.New("rewrite bases other than / aren't supported"))
+			}
+		case "rewritecond":
+			// a condition on the host can pick the host of the next rule, which is how www redirects are often written
+			if len(args) >= 3 && strings.EqualFold(args[1], "%{HTTP_HOST}") {
+				if m := htaccessHost.FindStringSubmatch(args[2]); m != nil && condHost == "" {
+					condHost = strings.ReplaceAll(m[1], `\.`, ".")
+					continue
+				}
+			}
+			// and a condition on https can limit it to one scheme, which is how https redirects are written
+			if len(args) >= 3 && strings.EqualFold(args[1], "%{HTTPS}") && condScheme == "" {
+				switch strings.ToLower(args[2]) {
+				case "off", "!on", "!=on":
+					condScheme = "http"
+					continue
+				case "on", "!off", "!=off":
+					condScheme = "https"
+					continue
+				}
+			}
+			conds = append(conds, text)
+		case "rewriterule":
+			h := host
+			if condHost != "" {
+				h = condHost
+			}
+			if len(conds) > 0 {
+				fail(fmt.Errorf("the condition %q isn't supported", conds[0]))
+			} else {
+				add(htaccessRewriteRule(args[1:], h, condScheme))
+			}
+			condHost, condScheme, conds = "", "", nil
+		}
+	}
+	return imported
+}
+
+// htaccessFields splits a directive into its arguments, which may be quoted
+func htaccessFields(s string) []string {
+	var (
+		fields []string
+		word   strings.Builder
+		quote  byte
+		inWord bool
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				fields = append(fields, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		fields = append(fields, word.String())
+	}
+	return fields
+}
+
+// htaccessStatus parses the optional status argument of Redirect and RedirectMatch, returning the remaining arguments
+func htaccessStatus(args []string, code int) (int, []string, error) {
+	if code != 0 || len(args) == 0 {
+		if code == 0 {
+			code = 302
+		}
+		return code, args, nil
+	}
+	switch strings.ToLower(args[0]) {
+	case "permanent":
+		return 301, args[1:], nil
+	case "temp":
+		return 302, args[1:], nil
+	case "seeother":
+		return 303, args[1:], nil
+	case "gone":
+		return 410, args[1:], nil
+	}
+	if c, err := strconv.Atoi(args[0]); err == nil {
+		return c, args[1:], nil
+	}
+	return 302, args, nil
+}
+
+// htaccessRedirect translates `Redirect [status] <path> <url>`, which also redirects the paths under path, carrying
+// what follows it and the query
+func htaccessRedirect(args []string, host string, code int) (string, error) {
+	code, args, err := htaccessStatus(args, code)
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 0 || !strings.HasPrefix(args[0], "/") {
+		return "", errors.New("expected a path")
+	}
+	pattern := importPattern(host, args[0], true)
+	if code == 410 {
+		return pattern + " gone", nil
+	}
+	if err := importRedirectCode(code); err != nil {
+		return "", err
+	}
+	if len(args) != 2 {
+		return "", errors.New("expected a path and a url")
+	}
+	dest, options, err := importDestination(args[1], host, false, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	options = append(options, "path", "query")
+	if p := strings.TrimSuffix(args[0], "/"); p != "" {
+		options = append(options, "strip="+p)
+	}
+	return importRoute(pattern, dest, options, code), nil
+}
+
+// htaccessRedirectMatch translates `RedirectMatch [status] <regex> <url>`
+func htaccessRedirectMatch(args []string, host string) (string, error) {
+	code, args, err := htaccessStatus(args, 0)
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return "", errors.New("expected a regular expression")
+	}
+	pattern, err := importRegexp(host, args[0], false)
+	if err != nil {
+		return "", err
+	}
+	if code == 410 {
+		return pattern + " gone", nil
+	}
+	if err := importRedirectCode(code); err != nil {
+		return "", err
+	}
+	if len(args) != 2 {
+		return "", errors.New("expected a regular expression and a url")
+	}
+	dest, options, err := importDestination(args[1], host, true, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	return importRoute(pattern, dest, options, code), nil
+}
+
+// htaccessRewriteRule translates `RewriteRule <regex> <substitution> [flags]`, which redirects with the R flag or when
+// the substitution is a url
+func htaccessRewriteRule(args []string, host, scheme string) (string, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return "", errors.New("expected a regular expression, a substitution, and flags")
+	}
+	re, dest := args[0], args[1]
+	var (
+		code                            int
+		caseInsensitive, qsa, qsd, gone bool
+	)
+	if len(args) == 3 {
+		for _, flag := range strings.Split(strings.Trim(args[2], "[]"), ",") {
+			name, value := strings.ToUpper(strings.TrimSpace(flag)), ""
+			if i := strings.IndexByte(name, '='); i != -1 {
+				name, value = name[:i], name[i+1:]
+			}
+			switch name {
+			case "R", "REDIRECT":
+				code = 302
+				if value != "" {
+					c, err := strconv.Atoi(value)
+					if err != nil {
+						return "", fmt.Errorf("invalid redirect status %q", value)
+					}
+					code = c
+				}
+			case "NC", "NOCASE":
+				caseInsensitive = true
+			case "QSA", "QSAPPEND":
+				qsa = true
+			case "QSD", "QSDISCARD":
+				qsd = true
+			case "G", "GONE":
+				gone = true
+			case "L", "LAST", "NE", "NOESCAPE", "END":
+			default:
+				return "", fmt.Errorf("the %s flag isn't supported", name)
+			}
+		}
+	}
+	// unlike nginx, .htaccess paths don't start with a slash
+	if !strings.HasPrefix(re, "^/") {
+		re = strings.Replace(re, "^", "^/", 1)
+	}
+	pattern, err := importRegexp(host, re, caseInsensitive)
+	if err != nil {
+		return "", err
+	}
+	if gone {
+		return joinNonEmpty(pattern, "gone", schemeOption(scheme)), nil
+	}
+	if code == 0 {
+		if !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") {
+			return "", errors.New("rewrites that don't redirect aren't supported")
+		}
+		code = 302
+	}
+	if err := importRedirectCode(code); err != nil {
+		return "", err
+	}
+	if dest == "-" {
+		return "", errors.New("redirects without a substitution aren't supported")
+	}
+
+	// apache passes the query on unless the substitution has its own, or it's discarded
+	keepQuery := !qsd && (qsa || !strings.Contains(dest, "?"))
+	dest = strings.TrimSuffix(dest, "?")
+	if !strings.HasPrefix(dest, "/") && !strings.Contains(dest, "://") && !strings.HasPrefix(dest, "%{") {
+		dest = "/" + dest
+	}
+	dest, options, err := importDestination(dest, host, true, htaccessVars(host), htaccessCarry)
+	if err != nil {
+		return "", err
+	}
+	if keepQuery && !containsString(options, "query") {
+		options = append(options, "query")
+	}
+	if scheme != "" {
+		options = append(options, schemeOption(scheme))
+	}
+	return importRoute(pattern, dest, options, code), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// initCommand is the `init` command. It returns the process's exit code.
+func initCommand(args []string) int {
+	var (
+		opts    initOptions
+		migrate strslice
+	)
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	output := fs.String("o", "routes.txt", `the file to write the routes to, or "-" for stdout.`)
+	fs.StringVar(&opts.domain, "domain", "", "the canonical domain, e.g. example.com.")
+	fs.BoolVar(&opts.www, "www", false, "use www.{domain} as the canonical host instead of {domain}.")
+	fs.Var(&migrate, "migrate", "an old domain whose requests should move to the canonical host. can be specified multiple times.")
+	force := fs.Bool("f", false, "overwrite the output file if it exists.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+🌱⛳ init flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	opts.migrate = migrate
+
+	if opts.domain == "" {
+		if !isTerminal(os.Stdin) {
+			fmt.Printf("🚨 -domain must be set\n")
+			return 1
+		}
+		opts.prompt(os.Stdin, os.Stdout)
+	}
+	if opts.domain == "" {
+		fmt.Printf("🚨 a domain must be set\n")
+		return 1
+	}
+
+	content := opts.routes()
+	if *output == "-" {
+		fmt.Print(content)
+		return 0
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(*output, flags, 0644)
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	fmt.Printf("✅ wrote %s\n", *output)
+	return 0
+}
+
+type initOptions struct {
+	domain  string
+	www     bool
+	migrate []string
+}
+
+// prompt asks for the options interactively
+func (o *initOptions) prompt(in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	ask := func(question string) string {
+		fmt.Fprintf(out, "%s ", question)
+		if !scanner.Scan() {
+			return ""
+		}
+		return strings.TrimSpace(scanner.Text())
+	}
+
+	o.domain = ask("🌐 what is your canonical domain? (e.g. example.com)")
+	o.www = strings.HasPrefix(strings.ToLower(ask("🔀 should requests land on www."+o.domain+" instead of "+o.domain+"? [y/N]")), "y")
+	for _, d := range strings.Split(ask("🚚 any old domains to move to it? (comma-separated, leave empty for none)"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			o.migrate = append(o.migrate, d)
+		}
+	}
+}
+
+// routes returns the contents of a routes file for the options
+func (o *initOptions) routes() string {
+	domain := strings.ToLower(strings.TrimPrefix(o.domain, "www."))
+	canonical, other := domain, "www."+domain
+	if o.www {
+		canonical, other = other, canonical
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `# routes generated by "redirector init"
+#
+# one route per line: <pattern> <destination> [path] [query] [code=<code>]
+# check them with "redirector fmt -lint <file>" and simulate requests with "redirector test".
+
+# send %s to the canonical host
+%s/* %s path query code=301
+`, other, other, canonical)
+
+	for _, d := range o.migrate {
+		d = strings.ToLower(d)
+		fmt.Fprintf(&b, `
+# move %[1]s to the canonical host
+%[1]s/* %[2]s path query code=301
+*.%[1]s/* %[2]s path query code=301
+`, d, canonical)
+	}
+	return b.String()
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
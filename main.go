@@ -1,16 +1,21 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"flag"
 	"fmt"
+	"html/template"
+	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/kamaln7/redirector/pkg/redirector"
 )
@@ -20,24 +25,140 @@ var cliUsage = func() {}
 func main() {
 	port := "8080"
 	if p := os.Getenv("PORT"); p != "" {
-		fmt.Printf("💡 using port %s from $PORT env var\n", p)
 		port = p
 	}
 	var redirectorOpts []redirector.Option
 
 	// cli handling
-	var routes strslice
+	rf := routeFlags{configURLInterval: 30 * time.Second}
 	fs := flag.NewFlagSet("", flag.ExitOnError)
-	fs.Var(&routes, "route", `add a route. can be specified multiple times.
-
-syntax: <pattern> <destination> [path: bool; default=false] [query: bool; default=false] [code: int; default=302]
-	<pattern> - must be {hostname}/{path} optionally containing a wildcard * character.
-	
-example routes:
-	- redirect all requests from www.example.com to example.com, preserving the original path and query parameters.
-	  www.example.com/* example.com path query code=301
-	- redirect blog from subdomain to subpath, appending the original path and preserving query parameters.
-	  blog.example.com/* example.com/blog path query code=301`)
+	var (
+		cacheSize       int
+		printVersion    bool
+		versionHeader   bool
+		debugHeaders    bool
+		preview         bool
+		dnsRoutes       bool
+		dryRun          bool
+		healthPath      string
+		readyPath       string
+		probePort       string
+		listenAddrs     strslice
+		listenMode      string
+		watchConfig     bool
+		tlsCerts        strslice
+		tlsKeys         strslice
+		tlsDir          string
+		autoTLS         bool
+		autoTLSCache    string
+		autoTLSEmail    string
+		httpRedirect    string
+		logFormat       string
+		logOutput       string
+		metrics         bool
+		metricsPort     string
+		adminPort       string
+		adminToken      string
+		adminBasic      string
+		adminListen     string
+		storePath       string
+		shortenHost     string
+		qrCodes         bool
+		h2cServe        bool
+		debugListen     string
+		shutdownTimeout time.Duration
+		serveDir        string
+		defaultDest     string
+		defaultCode     int
+		notFoundPage    string
+		robots          string
+		favicon         string
+		missWebhook     string
+		analyticsDest   string
+		analyticsSize   int64
+		refreshBody     bool
+		refreshTmpl     string
+		geoIPDB         string
+		trustProto      bool
+		stripForwarded  bool
+		forwardedHeader bool
+		trustedProxy    string
+		allow           string
+		deny            string
+		destHosts       string
+		cors            string
+		corsConfig      *redirector.CORS
+		maintOn         bool
+		maintFile       string
+		maintPage       string
+		maintHosts      string
+		maintRetry      time.Duration
+		collapse        bool
+		trailingSlash   string
+	)
+	fs.IntVar(&cacheSize, "cache-size", 0, "cache the results of this many recent route lookups. disabled by default.")
+	fs.BoolVar(&printVersion, "version", false, "print the version, commit, and build date, like the version command, and exit.")
+	fs.BoolVar(&versionHeader, "version-header", false, "set an X-Redirector-Version header on every response.")
+	fs.BoolVar(&debugHeaders, "debug-headers", false, "set X-Redirector-Route and X-Redirector-Route-Source headers on responses with the pattern of the\nroute that matched and where it was configured, such as a routes file and line.")
+	fs.BoolVar(&dnsRoutes, "dns-routes", false, "look up routes for requests that don't match any others in the _redirect TXT records of their hosts, e.g.\n_redirect.example.com, so that domain owners can configure their own redirects. records are cached for their ttl.")
+	fs.BoolVar(&preview, "preview", false, "show a page with the matching route, destination, and status code instead of redirecting requests\nwith ?"+redirector.PreviewParam+"=1.")
+	fs.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "on SIGINT or SIGTERM, stop accepting connections and wait this long for requests in flight to finish\nbefore exiting, or before passing the signal on to a wrapped command.")
+	fs.BoolVar(&dryRun, "dry-run", false, "load and validate everything, print the effective routes and listeners, then exit without serving.")
+	fs.BoolVar(&watchConfig, "watch", false, "reload the routes whenever the config file or routes files change. routes are always reloaded on SIGHUP.")
+	fs.StringVar(&healthPath, "health-path", "", "serve a health check endpoint at this path on every host, e.g. /healthz. disabled by default.")
+	fs.StringVar(&readyPath, "ready-path", "", "serve a readiness endpoint at this path on every host, e.g. /readyz. when wrapping a command, it only reports\nready once the command accepts connections. disabled by default.")
+	fs.StringVar(&probePort, "probe-port", "", "serve the health and readiness endpoints on this port instead of every host of the main one. they default to\n/healthz and /readyz.")
+	fs.Var(&listenAddrs, "listen", "an address to serve on instead of :$PORT, as host:port, e.g. 127.0.0.1:8080, or unix:<path> for a unix\nsocket, e.g. behind a local nginx. can be specified multiple times to serve the same routes on each.")
+	fs.StringVar(&listenMode, "listen-mode", "0660", "the permissions of the -listen unix socket, in octal.")
+	fs.BoolVar(&h2cServe, "h2c", false, "serve http/2 over cleartext, with prior knowledge or an upgrade from http/1.1, alongside http/1.1 when\nserving without tls, e.g. for grpc behind a load balancer. see wrap -h2c to pass it on to a wrapped command.")
+	fs.Var(&tlsCerts, "tls-cert", "serve https using this certificate file, which may include intermediate certificates. requires -tls-key.\ncan be specified multiple times, with a -tls-key for each, to pick the certificate for each hostname by sni.")
+	fs.Var(&tlsKeys, "tls-key", "the private key file for -tls-cert.")
+	fs.StringVar(&tlsDir, "tls-dir", "", "serve https using every certificate in this directory, as <name>.crt or <name>.pem files next to <name>.key\nfiles, picking the one for each hostname by sni. can be combined with -tls-cert.")
+	fs.BoolVar(&autoTLS, "auto-tls", false, "serve https with certificates obtained and renewed automatically from let's encrypt for the hostnames of the routes.\nwildcard hostnames are skipped.")
+	fs.StringVar(&autoTLSCache, "auto-tls-cache", defaultAutoTLSCache(), "the directory to cache -auto-tls certificates and account keys in.")
+	fs.StringVar(&autoTLSEmail, "auto-tls-email", "", "the contact email to register with let's encrypt for -auto-tls, for notices about certificate problems.")
+	fs.StringVar(&httpRedirect, "http-redirect-port", "", "when serving https, also listen for http on this port, e.g. 80, and redirect every request to https. acme http-01\nchallenges are served too with -auto-tls.")
+	fs.StringVar(&logFormat, "log-format", "", "write an access log line for every request, as json or in the apache combined format. disabled by default.")
+	fs.StringVar(&logOutput, "log-output", "stdout", `where to write the access log: "stdout", "stderr", or a file to append to.`)
+	fs.BoolVar(&metrics, "metrics", false, "serve prometheus metrics at /metrics on every host. takes precedence over routes.")
+	fs.StringVar(&debugListen, "debug-addr", "", "serve pprof profiles at /debug/pprof/ and expvar variables at /debug/vars on this address, e.g. 6060.\nit listens on localhost unless the address has another host. disabled by default.")
+	fs.StringVar(&metricsPort, "metrics-port", "", "serve prometheus metrics at /metrics on this port instead of the main one.")
+	fs.StringVar(&adminPort, "admin-port", "", "serve an admin api and web ui for listing, adding, updating, and removing routes at runtime on this port. it\nonly listens on localhost unless -admin-token or -admin-basic-auth is set. disabled by default.")
+	fs.StringVar(&adminToken, "admin-token", "", "require this bearer token for admin api requests, and listen on every interface. defaults to $REDIRECTOR_ADMIN_TOKEN.")
+	fs.StringVar(&adminBasic, "admin-basic-auth", "", "require basic auth with this user:password for admin api requests, and listen on every interface. defaults\nto $REDIRECTOR_ADMIN_BASIC_AUTH. with -admin-token too, requests may use either.")
+	fs.StringVar(&adminListen, "admin-listen", "", "the address for the admin api to listen on instead of -admin-port, as host:port, e.g. 127.0.0.1:9000 to\nonly listen on localhost even with credentials, or unix:<path> for a unix socket.")
+	fs.StringVar(&storePath, "store", "", "persist the routes added, changed, and removed through the admin api to this database file, and load them\nagain on boot.")
+	fs.StringVar(&shortenHost, "shorten-host", "", "serve short links on this host, e.g. sho.rt, added with POST /shorten in the admin api or the shorten\ncommand. requires the admin api, and -store to keep them across restarts.")
+	fs.BoolVar(&qrCodes, "qr", false, "serve a QR code pointing at the url of a route instead of following it for requests with ?qr=png or ?qr=svg,\ne.g. https://sho.rt/spring?qr=png.")
+	fs.StringVar(&defaultDest, "default", "", "redirect requests that don't match any routes to this url, such as a landing page, instead of a 404.")
+	fs.IntVar(&defaultCode, "default-code", 302, "the http status code to set on -default redirects.")
+	fs.StringVar(&robots, "robots", "", "serve a robots.txt on every host, even ones that wildcard routes redirect: \"disallow\" to keep crawlers\nout, \"allow\" to let them in, or the path of a file to serve.")
+	fs.StringVar(&favicon, "favicon", "", "serve this file as /favicon.ico on every host, even ones that wildcard routes redirect, or \"none\" to\nanswer its requests with 204 No Content.")
+	fs.StringVar(&notFoundPage, "not-found-page", "", "render this html template for requests that don't match any routes, instead of a plain 404. it can use\n{{.Host}}, {{.Path}}, {{.URL}}, and {{.RequestID}} from the request.")
+	fs.BoolVar(&maintOn, "maintenance", false, "start in maintenance mode, answering every request with 503 Service Unavailable and a Retry-After header\ninstead of following the routes, until it's turned off with DELETE /maintenance in the admin api. health,\nreadiness, and metrics endpoints keep responding.")
+	fs.StringVar(&maintFile, "maintenance-file", "", "turn maintenance mode on while a file exists at this path, e.g. /run/redirector/maintenance.")
+	fs.StringVar(&maintPage, "maintenance-page", "", "render this html template for requests during maintenance mode, instead of a plain 503. it can use the same\nfields as -not-found-page.")
+	fs.StringVar(&maintHosts, "maintenance-hosts", "", "only put these hosts in maintenance mode, separated by commas, e.g. example.com,*.example.com. every\nhost is by default.")
+	fs.DurationVar(&maintRetry, "maintenance-retry-after", 5*time.Minute, "how long clients are told to wait before trying again during maintenance mode.")
+	fs.StringVar(&missWebhook, "miss-webhook", "", "POST the hosts and paths of requests that don't match any routes to this url as json, batched every minute\nand each sent at most once a day, to find links that are missing routes.")
+	fs.StringVar(&analyticsDest, "analytics", "", "record every redirect, with its route, destination, referer, and anonymized client, as ndjson appended to\nthis file, or POSTed in batches to this url if it starts with http:// or https://.")
+	fs.Int64Var(&analyticsSize, "analytics-max-size", 100, "rotate the -analytics file once it grows past this many megabytes. 0 never rotates it.")
+	fs.BoolVar(&refreshBody, "refresh-body", false, "add an html body with a meta refresh and a link to the destination to every redirect, for clients that\ndon't follow Location headers. routes can opt in one by one with the refresh option instead.")
+	fs.StringVar(&refreshTmpl, "refresh-template", "", "render the bodies of -refresh-body and refresh routes from this html template instead. it can use\n{{.Destination}}, {{.Code}}, and {{.Status}}.")
+	fs.StringVar(&geoIPDB, "geoip-db", "", "a maxmind geoip2 or geolite2 country or city database to find the countries of requests in, for routes with\ncountry=. without it, such routes never match.")
+	fs.BoolVar(&trustProto, "trust-forwarded-proto", false, "take the scheme of requests from their X-Forwarded-Proto header, for routes with scheme= behind a load\nbalancer that terminates tls. only use it if every request comes through such a proxy, or with -trusted-proxies.")
+	fs.BoolVar(&stripForwarded, "strip-forwarded", false, "replace the X-Forwarded-* headers that clients send when forwarding requests to the wrapped command and\nupstreams, instead of appending to them, so that they can't be spoofed. always on with -trusted-proxies.")
+	fs.BoolVar(&forwardedHeader, "forwarded-header", false, "also set the standard Forwarded header on requests forwarded to the wrapped command and upstreams.")
+	fs.StringVar(&trustedProxy, "trusted-proxies", "", "a comma-separated list of cidrs or ip addresses of proxies in front of redirector, such as a load balancer.\nrequests from them are matched on their X-Forwarded-Host and logged with the client from X-Forwarded-For, and the\nX-Forwarded-* headers of everyone else are ignored.")
+	fs.StringVar(&allow, "allow", "", "a comma-separated list of cidrs or ip addresses that clients must come from. everyone else gets a 403\nresponse. routes can have their own allow= lists.")
+	fs.StringVar(&deny, "deny", "", "a comma-separated list of cidrs or ip addresses whose requests get a 403 response. routes can have their\nown deny= lists.")
+	fs.StringVar(&destHosts, "destination-hosts", "", "a comma-separated list of the hosts that redirects may send clients to, such as example.com or\n*.example.com for every subdomain. redirects anywhere else, such as ones built from the request, get a 400 response.")
+	fs.StringVar(&cors, "cors", "", "answer the CORS preflights of requests that match routes, or don't match any when nothing else handles\nthem, from these comma-separated origins, or * for any, and allow them on the responses. routes can have their\nown cors= lists.")
+	fs.BoolVar(&collapse, "collapse-slashes", false, "treat runs of slashes in request and destination paths as a single slash, so that example.com//docs\nmatches example.com/docs.")
+	fs.StringVar(&trailingSlash, "trailing-slash", "", `what to do with the trailing slash of destination paths: "keep" it when the request's path has one
+and it's carried, or "add" or "remove" it from every redirect. by default, carried paths lose it.`)
+	fs.StringVar(&serveDir, "serve-dir", "", "serve the files in this directory for requests that don't match any routes, instead of a 404. ignored when\nwrapping a command.")
+	rf.register(fs)
 	cliUsage = func() {
 		fmt.Printf(`🔄 redirector
 
@@ -52,17 +173,122 @@ redirector provides convenient http redirects.
 
         redirector -route "www.example.com/* example.com path query code=301"
 
+    routes can also be loaded from a file with one route per line, and routes and server settings from a yaml, toml, or
+    json file:
+
+        redirector -routes-file routes.txt
+        redirector -config redirector.yaml
+
   - wrap: wrap a command and route any incoming HTTP requests that don't match any of the configured routes to it.
 
     example: start an HTTP server that redirects any www.example.com requests to example.com. any other requests are
       forwarded to the "npm run serve" command. the command is run in the background once redirector boots up.
 
       the wrapped command receives a $PORT env var that defaults to 8000. the command must start an http server on that
-      port for redirector to forward requests to it. you may pass a -port flag to the wrap command to override.
+      port for redirector to forward requests to it. you may pass a -port flag to the wrap command to override, or
+      -port 0 to pick a free one.
   
           redirector -route "www.example.com/* example.com path query code=301" wrap -- \
             npm run serve
 
+      to wrap several commands, separate them with ';' and wrap, and pick their requests with -match.
+
+          redirector wrap -match "api.example.com/*" -port 8001 -- ./api ';' wrap -- npm run serve
+
+  - trace: follow the redirect chain of a url on the live internet, printing each hop's status and Location.
+
+        redirector trace -max-hops 5 http://www.example.com/foo
+
+  - healthcheck: probe the health endpoint of a redirector running locally (see -health-path) and exit with 0 if it's
+    healthy or 1 otherwise. useful as a container healthcheck.
+
+        redirector healthcheck -path /healthz
+
+  - self-update: replace the running binary with the latest github release after verifying its checksum.
+
+        redirector self-update
+
+  - systemd install: write a hardened systemd unit that runs redirector with the global flags it was given.
+
+        redirector -route "www.example.com/* example.com path query code=301" systemd install -enable
+
+  - completion: print a completion script for bash, zsh, or fish, covering commands, flags, and the options of
+    -route.
+
+        source <(redirector completion bash)
+
+  - version: print the version, commit, and build date, and the go version and platform it was built for. -version
+    does the same.
+
+  - validate: check the configured routes for errors, conflicts, and redirect loops without starting a server. exits
+    with a non-zero code if any problems are found.
+
+        redirector validate -route "www.example.com/* example.com path query code=301"
+
+  - check: validate the routes like validate does, and with -head also send a HEAD request to each destination to
+    report broken targets before deploying. exits with a non-zero code if anything fails.
+
+        redirector check -head -routes-file routes.txt
+
+  - init: generate a starter routes file, interactively or from flags, with the canonical host and old domains to move
+    to it.
+
+        redirector init -domain example.com -migrate old-example.com
+
+  - fmt: normalize the syntax of routes files (one route per line, # for comments): lowercase hostnames, canonical
+    option order, and explicit codes. pass -w to rewrite the files or -lint to report suspicious routes instead.
+
+        redirector fmt -w routes.txt
+
+  - test: print which route matches a url, the options applied, and the exact status and Location header that would be
+    returned, without starting a server.
+
+        redirector test -route "www.example.com/* example.com path query code=301" https://www.example.com/foo?x=1
+
+    pass -f <file> to check a file of "<url> <expected destination> <expected code>" lines instead.
+
+  - shorten: add a short link with a random key, or -key, to a redirector serving -shorten-host, through its admin api,
+    and print it.
+
+        redirector shorten -admin http://127.0.0.1:9000 https://example.com/a/very/long/url
+
+  - sign: print urls signed for routes with auth=hmac:<secret>, which work until -expires passes, if it's set.
+
+        redirector sign -secret s3cret -expires 24h https://dl.example.com/beta/app.dmg
+
+  - cloudflare export|import: convert the routes to a Cloudflare Bulk Redirect list (csv for the dashboard, or json for
+    the lists api), or convert an existing list to routes.
+
+        redirector -route "www.example.com/* example.com path query code=301" cloudflare export > redirects.csv
+        redirector cloudflare import redirects.csv > routes.txt
+
+  - import nginx|htaccess: translate the redirects of an nginx config (return and rewrite) or an .htaccess file
+    (Redirect, RedirectMatch, and RewriteRule) to routes, and report the directives that can't be translated.
+
+        redirector import nginx /etc/nginx/sites-enabled/example.com > routes.txt
+        redirector import htaccess -host example.com .htaccess > routes.txt
+
+  - export: print the effective route table, from the flags, files, dynamic sources, and -store, as json, csv, or a
+    Netlify _redirects file, e.g. to diff it against version control. pass -from to export a running instance's routes
+    from its admin api instead.
+
+        redirector -config redirector.yaml export -format csv > routes.csv
+        redirector export -from http://127.0.0.1:8081 > routes.json
+
+  - pass -kubernetes to any of the serving commands to also load routes from the annotations of Ingress objects in the
+    cluster and keep them in sync, and -kubernetes-redirects to load them from Redirect custom resources too.
+
+        redirector -kubernetes
+        redirector -kubernetes-redirects
+
+  - pass -consul to share one route table between several redirectors through consul's key-value store.
+
+        redirector -consul http://127.0.0.1:8500
+
+  - pass -platform to load routes from the metadata of the cloud instance that redirector runs on.
+
+        redirector -platform auto
+
 ⛳ global flags
 
 `)
@@ -73,161 +299,745 @@ redirector provides convenient http redirects.
 	var (
 		args    = fs.Args()
 		command string
+		wc      wrapped
 	)
 	if len(args) > 0 {
 		command = args[0]
 		args = args[1:]
 	}
+	if printVersion || command == "version" {
+		// before loading the config, so that any build can tell what it is
+		os.Exit(versionCommand())
+	}
+	if command == "completion" {
+		os.Exit(completionCommand(fs, args))
+	}
+
+	// flags take precedence over the config file, and $PORT over both
+	cfg, err := rf.loadConfig()
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		os.Exit(1)
+	}
+	if cfg != nil {
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if cfg.Port != 0 && os.Getenv("PORT") == "" {
+			port = strconv.Itoa(cfg.Port)
+		}
+		if !set["listen"] && len(cfg.Listen) > 0 {
+			listenAddrs = cfg.Listen
+		}
+		if !set["listen-mode"] && cfg.ListenMode != "" {
+			listenMode = cfg.ListenMode
+		}
+		if !set["cache-size"] && cfg.CacheSize != 0 {
+			cacheSize = cfg.CacheSize
+		}
+		if !set["version-header"] && cfg.VersionHeader {
+			versionHeader = true
+		}
+		if !set["debug-headers"] && cfg.DebugHeaders {
+			debugHeaders = true
+		}
+		if !set["preview"] && cfg.Preview {
+			preview = true
+		}
+		if !set["dns-routes"] && cfg.DNSRoutes {
+			dnsRoutes = true
+		}
+		if !set["health-path"] && cfg.HealthPath != "" {
+			healthPath = cfg.HealthPath
+		}
+		if !set["tls-cert"] && !set["tls-key"] {
+			if cfg.TLSCert != "" {
+				tlsCerts, tlsKeys = strslice{cfg.TLSCert}, strslice{cfg.TLSKey}
+			}
+			for _, pair := range cfg.TLSCerts {
+				tlsCerts, tlsKeys = append(tlsCerts, pair.Cert), append(tlsKeys, pair.Key)
+			}
+		}
+		if !set["tls-dir"] && cfg.TLSDir != "" {
+			tlsDir = cfg.TLSDir
+		}
+		if !set["ready-path"] && cfg.ReadyPath != "" {
+			readyPath = cfg.ReadyPath
+		}
+		if !set["probe-port"] && cfg.ProbePort != 0 {
+			probePort = strconv.Itoa(cfg.ProbePort)
+		}
+		if !set["log-format"] && cfg.LogFormat != "" {
+			logFormat = cfg.LogFormat
+		}
+		if !set["log-output"] && cfg.LogOutput != "" {
+			logOutput = cfg.LogOutput
+		}
+		if !set["metrics"] && cfg.Metrics {
+			metrics = true
+		}
+		if !set["metrics-port"] && cfg.MetricsPort != 0 {
+			metricsPort = strconv.Itoa(cfg.MetricsPort)
+		}
+		if !set["debug-addr"] && cfg.DebugAddr != "" {
+			debugListen = cfg.DebugAddr
+		}
+		if !set["admin-port"] && cfg.AdminPort != 0 {
+			adminPort = strconv.Itoa(cfg.AdminPort)
+		}
+		if !set["admin-listen"] && cfg.AdminListen != "" {
+			adminListen = cfg.AdminListen
+		}
+		if !set["store"] && cfg.Store != "" {
+			storePath = cfg.Store
+		}
+		if !set["shorten-host"] && cfg.ShortenHost != "" {
+			shortenHost = cfg.ShortenHost
+		}
+		if !set["qr"] && cfg.QR {
+			qrCodes = true
+		}
+		if !set["h2c"] && cfg.H2C {
+			h2cServe = true
+		}
+		if !set["shutdown-timeout"] && cfg.ShutdownTimeout != "" {
+			d, err := time.ParseDuration(cfg.ShutdownTimeout)
+			if err != nil {
+				fmt.Printf("🚨 shutdown_timeout: %v\n", err)
+				os.Exit(1)
+			}
+			shutdownTimeout = d
+		}
+		if !set["http-redirect-port"] && cfg.HTTPRedirectPort != 0 {
+			httpRedirect = strconv.Itoa(cfg.HTTPRedirectPort)
+		}
+		if a := cfg.AutoTLS; a != nil && !set["auto-tls"] {
+			autoTLS = true
+			if !set["auto-tls-cache"] && a.Cache != "" {
+				autoTLSCache = a.Cache
+			}
+			if !set["auto-tls-email"] {
+				autoTLSEmail = a.Email
+			}
+		}
+		if command == "" && (cfg.Wrap != nil || len(cfg.Wraps) > 0) {
+			command, args = "wrap", cfg.wrapArgs()
+		}
+		if cfg.DefaultProxy != "" && command != "wrap" {
+			u, err := url.Parse(cfg.DefaultProxy)
+			if err != nil || u.Host == "" {
+				fmt.Printf("🚨 invalid default_proxy %q in %s\n", cfg.DefaultProxy, rf.configPath)
+				os.Exit(1)
+			}
+			redirectorOpts = append(redirectorOpts, redirector.WithDefaultProxy(u))
+		}
+		if !set["serve-dir"] && cfg.ServeDir != "" {
+			serveDir = cfg.ServeDir
+		}
+		if !set["geoip-db"] && cfg.GeoIPDB != "" {
+			geoIPDB = cfg.GeoIPDB
+		}
+		if !set["trust-forwarded-proto"] && cfg.TrustForwardedProto {
+			trustProto = true
+		}
+		if !set["strip-forwarded"] && cfg.StripForwarded {
+			stripForwarded = true
+		}
+		if !set["forwarded-header"] && cfg.ForwardedHeader {
+			forwardedHeader = true
+		}
+		if !set["trusted-proxies"] && len(cfg.TrustedProxies) > 0 {
+			trustedProxy = strings.Join(cfg.TrustedProxies, ",")
+		}
+		if !set["allow"] && len(cfg.Allow) > 0 {
+			allow = strings.Join(cfg.Allow, ",")
+		}
+		if !set["deny"] && len(cfg.Deny) > 0 {
+			deny = strings.Join(cfg.Deny, ",")
+		}
+		if !set["destination-hosts"] && len(cfg.DestinationHosts) > 0 {
+			destHosts = strings.Join(cfg.DestinationHosts, ",")
+		}
+		if !set["cors"] && cfg.CORS != nil {
+			corsConfig = cfg.CORS
+		}
+		if !set["collapse-slashes"] && cfg.CollapseSlashes {
+			collapse = true
+		}
+		if !set["trailing-slash"] && cfg.TrailingSlash != "" {
+			trailingSlash = cfg.TrailingSlash
+		}
+		if !set["not-found-page"] && cfg.NotFoundPage != "" {
+			notFoundPage = cfg.NotFoundPage
+		}
+		if !set["robots"] && cfg.Robots != "" {
+			robots = cfg.Robots
+		}
+		if !set["favicon"] && cfg.Favicon != "" {
+			favicon = cfg.Favicon
+		}
+		if !set["maintenance"] && cfg.Maintenance {
+			maintOn = true
+		}
+		if !set["maintenance-file"] && cfg.MaintenanceFile != "" {
+			maintFile = cfg.MaintenanceFile
+		}
+		if !set["maintenance-page"] && cfg.MaintenancePage != "" {
+			maintPage = cfg.MaintenancePage
+		}
+		if !set["maintenance-hosts"] && len(cfg.MaintenanceHosts) > 0 {
+			maintHosts = strings.Join(cfg.MaintenanceHosts, ",")
+		}
+		if !set["maintenance-retry-after"] && cfg.MaintenanceRetryAfter != "" {
+			d, err := time.ParseDuration(cfg.MaintenanceRetryAfter)
+			if err != nil {
+				fmt.Printf("🚨 maintenance_retry_after: %v\n", err)
+				os.Exit(1)
+			}
+			maintRetry = d
+		}
+		if !set["miss-webhook"] && cfg.MissWebhook != "" {
+			missWebhook = cfg.MissWebhook
+		}
+		if !set["analytics"] && cfg.Analytics != "" {
+			analyticsDest = cfg.Analytics
+		}
+		if !set["analytics-max-size"] && cfg.AnalyticsMaxSize != 0 {
+			analyticsSize = cfg.AnalyticsMaxSize
+		}
+		if !set["refresh-body"] && cfg.RefreshBody {
+			refreshBody = true
+		}
+		if !set["refresh-template"] && cfg.RefreshTemplate != "" {
+			refreshTmpl = cfg.RefreshTemplate
+		}
+		if !set["default"] && cfg.Default != "" {
+			defaultDest = cfg.Default
+		}
+		if !set["default-code"] && cfg.DefaultCode != 0 {
+			defaultCode = cfg.DefaultCode
+		}
+	}
+	addrs := []string(listenAddrs)
+	if len(addrs) == 0 {
+		addrs = []string{":" + port}
+	}
+	// the first port served on is the one that https redirects and the setup page point at
+	ports := make(map[string]bool)
+	var unixSockets bool
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if _, p, err := net.SplitHostPort(addrs[i]); err == nil {
+			port = p
+			ports[p] = true
+		}
+		unixSockets = unixSockets || strings.HasPrefix(addrs[i], "unix:")
+	}
+	mode, err := strconv.ParseUint(listenMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		fmt.Printf("🚨 -listen-mode must be an octal mode such as 0660\n")
+		os.Exit(1)
+	}
+	var country func(*http.Request) string
+	if geoIPDB != "" {
+		g, err := openGeoIP(geoIPDB)
+		if err != nil {
+			fmt.Printf("🚨 -geoip-db: %v\n", err)
+			os.Exit(1)
+		}
+		country = g.country
+		redirectorOpts = append(redirectorOpts, redirector.WithCountry(country))
+	}
+	if trustProto {
+		redirectorOpts = append(redirectorOpts, redirector.WithScheme(forwardedScheme))
+	}
+	paths := redirector.PathNormalization{CollapseSlashes: collapse}
+	if trailingSlash != "" {
+		var ok bool
+		if paths.TrailingSlash, ok = redirector.ParseTrailingSlash(trailingSlash); !ok {
+			fmt.Printf("🚨 -trailing-slash must be keep, add, or remove\n")
+			os.Exit(1)
+		}
+	}
+	if paths != (redirector.PathNormalization{}) {
+		redirectorOpts = append(redirectorOpts, redirector.WithPathNormalization(paths))
+	}
+	var proxies trustedProxies
+	if trustedProxy != "" {
+		var err error
+		if proxies, err = parseTrustedProxies(trustedProxy); err != nil {
+			fmt.Printf("🚨 -trusted-proxies: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if allow != "" || deny != "" {
+		var (
+			access redirector.Access
+			err    error
+		)
+		if access.Allow, err = redirector.ParseIPList(strings.Split(allow, ",")); err != nil {
+			fmt.Printf("🚨 -allow: %v\n", err)
+			os.Exit(1)
+		}
+		if access.Deny, err = redirector.ParseIPList(strings.Split(deny, ",")); err != nil {
+			fmt.Printf("🚨 -deny: %v\n", err)
+			os.Exit(1)
+		}
+		redirectorOpts = append(redirectorOpts, redirector.WithAccess(access))
+	}
+	var destinations redirector.DestinationHosts
+	if destHosts != "" {
+		destinations = strings.Split(destHosts, ",")
+		redirectorOpts = append(redirectorOpts, redirector.WithDestinationHosts(destinations))
+	}
+	if cors != "" {
+		corsConfig = &redirector.CORS{Origins: strings.Split(cors, ",")}
+	}
+	if corsConfig != nil {
+		redirectorOpts = append(redirectorOpts, redirector.WithCORS(*corsConfig))
+	}
+	if dnsRoutes {
+		redirectorOpts = append(redirectorOpts, redirector.WithTXTRoutes(redirector.TXTRoutes{}))
+	}
+	if n := btoi(defaultDest != "") + btoi(serveDir != "") + btoi(notFoundPage != ""); n > 1 {
+		fmt.Printf("🚨 only one of -default, -serve-dir, and -not-found-page can be used\n")
+		os.Exit(1)
+	}
+	if notFoundPage != "" && command != "wrap" {
+		h, err := notFoundHandler(notFoundPage)
+		if err != nil {
+			fmt.Printf("🚨 -not-found-page: %v\n", err)
+			os.Exit(1)
+		}
+		redirectorOpts = append(redirectorOpts, redirector.WithDefaultHandler(h))
+	}
+	crawlers, err := crawlerFiles(robots, favicon)
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		os.Exit(1)
+	}
+	if refreshBody || refreshTmpl != "" {
+		rb := redirector.RefreshBody{All: refreshBody}
+		if refreshTmpl != "" {
+			tmpl, err := template.ParseFiles(refreshTmpl)
+			if err != nil {
+				fmt.Printf("🚨 -refresh-template: %v\n", err)
+				os.Exit(1)
+			}
+			rb.Template = tmpl
+		}
+		redirectorOpts = append(redirectorOpts, redirector.WithRefreshBody(rb))
+	}
+	if defaultDest != "" && command != "wrap" {
+		if !strings.Contains(defaultDest, "://") {
+			defaultDest = "https://" + defaultDest
+		}
+		u, err := url.Parse(defaultDest)
+		if err != nil || u.Host == "" {
+			fmt.Printf("🚨 invalid -default url %q\n", defaultDest)
+			os.Exit(1)
+		}
+		if defaultCode < 300 || defaultCode > 399 {
+			fmt.Printf("🚨 invalid -default-code %d: must be a 3xx redirect code\n", defaultCode)
+			os.Exit(1)
+		}
+		redirectorOpts = append(redirectorOpts, redirector.WithDefaultRedirect(u, defaultCode))
+	}
+	if serveDir != "" && command != "wrap" {
+		if fi, err := os.Stat(serveDir); err != nil || !fi.IsDir() {
+			fmt.Printf("🚨 -serve-dir %q is not a directory\n", serveDir)
+			os.Exit(1)
+		}
+		redirectorOpts = append(redirectorOpts, redirector.WithDefaultHandler(http.FileServer(http.Dir(serveDir))))
+	}
 	switch command {
 	case "":
 		// default behavior, redirect only
-		go func() {
-			chanSig := make(chan os.Signal, 1)
-			signal.Notify(chanSig, os.Interrupt, syscall.SIGTERM)
-			sig := <-chanSig
-			fmt.Printf("❗ got %s, shutting down...\n", sig)
-			os.Exit(0)
-		}()
+	case "validate":
+		os.Exit(validateCommand(&rf, args))
+	case "check":
+		os.Exit(checkCommand(&rf, args))
+	case "fmt":
+		os.Exit(fmtCommand(args))
+	case "init":
+		os.Exit(initCommand(args))
+	case "healthcheck":
+		os.Exit(healthcheckCommand(args))
+	case "self-update":
+		os.Exit(selfUpdateCommand(args))
+	case "systemd":
+		os.Exit(systemdCommand(os.Args[1:len(os.Args)-len(fs.Args())], args))
+	case "shorten":
+		os.Exit(shortenCommand(args))
+	case "sign":
+		os.Exit(signCommand(args))
+	case "cloudflare":
+		os.Exit(cloudflareCommand(&rf, args))
+	case "import":
+		os.Exit(importCommand(args))
+	case "export":
+		os.Exit(exportCommand(&rf, storePath, args))
+	case "trace":
+		os.Exit(traceCommand(args))
+	case "test":
+		os.Exit(testCommand(&rf, []redirector.Option{redirector.WithCache(cacheSize), redirector.WithPathNormalization(paths)}, args))
 	case "wrap":
-		// wrap another command that starts an http server and use it as the default handler
-		wc, err := NewWrapCommand(args)
+		// wrap other commands that start http servers and use them as the default handler
+		wc, err = newWrapped(args)
 		if err != nil {
 			fmt.Printf("🚨 creating wrapped command: %v\n", err)
 			os.Exit(1)
 		}
-		if fmt.Sprint(wc.Port()) == port {
-			fmt.Printf("🚨 the wrapped command's port cannot be the same as the redirector's.\n")
-			os.Exit(1)
+		for _, c := range wc {
+			if c.socket == "" && ports[fmt.Sprint(c.Port())] {
+				fmt.Printf("🚨 the wrapped command's port cannot be the same as the redirector's.\n")
+				os.Exit(1)
+			}
 		}
 		redirectorOpts = append(redirectorOpts, wc.RedirectorDefaultHandler())
-
-		// start the command
-		go func() {
-			chanSig := make(chan os.Signal, 1)
-			signal.Notify(chanSig)
-			fmt.Printf("🤖 starting wrapped command\n\n")
-			err := wc.Run(chanSig)
-			fmt.Println("") // add a newline after the command's output
-			if err == nil {
-				fmt.Printf("✅ command exited cleanly. Shutting down...\n")
-				os.Exit(0)
-			}
-			fmt.Printf("🚨 %v\n", err)
-			exitCode := 1
-			var exErr *exec.ExitError
-			if errors.As(err, &exErr) {
-				exitCode = exErr.ExitCode() // mirror the command's exit code
-			}
-			os.Exit(exitCode)
-		}()
 	default:
 		fmt.Printf("🚨 unrecognized command %s\n", command)
 		os.Exit(1)
 	}
 
+	if adminToken == "" {
+		adminToken = os.Getenv("REDIRECTOR_ADMIN_TOKEN")
+	}
+	if adminBasic == "" {
+		adminBasic = os.Getenv("REDIRECTOR_ADMIN_BASIC_AUTH")
+	}
+	if adminBasic != "" && !strings.Contains(adminBasic, ":") {
+		fmt.Printf("🚨 -admin-basic-auth must be user:password\n")
+		os.Exit(1)
+	}
+	if len(tlsCerts) != len(tlsKeys) {
+		fmt.Printf("🚨 every -tls-cert needs a -tls-key\n")
+		os.Exit(1)
+	}
+	tlsFiles := len(tlsCerts) > 0 || tlsDir != ""
+	if autoTLS && tlsFiles {
+		fmt.Printf("🚨 -auto-tls can't be used with -tls-cert or -tls-dir\n")
+		os.Exit(1)
+	}
+	if httpRedirect != "" && !autoTLS && !tlsFiles {
+		fmt.Printf("🚨 -http-redirect-port requires -tls-cert, -tls-dir, or -auto-tls\n")
+		os.Exit(1)
+	}
+	if h2cServe && (autoTLS || tlsFiles) {
+		fmt.Printf("🚨 -h2c is for serving without tls, which negotiates http/2 by itself\n")
+		os.Exit(1)
+	}
+	var certs *certificates
+	if tlsFiles {
+		var err error
+		if certs, err = loadCertificates(tlsCerts, tlsKeys, tlsDir); err != nil {
+			fmt.Printf("🚨 %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// create redirector
+	routes, errs := rf.load()
+	for _, err := range errs {
+		fmt.Printf("❌ %v\n", err)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+	for _, shadow := range redirector.FindShadowed(routes) {
+		fmt.Printf("⚠️  %s\n", shadow)
+	}
+	rf.reportFlattened()
+	if destinations != nil {
+		for _, r := range destinations.Unlisted(routes) {
+			fmt.Printf("⚠️  %q redirects to a host that isn't in -destination-hosts, so its requests will get a 400\n", r.Pattern)
+		}
+	}
+	static := newReloadStore(&rf, routes)
+	stores, err := rf.stores(static)
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		os.Exit(1)
+	}
+	if len(routes) == 0 && !rf.dynamic() && len(redirectorOpts) == 0 && !dryRun {
+		fmt.Printf("💡 no routes are configured. add some with -route \"<pattern> <destination> [options]\", or open http://localhost:%s for help.\n", port)
+		redirectorOpts = append(redirectorOpts, redirector.WithDefaultHandler(http.HandlerFunc(setupHandler)))
+	}
+	// requests from trusted proxies are forwarded with the client and host from their headers instead. this comes after
+	// the check for the setup page since it's always set.
+	redirectorOpts = append(redirectorOpts, redirector.WithForwardedHeaders(redirector.ForwardedHeaders{
+		Forwarded: forwardedHeader,
+		Strip:     stripForwarded || proxies != nil || unixSockets,
+	}))
+	var collector *promCollector
+	if metrics || metricsPort != "" || adminPort != "" || adminListen != "" {
+		// the admin ui shows the hit counts of routes
+		collector = newPromCollector()
+		redirectorOpts = append(redirectorOpts, redirector.WithMetrics(collector))
+	}
+	redirectorOpts = append(redirectorOpts, redirector.WithCache(cacheSize))
+	if missWebhook != "" {
+		mw := newMissWebhook(missWebhook)
+		go mw.run()
+		redirectorOpts = append(redirectorOpts, redirector.WithOnMiss(mw.record))
+	}
+	if analyticsDest != "" && !dryRun {
+		a, err := newAnalytics(analyticsDest, analyticsSize<<20, country)
+		if err != nil {
+			fmt.Printf("🚨 -analytics: %v\n", err)
+			os.Exit(1)
+		}
+		go a.run()
+		redirectorOpts = append(redirectorOpts, redirector.WithMiddleware(a.middleware))
+	}
+	tr, err := newTracerFromEnv()
+	if err != nil {
+		fmt.Printf("🚨 tracing: %v\n", err)
+		os.Exit(1)
+	}
+	if tr != nil {
+		if tr.console {
+			fmt.Printf("🔭 printing traces to stdout\n")
+		} else {
+			fmt.Printf("🔭 sending traces to %s\n", tr.endpoint)
+		}
+		redirectorOpts = append(redirectorOpts, redirector.WithMiddleware(tr.middleware))
+	}
+	if debugHeaders {
+		redirectorOpts = append(redirectorOpts, redirector.WithDebugHeaders())
+	}
+	if preview {
+		redirectorOpts = append(redirectorOpts, redirector.WithPreview())
+	}
 	re := redirector.New(nil, redirectorOpts...)
-	hasErr := false
-	for _, route := range routes {
-		r, err := redirector.NewRoute(route)
+	if dryRun {
+		if err := redirector.Load(context.Background(), re, stores...); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		var listeners []string
+		for _, addr := range addrs {
+			listener := "http " + addr
+			if certs != nil {
+				listener = "https " + addr + " (" + certs.String() + ")"
+			}
+			if autoTLS {
+				listener = "https " + addr + " (certificates from let's encrypt, cached in " + autoTLSCache + ")"
+			}
+			listeners = append(listeners, listener)
+		}
+		if httpRedirect != "" {
+			listeners = append(listeners, "http :"+httpRedirect+" (redirects to https)")
+		}
+		printDryRun(re.Routes(), listeners, wc)
+		os.Exit(0)
+	}
+	var overlay *overlayStore
+	if adminPort != "" || adminListen != "" || storePath != "" {
+		overlay = newOverlayStore(stores...)
+		stores = []redirector.RouteSource{overlay}
+	}
+	if storePath != "" {
+		db, err := openStore(storePath)
+		if err == nil {
+			err = overlay.persistTo(context.Background(), db)
+		}
 		if err != nil {
-			fmt.Printf("❌ parsing route %q: %v\n", route, err)
-			hasErr = true
+			fmt.Printf("🚨 -store: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if shortenHost != "" && adminPort == "" && adminListen == "" {
+		fmt.Printf("🚨 -shorten-host requires the admin api, set -admin-port or -admin-listen\n")
+		os.Exit(1)
+	}
+	var maint *maintenance
+	if maintOn || maintFile != "" || maintPage != "" || maintHosts != "" || adminPort != "" || adminListen != "" {
+		var hosts []string
+		if maintHosts != "" {
+			hosts = strings.Split(maintHosts, ",")
+		}
+		if maint, err = newMaintenance(maintOn, maintFile, maintPage, hosts, maintRetry); err != nil {
+			fmt.Printf("🚨 -maintenance-page: %v\n", err)
+			os.Exit(1)
 		}
-		err = re.AddRoute(r)
+	}
+	if adminPort != "" || adminListen != "" {
+		auth := adminAuth{token: adminToken, basic: adminBasic}
+		addr := adminListen
+		if addr == "" {
+			addr = "127.0.0.1:" + adminPort
+			if auth.enabled() {
+				addr = ":" + adminPort
+			}
+		}
+		// admin sockets are only accessible by redirector's user
+		l, err := listenOn("admin", addr, 0o600)
 		if err != nil {
-			fmt.Printf("❌ adding route %q: %v\n", route, err)
-			hasErr = true
+			fmt.Printf("🚨 %v\n", err)
+			os.Exit(1)
 		}
+		go func() {
+			fmt.Printf("🔧 serving the admin api on %s\n", addr)
+			var short *shortener
+			if shortenHost != "" {
+				short = &shortener{host: shortenHost, store: overlay, qr: qrCodes}
+			}
+			if err := http.Serve(l, adminHandler(overlay, auth, adminHosts(addr, auth), collector, static.reload, short, maint)); err != nil {
+				fmt.Printf("🚨 %v\n", err)
+				os.Exit(1)
+			}
+		}()
 	}
-	if hasErr {
+	if err := redirector.Sync(context.Background(), re, stores...); err != nil {
+		fmt.Printf("❌ %v\n", err)
 		os.Exit(1)
 	}
+	go reloadOnSignal(static)
+	if watchConfig {
+		paths := rf.paths()
+		if len(paths) == 0 {
+			fmt.Printf("🚨 -watch requires -config or -routes-file\n")
+			os.Exit(1)
+		}
+		if err := reloadOnChange(static, paths); err != nil {
+			fmt.Printf("🚨 watching %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if wc != nil {
+		go runWrapped(wc)
+	}
 
 	// start http
+	if p := os.Getenv("PORT"); p != "" && len(listenAddrs) == 0 {
+		fmt.Printf("💡 using port %s from $PORT env var\n", p)
+	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", re.Handler)
-	port = ":" + port
-	fmt.Printf("🚀 redirector running on %s\n", port)
-	if err := http.ListenAndServe(port, mux); err != nil {
-		fmt.Printf("🚨 %v\n", err)
-		os.Exit(1)
+	var root http.Handler = http.HandlerFunc(re.Handler)
+	if qrCodes {
+		root = qrHandler(re, root)
 	}
-}
-
-// WrapCommand is the `wrap` command
-type WrapCommand struct {
-	cmd  *exec.Cmd
-	port uint
-}
-
-func NewWrapCommand(args []string) (*WrapCommand, error) {
-	wc := &WrapCommand{}
-
-	fs := flag.NewFlagSet("wrap", flag.ExitOnError)
-	fs.UintVar(&wc.port, "port", 8000, "the port that the wrapped command will listen on")
-	fs.Usage = func() {
-		cliUsage()
-		fmt.Printf(`
-🌯⛳ wrap flags
-
-`)
-		fs.PrintDefaults()
+	if len(crawlers) > 0 {
+		root = crawlerFilesHandler(re, crawlers, root)
 	}
-	fs.Parse(args)
-	cmdLine := fs.Args()
-	if len(cmdLine) > 0 && cmdLine[0] == "--" {
-		cmdLine = cmdLine[1:]
+	if maint != nil {
+		root = maint.handler(root)
 	}
-	if len(cmdLine) == 0 {
-		return nil, fmt.Errorf("a command must be set")
+	mux.Handle("/", root)
+	if probePort != "" {
+		probeMux := http.NewServeMux()
+		probeMux.HandleFunc(orDefault(healthPath, "/healthz"), healthHandler)
+		probeMux.HandleFunc(orDefault(readyPath, "/readyz"), readyHandler(wc))
+		serve("probe", ":"+probePort, probeMux)
+	} else {
+		if healthPath != "" {
+			mux.HandleFunc(healthPath, healthHandler)
+		}
+		if readyPath != "" {
+			mux.HandleFunc(readyPath, readyHandler(wc))
+		}
 	}
-
-	wc.cmd = exec.Command(cmdLine[0], cmdLine[1:]...)
-	wc.cmd.Stdin = os.Stdin
-	wc.cmd.Stdout = os.Stdout
-	wc.cmd.Stderr = os.Stderr
-	wc.cmd.Env = make([]string, len(os.Environ())+1)
-	copy(wc.cmd.Env, os.Environ())
-	// set a PORT={wrapped command port} env
-	wc.cmd.Env = append(wc.cmd.Env, fmt.Sprintf("PORT=%d", wc.port))
-
-	return wc, nil
-}
-
-// Port returns the configured port
-func (wc *WrapCommand) Port() uint {
-	return wc.port
-}
-
-// RedirectorDefaultHandler returns a redirector.WithDefaultHandler option that forwards requests to the wrapped command.
-func (wc *WrapCommand) RedirectorDefaultHandler() redirector.Option {
-	u, _ := url.Parse(fmt.Sprintf("http://localhost:%d", wc.port))
-	proxy := httputil.NewSingleHostReverseProxy(u)
-	return redirector.WithDefaultHandler(proxy)
-}
-
-// Run runs the command
-func (wc *WrapCommand) Run(chanSig chan os.Signal) error {
-	if chanSig != nil {
-		go func() {
-			for sig := range chanSig {
-				_ = wc.cmd.Process.Signal(sig)
-			}
-		}()
+	if metrics || metricsPort != "" {
+		if metricsPort != "" {
+			fmt.Printf("📈 serving metrics on :%s/metrics\n", metricsPort)
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle("/metrics", collector)
+			serve("metrics", ":"+metricsPort, metricsMux)
+		} else {
+			mux.Handle("/metrics", collector)
+		}
+	}
+	if debugListen != "" {
+		addr := debugAddr(debugListen)
+		fmt.Printf("🐛 serving pprof and expvar on http://%s/debug/pprof/\n", addr)
+		serve("debug", addr, debugHandler())
+	}
+	var handler http.Handler = mux
+	if versionHeader {
+		handler = withVersionHeader(handler)
+	}
+	if logFormat != "" {
+		l, err := newAccessLog(logFormat, logOutput)
+		if err != nil {
+			fmt.Printf("🚨 %v\n", err)
+			os.Exit(1)
+		}
+		handler = l.handler(handler)
+	}
+	handler = requestIDHandler(handler)
+	if proxies != nil || unixSockets {
+		handler = proxies.handler(handler)
+	}
+	if h2cServe {
+		handler = h2cHandler(handler)
+	}
+	srv := &http.Server{Addr: addrs[0], Handler: handler}
+	go shutdownOnSignal(srv, shutdownTimeout, wc)
+	go upgradeOnSignal(srv, shutdownTimeout, wc)
+	var ls []net.Listener
+	for _, addr := range addrs {
+		// listeners are named by their address, so that upgrades hand each to the new process if it still serves on it
+		l, err := listenOn("http "+addr, addr, os.FileMode(mode))
+		if err != nil {
+			fmt.Printf("🚨 %v\n", err)
+			os.Exit(1)
+		}
+		ls = append(ls, l)
+	}
+	running := strings.Join(addrs, ", ")
+	if autoTLS {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(autoTLSCache),
+			HostPolicy: routeHostPolicy(re),
+			Email:      autoTLSEmail,
+		}
+		srv.TLSConfig = autoTLSConfig(m)
+		if httpRedirect != "" {
+			serveHTTPRedirect(httpRedirect, m.HTTPHandler(httpsRedirectHandler(port)))
+		}
+		fmt.Printf("🚀 redirector %s running on %s with automatic tls\n", getBuildInfo().Version, running)
+		upgradeReady()
+		err = serveAll(ls, func(l net.Listener) error { return srv.ServeTLS(l, "", "") })
+	} else if certs != nil {
+		srv.TLSConfig = newTLSConfig()
+		srv.TLSConfig.GetCertificate = certs.GetCertificate
+		if httpRedirect != "" {
+			serveHTTPRedirect(httpRedirect, httpsRedirectHandler(port))
+		}
+		fmt.Printf("🚀 redirector %s running on %s with tls\n", getBuildInfo().Version, running)
+		upgradeReady()
+		err = serveAll(ls, func(l net.Listener) error { return srv.ServeTLS(l, "", "") })
+	} else {
+		fmt.Printf("🚀 redirector %s running on %s\n", getBuildInfo().Version, running)
+		upgradeReady()
+		err = serveAll(ls, srv.Serve)
+	}
+	if err == http.ErrServerClosed {
+		// shutdownOnSignal exits once requests have been drained
+		select {}
+	}
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		os.Exit(1)
 	}
-	return wc.cmd.Run()
-}
-
-var _ flag.Value = new(strslice)
-
-type strslice []string
-
-func (i *strslice) String() string {
-	return fmt.Sprint([]string(*i))
 }
 
-func (i *strslice) Set(value string) error {
-	*i = append(*i, value)
-	return nil
+// shutdownOnSignal stops srv from accepting connections on SIGINT or SIGTERM, and waits up to timeout for the requests
+// in flight to finish. It then exits, or when wrapping a command, passes the signal on to the command, which exits
+// redirector once it does.
+func shutdownOnSignal(srv *http.Server, timeout time.Duration, wc wrapped) {
+	chanSig := make(chan os.Signal, 1)
+	signal.Notify(chanSig, os.Interrupt, syscall.SIGTERM)
+	sig := <-chanSig
+	fmt.Printf("❗ got %s, shutting down...\n", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Printf("⚠️  requests were still in flight after %s: %v\n", timeout, err)
+	}
+	if wc != nil {
+		wc.Signal(sig)
+		return
+	}
+	os.Exit(0)
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maintenance answers requests with 503 Service Unavailable instead of following the routes while it's on, which it
+// is while it's been turned on, with -maintenance or through the admin api, or while its flag file exists
+type maintenance struct {
+	// hosts are the hosts that it applies to, as hostnames or *.<domain> for every subdomain. it applies to every host
+	// if there are none.
+	hosts      []string
+	retryAfter time.Duration
+	page       *template.Template
+	file       string
+
+	mu      sync.Mutex
+	enabled bool
+	flagged bool
+	// on is whether it was on the last time that it was checked, to log when it changes
+	on bool
+}
+
+func newMaintenance(enabled bool, file, page string, hosts []string, retryAfter time.Duration) (*maintenance, error) {
+	m := &maintenance{hosts: hosts, retryAfter: retryAfter, file: file, enabled: enabled}
+	for i, h := range m.hosts {
+		m.hosts[i] = strings.ToLower(strings.TrimSpace(h))
+	}
+	if page != "" {
+		tmpl, err := template.ParseFiles(page)
+		if err != nil {
+			return nil, err
+		}
+		m.page = tmpl
+	}
+	if file != "" {
+		m.flagged = fileExists(file)
+		go m.watchFile()
+	}
+	m.changed()
+	return m, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// watchFile checks whether the flag file exists every second
+func (m *maintenance) watchFile() {
+	for range time.Tick(time.Second) {
+		flagged := fileExists(m.file)
+		m.mu.Lock()
+		m.flagged = flagged
+		m.mu.Unlock()
+		m.changed()
+	}
+}
+
+// set turns maintenance mode on or off. It stays on while the flag file exists.
+func (m *maintenance) set(enabled bool) {
+	m.mu.Lock()
+	m.enabled = enabled
+	m.mu.Unlock()
+	m.changed()
+}
+
+// active returns whether maintenance mode is on
+func (m *maintenance) active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.enabled || m.flagged
+}
+
+// changed logs whether maintenance mode is on if that changed since the last time
+func (m *maintenance) changed() {
+	m.mu.Lock()
+	on := m.enabled || m.flagged
+	changed := on != m.on
+	m.on = on
+	m.mu.Unlock()
+	switch {
+	case changed && on:
+		fmt.Printf("🚧 maintenance mode is on, answering %s with 503s\n", m.scope())
+	case changed:
+		fmt.Printf("✅ maintenance mode is off\n")
+	}
+}
+
+// scope describes the hosts that maintenance mode applies to
+func (m *maintenance) scope() string {
+	if len(m.hosts) == 0 {
+		return "every request"
+	}
+	return "requests for " + strings.Join(m.hosts, ", ")
+}
+
+// covers returns whether maintenance mode applies to requests for host
+func (m *maintenance) covers(host string) bool {
+	if len(m.hosts) == 0 {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range m.hosts {
+		if h == host || (strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// handler answers the requests that maintenance mode applies to while it's on, and passes the others on to h
+func (m *maintenance) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !m.active() || !m.covers(req.Host) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+		w.Header().Set("Cache-Control", "no-store")
+		if m.page == nil {
+			http.Error(w, "down for maintenance, try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		data := notFoundData{
+			Host:      req.Host,
+			Path:      req.URL.Path,
+			URL:       scheme + "://" + req.Host + req.URL.RequestURI(),
+			RequestID: req.Header.Get(requestIDHeader),
+		}
+		var buf bytes.Buffer
+		if err := m.page.Execute(&buf, data); err != nil {
+			log.Printf("rendering the maintenance page for %q (request %s): %v", data.URL, data.RequestID, err)
+			http.Error(w, "down for maintenance, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		buf.WriteTo(w)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// durationBuckets are the upper bounds of the request duration histogram's buckets, in seconds
+var durationBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+// promCollector is a redirector.Collector that serves its metrics in the prometheus text format
+type promCollector struct {
+	mu          sync.Mutex
+	redirects   map[redirectKey]uint64
+	lastHits    map[string]time.Time
+	misses      uint64
+	proxyErrors uint64
+	started     time.Time
+	buckets     []uint64
+	count       uint64
+	sum         float64
+}
+
+type redirectKey struct {
+	pattern string
+	code    int
+}
+
+var _ redirector.Collector = new(promCollector)
+
+func newPromCollector() *promCollector {
+	return &promCollector{
+		redirects: make(map[redirectKey]uint64),
+		lastHits:  make(map[string]time.Time),
+		started:   time.Now(),
+		buckets:   make([]uint64, len(durationBuckets)),
+	}
+}
+
+// Matched implements redirector.Collector.Matched
+func (c *promCollector) Matched(route *redirector.Route, duration time.Duration) {
+	seconds := duration.Seconds()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.redirects[redirectKey{route.Pattern, route.Code}]++
+	c.lastHits[route.Pattern] = time.Now()
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			c.buckets[i]++
+		}
+	}
+	c.count++
+	c.sum += seconds
+}
+
+// Missed implements redirector.Collector.Missed. Misses aren't labeled by pattern since anyone can make up requests
+// for any number of them.
+func (c *promCollector) Missed(string) {
+	c.mu.Lock()
+	c.misses++
+	c.mu.Unlock()
+}
+
+// ProxyError implements redirector.Collector.ProxyError
+func (c *promCollector) ProxyError(error) {
+	c.mu.Lock()
+	c.proxyErrors++
+	c.mu.Unlock()
+}
+
+// ServeHTTP serves the metrics in the prometheus text format
+func (c *promCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var b strings.Builder
+	c.mu.Lock()
+	keys := make([]redirectKey, 0, len(c.redirects))
+	for k := range c.redirects {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].pattern != keys[j].pattern {
+			return keys[i].pattern < keys[j].pattern
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	b.WriteString("# HELP redirector_redirects_total Requests that matched a route, by route pattern and status code.\n")
+	b.WriteString("# TYPE redirector_redirects_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "redirector_redirects_total{pattern=\"%s\",code=\"%d\"} %d\n", escapeLabel(k.pattern), k.code, c.redirects[k])
+	}
+	b.WriteString("# HELP redirector_misses_total Requests that didn't match any route.\n")
+	b.WriteString("# TYPE redirector_misses_total counter\n")
+	fmt.Fprintf(&b, "redirector_misses_total %d\n", c.misses)
+	b.WriteString("# HELP redirector_proxy_errors_total Requests that failed to be forwarded to the wrapped command or default proxy.\n")
+	b.WriteString("# TYPE redirector_proxy_errors_total counter\n")
+	fmt.Fprintf(&b, "redirector_proxy_errors_total %d\n", c.proxyErrors)
+	b.WriteString("# HELP redirector_proxy_cache_hits_total Requests for the wrapped command that were served from its -cache.\n")
+	b.WriteString("# TYPE redirector_proxy_cache_hits_total counter\n")
+	fmt.Fprintf(&b, "redirector_proxy_cache_hits_total %d\n", proxyCacheHits.Load())
+	b.WriteString("# HELP redirector_proxy_cache_misses_total Requests for the wrapped command that could have been served from its -cache but weren't in it.\n")
+	b.WriteString("# TYPE redirector_proxy_cache_misses_total counter\n")
+	fmt.Fprintf(&b, "redirector_proxy_cache_misses_total %d\n", proxyCacheMisses.Load())
+	b.WriteString("# HELP redirector_request_duration_seconds Time taken to handle requests that matched a route.\n")
+	b.WriteString("# TYPE redirector_request_duration_seconds histogram\n")
+	for i, le := range durationBuckets {
+		fmt.Fprintf(&b, "redirector_request_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), c.buckets[i])
+	}
+	fmt.Fprintf(&b, "redirector_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", c.count)
+	fmt.Fprintf(&b, "redirector_request_duration_seconds_sum %s\n", strconv.FormatFloat(c.sum, 'g', -1, 64))
+	fmt.Fprintf(&b, "redirector_request_duration_seconds_count %d\n", c.count)
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// hits returns the number of requests that matched each route pattern
+func (c *promCollector) hits() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hits := make(map[string]uint64, len(c.redirects))
+	for k, n := range c.redirects {
+		hits[k.pattern] += n
+	}
+	return hits
+}
+
+// routeStats are the hit counts of a route for the admin api's /stats
+type routeStats struct {
+	Pattern string `json:"pattern"`
+	Source  string `json:"source,omitempty"`
+	Hits    uint64 `json:"hits"`
+	// LastHit is when the route last matched a request, or nil if it hasn't since redirector started
+	LastHit *time.Time `json:"last_hit"`
+}
+
+// stats returns the hit counts and last hits of routes, including those that haven't been hit, along with the number
+// of misses and proxy errors
+func (c *promCollector) stats(routes []*redirector.Route) ([]routeStats, uint64, uint64) {
+	hits := c.hits()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make([]routeStats, 0, len(routes))
+	for _, r := range routes {
+		s := routeStats{Pattern: r.Pattern, Source: r.Source, Hits: hits[r.Pattern]}
+		if t, ok := c.lastHits[r.Pattern]; ok {
+			s.LastHit = &t
+		}
+		stats = append(stats, s)
+	}
+	return stats, c.misses, c.proxyErrors
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
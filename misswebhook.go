@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+const (
+	// missWebhookInterval is how often batches of misses are sent, so the webhook gets at most one request per interval
+	missWebhookInterval = time.Minute
+	// missWebhookBatchSize is how many distinct misses a batch holds. the rest are only counted.
+	missWebhookBatchSize = 1000
+	// missWebhookForget is how long misses that have been sent aren't sent again for
+	missWebhookForget = 24 * time.Hour
+)
+
+// missWebhook batches the host and path combinations of requests that don't match any route, and POSTs them to a
+// webhook, so that links that are missing routes can be found. Each combination is only sent once a day.
+type missWebhook struct {
+	url string
+
+	mu      sync.Mutex
+	batch   map[string]*missEntry
+	dropped int
+	// sent are the misses that have been sent since forgotten
+	sent      map[string]bool
+	forgotten time.Time
+}
+
+// missEntry is a miss in a webhook batch
+type missEntry struct {
+	Pattern   string    `json:"pattern"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	// Referer is the referer of the first request, which is usually the page with the missing link
+	Referer string `json:"referer,omitempty"`
+}
+
+func newMissWebhook(url string) *missWebhook {
+	return &missWebhook{
+		url:       url,
+		batch:     make(map[string]*missEntry),
+		sent:      make(map[string]bool),
+		forgotten: time.Now(),
+	}
+}
+
+// record adds req to the next batch. It's used with redirector.WithOnMiss.
+func (m *missWebhook) record(req *http.Request) {
+	pattern := redirector.RequestPattern(req)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sent[pattern] {
+		return
+	}
+	if e, ok := m.batch[pattern]; ok {
+		e.Count++
+		return
+	}
+	if len(m.batch) >= missWebhookBatchSize {
+		m.dropped++
+		return
+	}
+	m.batch[pattern] = &missEntry{Pattern: pattern, Count: 1, FirstSeen: time.Now(), Referer: req.Referer()}
+}
+
+// run sends a batch every interval, if there were any misses
+func (m *missWebhook) run() {
+	for range time.Tick(missWebhookInterval) {
+		if err := m.flush(); err != nil {
+			fmt.Printf("❌ -miss-webhook: %v\n", err)
+		}
+	}
+}
+
+func (m *missWebhook) flush() error {
+	m.mu.Lock()
+	if time.Since(m.forgotten) > missWebhookForget {
+		m.sent, m.forgotten = make(map[string]bool), time.Now()
+	}
+	if len(m.batch) == 0 {
+		m.mu.Unlock()
+		return nil
+	}
+	misses := make([]*missEntry, 0, len(m.batch))
+	for pattern, e := range m.batch {
+		misses = append(misses, e)
+		m.sent[pattern] = true
+	}
+	dropped := m.dropped
+	m.batch, m.dropped = make(map[string]*missEntry), 0
+	m.mu.Unlock()
+
+	sort.Slice(misses, func(i, j int) bool { return misses[i].Count > misses[j].Count })
+	body, err := json.Marshal(map[string]interface{}{"misses": misses, "dropped": dropped})
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	res, err := client.Post(m.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", m.url, res.Status)
+	}
+	return nil
+}
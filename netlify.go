@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// netlifyPlaceholder is a placeholder in a Netlify rule, such as :splat or :year
+var netlifyPlaceholder = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+
+// readNetlifyRedirects reads a Netlify _redirects file, converting each rule to a route. Rules whose paths have no
+// hostname are on host, or on any host if it's empty.
+func readNetlifyRedirects(path, host string) ([]routeLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []routeLine
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		l := routeLine{n: n, text: scanner.Text()}
+		if l.isRoute() {
+			var s string
+			if s, l.err = netlifyRoute(l.text, host); l.err == nil {
+				l.route, l.err = redirector.NewRoute(s)
+			}
+		}
+		lines = append(lines, l)
+	}
+	return lines, scanner.Err()
+}
+
+// netlifyRoute converts a Netlify rule, `<from> [<param>=<value>]... <to> [<status>[!]] [<condition>=<values>]...`,
+// to a route in the -route syntax
+func netlifyRoute(rule, host string) (string, error) {
+	fields := strings.Fields(rule)
+	from, fields := fields[0], fields[1:]
+
+	// query parameters to match come between the paths
+	var (
+		matchQuery  []string
+		queryValues = make(map[string]bool)
+	)
+	for len(fields) > 0 && strings.Contains(fields[0], "=") && !strings.HasPrefix(fields[0], "/") && !strings.Contains(fields[0], "://") {
+		name, value := fields[0], ""
+		if i := strings.IndexByte(name, '='); i != -1 {
+			name, value = name[:i], name[i+1:]
+		}
+		if strings.HasPrefix(value, ":") {
+			// any value
+			matchQuery = append(matchQuery, "match_query="+name)
+			queryValues[strings.ToLower(value[1:])] = true
+		} else {
+			matchQuery = append(matchQuery, "match_query="+name+"="+value)
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return "", errors.New("rule is missing a destination")
+	}
+	to, fields := fields[0], fields[1:]
+
+	code := http.StatusMovedPermanently
+	if len(fields) > 0 && len(strings.TrimSuffix(fields[0], "!")) == 3 {
+		// a trailing ! forces the rule even if a file exists at the path, which is the only way redirector works
+		c, err := strconv.Atoi(strings.TrimSuffix(fields[0], "!"))
+		if err != nil {
+			return "", fmt.Errorf("invalid status %q", fields[0])
+		}
+		code, fields = c, fields[1:]
+	}
+
+	var options []string
+	for _, cond := range fields {
+		i := strings.IndexByte(cond, '=')
+		if i == -1 {
+			return "", fmt.Errorf("unexpected %q", cond)
+		}
+		switch name := cond[:i]; name {
+		case "Country":
+			options = append(options, "country="+strings.ToUpper(cond[i+1:]))
+		case "Cookie":
+			if strings.Contains(cond[i+1:], ",") {
+				return "", errors.New("Cookie conditions with more than one cookie aren't supported")
+			}
+			options = append(options, "cookie="+cond[i+1:])
+		default:
+			return "", fmt.Errorf("the %s condition isn't supported", name)
+		}
+	}
+
+	pattern, patternHost, names, err := netlifyPattern(from, host)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case code == http.StatusGone:
+		return strings.Join(append(append([]string{pattern, "gone"}, options...), matchQuery...), " "), nil
+	case code == 200 || code == 404:
+		return "", fmt.Errorf("%d rewrites and custom pages aren't supported, only redirects", code)
+	case code < 300 || code > 399:
+		return "", fmt.Errorf("status %d isn't supported", code)
+	}
+
+	dest := to
+	if !strings.Contains(dest, "://") {
+		if !strings.HasPrefix(dest, "/") {
+			return "", fmt.Errorf("invalid destination %q", to)
+		}
+		if patternHost == "*" {
+			return "", errors.New("destinations without a hostname need -redirects-host")
+		}
+		dest = patternHost + dest
+	}
+	var missing string
+	dest = replaceAfterHost(dest, func(s string) string {
+		return netlifyPlaceholder.ReplaceAllStringFunc(s, func(p string) string {
+			name := strings.ToLower(p[1:])
+			if name == "splat" {
+				if !strings.HasSuffix(pattern, "/*") && missing == "" {
+					missing = p
+				}
+				return "{*}"
+			}
+			if !names[name] && missing == "" {
+				missing = p
+			}
+			return "{" + name + "}"
+		})
+	})
+	if queryValues[strings.ToLower(strings.TrimPrefix(missing, ":"))] {
+		return "", fmt.Errorf("the destination uses the query parameter value %s, which isn't supported", missing)
+	}
+	if missing != "" {
+		return "", fmt.Errorf("the destination uses %s, which the path doesn't capture", missing)
+	}
+
+	parts := []string{pattern, dest}
+	if len(matchQuery) == 0 {
+		// netlify passes the query on unless the rule matches on it
+		parts = append(parts, "query")
+	}
+	parts = append(parts, options...)
+	parts = append(parts, matchQuery...)
+	parts = append(parts, "code="+strconv.Itoa(code))
+	return strings.Join(parts, " "), nil
+}
+
+// netlifyPattern converts the path of a Netlify rule to a pattern, returning it, its hostname, and the names of its
+// placeholders
+func netlifyPattern(from, host string) (string, string, map[string]bool, error) {
+	path := from
+	if strings.Contains(from, "://") {
+		u, err := url.Parse(from)
+		if err != nil {
+			return "", "", nil, err
+		}
+		host, path = u.Host, u.Path
+	} else if !strings.HasPrefix(from, "/") {
+		return "", "", nil, fmt.Errorf("invalid path %q", from)
+	}
+	if host == "" {
+		host = "*"
+	}
+
+	names := make(map[string]bool)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, seg := range segments {
+		switch {
+		case seg == "*" && i != len(segments)-1:
+			return "", "", nil, errors.New("splats are only supported at the end of the path")
+		case strings.HasPrefix(seg, ":"):
+			name := strings.ToLower(seg[1:])
+			if !netlifyPlaceholder.MatchString(seg) || netlifyPlaceholder.FindString(seg) != seg {
+				return "", "", nil, fmt.Errorf("invalid placeholder %q", seg)
+			}
+			names[name] = true
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return host + "/" + strings.Join(segments, "/"), host, names, nil
+}
+
+// replaceAfterHost replaces the path and query of a url with f, leaving the scheme and host alone since they may
+// contain colons
+func replaceAfterHost(dest string, f func(string) string) string {
+	start := 0
+	if i := strings.Index(dest, "://"); i != -1 {
+		start = i + 3
+	}
+	i := strings.IndexByte(dest[start:], '/')
+	if i == -1 {
+		return dest
+	}
+	return dest[:start+i] + f(dest[start+i:])
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// notFoundData is what -not-found-page templates can use
+type notFoundData struct {
+	// Host is the request's host
+	Host string
+	// Path is the request's path
+	Path string
+	// URL is the full url of the request
+	URL string
+	// RequestID is the request's X-Request-ID, for users to quote in support requests
+	RequestID string
+}
+
+// notFoundHandler answers requests that don't match any routes with a 404 rendered from the html template at path
+func notFoundHandler(path string) (http.Handler, error) {
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		data := notFoundData{
+			Host:      req.Host,
+			Path:      req.URL.Path,
+			URL:       scheme + "://" + req.Host + req.URL.RequestURI(),
+			RequestID: req.Header.Get(requestIDHeader),
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			log.Printf("rendering the not found page for %q (request %s): %v", data.URL, data.RequestID, err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		buf.WriteTo(w)
+	}), nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
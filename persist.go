@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+var (
+	// putsBucket holds the routes put through the admin api, as json, by routeKey
+	putsBucket = []byte("puts")
+	// deletesBucket holds the patterns deleted through the admin api
+	deletesBucket = []byte("deletes")
+)
+
+// openStore opens the bolt database at path that the changes made to an overlayStore are persisted to, creating it if
+// it doesn't exist
+func openStore(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{putsBucket, deletesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return db, nil
+}
+
+// persistTo loads the changes that were persisted to db into the store, and persists every change made from now on.
+// Persisted routes that are invalid, or that can't be used along with the other routes, are reported and skipped, so
+// that they can't keep redirector from starting.
+func (s *overlayStore) persistTo(ctx context.Context, db *bolt.DB) error {
+	type persisted struct{ key, data []byte }
+	var puts []persisted
+	err := db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(putsBucket).ForEach(func(k, v []byte) error {
+			puts = append(puts, persisted{append([]byte(nil), k...), append([]byte(nil), v...)})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return tx.Bucket(deletesBucket).ForEach(func(k, _ []byte) error {
+			s.deletes[string(k)] = true
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", db.Path(), err)
+	}
+
+	for _, p := range puts {
+		var route redirector.Route
+		err := json.Unmarshal(p.data, &route)
+		if err == nil {
+			err = route.Validate()
+		}
+		if err == nil {
+			err = s.check(ctx, &route)
+		}
+		if err != nil {
+			fmt.Printf("⚠️  skipping route %q from %s: %v\n", strings.TrimSpace(string(p.key)), db.Path(), err)
+			continue
+		}
+		route.Source = "admin api"
+		s.mu.Lock()
+		s.puts[string(p.key)] = &route
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	s.db = db
+	s.mu.Unlock()
+	return nil
+}
+
+// persistPut persists that route was put, if the store is persisted
+func (s *overlayStore) persistPut(route *redirector.Route) error {
+	if s.db == nil {
+		return nil
+	}
+	data, err := json.Marshal(route)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(deletesBucket).Delete([]byte(route.Pattern)); err != nil {
+			return err
+		}
+		return tx.Bucket(putsBucket).Put([]byte(routeKey(route)), data)
+	})
+}
+
+// persistDelete persists that the routes with pattern were deleted, if the store is persisted
+func (s *overlayStore) persistDelete(pattern string) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		puts := tx.Bucket(putsBucket)
+		for key, r := range s.puts {
+			if r.Pattern == pattern {
+				if err := puts.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Bucket(deletesBucket).Put([]byte(pattern), nil)
+	})
+}
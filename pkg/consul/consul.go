@@ -0,0 +1,354 @@
+// Package consul stores redirector routes in Consul's key-value store, so that a fleet of redirectors can share one
+// route table. Every key under a prefix holds routes in the -route syntax, one per line, and changes are picked up
+// within seconds with blocking queries. It talks to the Consul HTTP API directly rather than through its client
+// library.
+//
+//	consul kv put redirector/routes/www 'www.example.com/* example.com path query code=301'
+package consul
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// DefaultPrefix is the key prefix that routes are stored under by default
+const DefaultPrefix = "redirector/routes"
+
+// Config configures access to Consul
+type Config struct {
+	// Address is the URL of the Consul agent, e.g. http://127.0.0.1:8500. Addresses without a scheme use http.
+	Address string
+	// Token is an ACL token to authenticate with, if any
+	Token string
+	// Prefix is the key prefix that routes are stored under. It defaults to DefaultPrefix.
+	Prefix string
+}
+
+// EnvConfig returns the configuration from the environment variables that the consul command uses:
+// $CONSUL_HTTP_ADDR, which defaults to http://127.0.0.1:8500, and $CONSUL_HTTP_TOKEN
+func EnvConfig() Config {
+	addr := os.Getenv("CONSUL_HTTP_ADDR")
+	if addr == "" {
+		addr = "http://127.0.0.1:8500"
+	}
+	return Config{Address: addr, Token: os.Getenv("CONSUL_HTTP_TOKEN")}
+}
+
+// Store is a redirector.RouteStore of the routes under a prefix in Consul's key-value store. Put stores each route
+// under its own key, named after its pattern and conditions, and Delete removes the routes with a pattern from
+// whichever keys hold them.
+type Store struct {
+	redirector.Broadcaster
+	cfg  Config
+	http *http.Client
+
+	mu       sync.Mutex
+	loaded   bool
+	index    uint64
+	entries  map[string]string
+	watching bool
+}
+
+var _ redirector.RouteStore = new(Store)
+
+// New creates a Store
+func New(cfg Config) (*Store, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("the consul address must be set")
+	}
+	if !strings.Contains(cfg.Address, "://") {
+		cfg.Address = "http://" + cfg.Address
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	return &Store{cfg: cfg, http: &http.Client{}}, nil
+}
+
+// kvPair is an entry of Consul's key-value store
+type kvPair struct {
+	Key string
+	// Value is base64 encoded in Consul's responses, which encoding/json decodes
+	Value []byte
+}
+
+// do sends a request for the key-value api path of key with query q
+func (s *Store) do(ctx context.Context, method, key string, q url.Values, body string) (*http.Response, error) {
+	u := strings.TrimSuffix(s.cfg.Address, "/") + (&url.URL{Path: "/v1/kv/" + key}).EscapedPath()
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", s.cfg.Token)
+	}
+	res, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && !(method == http.MethodGet && res.StatusCode == http.StatusNotFound) {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+		return nil, fmt.Errorf("consul: %d %s: %s", res.StatusCode, http.StatusText(res.StatusCode), strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+// fetch lists the keys under the prefix. If index is set, it blocks until they change after that index or the query
+// times out. It returns the index of the result.
+func (s *Store) fetch(ctx context.Context, index uint64) (map[string]string, uint64, error) {
+	q := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", "5m")
+	}
+	res, err := s.do(ctx, http.MethodGet, s.cfg.Prefix+"/", q, "")
+	if err != nil {
+		return nil, index, err
+	}
+	defer res.Body.Close()
+	next, _ := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+
+	entries := make(map[string]string)
+	if res.StatusCode == http.StatusNotFound {
+		// there are no keys under the prefix yet
+		return entries, next, nil
+	}
+	var pairs []kvPair
+	if err := json.NewDecoder(res.Body).Decode(&pairs); err != nil {
+		return nil, index, fmt.Errorf("decoding keys: %v", err)
+	}
+	for _, p := range pairs {
+		if !strings.HasSuffix(p.Key, "/") {
+			entries[p.Key] = string(p.Value)
+		}
+	}
+	return entries, next, nil
+}
+
+// refresh replaces the known entries with the ones in Consul
+func (s *Store) refresh(ctx context.Context) error {
+	entries, index, err := s.fetch(ctx, 0)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.entries, s.index, s.loaded = entries, index, true
+	s.mu.Unlock()
+	return nil
+}
+
+// List implements redirector.RouteStore.List. Invalid routes are logged and skipped.
+func (s *Store) List(ctx context.Context) ([]*redirector.Route, error) {
+	s.mu.Lock()
+	loaded := s.loaded
+	s.mu.Unlock()
+	if !loaded {
+		if err := s.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.entries))
+	for k := range s.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var routes []*redirector.Route
+	for _, k := range keys {
+		for _, l := range parseEntry(s.entries[k]) {
+			if l.err != nil {
+				log.Printf("consul key %s: %v", k, l.err)
+				continue
+			}
+			if l.route == nil {
+				continue
+			}
+			l.route.Source = "consul " + k
+			routes = append(routes, l.route)
+		}
+	}
+	return routes, nil
+}
+
+// Watch implements redirector.RouteStore.Watch. The first call starts watching Consul until ctx is done.
+func (s *Store) Watch(ctx context.Context) (<-chan struct{}, error) {
+	s.mu.Lock()
+	if !s.watching {
+		s.watching = true
+		go s.watch(ctx)
+	}
+	s.mu.Unlock()
+	return s.Broadcaster.Watch(ctx)
+}
+
+func (s *Store) watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		index := s.index
+		s.mu.Unlock()
+
+		entries, next, err := s.fetch(ctx, index)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("watching consul: %v", err)
+				sleep(ctx, 5*time.Second)
+			}
+			continue
+		}
+		if next < index {
+			// the index went backwards, e.g. because consul's state was restored, so start over
+			next = 0
+		}
+
+		s.mu.Lock()
+		changed := !s.loaded || !equal(s.entries, entries)
+		s.entries, s.index, s.loaded = entries, next, true
+		s.mu.Unlock()
+		if changed {
+			s.Notify()
+		}
+	}
+}
+
+// Put implements redirector.RouteStore.Put. Any route with the same pattern and conditions is removed from the keys
+// that hold it first.
+func (s *Store) Put(ctx context.Context, route *redirector.Route) error {
+	if err := route.Validate(); err != nil {
+		return err
+	}
+	key := route.Pattern + " " + route.Conditions()
+	if err := s.remove(ctx, func(r *redirector.Route) bool { return r.Pattern+" "+r.Conditions() == key }); err != nil {
+		return err
+	}
+	res, err := s.do(ctx, http.MethodPut, s.cfg.Prefix+"/"+url.PathEscape(strings.TrimSpace(key)), nil, route.String())
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return s.refresh(ctx)
+}
+
+// Delete implements redirector.RouteStore.Delete
+func (s *Store) Delete(ctx context.Context, pattern string) error {
+	found := false
+	err := s.remove(ctx, func(r *redirector.Route) bool {
+		found = found || r.Pattern == pattern
+		return r.Pattern == pattern
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return redirector.ErrNotFound
+	}
+	return s.refresh(ctx)
+}
+
+// remove removes the routes that match from every key, deleting the keys that are left without routes
+func (s *Store) remove(ctx context.Context, match func(*redirector.Route) bool) error {
+	entries, _, err := s.fetch(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for key, value := range entries {
+		var kept []string
+		removed := false
+		for _, l := range parseEntry(value) {
+			if l.route != nil && match(l.route) {
+				removed = true
+				continue
+			}
+			kept = append(kept, l.text)
+		}
+		if !removed {
+			continue
+		}
+
+		method, body := http.MethodDelete, ""
+		if len(kept) > 0 {
+			method, body = http.MethodPut, strings.Join(kept, "\n")
+		}
+		res, err := s.do(ctx, method, key, nil, body)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+	}
+	return nil
+}
+
+// entryLine is a line of a key's value: a route, a route that failed to parse, or a comment or empty line, which
+// have no route
+type entryLine struct {
+	text  string
+	route *redirector.Route
+	err   error
+}
+
+// parseEntry parses the routes in a key's value, one per line. Empty lines and lines starting with # are skipped.
+func parseEntry(value string) []entryLine {
+	var lines []entryLine
+	scanner := bufio.NewScanner(strings.NewReader(value))
+	for scanner.Scan() {
+		l := entryLine{text: scanner.Text()}
+		if s := strings.TrimSpace(l.text); s != "" && !strings.HasPrefix(s, "#") {
+			l.route, l.err = redirector.NewRoute(s)
+			if l.err == nil {
+				l.err = l.route.Validate()
+			}
+			if l.err != nil {
+				l.err, l.route = fmt.Errorf("route %q: %v", s, l.err), nil
+			}
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func equal(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,501 @@
+// Package kubernetes builds redirector routes from Ingress objects or Redirect custom resources in a Kubernetes cluster,
+// keeping them in sync as the objects change. It talks to the Kubernetes API directly rather than through client-go.
+//
+// Ingresses opt in with annotations:
+//
+//	redirector/routes: |
+//	  www.example.com/* example.com path query code=301
+//	  blog.example.com/* example.com/blog path code=301
+//
+// or, to redirect every host and path of the Ingress's rules to one destination:
+//
+//	redirector/redirect-to: https://example.com
+//	redirector/options: path query code=301
+//
+// Redirects list their routes in the spec, either in the -route syntax or as route documents:
+//
+//	apiVersion: redirector.kamaln7.github.io/v1
+//	kind: Redirect
+//	metadata:
+//	  name: www
+//	spec:
+//	  routes:
+//	    - www.example.com/* example.com path query code=301
+//	    - pattern: blog.example.com/*
+//	      destination: example.com/blog
+//	      path: true
+package kubernetes
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+const (
+	// AnnotationRoutes holds routes in the -route syntax, one per line
+	AnnotationRoutes = "redirector/routes"
+	// AnnotationRedirectTo is a destination that every host and path of the Ingress's rules redirect to
+	AnnotationRedirectTo = "redirector/redirect-to"
+	// AnnotationOptions are route options, such as "path query code=301", for routes created by AnnotationRedirectTo
+	AnnotationOptions = "redirector/options"
+
+	// RedirectGroup and RedirectVersion are the api group and version of the Redirect custom resource
+	RedirectGroup   = "redirector.kamaln7.github.io"
+	RedirectVersion = "v1"
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// Config configures access to the Kubernetes API
+type Config struct {
+	// Server is the API server's URL, e.g. https://10.0.0.1:443 or http://127.0.0.1:8001 for kubectl proxy
+	Server string
+	// Token is a bearer token to authenticate with, if any
+	Token string
+	// CAFile is a file containing the CA certificates to verify the API server with. The system's are used if empty.
+	CAFile string
+	// Namespace limits the watched objects to one namespace. All namespaces are watched if empty.
+	Namespace string
+}
+
+// InClusterConfig returns the configuration for running inside a pod, using its service account
+func InClusterConfig() (Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return Config{}, errors.New("not running in a kubernetes cluster: $KUBERNETES_SERVICE_HOST and $KUBERNETES_SERVICE_PORT must be set")
+	}
+	token, err := os.ReadFile(path.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{
+		Server: "https://" + net.JoinHostPort(host, port),
+		Token:  strings.TrimSpace(string(token)),
+		CAFile: path.Join(serviceAccountDir, "ca.crt"),
+	}, nil
+}
+
+// client is a minimal Kubernetes API client
+type client struct {
+	cfg  Config
+	http *http.Client
+}
+
+func newClient(cfg Config) (*client, error) {
+	if cfg.Server == "" {
+		return nil, errors.New("the api server must be set")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s doesn't contain any certificates", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &client{cfg: cfg, http: &http.Client{Transport: transport}}, nil
+}
+
+// get sends a GET request for the api path p with query q
+func (c *client) get(ctx context.Context, p string, q url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.cfg.Server, "/")+p+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+		return nil, &statusError{code: res.StatusCode, msg: strings.TrimSpace(string(body))}
+	}
+	return res, nil
+}
+
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("kubernetes api: %d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Annotations     map[string]string `json:"annotations"`
+}
+
+type listMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// object is a Kubernetes object that configures routes
+type object interface {
+	meta() objectMeta
+	routes() ([]*redirector.Route, []error)
+}
+
+// resource is a kind of object that a Store lists and watches
+type resource struct {
+	// kind names the objects in messages, e.g. ingress
+	kind string
+	// groupVersion and plural make up the api path of the objects, e.g. networking.k8s.io/v1 and ingresses
+	groupVersion string
+	plural       string
+	decode       func([]byte) (object, error)
+}
+
+var (
+	ingresses = resource{kind: "ingress", groupVersion: "networking.k8s.io/v1", plural: "ingresses", decode: func(data []byte) (object, error) {
+		var ing ingress
+		err := json.Unmarshal(data, &ing)
+		return &ing, err
+	}}
+	redirects = resource{kind: "redirect", groupVersion: RedirectGroup + "/" + RedirectVersion, plural: "redirects", decode: func(data []byte) (object, error) {
+		var rd redirect
+		err := json.Unmarshal(data, &rd)
+		return &rd, err
+	}}
+)
+
+type ingress struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		Rules []struct {
+			Host string `json:"host"`
+			HTTP *struct {
+				Paths []struct {
+					Path     string `json:"path"`
+					PathType string `json:"pathType"`
+				} `json:"paths"`
+			} `json:"http"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+func (ing *ingress) meta() objectMeta { return ing.Metadata }
+
+// routes returns the routes configured by the ingress's annotations
+func (ing *ingress) routes() ([]*redirector.Route, []error) {
+	var (
+		specs []string
+		errs  []error
+	)
+	scanner := bufio.NewScanner(strings.NewReader(ing.Metadata.Annotations[AnnotationRoutes]))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			specs = append(specs, line)
+		}
+	}
+
+	if dest := ing.Metadata.Annotations[AnnotationRedirectTo]; dest != "" {
+		opts := ing.Metadata.Annotations[AnnotationOptions]
+		for _, rule := range ing.Spec.Rules {
+			if rule.Host == "" {
+				errs = append(errs, errors.New("rules without a host can't be redirected"))
+				continue
+			}
+			if rule.HTTP == nil || len(rule.HTTP.Paths) == 0 {
+				specs = append(specs, fmt.Sprintf("%s/* %s %s", rule.Host, dest, opts))
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				pattern := rule.Host + "/" + strings.Trim(p.Path, "/")
+				if p.PathType != "Exact" {
+					pattern = strings.TrimSuffix(pattern, "/") + "/*"
+				}
+				specs = append(specs, fmt.Sprintf("%s %s %s", pattern, dest, opts))
+			}
+		}
+	}
+
+	var routes []*redirector.Route
+	for _, s := range specs {
+		r, err := redirector.NewRoute(s)
+		if err == nil {
+			err = r.Validate()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route %q: %v", s, err))
+			continue
+		}
+		r.Source = "ingress " + ing.Metadata.Namespace + "/" + ing.Metadata.Name
+		routes = append(routes, r)
+	}
+	return routes, errs
+}
+
+// redirect is a Redirect custom resource, whose spec lists routes
+type redirect struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		// Routes are either strings in the -route syntax or route documents
+		Routes []json.RawMessage `json:"routes"`
+	} `json:"spec"`
+}
+
+func (rd *redirect) meta() objectMeta { return rd.Metadata }
+
+// routes returns the routes listed in the redirect's spec
+func (rd *redirect) routes() ([]*redirector.Route, []error) {
+	var (
+		routes []*redirector.Route
+		errs   []error
+	)
+	for i, data := range rd.Spec.Routes {
+		r := new(redirector.Route)
+		err := json.Unmarshal(data, r)
+		if err == nil {
+			err = r.Validate()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route %d: %v", i+1, err))
+			continue
+		}
+		r.Source = "redirect " + rd.Metadata.Namespace + "/" + rd.Metadata.Name
+		routes = append(routes, r)
+	}
+	return routes, errs
+}
+
+// Store is a read-only redirector.RouteStore of the routes configured by Ingress annotations or Redirect resources
+type Store struct {
+	redirector.Broadcaster
+	client   *client
+	resource resource
+
+	mu       sync.Mutex
+	loaded   bool
+	objects  map[string]object
+	watching bool
+}
+
+var _ redirector.RouteStore = new(Store)
+
+// New creates a Store of the routes configured by Ingress annotations
+func New(cfg Config) (*Store, error) {
+	return newStore(cfg, ingresses)
+}
+
+// NewRedirects creates a Store of the routes listed by Redirect resources. The Redirect CustomResourceDefinition must
+// be installed in the cluster.
+func NewRedirects(cfg Config) (*Store, error) {
+	return newStore(cfg, redirects)
+}
+
+func newStore(cfg Config, res resource) (*Store, error) {
+	c, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{client: c, resource: res}, nil
+}
+
+func (s *Store) objectsPath() string {
+	if s.client.cfg.Namespace != "" {
+		return "/apis/" + s.resource.groupVersion + "/namespaces/" + url.PathEscape(s.client.cfg.Namespace) + "/" + s.resource.plural
+	}
+	return "/apis/" + s.resource.groupVersion + "/" + s.resource.plural
+}
+
+// List implements redirector.RouteStore.List. Objects with invalid routes are logged and skipped.
+func (s *Store) List(ctx context.Context) ([]*redirector.Route, error) {
+	s.mu.Lock()
+	loaded := s.loaded
+	s.mu.Unlock()
+	if !loaded {
+		if _, err := s.relist(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var routes []*redirector.Route
+	for _, k := range keys {
+		rs, errs := s.objects[k].routes()
+		for _, err := range errs {
+			log.Printf("%s %s: %v", s.resource.kind, k, err)
+		}
+		routes = append(routes, rs...)
+	}
+	return routes, nil
+}
+
+// relist replaces the known objects with a fresh list, returning the list's resource version
+func (s *Store) relist(ctx context.Context) (string, error) {
+	res, err := s.client.get(ctx, s.objectsPath(), nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var list struct {
+		Metadata listMeta          `json:"metadata"`
+		Items    []json.RawMessage `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("decoding %s: %v", s.resource.plural, err)
+	}
+
+	objects := make(map[string]object, len(list.Items))
+	for _, item := range list.Items {
+		obj, err := s.resource.decode(item)
+		if err != nil {
+			return "", fmt.Errorf("decoding %s: %v", s.resource.plural, err)
+		}
+		objects[obj.meta().Namespace+"/"+obj.meta().Name] = obj
+	}
+	s.mu.Lock()
+	s.objects = objects
+	s.loaded = true
+	s.mu.Unlock()
+	return list.Metadata.ResourceVersion, nil
+}
+
+// Watch implements redirector.RouteStore.Watch. The first call starts watching the cluster until ctx is done.
+func (s *Store) Watch(ctx context.Context) (<-chan struct{}, error) {
+	s.mu.Lock()
+	if !s.watching {
+		s.watching = true
+		go s.watch(ctx)
+	}
+	s.mu.Unlock()
+	return s.Broadcaster.Watch(ctx)
+}
+
+func (s *Store) watch(ctx context.Context) {
+	var rv string
+	for ctx.Err() == nil {
+		if rv == "" {
+			var err error
+			if rv, err = s.relist(ctx); err != nil {
+				log.Printf("listing %s: %v", s.resource.plural, err)
+				sleep(ctx, 5*time.Second)
+				continue
+			}
+			s.Notify()
+		}
+
+		var err error
+		rv, err = s.watchFrom(ctx, rv)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("watching %s: %v", s.resource.plural, err)
+			if se, ok := err.(*statusError); ok && se.code == http.StatusGone {
+				rv = ""
+			}
+			sleep(ctx, time.Second)
+		}
+	}
+}
+
+// watchFrom streams changes after the resource version rv until the stream ends, returning the last resource version
+// seen
+func (s *Store) watchFrom(ctx context.Context, rv string) (string, error) {
+	res, err := s.client.get(ctx, s.objectsPath(), url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {rv},
+		"allowWatchBookmarks": {"true"},
+	})
+	if err != nil {
+		return rv, err
+	}
+	defer res.Body.Close()
+
+	dec := json.NewDecoder(res.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&event); err != nil {
+			if err == io.EOF {
+				return rv, nil
+			}
+			return rv, err
+		}
+
+		if event.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(event.Object, &status)
+			return rv, &statusError{code: status.Code, msg: status.Message}
+		}
+		obj, err := s.resource.decode(event.Object)
+		if err != nil {
+			return rv, fmt.Errorf("decoding %s event: %v", event.Type, err)
+		}
+		meta := obj.meta()
+		rv = meta.ResourceVersion
+		key := meta.Namespace + "/" + meta.Name
+
+		s.mu.Lock()
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			s.objects[key] = obj
+		case "DELETED":
+			delete(s.objects, key)
+		}
+		s.mu.Unlock()
+		if event.Type != "BOOKMARK" {
+			s.Notify()
+		}
+	}
+}
+
+// Put implements redirector.RouteStore.Put. Routes are managed through Kubernetes objects, so it always fails.
+func (s *Store) Put(context.Context, *redirector.Route) error {
+	return redirector.ErrReadOnly
+}
+
+// Delete implements redirector.RouteStore.Delete. Routes are managed through Kubernetes objects, so it always fails.
+func (s *Store) Delete(context.Context, string) error {
+	return redirector.ErrReadOnly
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
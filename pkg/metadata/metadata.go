@@ -0,0 +1,309 @@
+// Package metadata loads redirector routes from the metadata service of the cloud instance that redirector runs on,
+// so that a redirector instance needs nothing but its own launch settings. Routes are in the -route syntax, one per
+// line, with empty lines and lines starting with # ignored, and are read from:
+//
+//   - DigitalOcean: the droplet's user data
+//   - AWS: the EC2 instance's user data, through IMDSv2
+//   - GCE: the instance's redirector-routes metadata attribute, which is watched for changes
+//
+// For example:
+//
+//	gcloud compute instances add-metadata redirector-1 \
+//	  --metadata=redirector-routes='www.example.com/* example.com path query code=301'
+//
+// User data can only change while an instance is stopped or not at all, so it's read once.
+package metadata
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// Provider is a cloud whose metadata service routes can be loaded from
+type Provider string
+
+const (
+	DigitalOcean Provider = "digitalocean"
+	AWS          Provider = "aws"
+	GCE          Provider = "gce"
+)
+
+// Providers are the supported providers, in the order that Detect tries them
+var Providers = []Provider{DigitalOcean, AWS, GCE}
+
+// GCEAttribute is the instance metadata attribute that routes are read from on GCE
+const GCEAttribute = "redirector-routes"
+
+// endpoints are the addresses of the providers' metadata services
+var endpoints = map[Provider]string{
+	DigitalOcean: "http://169.254.169.254",
+	AWS:          "http://169.254.169.254",
+	GCE:          "http://metadata.google.internal",
+}
+
+// Config configures access to a metadata service
+type Config struct {
+	Provider Provider
+	// Endpoint is the URL of the metadata service. It defaults to the provider's.
+	Endpoint string
+}
+
+// probes are requests that only the providers' own metadata services answer with 200 OK
+var probes = map[Provider]struct{ method, path, header, value string }{
+	DigitalOcean: {http.MethodGet, "/metadata/v1/id", "", ""},
+	AWS:          {http.MethodPut, "/latest/api/token", "X-aws-ec2-metadata-token-ttl-seconds", "60"},
+	GCE:          {http.MethodGet, "/computeMetadata/v1/", "Metadata-Flavor", "Google"},
+}
+
+// Detect returns the provider whose metadata service answers, trying each of them briefly
+func Detect(ctx context.Context) (Provider, error) {
+	client := &http.Client{Timeout: time.Second}
+	for _, p := range Providers {
+		probe := probes[p]
+		req, err := http.NewRequestWithContext(ctx, probe.method, endpoints[p]+probe.path, nil)
+		if err != nil {
+			return "", err
+		}
+		if probe.header != "" {
+			req.Header.Set(probe.header, probe.value)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			return p, nil
+		}
+	}
+	return "", errors.New("no metadata service of a supported cloud answered")
+}
+
+// Store is a redirector.RouteSource of the routes in an instance's metadata
+type Store struct {
+	redirector.Broadcaster
+	cfg  Config
+	http *http.Client
+
+	mu       sync.Mutex
+	loaded   bool
+	data     string
+	etag     string
+	watching bool
+}
+
+var _ redirector.RouteSource = new(Store)
+
+// New creates a Store
+func New(cfg Config) (*Store, error) {
+	if _, ok := endpoints[cfg.Provider]; !ok {
+		return nil, fmt.Errorf("unsupported provider %q", cfg.Provider)
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = endpoints[cfg.Provider]
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &Store{cfg: cfg, http: &http.Client{}}, nil
+}
+
+// metadata is the routes' metadata, with the etag that GCE sends to watch it with. missing is whether it isn't set.
+type metadata struct {
+	data, etag string
+	missing    bool
+}
+
+// fetch reads the metadata that holds the routes. If etag is set, it blocks until the metadata changes from that
+// version or the request times out. Metadata that isn't set is empty.
+func (s *Store) fetch(ctx context.Context, etag string) (metadata, error) {
+	var (
+		u      string
+		header = make(http.Header)
+	)
+	switch s.cfg.Provider {
+	case DigitalOcean:
+		u = s.cfg.Endpoint + "/metadata/v1/user-data"
+	case AWS:
+		token, err := s.awsToken(ctx)
+		if err != nil {
+			return metadata{}, err
+		}
+		u = s.cfg.Endpoint + "/latest/user-data"
+		header.Set("X-aws-ec2-metadata-token", token)
+	case GCE:
+		u = s.cfg.Endpoint + "/computeMetadata/v1/instance/attributes/" + GCEAttribute
+		if etag != "" {
+			u += "?wait_for_change=true&timeout_sec=300&last_etag=" + etag
+		}
+		header.Set("Metadata-Flavor", "Google")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return metadata{}, err
+	}
+	req.Header = header
+	res, err := s.http.Do(req)
+	if err != nil {
+		return metadata{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return metadata{etag: res.Header.Get("ETag"), missing: true}, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return metadata{}, fmt.Errorf("%s: %s", u, res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, 16<<20))
+	if err != nil {
+		return metadata{}, fmt.Errorf("%s: %v", u, err)
+	}
+	return metadata{data: string(data), etag: res.Header.Get("ETag")}, nil
+}
+
+// awsToken gets a session token for IMDSv2
+func (s *Store) awsToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.cfg.Endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	res, err := s.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting an imds token: %s", res.Status)
+	}
+	token, err := io.ReadAll(io.LimitReader(res.Body, 4096))
+	if err != nil {
+		return "", fmt.Errorf("getting an imds token: %v", err)
+	}
+	return string(token), nil
+}
+
+// List implements redirector.RouteSource.List. The metadata is read the first time.
+func (s *Store) List(ctx context.Context) ([]*redirector.Route, error) {
+	s.mu.Lock()
+	loaded := s.loaded
+	s.mu.Unlock()
+	if !loaded {
+		md, err := s.fetch(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.data, s.etag, s.loaded = md.data, md.etag, true
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return parse(s.source(), s.data)
+}
+
+// source is the Source of the routes
+func (s *Store) source() string {
+	if s.cfg.Provider == GCE {
+		return "gce " + GCEAttribute
+	}
+	return string(s.cfg.Provider) + " user data"
+}
+
+// Watch implements redirector.RouteSource.Watch. On GCE, the first call starts watching the metadata until ctx is
+// done. User data doesn't change while an instance runs, so it isn't watched.
+func (s *Store) Watch(ctx context.Context) (<-chan struct{}, error) {
+	s.mu.Lock()
+	if !s.watching && s.cfg.Provider == GCE {
+		s.watching = true
+		go s.watch(ctx)
+	}
+	s.mu.Unlock()
+	return s.Broadcaster.Watch(ctx)
+}
+
+func (s *Store) watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		etag := s.etag
+		s.mu.Unlock()
+		if etag == "" {
+			// an empty etag would return right away
+			etag = "0"
+		}
+
+		md, err := s.fetch(ctx, etag)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("watching the gce metadata: %v", err)
+				sleep(ctx, 5*time.Second)
+			}
+			continue
+		}
+
+		if _, err := parse(s.source(), md.data); err != nil {
+			log.Printf("%v. keeping the previous routes", err)
+			s.mu.Lock()
+			s.etag = md.etag
+			s.mu.Unlock()
+			continue
+		}
+
+		s.mu.Lock()
+		changed := !s.loaded || md.data != s.data
+		s.data, s.etag, s.loaded = md.data, md.etag, true
+		s.mu.Unlock()
+		if changed {
+			s.Notify()
+		}
+		if md.missing {
+			// the metadata server doesn't wait for attributes that don't exist yet
+			sleep(ctx, time.Minute)
+		}
+	}
+}
+
+// parse parses the routes in data, one per line, stopping at the first invalid one. Empty lines and lines starting
+// with # are skipped. Scripts and cloud-config documents are refused, since user data is often used for those.
+func parse(source, data string) ([]*redirector.Route, error) {
+	if strings.HasPrefix(data, "#!") || strings.HasPrefix(data, "#cloud-config") {
+		return nil, fmt.Errorf("%s is a script or cloud-config document rather than routes", source)
+	}
+	var routes []*redirector.Route
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		r, err := redirector.NewRoute(s)
+		if err == nil {
+			err = r.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: route %q: %v", source, n, s, err)
+		}
+		r.Source = fmt.Sprintf("%s:%d", source, n)
+		routes = append(routes, r)
+	}
+	return routes, scanner.Err()
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,99 @@
+package redirector
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPList is a list of networks that client addresses are checked against
+type IPList []*net.IPNet
+
+// ParseIPList parses CIDRs and ip addresses, which match only themselves. Empty entries are skipped.
+func ParseIPList(entries []string) (IPList, error) {
+	var l IPList
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			l = append(l, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, network)
+	}
+	return l, nil
+}
+
+// Contains reports whether ip is in any of the list's networks
+func (l IPList) Contains(ip net.IP) bool {
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Access decides which clients may use a Redirector or a route, by their address
+type Access struct {
+	// Allow, if set, are the only networks that clients may come from
+	Allow IPList
+	// Deny are networks that clients may not come from, even if Allow has them
+	Deny IPList
+}
+
+// allows reports whether the client that sent req may be served. Clients whose address can't be parsed are only
+// allowed if there is no Allow list.
+func (a Access) allows(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		// addresses taken from X-Forwarded-For have no port
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return len(a.Allow) == 0
+	}
+	return !a.Deny.Contains(ip) && (len(a.Allow) == 0 || a.Allow.Contains(ip))
+}
+
+// WithAccess refuses requests from clients that a doesn't allow with 403 Forbidden, before they are matched against
+// any routes. Routes can also have their own Allow and Deny lists.
+func WithAccess(a Access) Option {
+	return func(r *Redirector) {
+		r.access = &a
+	}
+}
+
+// routeAccess parses the route's Allow and Deny lists
+func (r *Route) routeAccess() (Access, error) {
+	allow, err := ParseIPList(r.Allow)
+	if err != nil {
+		return Access{}, fmt.Errorf("parsing allow: %v", err)
+	}
+	deny, err := ParseIPList(r.Deny)
+	if err != nil {
+		return Access{}, fmt.Errorf("parsing deny: %v", err)
+	}
+	return Access{Allow: allow, Deny: deny}, nil
+}
+
+// forbid answers req with 403 Forbidden
+func forbid(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
@@ -0,0 +1,154 @@
+package redirector
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureParam is the query parameter that holds the signature of a request for a route with hmac auth
+	SignatureParam = "sig"
+	// ExpiresParam is the query parameter that holds the unix time that a signed url expires at, if it does
+	ExpiresParam = "expires"
+)
+
+// RedactedAuth takes the place of the password or secret of a route's Auth in Redacted
+const RedactedAuth = "REDACTED"
+
+// hmacAuthPrefix starts the Auth of routes that verify signatures rather than basic auth credentials
+const hmacAuthPrefix = "hmac:"
+
+// validateAuth checks that the route's Auth is either <user>:<password> or hmac:<secret>
+func (r *Route) validateAuth() error {
+	if r.Auth == "" {
+		return nil
+	}
+	if strings.HasPrefix(r.Auth, hmacAuthPrefix) {
+		if r.Auth == hmacAuthPrefix {
+			return errors.New("invalid auth: hmac needs a secret")
+		}
+		return nil
+	}
+	user, _, ok := strings.Cut(r.Auth, ":")
+	if !ok || user == "" {
+		return errors.New("invalid auth: must be <user>:<password> or hmac:<secret>")
+	}
+	return nil
+}
+
+// Redacted returns the route, or a copy of it with the password or secret of its Auth replaced with RedactedAuth if it
+// has one, for showing it to people who shouldn't be able to get past it
+func (r *Route) Redacted() *Route {
+	if r.Auth == "" {
+		return r
+	}
+	c := *r
+	if r.signed() {
+		c.Auth = hmacAuthPrefix + RedactedAuth
+	} else {
+		user, _, _ := strings.Cut(r.Auth, ":")
+		c.Auth = user + ":" + RedactedAuth
+	}
+	return &c
+}
+
+// signed returns whether the route verifies signatures rather than basic auth credentials
+func (r *Route) signed() bool {
+	return strings.HasPrefix(r.Auth, hmacAuthPrefix)
+}
+
+// authorizes returns whether req has the credentials or signature that the route's Auth requires
+func (r *Route) authorizes(req *http.Request) bool {
+	if r.signed() {
+		return verifySignature(req, strings.TrimPrefix(r.Auth, hmacAuthPrefix), time.Now())
+	}
+	wantUser, wantPass, _ := strings.Cut(r.Auth, ":")
+	user, pass, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	// the digests have the same length whatever was sent, so comparing them takes the same time
+	userOK := subtle.ConstantTimeCompare(digest(user), digest(wantUser))
+	passOK := subtle.ConstantTimeCompare(digest(pass), digest(wantPass))
+	return userOK&passOK == 1
+}
+
+func digest(s string) []byte {
+	d := sha256.Sum256([]byte(s))
+	return d[:]
+}
+
+// signature returns the hex HMAC-SHA256 of path and expires, separated by a newline, with secret
+func signature(secret, path, expires string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(path + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature returns whether req has a valid signature of its path, and of its expiry time if it has one, which
+// must not have passed at now
+func verifySignature(req *http.Request, secret string, now time.Time) bool {
+	q := req.URL.Query()
+	expires := q.Get(ExpiresParam)
+	if expires != "" {
+		t, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || now.Unix() >= t {
+			return false
+		}
+	}
+	want := signature(secret, req.URL.Path, expires)
+	return hmac.Equal([]byte(q.Get(SignatureParam)), []byte(want))
+}
+
+// SignURL returns a copy of u with the signature that routes with auth=hmac:<secret> require, which covers its path
+// and, unless expires is zero, the time that the signature expires at. The signature is the hex HMAC-SHA256 of the
+// path and the unix time, or nothing if it doesn't expire, separated by a newline.
+func SignURL(u *url.URL, secret string, expires time.Time) *url.URL {
+	signed := *u
+	q := signed.Query()
+	q.Del(ExpiresParam)
+	var exp string
+	if !expires.IsZero() {
+		exp = strconv.FormatInt(expires.Unix(), 10)
+		q.Set(ExpiresParam, exp)
+	}
+	q.Set(SignatureParam, signature(secret, u.Path, exp))
+	signed.RawQuery = q.Encode()
+	return &signed
+}
+
+// withoutSignature returns req without the signature parameters in its query, so that they aren't carried over to the
+// destination
+func withoutSignature(req *http.Request) *http.Request {
+	var kept []string
+	for _, param := range strings.Split(req.URL.RawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(name); err == nil && (name == SignatureParam || name == ExpiresParam) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	u := *req.URL
+	u.RawQuery = strings.Join(kept, "&")
+	r := req.Clone(req.Context())
+	r.URL = &u
+	return r
+}
+
+// unauthorized answers a request for route that lacks the credentials or signature that it requires with 401
+// Unauthorized
+func unauthorized(w http.ResponseWriter, route *Route) {
+	if !route.signed() {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", route.Pattern))
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
@@ -0,0 +1,109 @@
+package redirector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// benchRoutes is the number of exact routes in the table that the benchmarks match against, as in the README's
+// performance section
+const benchRoutes = 1000000
+
+// benchRoute returns the i'th route of a legacy url mapping, in the -route syntax
+func benchRoute(i int) string {
+	return fmt.Sprintf("old.example.com/articles/%d/some-post-title new.example.com/blog/%d", i, i)
+}
+
+var (
+	benchOnce  sync.Once
+	benchTable *Redirector
+)
+
+// benchRedirector returns a Redirector with benchRoutes exact routes and a wildcard one, which is only built once
+// since it takes a while
+func benchRedirector(b *testing.B) *Redirector {
+	b.Helper()
+	benchOnce.Do(func() {
+		routes := make([]*Route, 0, benchRoutes+1)
+		for i := 0; i < benchRoutes; i++ {
+			r, err := NewRoute(benchRoute(i))
+			if err != nil {
+				b.Fatal(err)
+			}
+			routes = append(routes, r)
+		}
+		r, err := NewRoute("old.example.com/tags/* new.example.com/topics/{*}")
+		if err != nil {
+			b.Fatal(err)
+		}
+		re := New(nil)
+		if err := re.SetRoutes(append(routes, r)); err != nil {
+			b.Fatal(err)
+		}
+		benchTable = re
+	})
+	if benchTable == nil {
+		b.Fatal("building the table failed")
+	}
+	return benchTable
+}
+
+func benchmarkMatch(b *testing.B, target string) {
+	re := benchRedirector(b)
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := re.Match(req); !ok {
+			b.Fatalf("%s didn't match", target)
+		}
+	}
+}
+
+func BenchmarkMatchExact(b *testing.B) {
+	benchmarkMatch(b, fmt.Sprintf("http://old.example.com/articles/%d/some-post-title", benchRoutes/2))
+}
+
+func BenchmarkMatchWildcard(b *testing.B) {
+	benchmarkMatch(b, "http://old.example.com/tags/go")
+}
+
+// BenchmarkLoad parses benchRoutes routes and sets them, and reports how much memory the table keeps per route once
+// it's been garbage collected
+func BenchmarkLoad(b *testing.B) {
+	lines := make([]string, benchRoutes)
+	for i := range lines {
+		lines[i] = benchRoute(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		routes := make([]*Route, 0, len(lines))
+		for _, line := range lines {
+			r, err := NewRoute(line)
+			if err != nil {
+				b.Fatal(err)
+			}
+			routes = append(routes, r)
+		}
+		re := New(nil)
+		if err := re.SetRoutes(routes); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		routes = nil
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/benchRoutes, "bytes/route")
+		runtime.KeepAlive(re)
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,334 @@
+package redirector
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Builder builds a Route without going through the string syntax accepted by NewRoute
+//
+//	route, err := redirector.To("example.com").From("www.example.com/*").CarryPath().Code(301).Build()
+type Builder struct {
+	route Route
+	dest  string
+	// destURL is the destination set with ToURL, used instead of parsing dest
+	destURL *url.URL
+	// proxy makes dest the route's upstream
+	proxy bool
+	// split are the destinations of a split route, parsed along with dest
+	split []splitDoc
+	// codeSet is whether Code was called, so that a Group's code doesn't replace it
+	codeSet bool
+}
+
+// ToResolver starts building a route whose destination is decided per request by res
+func ToResolver(res Resolver) *Builder {
+	return &Builder{
+		route: Route{Code: 302, Resolver: res},
+	}
+}
+
+// Gone starts building a route that answers with 410 Gone instead of redirecting. Code may set another 4xx code.
+func Gone() *Builder {
+	return &Builder{
+		route: Route{Code: http.StatusGone, Response: &Response{}},
+	}
+}
+
+// Respond starts building a route that answers with 200 OK and body instead of redirecting. Code may set another
+// code.
+func Respond(body string) *Builder {
+	return &Builder{
+		route: Route{Code: http.StatusOK, Response: &Response{Body: body}},
+	}
+}
+
+// Proxy starts building a route that proxies requests to upstream instead of redirecting them. upstream follows the
+// same rules as the destination in NewRoute.
+func Proxy(upstream string) *Builder {
+	return &Builder{
+		dest:  upstream,
+		proxy: true,
+	}
+}
+
+// Files starts building a route that serves the files in dir instead of redirecting
+func Files(dir string) *Builder {
+	return &Builder{
+		route: Route{Files: dir},
+	}
+}
+
+// Split starts building a route that splits requests between the destinations added with SplitTo
+func Split() *Builder {
+	return &Builder{
+		route: Route{Code: 302},
+	}
+}
+
+// SplitTo adds dest to the destinations of a split route, getting weight shares of its requests. dest follows the
+// same rules as the destination in NewRoute.
+func (b *Builder) SplitTo(weight int, dest string) *Builder {
+	b.split = append(b.split, splitDoc{Weight: weight, Destination: dest})
+	return b
+}
+
+// Sticky keeps sending clients of a split route to the same destination
+func (b *Builder) Sticky() *Builder {
+	b.route.Sticky = true
+	return b
+}
+
+// StickyCookie keeps sending clients of a split route to the same destination like Sticky, with a cookie called name
+// that holds the position of their destination, counting from 0, for MatchCookie
+func (b *Builder) StickyCookie(name string) *Builder {
+	b.route.Sticky, b.route.StickyCookie = true, name
+	return b
+}
+
+// To starts building a route that redirects to dest. dest follows the same rules as the destination in NewRoute: it
+// defaults to https if no scheme is set.
+func To(dest string) *Builder {
+	return &Builder{
+		route: Route{Code: 302},
+		dest:  dest,
+	}
+}
+
+// ToURL starts building a route that redirects to dest, for programs that already have the destination as a url. Unlike
+// To, dest is used as is, so it must have a scheme and host.
+func ToURL(dest *url.URL) *Builder {
+	u := *dest
+	return &Builder{
+		route:   Route{Code: 302},
+		destURL: &u,
+	}
+}
+
+// From sets the pattern that the route matches
+func (b *Builder) From(pattern string) *Builder {
+	b.route.Pattern = pattern
+	return b
+}
+
+// CarryPath forwards the path from the original request
+func (b *Builder) CarryPath() *Builder {
+	b.route.CarryPath = true
+	return b
+}
+
+// CarryQuery forwards the query parameters from the original request
+func (b *Builder) CarryQuery() *Builder {
+	b.route.CarryQuery = true
+	return b
+}
+
+// MergeQuery merges the query parameters from the original request into the destination's
+func (b *Builder) MergeQuery() *Builder {
+	b.route.MergeQuery = true
+	return b
+}
+
+// AddQuery sets the query parameter name to value on redirects
+func (b *Builder) AddQuery(name, value string) *Builder {
+	if b.route.AddQuery == nil {
+		b.route.AddQuery = make(url.Values)
+	}
+	b.route.AddQuery.Add(name, value)
+	return b
+}
+
+// DropQuery removes the query parameter name from redirects
+func (b *Builder) DropQuery(name string) *Builder {
+	b.route.DropQuery = append(b.route.DropQuery, name)
+	return b
+}
+
+// StripPrefix removes prefix from the start of the carried path
+func (b *Builder) StripPrefix(prefix string) *Builder {
+	b.route.StripPrefix = prefix
+	return b
+}
+
+// KeepTrailingSlash keeps the trailing slash of the carried path
+func (b *Builder) KeepTrailingSlash() *Builder {
+	b.route.KeepTrailingSlash = true
+	return b
+}
+
+// Header adds a header to set on redirect responses
+func (b *Builder) Header(name, value string) *Builder {
+	if b.route.Headers == nil {
+		b.route.Headers = make(http.Header)
+	}
+	b.route.Headers.Add(name, value)
+	return b
+}
+
+// ContentType sets the content type of the response of a route that responds
+func (b *Builder) ContentType(contentType string) *Builder {
+	if b.route.Response != nil {
+		b.route.Response.ContentType = contentType
+	}
+	return b
+}
+
+// Methods restricts the route to requests with one of methods
+func (b *Builder) Methods(methods ...string) *Builder {
+	b.route.Methods = append(b.route.Methods, methods...)
+	return b
+}
+
+// MatchHeader restricts the route to requests with the header name set to value, or set at all if value is empty
+func (b *Builder) MatchHeader(name, value string) *Builder {
+	if b.route.MatchHeaders == nil {
+		b.route.MatchHeaders = make(http.Header)
+	}
+	b.route.MatchHeaders.Add(name, value)
+	return b
+}
+
+// MatchQuery restricts the route to requests with the query parameter name set to value, or set at all if value is
+// empty
+func (b *Builder) MatchQuery(name, value string) *Builder {
+	if b.route.MatchQuery == nil {
+		b.route.MatchQuery = make(url.Values)
+	}
+	b.route.MatchQuery.Add(name, value)
+	return b
+}
+
+// MatchCookie restricts the route to requests with the cookie name set to value, or set at all if value is empty
+func (b *Builder) MatchCookie(name, value string) *Builder {
+	if b.route.MatchCookies == nil {
+		b.route.MatchCookies = make(url.Values)
+	}
+	b.route.MatchCookies.Add(name, value)
+	return b
+}
+
+// UserAgents restricts the route to requests from one of the classes of user agents, out of UAMobile, UADesktop, and
+// UABot
+func (b *Builder) UserAgents(classes ...string) *Builder {
+	b.route.UserAgents = append(b.route.UserAgents, classes...)
+	return b
+}
+
+// Countries restricts the route to requests from one of countries, as ISO 3166-1 alpha-2 codes
+func (b *Builder) Countries(countries ...string) *Builder {
+	b.route.Countries = append(b.route.Countries, countries...)
+	return b
+}
+
+// ActiveBetween limits the route to requests made between from and until. Either may be zero to leave that end of
+// the window open.
+func (b *Builder) ActiveBetween(from, until time.Time) *Builder {
+	if !from.IsZero() {
+		b.route.ActiveFrom = &from
+	}
+	if !until.IsZero() {
+		b.route.ActiveUntil = &until
+	}
+	return b
+}
+
+// Scheme restricts the route to requests made with scheme, http or https
+func (b *Builder) Scheme(scheme string) *Builder {
+	b.route.Scheme = scheme
+	return b
+}
+
+// Allow only lets clients from these CIDRs or ip addresses use the route, and refuses everyone else with 403 Forbidden
+func (b *Builder) Allow(cidrs ...string) *Builder {
+	b.route.Allow = append(b.route.Allow, cidrs...)
+	return b
+}
+
+// Deny refuses clients from these CIDRs or ip addresses with 403 Forbidden
+func (b *Builder) Deny(cidrs ...string) *Builder {
+	b.route.Deny = append(b.route.Deny, cidrs...)
+	return b
+}
+
+// BasicAuth requires clients to send these basic auth credentials, and refuses everyone else with 401 Unauthorized
+func (b *Builder) BasicAuth(user, password string) *Builder {
+	b.route.Auth = user + ":" + password
+	return b
+}
+
+// SignedWith requires requests to be signed with secret, as SignURL does, and refuses everyone else with 401
+// Unauthorized
+func (b *Builder) SignedWith(secret string) *Builder {
+	b.route.Auth = hmacAuthPrefix + secret
+	return b
+}
+
+// Priority makes the route take precedence over other matching routes with a lower priority
+func (b *Builder) Priority(priority int) *Builder {
+	b.route.Priority = priority
+	return b
+}
+
+// Refresh adds an HTML body with a meta refresh to the route's redirects
+func (b *Builder) Refresh() *Builder {
+	b.route.Refresh = true
+	return b
+}
+
+// CORS answers the CORS preflights of requests for the route with c, and allows their origins on its responses
+func (b *Builder) CORS(c CORS) *Builder {
+	b.route.CORS = &c
+	return b
+}
+
+// LogOnly makes the route only log where it would have redirected requests to
+func (b *Builder) LogOnly() *Builder {
+	b.route.LogOnly = true
+	return b
+}
+
+// Code sets the http status code to set on redirects
+func (b *Builder) Code(code int) *Builder {
+	b.route.Code, b.codeSet = code, true
+	return b
+}
+
+// Build returns the configured route after validating it
+func (b *Builder) Build() (*Route, error) {
+	r, err := b.build()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// build returns the configured route without validating it
+func (b *Builder) build() (*Route, error) {
+	r := b.route
+	for _, split := range b.split {
+		u, err := parseDestination(split.Destination)
+		if err != nil {
+			return nil, err
+		}
+		r.Split = append(r.Split, SplitDestination{Weight: split.Weight, Destination: u})
+	}
+	if b.destURL != nil {
+		u := *b.destURL
+		r.Destination = &u
+	} else if r.Resolver == nil && r.Response == nil && r.Files == "" && len(r.Split) == 0 {
+		u, err := parseDestination(b.dest)
+		if err != nil {
+			return nil, err
+		}
+		if b.proxy {
+			r.Upstream = u
+		} else {
+			r.Destination = u
+		}
+	}
+	return &r, nil
+}
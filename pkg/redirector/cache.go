@@ -0,0 +1,60 @@
+package redirector
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache is a fixed-size LRU cache of lookup results, including misses
+type cache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	host, path string
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	route    *Route
+	captures []string
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[cacheKey]*list.Element, size),
+	}
+}
+
+func (c *cache) get(key cacheKey) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry), true
+}
+
+func (c *cache) add(key cacheKey, route *Route, captures []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		entry := e.Value.(*cacheEntry)
+		entry.route, entry.captures = route, captures
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, route: route, captures: captures})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,175 @@
+package redirector
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// conditional reports whether the route has any conditions besides its pattern
+func (r *Route) conditional() bool {
+	return len(r.Methods) > 0 || len(r.MatchHeaders) > 0 || len(r.MatchQuery) > 0 || len(r.MatchCookies) > 0 ||
+		len(r.UserAgents) > 0 || len(r.Countries) > 0 || r.ActiveFrom != nil || r.ActiveUntil != nil || r.Scheme != ""
+}
+
+// matches reports whether req meets the route's conditions
+func (r *Route) matches(req *http.Request) bool {
+	if r.ActiveFrom != nil || r.ActiveUntil != nil {
+		now := time.Now()
+		if (r.ActiveFrom != nil && now.Before(*r.ActiveFrom)) || (r.ActiveUntil != nil && !now.Before(*r.ActiveUntil)) {
+			return false
+		}
+	}
+	if r.Scheme != "" && Scheme(req) != r.Scheme {
+		return false
+	}
+	if len(r.Methods) > 0 && !containsString(r.Methods, req.Method) {
+		return false
+	}
+	for name, values := range r.MatchHeaders {
+		if !matchesAny(req.Header.Values(name), values) {
+			return false
+		}
+	}
+	if len(r.UserAgents) > 0 && !containsString(r.UserAgents, ClassifyUserAgent(req.UserAgent())) {
+		return false
+	}
+	if len(r.Countries) > 0 && !containsString(r.Countries, Country(req)) {
+		return false
+	}
+	if len(r.MatchQuery) > 0 {
+		query := req.URL.Query()
+		for name, values := range r.MatchQuery {
+			if !matchesAny(query[name], values) {
+				return false
+			}
+		}
+	}
+	if len(r.MatchCookies) > 0 {
+		cookies := make(map[string][]string)
+		for _, c := range req.Cookies() {
+			cookies[c.Name] = append(cookies[c.Name], c.Value)
+		}
+		for name, values := range r.MatchCookies {
+			if !matchesAny(cookies[name], values) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchesAny reports whether any of the request's values for a header, query parameter, or cookie is one of want. An
+// empty string in want matches any value.
+func matchesAny(got, want []string) bool {
+	for _, g := range got {
+		for _, w := range want {
+			if w == "" || g == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conditions returns the route's conditions in the route syntax, or an empty string if it has none. Routes may share
+// a pattern as long as their conditions differ.
+func (r *Route) Conditions() string {
+	parts := r.conditionParts()
+	for i, part := range parts {
+		parts[i] = quote(part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// conditionParts returns the route's conditions as options in the route syntax, before quoting, in canonical order
+func (r *Route) conditionParts() []string {
+	var parts []string
+	if len(r.Methods) > 0 {
+		parts = append(parts, "method="+strings.Join(r.Methods, ","))
+	}
+	names := make([]string, 0, len(r.MatchHeaders))
+	for name := range r.MatchHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range r.MatchHeaders[name] {
+			if v == "" {
+				parts = append(parts, "match_header="+name)
+			} else {
+				parts = append(parts, "match_header="+name+": "+v)
+			}
+		}
+	}
+	names = names[:0]
+	for name := range r.MatchQuery {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range r.MatchQuery[name] {
+			if v == "" {
+				parts = append(parts, "match_query="+url.QueryEscape(name))
+			} else {
+				parts = append(parts, "match_query="+url.Values{name: {v}}.Encode())
+			}
+		}
+	}
+	names = names[:0]
+	for name := range r.MatchCookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range r.MatchCookies[name] {
+			if v == "" {
+				parts = append(parts, "cookie="+name)
+			} else {
+				parts = append(parts, "cookie="+name+"="+v)
+			}
+		}
+	}
+	if len(r.UserAgents) > 0 {
+		parts = append(parts, "ua="+strings.Join(r.UserAgents, ","))
+	}
+	if len(r.Countries) > 0 {
+		parts = append(parts, "country="+strings.Join(r.Countries, ","))
+	}
+	if r.ActiveFrom != nil {
+		parts = append(parts, "from="+r.ActiveFrom.Format(time.RFC3339))
+	}
+	if r.ActiveUntil != nil {
+		parts = append(parts, "until="+r.ActiveUntil.Format(time.RFC3339))
+	}
+	if r.Scheme != "" {
+		parts = append(parts, "scheme="+r.Scheme)
+	}
+	return parts
+}
+
+// varyHeaders returns the request headers that the route's conditions depend on
+func (r *Route) varyHeaders() []string {
+	var names []string
+	for name := range r.MatchHeaders {
+		names = append(names, name)
+	}
+	if len(r.MatchCookies) > 0 {
+		names = append(names, "Cookie")
+	}
+	if len(r.UserAgents) > 0 {
+		names = append(names, "User-Agent")
+	}
+	return names
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
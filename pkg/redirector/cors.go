@@ -0,0 +1,125 @@
+package redirector
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORS answers the CORS preflights of cross-origin requests, and lets the responses to those requests through, so that
+// browser fetches to a moved api endpoint see its redirect instead of failing the preflight
+type CORS struct {
+	// Origins are the origins that may make requests, such as https://app.example.com. It defaults to any origin, as
+	// does an origin of *.
+	Origins []string `json:"origins,omitempty" yaml:"origins,omitempty"`
+	// Methods are the methods that preflights allow. They default to the method that a preflight asks for.
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	// Headers are the request headers that preflights allow. They default to the headers that a preflight asks for.
+	Headers []string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// MaxAge is how many seconds browsers may cache preflight responses for. Browsers pick their own default if it's
+	// 0.
+	MaxAge int `json:"max_age,omitempty" yaml:"max_age,omitempty"`
+	// Credentials allows requests with cookies and other credentials. The request's origin is allowed explicitly
+	// then, since browsers don't accept * for such requests.
+	Credentials bool `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+}
+
+// WithCORS answers the preflights of requests that match routes without a CORS of their own, and of requests that
+// don't match any route if there's no default handler, with c. Requests that a default handler or a proxy route gets
+// are passed on, preflights included, so that the server behind it can answer them itself.
+func WithCORS(c CORS) Option {
+	return func(r *Redirector) {
+		r.cors = &c
+	}
+}
+
+// isPreflight returns whether req is a CORS preflight
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsFor returns the CORS settings for requests that matched route, or for ones that didn't match any if route is
+// nil. It returns nil if they have none.
+func (r *Redirector) corsFor(route *Route) *CORS {
+	switch {
+	case route != nil && route.Upstream != nil, route == nil && r.defaultHandler != nil:
+		return nil
+	case route != nil && route.CORS != nil:
+		return route.CORS
+	}
+	return r.cors
+}
+
+// servePreflight answers req, a preflight, if its request would match a route with CORS settings or the Redirector
+// has them, and returns whether it did. The request is matched with the method that the preflight asks for, so that
+// routes limited to some methods are found.
+func (r *Redirector) servePreflight(w http.ResponseWriter, req *http.Request, t *table) bool {
+	actual := *req
+	actual.Method = strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+	route, _, _ := r.matchIn(t, &actual)
+	if route != nil && route.LogOnly {
+		route = nil
+	}
+	c := r.corsFor(route)
+	if c == nil {
+		return false
+	}
+	if !c.allowOrigin(w, req) {
+		// without the headers, the browser fails the request, which is what it should do
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	if len(c.Methods) > 0 {
+		h.Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+	} else {
+		h.Set("Access-Control-Allow-Methods", actual.Method)
+	}
+	if len(c.Headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+	} else if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// allowOrigin sets the headers that allow the origin of req, if it has one and it's allowed, and returns whether it
+// did
+func (c *CORS) allowOrigin(w http.ResponseWriter, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	anyOrigin := len(c.Origins) == 0
+	allowed := anyOrigin
+	for _, o := range c.Origins {
+		if o == "*" {
+			anyOrigin, allowed = true, true
+		} else if strings.EqualFold(o, origin) {
+			allowed = true
+		}
+	}
+	h := w.Header()
+	if !anyOrigin || c.Credentials {
+		// the response depends on the origin
+		h.Add("Vary", "Origin")
+	}
+	if !allowed {
+		return false
+	}
+	if anyOrigin && !c.Credentials {
+		origin = "*"
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if c.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package redirector
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DestinationHosts are the hosts that redirects may send clients to, as hostnames or *.<domain> for every subdomain
+// of domain, so that destinations decided per request, such as ones from a Resolver or from DNS records, can't send
+// clients to a third-party site
+type DestinationHosts []string
+
+// Allows reports whether redirects may send clients to host, which may have a port
+func (h DestinationHosts) Allows(host string) bool {
+	host = normalizeHost(host)
+	if i, ok := portIndex(host); ok {
+		host = host[:i]
+	}
+	for _, allowed := range h {
+		allowed = normalizeHost(strings.TrimSpace(allowed))
+		if allowed == host || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unlisted returns the routes whose destination, or one of whose split destinations, is the same for every request
+// and isn't one of the hosts, which would be refused for every request
+func (h DestinationHosts) Unlisted(routes []*Route) []*Route {
+	var unlisted []*Route
+	for _, r := range routes {
+		dests := []*url.URL{r.Destination}
+		for _, split := range r.Split {
+			dests = append(dests, split.Destination)
+		}
+		for _, u := range dests {
+			if u != nil && r.Resolver == nil && !h.Allows(u.Host) {
+				unlisted = append(unlisted, r)
+				break
+			}
+		}
+	}
+	return unlisted
+}
+
+// WithDestinationHosts refuses requests that would be redirected to a host that isn't one of hosts with 400 Bad
+// Request, and logs them
+func WithDestinationHosts(hosts DestinationHosts) Option {
+	return func(r *Redirector) {
+		r.destinations = hosts
+	}
+}
+
+type destinationsKey struct{}
+
+func withDestinationHosts(req *http.Request, hosts DestinationHosts) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), destinationsKey{}, hosts))
+}
+
+// allowsDestination reports whether the Redirector handling req lets it be redirected to dest, and answers it with
+// 400 Bad Request if it doesn't
+func (r *Route) allowsDestination(w http.ResponseWriter, req *http.Request, dest *url.URL) bool {
+	hosts, ok := req.Context().Value(destinationsKey{}).(DestinationHosts)
+	if !ok || hosts.Allows(dest.Host) {
+		return true
+	}
+	requestLogger(req).Error("refusing to redirect to a host that isn't one of the destination hosts", "route", r.Pattern,
+		"pattern", RequestPattern(req), "destination", dest.String())
+	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	return false
+}
@@ -0,0 +1,174 @@
+package redirector
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Flattened is a route that redirected to a url of another route, and now redirects straight to where the chain of
+// routes that followed it ended
+type Flattened struct {
+	Route *Route
+	// Via are the routes that the chain went through, in order
+	Via []*Route
+	// From is the route's destination before it was flattened
+	From *url.URL
+}
+
+// String returns a description of the flattened chain
+func (f Flattened) String() string {
+	patterns := make([]string, len(f.Via))
+	for i, r := range f.Via {
+		patterns[i] = fmt.Sprintf("%q", r.Pattern)
+	}
+	return fmt.Sprintf("%q now redirects straight to %s instead of %s, which went through %s", f.Route.Pattern,
+		f.Route.Destination, f.From, strings.Join(patterns, " → "))
+}
+
+// FlattenChains points routes that redirect to a url which another of the routes redirects from at where that chain of
+// redirects ends instead, so that clients take a single hop. Only routes whose destination is the same for every
+// request are flattened, and chains are only followed through routes without conditions, access lists, auth, headers,
+// or a refresh body, and that no route with conditions could take precedence over for some clients, since those could
+// answer differently. Each flattened route keeps its code, unless a later hop was temporary or changed the method, in
+// which case it becomes temporary or changes the method too. Chains that form a loop aren't flattened, and neither are
+// invalid and duplicate routes. The routes are changed in place, and the ones that were flattened are returned.
+func FlattenChains(routes []*Route) []Flattened {
+	m := newMatcher()
+	// routes with conditions are added without them, and first so that they come before any route without conditions
+	// that shares their pattern, so that looking a url up finds them wherever they could match
+	conditional := make(map[*Route]bool)
+	for _, r := range routes {
+		if r.Validate() == nil && (r.conditional() || r.LogOnly) {
+			c := *r
+			c.Methods, c.MatchHeaders, c.MatchQuery, c.MatchCookies, c.UserAgents, c.Countries = nil, nil, nil, nil, nil, nil
+			c.ActiveFrom, c.ActiveUntil, c.Scheme = nil, nil, ""
+			if m.add(&c) == nil {
+				conditional[&c] = true
+			}
+		}
+	}
+	for _, r := range routes {
+		if r.Validate() == nil && !r.conditional() && !r.LogOnly {
+			_ = m.add(r)
+		}
+	}
+
+	type flattening struct {
+		Flattened
+		dest *url.URL
+		code int
+	}
+	var found []flattening
+	for _, r := range routes {
+		if !r.staticDestination() || r.Validate() != nil || !m.hasHost(normalizeHost(r.Destination.Host)) {
+			continue
+		}
+		via, dest, code := followChain(m, conditional, r, len(routes))
+		if len(via) == 0 {
+			continue
+		}
+		// the destination is expanded again for each request, so it can't have anything that looks like a placeholder
+		s := dest.Path + dest.RawQuery
+		if strings.Contains(s, "{") || (isRegexpPattern(r.Pattern) && strings.Contains(s, "$")) {
+			continue
+		}
+		found = append(found, flattening{Flattened{Route: r, Via: via, From: r.Destination}, dest, code})
+	}
+
+	// the routes are only changed once every chain has been followed, so that the chains don't depend on their order
+	flattened := make([]Flattened, len(found))
+	for i, f := range found {
+		f.Route.Destination, f.Route.Code = f.dest, f.code
+		f.Route.AddQuery, f.Route.DropQuery = nil, nil
+		flattened[i] = f.Flattened
+	}
+	return flattened
+}
+
+// staticDestination returns whether the route redirects every request that it matches to the same url
+func (r *Route) staticDestination() bool {
+	if r.Destination == nil || r.Destination.Host == "" || r.Resolver != nil || len(r.Split) > 0 || r.Response != nil || r.Upstream != nil ||
+		r.Files != "" || r.LogOnly || r.CarryPath || r.CarryQuery || r.MergeQuery {
+		return false
+	}
+	s := r.Destination.Path + r.Destination.RawQuery
+	return !strings.Contains(s, "{") && !(isRegexpPattern(r.Pattern) && strings.Contains(s, "$"))
+}
+
+// followable returns whether clients that reach the route are all redirected the same way, so that a chain can be
+// followed through it
+func (r *Route) followable() bool {
+	return r.Destination != nil && r.Resolver == nil && len(r.Split) == 0 && r.Response == nil && r.Upstream == nil &&
+		r.Files == "" && !r.LogOnly && !r.Refresh && len(r.Headers) == 0 && len(r.Allow) == 0 && len(r.Deny) == 0 &&
+		r.Auth == ""
+}
+
+// followChain follows the redirects of route, which has a static destination, through the routes in m, and returns
+// the routes it went through along with the url and code that the chain ends with. It returns no routes if the first
+// redirect doesn't lead to another route, or if the chain loops or is longer than maxHops.
+func followChain(m *matcher, conditional map[*Route]bool, route *Route, maxHops int) ([]*Route, *url.URL, int) {
+	req := &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/"}, Host: "", Header: make(http.Header)}
+	dest, code, err := route.Resolve(req)
+	if err != nil {
+		return nil, nil, 0
+	}
+
+	var via []*Route
+	for hop := 0; hop < maxHops; hop++ {
+		req = &http.Request{Method: http.MethodGet, URL: dest, Host: dest.Host, Header: make(http.Header)}
+		req = withScheme(req, dest.Scheme)
+		host := normalizeHost(req.Host)
+		next, captures := m.lookup(req, host, req.URL.Path)
+		if i, ok := portIndex(host); ok && next == nil {
+			next, captures = m.lookup(req, host[:i], req.URL.Path)
+		}
+		if next == nil || conditional[next] || !next.followable() {
+			return via, dest, code
+		}
+		if next == route {
+			return nil, nil, 0
+		}
+		for _, r := range via {
+			if r == next {
+				return nil, nil, 0
+			}
+		}
+		if len(captures) > 0 {
+			req = withCaptures(req, captures)
+		}
+		nextDest, nextCode, err := next.Resolve(req)
+		if err != nil {
+			return via, dest, code
+		}
+		via, dest, code = append(via, next), nextDest, chainCode(code, nextCode)
+	}
+	return nil, nil, 0
+}
+
+// chainCode returns the code that a single redirect should have in place of a redirect with code followed by one with
+// next: permanent only if both are, and preserving the method only if both do
+func chainCode(code, next int) int {
+	permanent := isPermanent(code) && isPermanent(next)
+	preserve := preservesMethod(code) && preservesMethod(next)
+	switch {
+	case permanent && preserve:
+		return http.StatusPermanentRedirect
+	case permanent:
+		return http.StatusMovedPermanently
+	case preserve:
+		return http.StatusTemporaryRedirect
+	case code == http.StatusSeeOther:
+		return code
+	}
+	return http.StatusFound
+}
+
+func isPermanent(code int) bool {
+	return code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect
+}
+
+func preservesMethod(code int) bool {
+	return code == http.StatusTemporaryRedirect || code == http.StatusPermanentRedirect
+}
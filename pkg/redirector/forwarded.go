@@ -0,0 +1,80 @@
+package redirector
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ForwardedHeaders configures the headers that tell upstreams about the requests that they're forwarded, so that they
+// can find the client, host, and scheme that requests were originally made with
+type ForwardedHeaders struct {
+	// Forwarded sets the standard Forwarded header from RFC 7239 too
+	Forwarded bool
+	// Strip replaces the X-Forwarded-* and Forwarded headers that requests came with instead of appending to them, for
+	// when clients can't be trusted to set them
+	Strip bool
+}
+
+// WithForwardedHeaders sets X-Forwarded-For, X-Forwarded-Host, and X-Forwarded-Proto with the client address, host,
+// and scheme (as found by the function set with WithScheme, if any) of requests on the requests forwarded by
+// WithDefaultProxy and proxy routes. Without it, only X-Forwarded-For is appended to.
+func WithForwardedHeaders(f ForwardedHeaders) Option {
+	return func(r *Redirector) {
+		r.forwarded = &f
+	}
+}
+
+// setForwarded sets the forwarded headers on req, a request that's being forwarded, which still has the client's address
+// and host
+func (r *Redirector) setForwarded(req *http.Request) {
+	f := r.forwarded
+	if f == nil {
+		return
+	}
+	scheme := Scheme(req)
+	if r.schemeFunc != nil {
+		scheme = r.schemeFunc(req)
+	}
+	if f.Strip {
+		for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "Forwarded"} {
+			req.Header.Del(name)
+		}
+	}
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil && net.ParseIP(req.RemoteAddr) != nil {
+		// the proxy only appends the client to X-Forwarded-For if its address has a port, which it doesn't if it was
+		// taken from a trusted proxy's X-Forwarded-For
+		ip = req.RemoteAddr
+		if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+			req.Header.Set("X-Forwarded-For", prior+", "+ip)
+		} else {
+			req.Header.Set("X-Forwarded-For", ip)
+		}
+	}
+	if req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	if req.Header.Get("X-Forwarded-Proto") == "" {
+		req.Header.Set("X-Forwarded-Proto", scheme)
+	}
+	if !f.Forwarded {
+		return
+	}
+	elem := "proto=" + scheme + ";host=" + quoteForwarded(req.Host)
+	if ip != "" {
+		if strings.Contains(ip, ":") {
+			ip = `"[` + ip + `]"`
+		}
+		elem = "for=" + ip + ";" + elem
+	}
+	if prior := req.Header.Get("Forwarded"); prior != "" {
+		elem = prior + ", " + elem
+	}
+	req.Header.Set("Forwarded", elem)
+}
+
+// quoteForwarded quotes v as a Forwarded header value
+func quoteForwarded(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
@@ -0,0 +1,101 @@
+package redirector
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Group holds settings that several routes share, so that they don't have to be repeated on each of them, like
+// "path query code=301" on every route of a site that moved. The routes of a group are normal routes with the group's
+// settings, except for the ones that they set themselves.
+//
+//	g := redirector.Group{Host: "old.example.com", Code: 301, CarryQuery: true}
+//	blog, err := g.NewRoute("/blog/* example.com/posts path")
+type Group struct {
+	// Host is put in front of the patterns of the group's routes that start with /, so that /blog/* becomes
+	// old.example.com/blog/*
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Code is set on the group's routes that redirect, unless they set their own
+	Code int `json:"code,omitempty" yaml:"code,omitempty"`
+	// CarryPath and CarryQuery are set on every route of the group that redirects
+	CarryPath  bool `json:"path,omitempty" yaml:"path,omitempty"`
+	CarryQuery bool `json:"query,omitempty" yaml:"query,omitempty"`
+	// Headers are set on the responses of the group's routes, except for the headers that a route sets itself. Routes
+	// that proxy don't get them.
+	Headers http.Header `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// NewRoute parses a route in the syntax accepted by NewRoute, with the group's settings
+func (g Group) NewRoute(s string) (*Route, error) {
+	var codeSet bool
+	r, err := newRoute(s, &codeSet)
+	if err != nil {
+		return nil, err
+	}
+	g.apply(r, codeSet)
+	return r, nil
+}
+
+// UnmarshalRoute parses a route in JSON, either as an object or as a string like Route.UnmarshalJSON, with the
+// group's settings
+func (g Group) UnmarshalRoute(data []byte) (*Route, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return g.NewRoute(s)
+	}
+
+	var doc routeDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	r, err := doc.route()
+	if err != nil {
+		return nil, err
+	}
+	g.apply(r, doc.Code != 0)
+	return r, nil
+}
+
+// Build returns the route configured by b with the group's settings, after validating it
+func (g Group) Build(b *Builder) (*Route, error) {
+	r, err := b.build()
+	if err != nil {
+		return nil, err
+	}
+	g.apply(r, b.codeSet)
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// apply gives r the group's settings. codeSet is whether r sets its own code.
+func (g Group) apply(r *Route, codeSet bool) {
+	if g.Host != "" && strings.HasPrefix(r.Pattern, "/") {
+		r.Pattern = g.Host + r.Pattern
+	}
+	if r.Response == nil && r.Upstream == nil && r.Files == "" {
+		if g.Code != 0 && !codeSet {
+			r.Code = g.Code
+		}
+		r.CarryPath = r.CarryPath || g.CarryPath
+		r.CarryQuery = r.CarryQuery || g.CarryQuery
+	}
+	if r.Upstream != nil || len(g.Headers) == 0 {
+		return
+	}
+	headers := r.Headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	for name, values := range g.Headers {
+		if len(r.Headers.Values(name)) > 0 {
+			continue
+		}
+		for _, v := range values {
+			headers.Add(name, v)
+		}
+	}
+	r.Headers = headers
+}
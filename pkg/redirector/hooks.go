@@ -0,0 +1,20 @@
+package redirector
+
+import "net/http"
+
+// WithOnMatch calls f for every request that matches a route, before the route is executed, e.g. to record analytics
+// or emit events. If f returns false, the redirect is vetoed and the request is handled as if it hadn't matched any
+// route. It can be passed more than once, and the functions are called in order until one vetoes the request.
+func WithOnMatch(f func(route *Route, req *http.Request) bool) Option {
+	return func(r *Redirector) {
+		r.onMatch = append(r.onMatch, f)
+	}
+}
+
+// WithOnMiss calls f for every request that doesn't match any route, or whose route was vetoed by a WithOnMatch
+// function, before it's passed to the default handler. It can be passed more than once.
+func WithOnMiss(f func(req *http.Request)) Option {
+	return func(r *Redirector) {
+		r.onMiss = append(r.onMiss, f)
+	}
+}
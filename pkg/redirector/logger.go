@@ -0,0 +1,76 @@
+package redirector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Logger receives the log messages of a Redirector, so that they can flow into any logging system. Each message comes
+// with alternating keys and values, like "pattern", "example.com/foo". *slog.Logger implements it.
+type Logger interface {
+	// Debug is called for every request that matches a route
+	Debug(msg string, args ...any)
+	// Info is called for requests that don't match any route and for what log-only routes would have done
+	Info(msg string, args ...any)
+	// Error is called when a request or a reload fails
+	Error(msg string, args ...any)
+}
+
+// WithLogger sends log messages to l instead of the standard library's log package. Matched requests are only logged
+// to a Logger set with WithLogger.
+func WithLogger(l Logger) Option {
+	return func(r *Redirector) {
+		r.logger = l
+	}
+}
+
+// stdLogger logs messages other than debug ones with the log package, as the message followed by key=value pairs
+type stdLogger struct{}
+
+func (stdLogger) Debug(string, ...any) {}
+
+func (stdLogger) Info(msg string, args ...any) {
+	log.Print(formatLog(msg, args))
+}
+
+func (stdLogger) Error(msg string, args ...any) {
+	log.Print(formatLog(msg, args))
+}
+
+func formatLog(msg string, args []any) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		if s, ok := args[i+1].(string); ok {
+			fmt.Fprintf(&b, " %v=%q", args[i], s)
+		} else {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		}
+	}
+	return b.String()
+}
+
+// log returns the Logger set with WithLogger, or one that uses the log package
+func (r *Redirector) log() Logger {
+	if r == nil || r.logger == nil {
+		return stdLogger{}
+	}
+	return r.logger
+}
+
+type loggerKey struct{}
+
+func withLogger(req *http.Request, l Logger) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), loggerKey{}, l))
+}
+
+// requestLogger returns the Logger of the Redirector handling req, for routes executed by it
+func requestLogger(req *http.Request) Logger {
+	if l, ok := req.Context().Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return stdLogger{}
+}
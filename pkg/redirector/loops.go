@@ -0,0 +1,139 @@
+package redirector
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Loop is a chain of routes that redirect to each other in a cycle. The last route redirects back to the first one.
+type Loop []*Route
+
+// String returns the chain of patterns in the loop, ending with the first one again
+func (l Loop) String() string {
+	patterns := make([]string, 0, len(l)+1)
+	for _, r := range l {
+		patterns = append(patterns, r.Pattern)
+	}
+	if len(l) > 0 {
+		patterns = append(patterns, l[0].Pattern)
+	}
+	return strings.Join(patterns, " → ")
+}
+
+// FindLoops finds chains of routes that would redirect clients in a cycle, including routes that redirect to
+// themselves. Each route is followed starting from a sample request that matches its pattern. Routes with a Resolver
+// are not followed since their destinations are only known per request, log-only routes don't redirect, and chains can't
+// start at routes with a regular expression pattern since there's no sample request for them. Invalid and duplicate
+// routes are ignored.
+func FindLoops(routes []*Route) []Loop {
+	m := newMatcher()
+	for _, r := range routes {
+		if r.Validate() == nil {
+			_ = m.add(r)
+		}
+	}
+	return findLoops(m, routes)
+}
+
+// findLoops finds the loops formed by routes, which have been added to m
+func findLoops(m *matcher, routes []*Route) []Loop {
+	var loops []Loop
+	found := make(map[*Route]bool)
+	for _, start := range routes {
+		if found[start] {
+			continue
+		}
+		if loop := followLoop(m, start, len(routes)); loop != nil {
+			for _, r := range loop {
+				found[r] = true
+			}
+			loops = append(loops, loop)
+		}
+	}
+	return loops
+}
+
+// followLoop follows the redirects starting at route and returns the routes that form a loop, if any. At most maxHops
+// redirects are followed.
+func followLoop(m *matcher, route *Route, maxHops int) Loop {
+	if route.Destination != nil && !m.hasHost(normalizeHost(route.Destination.Host)) {
+		// the first redirect leaves the table, which is the case for most routes of large tables
+		return nil
+	}
+	req, err := sampleRequest(route.Patterns()[0])
+	if err != nil {
+		return nil
+	}
+
+	var chain []*Route
+	for hop := 0; hop <= maxHops; hop++ {
+		// chains are short, so looking for the route in them is cheaper than keeping a set for every route followed
+		for i, r := range chain {
+			if r == route {
+				return Loop(chain[i:])
+			}
+		}
+		chain = append(chain, route)
+
+		if route.Resolver != nil || route.LogOnly {
+			return nil
+		}
+		dest, _, err := route.Resolve(req)
+		if err != nil {
+			return nil
+		}
+		req = &http.Request{Method: http.MethodGet, URL: dest, Host: dest.Host, Header: make(http.Header)}
+		req = withScheme(req, dest.Scheme)
+		var captures []string
+		host := normalizeHost(req.Host)
+		route, captures = m.lookup(req, host, req.URL.Path)
+		if i, ok := portIndex(host); ok && route == nil {
+			route, captures = m.lookup(req, host[:i], req.URL.Path)
+		}
+		if route == nil {
+			return nil
+		}
+		if len(captures) > 0 {
+			req = withCaptures(req, captures)
+		}
+	}
+	return nil
+}
+
+// sampleRequest returns a request that matches pattern, with wildcards replaced by placeholder labels and segments
+func sampleRequest(pattern string) (*http.Request, error) {
+	p, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := sampleWildcards(p.labels)
+	reverse(labels)
+	if p.hostWildcard {
+		labels = append([]string{"sample"}, labels...)
+	}
+	segments := sampleWildcards(p.segments)
+	if p.pathWildcard {
+		segments = append(segments, "sample")
+	}
+
+	u := &url.URL{
+		Scheme: "https",
+		Host:   strings.Join(labels, "."),
+		Path:   "/" + strings.Join(segments, "/"),
+	}
+	return &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: make(http.Header)}, nil
+}
+
+// sampleWildcards returns a copy of labels or segments with their wildcards replaced by a sample value
+func sampleWildcards(parts []string) []string {
+	out := make([]string, len(parts))
+	for i, part := range parts {
+		if part == "*" {
+			part = "sample"
+		}
+		out[i] = part
+	}
+	return out
+}
@@ -0,0 +1,341 @@
+package redirector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// routeAlias has the same fields as Route but none of its methods, so that it can be (un)marshaled without recursing
+type routeAlias Route
+
+// routeDoc is the structured representation of a Route in JSON and YAML documents. Pattern and Destination are
+// declared here rather than promoted from routeAlias so that they always come first.
+type routeDoc struct {
+	Pattern     string `json:"pattern" yaml:"pattern"`
+	Destination string `json:"destination" yaml:"destination"`
+	routeAlias  `yaml:",inline"`
+	// Upstream is set for routes that proxy
+	Upstream string `json:"upstream,omitempty" yaml:"upstream,omitempty"`
+	// Split is set for routes that split requests between several destinations
+	Split []splitDoc `json:"split,omitempty" yaml:"split,omitempty"`
+	// Dir is set for routes that serve files
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// Body and ContentType are set for routes that respond. Body may be @ followed by a file to read it from.
+	Body        string `json:"body,omitempty" yaml:"body,omitempty"`
+	ContentType string `json:"content_type,omitempty" yaml:"content_type,omitempty"`
+}
+
+// splitDoc is the structured representation of a SplitDestination
+type splitDoc struct {
+	Weight      int    `json:"weight" yaml:"weight"`
+	Destination string `json:"destination" yaml:"destination"`
+}
+
+func newRouteDoc(r *Route) routeDoc {
+	doc := routeDoc{Pattern: r.Pattern, routeAlias: routeAlias(*r)}
+	if r.Destination != nil {
+		doc.Destination = destinationString(r.Destination)
+	} else if r.Upstream != nil {
+		doc.Destination, doc.Upstream = "proxy", r.Upstream.String()
+	} else if len(r.Split) > 0 {
+		doc.Destination = "split"
+		for _, split := range r.Split {
+			doc.Split = append(doc.Split, splitDoc{Weight: split.Weight, Destination: destinationString(split.Destination)})
+		}
+	} else if r.Files != "" {
+		doc.Destination, doc.Dir = "files", r.Files
+	} else if r.Response != nil {
+		doc.Destination = responseKind(r)
+		doc.Body = responseBody(r.Response)
+		doc.ContentType = r.Response.ContentType
+	}
+	return doc
+}
+
+func (doc routeDoc) route() (*Route, error) {
+	r := Route(doc.routeAlias)
+	r.Pattern = doc.Pattern
+	if doc.Destination == "proxy" {
+		u, err := parseDestination(doc.Upstream)
+		if err != nil {
+			return nil, err
+		}
+		r.Upstream = u
+		return &r, nil
+	}
+	if doc.Upstream != "" {
+		return nil, errors.New("upstream only applies to routes that proxy")
+	}
+	if doc.Destination == "split" {
+		if len(doc.Split) == 0 {
+			return nil, errors.New("split routes must have at least one split destination")
+		}
+		for _, split := range doc.Split {
+			u, err := parseDestination(split.Destination)
+			if err != nil {
+				return nil, err
+			}
+			r.Split = append(r.Split, SplitDestination{Weight: split.Weight, Destination: u})
+		}
+		if r.Code == 0 {
+			r.Code = 302
+		}
+		return &r, nil
+	}
+	if len(doc.Split) > 0 {
+		return nil, errors.New("split only applies to split routes")
+	}
+	if doc.Destination == "files" {
+		r.Files = doc.Dir
+		return &r, nil
+	}
+	if doc.Dir != "" {
+		return nil, errors.New("dir only applies to routes that serve files")
+	}
+	if doc.Destination == "gone" || doc.Destination == "respond" {
+		r.Response = &Response{ContentType: doc.ContentType}
+		if err := r.Response.readBody(doc.Body); err != nil {
+			return nil, err
+		}
+		if r.Code == 0 {
+			r.Code = http.StatusOK
+			if doc.Destination == "gone" {
+				r.Code = http.StatusGone
+			}
+		}
+		return &r, nil
+	}
+	if doc.Body != "" || doc.ContentType != "" {
+		return nil, errors.New("body and content_type only apply to routes that respond")
+	}
+	if r.Code == 0 {
+		r.Code = 302
+	}
+	u, err := parseDestination(doc.Destination)
+	if err != nil {
+		return nil, err
+	}
+	r.Destination = u
+	return &r, nil
+}
+
+// String returns the route in the string syntax accepted by NewRoute, with its options in canonical order
+func (r *Route) String() string {
+	if r.Upstream != nil {
+		return strings.Join([]string{r.Pattern, "proxy", quote(r.Upstream.String())}, " ")
+	}
+	parts := []string{r.Pattern, ""}
+	if r.Files != "" {
+		parts = []string{r.Pattern, "files", r.Files}
+	} else if r.Destination != nil {
+		parts[1] = destinationString(r.Destination)
+	} else if len(r.Split) > 0 {
+		parts[1] = "split"
+		for _, split := range r.Split {
+			parts = append(parts, strconv.Itoa(split.Weight)+":"+destinationString(split.Destination))
+		}
+		if r.StickyCookie != "" {
+			parts = append(parts, "sticky="+r.StickyCookie)
+		} else if r.Sticky {
+			parts = append(parts, "sticky")
+		}
+	} else if r.Response != nil {
+		parts[1] = responseKind(r)
+		if body := responseBody(r.Response); body != "" {
+			parts = append(parts, "body="+body)
+		}
+		if r.Response.ContentType != "" {
+			parts = append(parts, "content-type="+r.Response.ContentType)
+		}
+	}
+	if r.CarryPath {
+		parts = append(parts, "path")
+	}
+	if r.CarryQuery {
+		parts = append(parts, "query")
+	}
+	if r.MergeQuery {
+		parts = append(parts, "merge_query")
+	}
+	names := make([]string, 0, len(r.AddQuery))
+	for k := range r.AddQuery {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		for _, v := range r.AddQuery[k] {
+			parts = append(parts, "add_query="+url.Values{k: {v}}.Encode())
+		}
+	}
+	for _, k := range r.DropQuery {
+		parts = append(parts, "drop_query="+k)
+	}
+	if r.StripPrefix != "" {
+		parts = append(parts, "strip="+r.StripPrefix)
+	}
+	if r.KeepTrailingSlash {
+		parts = append(parts, "keep-trailing-slash")
+	}
+	if r.Refresh {
+		parts = append(parts, "refresh")
+	}
+	names = names[:0]
+	for name := range r.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range r.Headers[name] {
+			parts = append(parts, "header="+name+": "+v)
+		}
+	}
+	parts = append(parts, r.conditionParts()...)
+	if r.CORS != nil {
+		if len(r.CORS.Origins) > 0 {
+			parts = append(parts, "cors="+strings.Join(r.CORS.Origins, ","))
+		} else {
+			parts = append(parts, "cors")
+		}
+	}
+	if len(r.Allow) > 0 {
+		parts = append(parts, "allow="+strings.Join(r.Allow, ","))
+	}
+	if len(r.Deny) > 0 {
+		parts = append(parts, "deny="+strings.Join(r.Deny, ","))
+	}
+	if r.Auth != "" {
+		parts = append(parts, "auth="+r.Auth)
+	}
+	if r.Priority != 0 {
+		parts = append(parts, "priority="+strconv.Itoa(r.Priority))
+	}
+	if r.LogOnly {
+		parts = append(parts, "log_only")
+	}
+	if r.Code != 0 {
+		parts = append(parts, "code="+strconv.Itoa(r.Code))
+	}
+
+	for i, part := range parts {
+		if i == 0 && isRegexpPattern(part) {
+			// NewRoute takes regular expression patterns literally
+			continue
+		}
+		parts[i] = quote(part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// responseKind returns the destination keyword of a route that responds: gone for routes that only answer with a
+// 4xx code, and respond for any others
+func responseKind(r *Route) string {
+	if r.Response.Body == "" && r.Response.ContentType == "" && r.Code >= 400 && r.Code <= 499 {
+		return "gone"
+	}
+	return "respond"
+}
+
+// responseBody returns the body of res as it's written in the route syntax
+func responseBody(res *Response) string {
+	if res.BodyFile != "" {
+		return "@" + res.BodyFile
+	}
+	return res.Body
+}
+
+// destinationString returns u as a string, with placeholders unescaped so that they stay readable. Both forms parse
+// to the same URL.
+func destinationString(u *url.URL) string {
+	s := u.String()
+	if strings.Contains(u.Path, "{") {
+		for p, escaped := range placeholders {
+			s = strings.ReplaceAll(s, escaped, p)
+		}
+		s = escapedWildcard.ReplaceAllString(s, "{$1}")
+	}
+	return s
+}
+
+// escapedWildcard matches the escaped form of a {name} placeholder for a named wildcard
+var escapedWildcard = regexp.MustCompile(`%7B([a-z0-9_]+)%7D`)
+
+// quote quotes s only if shellquote.Split would otherwise not return it verbatim
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\r\n'\"\\") {
+		return s
+	}
+	return shellquote.Join(s)
+}
+
+// MarshalJSON implements json.Marshaler
+func (r *Route) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newRouteDoc(r))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts either an object or a string in the syntax accepted by NewRoute.
+func (r *Route) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return r.set(NewRoute(s))
+	}
+
+	var doc routeDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return r.set(doc.route())
+}
+
+// UnmarshalJSONStrict is like UnmarshalJSON, but refuses objects with fields that routes don't have, such as
+// misspelled options, rather than ignoring them
+func (r *Route) UnmarshalJSONStrict(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return r.set(NewRoute(s))
+	}
+
+	var doc routeDoc
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	return r.set(doc.route())
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (r *Route) MarshalYAML() (interface{}, error) {
+	return newRouteDoc(r), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler. It accepts either a mapping or a string in the syntax accepted by
+// NewRoute.
+func (r *Route) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		return r.set(NewRoute(s))
+	}
+
+	var doc routeDoc
+	if err := unmarshal(&doc); err != nil {
+		return err
+	}
+	return r.set(doc.route())
+}
+
+func (r *Route) set(route *Route, err error) error {
+	if err != nil {
+		return err
+	}
+	*r = *route
+	return nil
+}
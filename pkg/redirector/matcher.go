@@ -0,0 +1,690 @@
+package redirector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// matcher matches request hosts and paths against route patterns. Hosts are matched label by label starting from the
+// top-level domain, and paths segment by segment. A * label at the start of a host matches one or more labels, and a *
+// segment at the end of a path matches any remaining segments, including none. A * anywhere else, or a named wildcard
+// such as {tenant} anywhere, matches exactly one label or segment. Exact labels and segments take precedence over
+// wildcards. Patterns that start with ~ are regular expressions, which are tried in the order they were
+// added when no other pattern matches.
+//
+// A hostname with a port only matches requests for that port, and takes precedence over the same hostname without
+// one, which matches requests for any port.
+//
+// Routes with a higher Priority take precedence over any others that match, however precise, and precision only
+// decides between routes with the same priority.
+//
+// Several routes may share a pattern if they have different conditions. They're tried in the order they were added,
+// except that the route without conditions, if any, is tried last, and when none of them meet their conditions the
+// lookup carries on as if the pattern didn't match.
+//
+// A ** segment at the end of a path matches like a * one, but only when no other pattern matches, not even a regular
+// expression, so that a host can have a default route that never takes over more specific ones, regardless of their
+// priority.
+//
+// Patterns without wildcards are kept in a map by hostname and path rather than in the trie, since they're the most
+// precise patterns there are, and large tables of one-to-one redirects would otherwise need a node for every segment.
+type matcher struct {
+	root hostNode
+	// fallback holds the patterns whose path ends with a ** segment
+	fallback hostNode
+	// exact holds the routes of patterns without wildcards, by hostname and then by path without its leading and
+	// trailing slashes
+	exact map[string]map[string]routeSet
+	// regexps are the routes with regular expression patterns
+	regexps []regexpRoute
+	// ports is whether any of the patterns have a port, which makes lookups try the request's host with its port
+	// before trying it without
+	ports bool
+	// prioritized is whether any of the routes have a Priority, which makes lookups consider every matching route
+	// rather than only the most precise one
+	prioritized bool
+	// conditional is whether any of the routes have conditions, which makes lookups depend on more than the host and
+	// path
+	conditional bool
+	// vary holds the Vary header of routes that share a pattern with routes whose conditions depend on request headers
+	vary map[*Route]string
+	// countries is whether any of the routes have Countries
+	countries bool
+	// schemes is whether any of the routes have a Scheme
+	schemes bool
+	// private holds the routes that share a pattern with routes with Countries, whose responses shared caches must not
+	// keep
+	private map[*Route]bool
+	// access holds the Allow and Deny lists of the routes that have them
+	access map[*Route]Access
+}
+
+type regexpRoute struct {
+	re     *regexp.Regexp
+	routes routeSet
+}
+
+type hostNode struct {
+	labels map[string]*hostNode
+	// any is the node of a * label that isn't at the start of the host
+	any *hostNode
+	// wildcard holds the paths of patterns whose host starts with a * label below this node
+	wildcard *pathNode
+	// paths holds the paths of patterns whose host ends at this node
+	paths *pathNode
+}
+
+type pathNode struct {
+	segments map[string]*pathNode
+	// any is the node of a * segment that isn't at the end of the path
+	any *pathNode
+	// wildcard are the routes whose path ends with a * segment below this node
+	wildcard routeSet
+	// routes are the routes whose path ends at this node
+	routes routeSet
+}
+
+// routeSet is the routes that share a pattern
+type routeSet []*Route
+
+// add adds route to the set, before the route without conditions. It fails if a route with the same conditions has
+// already been added.
+func (s *routeSet) add(route *Route) error {
+	key := route.Conditions()
+	for _, existing := range *s {
+		if existing.Conditions() == key {
+			return errors.New("route already exists")
+		}
+	}
+	i := len(*s)
+	if i > 0 && !(*s)[i-1].conditional() {
+		i--
+	}
+	*s = append(*s, nil)
+	copy((*s)[i+1:], (*s)[i:])
+	(*s)[i] = route
+	return nil
+}
+
+// pick returns the first route in the set whose conditions req meets
+func (s routeSet) pick(req *http.Request) *Route {
+	for _, route := range s {
+		if route.matches(req) {
+			return route
+		}
+	}
+	return nil
+}
+
+// pattern is a parsed route pattern
+type pattern struct {
+	// labels are the host's labels in reverse order, excluding a leading wildcard. Other wildcards are kept as *.
+	labels       []string
+	hostWildcard bool
+	// segments are the path's segments, excluding a trailing wildcard. Other wildcards are kept as *.
+	segments     []string
+	pathWildcard bool
+	// fallback is whether the path ends with a ** segment rather than a * one
+	fallback bool
+	// path is the pattern's path without its leading and trailing slashes, which shares the pattern's memory
+	path string
+	// names are the names of the pattern's wildcards in the order they capture, empty for * wildcards
+	names []string
+	// port is whether the hostname has a port, which its last label keeps
+	port bool
+}
+
+func parsePattern(s string) (*pattern, error) {
+	if s == "" {
+		return nil, errors.New("pattern is empty")
+	}
+	if strings.ContainsAny(s, " \t\r\n") {
+		return nil, errors.New("pattern must not contain whitespace")
+	}
+	i := strings.IndexByte(s, '/')
+	if i == -1 {
+		return nil, errors.New("pattern must be {hostname}/{path}")
+	}
+	if i == 0 {
+		return nil, errors.New("pattern is missing a hostname")
+	}
+
+	p := &pattern{}
+	host := s[:i]
+	if j, ok := portIndex(host); ok {
+		if port, err := strconv.Atoi(host[j+1:]); err != nil || port < 1 || port > 65535 || host[j+1] == '+' {
+			return nil, fmt.Errorf("invalid port %q", host[j+1:])
+		}
+		host, p.port = strings.TrimSuffix(host[:j], ".")+host[j:], true
+	}
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	for j, label := range labels {
+		name, named, err := wildcardName(label)
+		switch {
+		case err != nil:
+			return nil, err
+		case named:
+			label = "*"
+		case label == "*" && j == 0:
+			p.hostWildcard = true
+			p.names = append(p.names, "")
+			continue
+		case label == "":
+			return nil, errors.New("hostname has an empty label")
+		case label != "*" && strings.Contains(label, "*"):
+			return nil, errors.New("a wildcard must be a whole label of the hostname")
+		case label != "*":
+			if label, err = normalizeLabel(label); err != nil {
+				return nil, err
+			}
+		}
+		if label == "*" {
+			p.names = append(p.names, name)
+		}
+		p.labels = append(p.labels, label)
+	}
+	reverse(p.labels)
+
+	p.path = strings.Trim(s[i:], "/")
+	segments := splitPath(s[i:])
+	for j, segment := range segments {
+		name, named, err := wildcardName(segment)
+		switch {
+		case err != nil:
+			return nil, err
+		case named:
+			segment = "*"
+		case (segment == "*" || segment == "**") && j == len(segments)-1:
+			p.pathWildcard, p.fallback = true, segment == "**"
+			p.names = append(p.names, "")
+			continue
+		case segment != "*" && strings.Contains(segment, "*"):
+			return nil, errors.New("a wildcard must be a whole segment of the path")
+		}
+		if segment == "*" {
+			p.names = append(p.names, name)
+		}
+		p.segments = append(p.segments, segment)
+	}
+
+	for j, name := range p.names {
+		for _, other := range p.names[:j] {
+			if name != "" && name == other {
+				return nil, fmt.Errorf("wildcard {%s} is used more than once", name)
+			}
+		}
+	}
+	return p, nil
+}
+
+// wildcardName returns the name of a named wildcard label or segment, {name}, which matches exactly one label or
+// segment. named is false if s isn't one.
+func wildcardName(s string) (name string, named bool, err error) {
+	if !strings.HasPrefix(s, "{") && !strings.HasSuffix(s, "}") {
+		return "", false, nil
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	if len(name) != len(s)-2 || !validWildcardName(name) {
+		return "", false, fmt.Errorf("invalid wildcard %q: must be {name}, with a name of lowercase letters, digits, and underscores", s)
+	}
+	if _, reserved := placeholders["{"+name+"}"]; reserved {
+		return "", false, fmt.Errorf("invalid wildcard %q: {%s} is already a placeholder", s, name)
+	}
+	return name, true, nil
+}
+
+// validWildcardName reports whether name can name a wildcard
+func validWildcardName(name string) bool {
+	if name == "" || strings.Trim(name, "0123456789") == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// Patterns returns the patterns that the route matches: one for each host of a pattern with a comma-separated list of
+// them, such as example.com,example.org/*, or just its pattern otherwise
+func (r *Route) Patterns() []string {
+	i := strings.IndexByte(r.Pattern, '/')
+	if isRegexpPattern(r.Pattern) || i == -1 || !strings.Contains(r.Pattern[:i], ",") {
+		return []string{r.Pattern}
+	}
+	hosts := strings.Split(r.Pattern[:i], ",")
+	patterns := make([]string, len(hosts))
+	for j, host := range hosts {
+		patterns[j] = host + r.Pattern[i:]
+	}
+	return patterns
+}
+
+// isRegexpPattern reports whether s is a regular expression pattern
+func isRegexpPattern(s string) bool {
+	return strings.HasPrefix(s, "~")
+}
+
+// compileRegexpPattern compiles a regular expression pattern. It must match the whole {hostname}/{path}.
+func compileRegexpPattern(s string) (*regexp.Regexp, error) {
+	if s == "~" {
+		return nil, errors.New("regular expression is empty")
+	}
+	return regexp.Compile("^(?:" + s[1:] + ")$")
+}
+
+func newMatcher() *matcher {
+	return &matcher{}
+}
+
+// add adds a route to the matcher, once for each of its hosts if it has a list of them. It fails if the route's
+// pattern is invalid or if a route with the same pattern and conditions has already been added.
+func (m *matcher) add(route *Route) error {
+	if isRegexpPattern(route.Pattern) {
+		re, err := compileRegexpPattern(route.Pattern)
+		if err != nil {
+			return err
+		}
+		for i := range m.regexps {
+			if m.regexps[i].routes[0].Pattern == route.Pattern {
+				if err := m.regexps[i].routes.add(route); err != nil {
+					return err
+				}
+				m.added(m.regexps[i].routes, route)
+				return nil
+			}
+		}
+		m.regexps = append(m.regexps, regexpRoute{re, routeSet{route}})
+		m.added(routeSet{route}, route)
+		return nil
+	}
+
+	for _, s := range route.Patterns() {
+		p, err := parsePattern(s)
+		if err != nil {
+			return err
+		}
+		if err := m.insert(p, route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert adds route to the trie under p
+func (m *matcher) insert(p *pattern, route *Route) error {
+	m.ports = m.ports || p.port
+	if !p.hostWildcard && !p.pathWildcard && len(p.names) == 0 {
+		return m.insertExact(p, route)
+	}
+	h := &m.root
+	if p.fallback {
+		h = &m.fallback
+	}
+	for _, label := range p.labels {
+		if label == "*" {
+			if h.any == nil {
+				h.any = &hostNode{}
+			}
+			h = h.any
+			continue
+		}
+		if h.labels == nil {
+			h.labels = make(map[string]*hostNode)
+		}
+		child, ok := h.labels[label]
+		if !ok {
+			child = &hostNode{}
+			h.labels[label] = child
+		}
+		h = child
+	}
+
+	paths := &h.paths
+	if p.hostWildcard {
+		paths = &h.wildcard
+	}
+	if *paths == nil {
+		*paths = &pathNode{}
+	}
+	n := *paths
+	for _, segment := range p.segments {
+		if segment == "*" {
+			if n.any == nil {
+				n.any = &pathNode{}
+			}
+			n = n.any
+			continue
+		}
+		if n.segments == nil {
+			n.segments = make(map[string]*pathNode)
+		}
+		child, ok := n.segments[segment]
+		if !ok {
+			child = &pathNode{}
+			n.segments[segment] = child
+		}
+		n = child
+	}
+
+	target := &n.routes
+	if p.pathWildcard {
+		target = &n.wildcard
+	}
+	if err := target.add(route); err != nil {
+		return err
+	}
+	m.added(*target, route)
+	return nil
+}
+
+// insertExact adds route to the exact patterns under p, which has no wildcards
+func (m *matcher) insertExact(p *pattern, route *Route) error {
+	labels := append([]string(nil), p.labels...)
+	reverse(labels)
+	host := strings.Join(labels, ".")
+	if m.exact == nil {
+		m.exact = make(map[string]map[string]routeSet)
+	}
+	paths, ok := m.exact[host]
+	if !ok {
+		paths = make(map[string]routeSet)
+		m.exact[host] = paths
+	}
+	set := paths[p.path]
+	if err := set.add(route); err != nil {
+		return err
+	}
+	paths[p.path] = set
+	m.added(set, route)
+	return nil
+}
+
+// added updates the matcher after route was added to set
+func (m *matcher) added(set routeSet, route *Route) {
+	m.conditional = m.conditional || route.conditional()
+	m.prioritized = m.prioritized || route.Priority != 0
+	m.schemes = m.schemes || route.Scheme != ""
+	if len(route.Allow) > 0 || len(route.Deny) > 0 {
+		if m.access == nil {
+			m.access = make(map[*Route]Access)
+		}
+		// routes are validated before they're added, so their lists parse
+		m.access[route], _ = route.routeAccess()
+	}
+	if len(route.Countries) > 0 {
+		m.countries = true
+		if m.private == nil {
+			m.private = make(map[*Route]bool)
+		}
+	}
+	for _, r := range set {
+		if len(r.Countries) > 0 {
+			for _, r := range set {
+				m.private[r] = true
+			}
+			break
+		}
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, r := range set {
+		for _, name := range r.varyHeaders() {
+			if !seen[name] {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	if m.vary == nil {
+		m.vary = make(map[*Route]string)
+	}
+	for _, r := range set {
+		m.vary[r] = strings.Join(names, ", ")
+	}
+}
+
+// lookup returns the route that matches host and path with the highest priority, then most precisely, and whose
+// conditions req meets, along with what its wildcards captured: the labels and segments they matched, in the order the
+// wildcards appear in the pattern, or the groups of a regular expression. It walks host and path in place rather than
+// splitting them.
+func (m *matcher) lookup(req *http.Request, host, path string) (*Route, []string) {
+	route, captures, _ := m.lookupVary(req, host, path)
+	return route, captures
+}
+
+// lookupVary is like lookup, but also returns the Vary header of the patterns that were tried for req, whose responses
+// depend on the request headers that their routes' conditions do, even if another pattern's route ends up matching
+func (m *matcher) lookupVary(req *http.Request, host, path string) (*Route, []string, string) {
+	v := visitor{req: req, prioritized: m.prioritized, varies: m.vary}
+	path = strings.Trim(path, "/")
+	// the exact patterns are the first that a walk of the trie would find
+	if route := v.pick(m.exact[host][path]); route != nil && v.found(route, nil) {
+		return v.route, v.captures, joinVary(v.vary...)
+	}
+	if m.root.visit(&v, host, path, nil) {
+		return v.route, v.captures, joinVary(v.vary...)
+	}
+
+	if len(m.regexps) > 0 {
+		s := host + "/" + path
+		for _, r := range m.regexps {
+			if groups := r.re.FindStringSubmatch(s); groups != nil {
+				if route := v.pick(r.routes); route != nil && v.found(route, groups[1:]) {
+					break
+				}
+			}
+		}
+	}
+	if v.route == nil {
+		m.fallback.visit(&v, host, path, nil)
+	}
+	return v.route, v.captures, joinVary(v.vary...)
+}
+
+// joinVary joins Vary headers into one with each of their names once, in order
+func joinVary(varies ...string) string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, vary := range varies {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.TrimSpace(name); name != "" && !seen[name] {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// hasHost reports whether any of the patterns could match requests for host, with or without its port
+func (m *matcher) hasHost(host string) bool {
+	if len(m.regexps) > 0 || m.exact[host] != nil || m.root.hasHost(host) || m.fallback.hasHost(host) {
+		return true
+	}
+	if i, ok := portIndex(host); ok {
+		return m.exact[host[:i]] != nil || m.root.hasHost(host[:i]) || m.fallback.hasHost(host[:i])
+	}
+	return false
+}
+
+// hasHost reports whether the remaining labels of host lead to any paths
+func (n *hostNode) hasHost(host string) bool {
+	if host == "" {
+		return n.paths != nil
+	}
+	rest, label := "", host
+	if i := strings.LastIndexByte(host, '.'); i != -1 {
+		rest, label = host[:i], host[i+1:]
+	}
+	if child, ok := n.labels[label]; ok && child.hasHost(rest) {
+		return true
+	}
+	return (n.any != nil && n.any.hasHost(rest)) || n.wildcard != nil
+}
+
+// visitor collects the best route of a lookup
+type visitor struct {
+	req *http.Request
+	// varies holds the Vary header of the routes that share a pattern with routes whose conditions depend on request
+	// headers, and vary collects those of the patterns that were tried
+	varies map[*Route]string
+	vary   []string
+	// prioritized is whether every matching route must be visited to find the one with the highest priority. Otherwise
+	// routes are visited in order of precision and the first one wins.
+	prioritized bool
+	route       *Route
+	captures    []string
+}
+
+// pick returns the first route in set whose conditions the request meets, noting the headers that picking it depended
+// on, which the response varies on whether or not one was picked
+func (v *visitor) pick(set routeSet) *Route {
+	if len(set) > 0 {
+		if vary := v.varies[set[0]]; vary != "" {
+			v.vary = append(v.vary, vary)
+		}
+	}
+	return set.pick(v.req)
+}
+
+// found offers a matching route and what its wildcards captured, and returns whether the lookup is done
+func (v *visitor) found(route *Route, captures []string) bool {
+	if v.route == nil || route.Priority > v.route.Priority {
+		v.route, v.captures = route, captures
+	}
+	return !v.prioritized
+}
+
+// visit matches the remaining labels of host, consuming them from the end, followed by path. captures are what the
+// wildcards to the right of the remaining labels captured.
+func (n *hostNode) visit(v *visitor, host, path string, captures []string) bool {
+	if host == "" {
+		return n.paths != nil && n.paths.visit(v, path, captures)
+	}
+
+	rest, label := "", host
+	if i := strings.LastIndexByte(host, '.'); i != -1 {
+		rest, label = host[:i], host[i+1:]
+	}
+	if child, ok := n.labels[label]; ok && child.visit(v, rest, path, captures) {
+		return true
+	}
+	if n.any != nil && n.any.visit(v, rest, path, prepend(label, captures)) {
+		return true
+	}
+	return n.wildcard != nil && n.wildcard.visit(v, path, prepend(host, captures))
+}
+
+// visit matches the remaining segments of path, consuming them from the start
+func (n *pathNode) visit(v *visitor, path string, captures []string) bool {
+	if path == "" {
+		if route := v.pick(n.routes); route != nil && v.found(route, captures) {
+			return true
+		}
+	}
+	if path != "" {
+		segment, rest := path, ""
+		if i := strings.IndexByte(path, '/'); i != -1 {
+			segment, rest = path[:i], path[i+1:]
+		}
+		if child, ok := n.segments[segment]; ok && child.visit(v, rest, captures) {
+			return true
+		}
+		if n.any != nil && n.any.visit(v, rest, appendCapture(captures, segment)) {
+			return true
+		}
+	}
+	if route := v.pick(n.wildcard); route != nil {
+		return v.found(route, appendCapture(captures, path))
+	}
+	return false
+}
+
+// prepend returns a new slice of s followed by captures
+func prepend(s string, captures []string) []string {
+	return append([]string{s}, captures...)
+}
+
+// appendCapture returns a new slice of captures followed by s, leaving captures as it was for other branches of a
+// lookup
+func appendCapture(captures []string, s string) []string {
+	return append(captures[:len(captures):len(captures)], s)
+}
+
+// normalizeHost lowercases host, converts it to punycode if it has unicode labels, and removes any trailing dot, so
+// that it's matched the same way as the hostnames of patterns. Hosts that are already normalized are returned as they
+// are without allocating.
+func normalizeHost(host string) string {
+	name, port := host, ""
+	if i, ok := portIndex(host); ok {
+		// the dot is trimmed from before the port
+		name, port = host[:i], host[i:]
+	}
+	trimmed := strings.HasSuffix(name, ".")
+	name = strings.TrimSuffix(name, ".")
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c >= utf8.RuneSelf || (c >= 'A' && c <= 'Z') {
+			if ascii, err := idna.Lookup.ToASCII(name); err == nil {
+				return ascii + port
+			}
+			return strings.ToLower(name) + port
+		}
+	}
+	if !trimmed {
+		return host
+	}
+	return name + port
+}
+
+// portIndex returns the index of the colon that separates host from its port, if it has one
+func portIndex(host string) (int, bool) {
+	i := strings.LastIndexByte(host, ':')
+	if i == -1 || strings.IndexByte(host[i:], ']') != -1 || (host[0] != '[' && strings.IndexByte(host[:i], ':') != -1) {
+		// no port, or an ipv6 address without one
+		return 0, false
+	}
+	return i, true
+}
+
+// normalizeLabel lowercases a label of a pattern's hostname and converts it to punycode if it's unicode
+func normalizeLabel(label string) (string, error) {
+	for i := 0; i < len(label); i++ {
+		if label[i] >= utf8.RuneSelf {
+			ascii, err := idna.Lookup.ToASCII(label)
+			if err != nil {
+				return "", fmt.Errorf("invalid hostname label %q: %v", label, err)
+			}
+			return ascii, nil
+		}
+	}
+	return strings.ToLower(label), nil
+}
+
+// splitPath splits a path into its segments, ignoring leading and trailing slashes
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func reverse(s []string) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
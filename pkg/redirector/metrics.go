@@ -0,0 +1,27 @@
+package redirector
+
+import "time"
+
+// Collector receives metrics about the requests that a Redirector handles, so that they can be recorded in any
+// metrics system
+type Collector interface {
+	// Matched is called after a request that matched route has been handled
+	Matched(route *Route, duration time.Duration)
+	// Missed is called for requests that didn't match any route, with the pattern derived from the request
+	Missed(pattern string)
+	// ProxyError is called when a proxy set up by the Redirector fails to forward a request
+	ProxyError(err error)
+}
+
+// WithMetrics sends metrics to c
+func WithMetrics(c Collector) Option {
+	return func(r *Redirector) {
+		r.metrics = c
+	}
+}
+
+type nopCollector struct{}
+
+func (nopCollector) Matched(*Route, time.Duration) {}
+func (nopCollector) Missed(string)                 {}
+func (nopCollector) ProxyError(error)              {}
@@ -0,0 +1,42 @@
+package redirector
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// WithMiddleware wraps the handling of requests, both the execution of the routes they match and the default handler
+// for those that don't match any, in mw. It can be passed more than once, and the first middleware passed is the
+// outermost. Middleware can find the route that matched with MatchedRoute.
+//
+//	re := redirector.New(routes, redirector.WithMiddleware(tracing), redirector.WithMiddleware(auth))
+func WithMiddleware(mw func(http.Handler) http.Handler) Option {
+	return func(r *Redirector) {
+		r.middleware = append(r.middleware, mw)
+	}
+}
+
+// match is what Handler found for a request, passed through the middleware chain
+type match struct {
+	table    *table
+	route    *Route
+	captures []string
+	vary     string
+	start    time.Time
+}
+
+type matchKey struct{}
+
+func withMatch(req *http.Request, m *match) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), matchKey{}, m))
+}
+
+// MatchedRoute returns the route that matched req, for middleware set with WithMiddleware. It returns nil if no
+// route matched req, or if it wasn't passed through a Redirector's middleware.
+func MatchedRoute(req *http.Request) *Route {
+	if m, ok := req.Context().Value(matchKey{}).(*match); ok {
+		return m.route
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package redirector
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TrailingSlash decides what happens to the trailing slash of the paths that routes redirect to
+type TrailingSlash int
+
+const (
+	// TrailingSlashAsIs leaves destination paths as routes build them. Carried paths lose their trailing slash.
+	TrailingSlashAsIs TrailingSlash = iota
+	// TrailingSlashKeep keeps the trailing slash of the request's path on carried paths
+	TrailingSlashKeep
+	// TrailingSlashAdd makes every destination path end with a slash
+	TrailingSlashAdd
+	// TrailingSlashRemove removes the trailing slash of every destination path other than /
+	TrailingSlashRemove
+)
+
+// ParseTrailingSlash parses the name of a TrailingSlash mode: keep, add, or remove
+func ParseTrailingSlash(s string) (TrailingSlash, bool) {
+	switch s {
+	case "keep":
+		return TrailingSlashKeep, true
+	case "add":
+		return TrailingSlashAdd, true
+	case "remove":
+		return TrailingSlashRemove, true
+	}
+	return TrailingSlashAsIs, false
+}
+
+// PathNormalization is how a Redirector normalizes paths
+type PathNormalization struct {
+	// CollapseSlashes replaces runs of slashes in request and destination paths with a single one, so that
+	// example.com//docs matches example.com/docs
+	CollapseSlashes bool
+	// TrailingSlash is what happens to the trailing slash of destination paths. Routes with KeepTrailingSlash always
+	// keep it.
+	TrailingSlash TrailingSlash
+}
+
+type pathNormalizationKey struct{}
+
+func withPathNormalization(req *http.Request, n PathNormalization) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), pathNormalizationKey{}, n))
+}
+
+// normalizePath applies the path normalization of the Redirector that's handling req, and the route's
+// KeepTrailingSlash, to dest
+func (r *Route) normalizePath(dest *url.URL, req *http.Request) {
+	n, _ := req.Context().Value(pathNormalizationKey{}).(PathNormalization)
+	if r.KeepTrailingSlash {
+		n.TrailingSlash = TrailingSlashKeep
+	}
+
+	p := dest.EscapedPath()
+	if n.CollapseSlashes {
+		p = collapseSlashes(p)
+	}
+	switch n.TrailingSlash {
+	case TrailingSlashKeep:
+		if r.CarryPath && strings.HasSuffix(req.URL.Path, "/") && !strings.HasSuffix(p, "/") {
+			p += "/"
+		}
+	case TrailingSlashAdd:
+		if !strings.HasSuffix(p, "/") {
+			p += "/"
+		}
+	case TrailingSlashRemove:
+		if trimmed := strings.TrimRight(p, "/"); trimmed != "" {
+			p = trimmed
+		}
+	}
+	if p != dest.EscapedPath() {
+		setEscapedPath(dest, p)
+	}
+}
+
+// collapseSlashes replaces runs of slashes in p with a single slash. It only allocates if there are any.
+func collapseSlashes(p string) string {
+	if !strings.Contains(p, "//") {
+		return p
+	}
+	var b strings.Builder
+	b.Grow(len(p))
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && i > 0 && p[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package redirector
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PreviewParam is the query parameter that makes a Redirector with WithPreview show a preview page instead of
+// redirecting, when it's set to 1
+const PreviewParam = "__redirector_preview"
+
+var previewPage = template.Must(template.New("preview").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>🔄 redirect preview</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 42rem; margin: 4rem auto; padding: 0 1rem; line-height: 1.5; color: #222; }
+code { background: #f3f3f3; border-radius: 4px; padding: 0.1rem 0.3rem; word-break: break-all; }
+th { text-align: left; padding-right: 1rem; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>🔄 redirect preview</h1>
+<table>
+<tr><th>request</th><td><code>{{.Request}}</code></td></tr>
+<tr><th>route</th><td><code>{{.Route}}</code></td></tr>
+<tr><th>status</th><td>{{.Code}} {{.Status}}</td></tr>
+<tr><th>destination</th><td><a href="{{.Destination}}"><code>{{.Destination}}</code></a></td></tr>
+</table>
+</body>
+</html>
+`))
+
+// WithPreview lets requests with PreviewParam set to 1 see a page with the route that matched them, their destination,
+// and the status code instead of being redirected, for testing short links and campaign urls safely. The parameter
+// is removed from the request before its destination is resolved.
+func WithPreview() Option {
+	return func(r *Redirector) {
+		r.preview = true
+	}
+}
+
+// wantsPreview returns whether req asks for a preview
+func wantsPreview(req *http.Request) bool {
+	return strings.Contains(req.URL.RawQuery, PreviewParam) && req.URL.Query().Get(PreviewParam) == "1"
+}
+
+// servePreview answers req with a page that shows where the route would redirect it to
+func (r *Route) servePreview(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	q.Del(PreviewParam)
+	u := *req.URL
+	u.RawQuery = q.Encode()
+	req = req.Clone(req.Context())
+	req.URL = &u
+
+	dest, code, err := r.Resolve(req)
+	if err != nil {
+		requestLogger(req).Error("resolving the destination", "route", r.Pattern, "pattern", RequestPattern(req), "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !r.allowsDestination(w, req, dest) {
+		return
+	}
+	var buf bytes.Buffer
+	err = previewPage.Execute(&buf, struct {
+		Request, Route, Status string
+		Code                   int
+		Destination            *url.URL
+	}{
+		Request:     RequestPattern(req),
+		Route:       r.Redacted().String(),
+		Status:      http.StatusText(code),
+		Code:        code,
+		Destination: dest,
+	})
+	if err != nil {
+		requestLogger(req).Error("rendering the preview", "route", r.Pattern, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
@@ -0,0 +1,115 @@
+package redirector
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// WithDefaultProxy forwards requests that don't match any of the configured routes to target. Proxy errors are
+// reported to the Collector set with WithMetrics.
+func WithDefaultProxy(target *url.URL) Option {
+	return func(r *Redirector) {
+		r.defaultHandler = r.newProxy(target)
+	}
+}
+
+// WithDefaultProxyTransport is like WithDefaultProxy, but forwards requests with rt instead of http.DefaultTransport,
+// e.g. to speak h2c to target.
+func WithDefaultProxyTransport(target *url.URL, rt http.RoundTripper) Option {
+	return func(r *Redirector) {
+		proxy := r.newProxy(target)
+		proxy.Transport = rt
+		r.defaultHandler = proxy
+	}
+}
+
+// DefaultProxy is an upstream for some of the requests that don't match any of the configured routes, for
+// WithDefaultProxies
+type DefaultProxy struct {
+	// Pattern is a route pattern, such as api.example.com/*, that requests must match to be forwarded to Target. The
+	// DefaultProxy without one, if any, gets the requests that don't match any other.
+	Pattern string
+	Target  *url.URL
+	// Transport forwards requests to Target instead of http.DefaultTransport if it's set
+	Transport http.RoundTripper
+	// Middleware wraps the proxy if it's set, e.g. to hold requests until Target is up
+	Middleware func(http.Handler) http.Handler
+}
+
+// WithDefaultProxies forwards requests that don't match any of the configured routes to the one of proxies whose
+// pattern they match, like WithDefaultProxy. Patterns are picked between like those of routes, and proxies with
+// patterns that aren't valid, as reported by Route.Validate, or that repeat an earlier one, are skipped. Requests that
+// don't match any of the patterns get a 404, unless one of proxies has no pattern.
+func WithDefaultProxies(proxies ...DefaultProxy) Option {
+	return func(r *Redirector) {
+		r.defaultHandler = r.newDefaultProxies(proxies)
+	}
+}
+
+func (r *Redirector) newDefaultProxies(proxies []DefaultProxy) http.Handler {
+	t := &table{matcher: newMatcher()}
+	handlers := make(map[*Route]http.Handler, len(proxies))
+	var fallback http.Handler
+	for _, p := range proxies {
+		proxy := r.newProxy(p.Target)
+		if p.Transport != nil {
+			proxy.Transport = p.Transport
+		}
+		var h http.Handler = proxy
+		if p.Middleware != nil {
+			h = p.Middleware(h)
+		}
+		if p.Pattern == "" {
+			if fallback == nil {
+				fallback = h
+			}
+			continue
+		}
+		route := &Route{Pattern: p.Pattern, Upstream: p.Target}
+		if route.Validate() != nil || t.matcher.add(route) != nil {
+			continue
+		}
+		handlers[route] = h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if route, _, _ := r.matchIn(t, req); route != nil {
+			handlers[route].ServeHTTP(w, req)
+			return
+		}
+		if fallback != nil {
+			fallback.ServeHTTP(w, req)
+			return
+		}
+		r.log().Info("request did not match any configured routes", "pattern", r.requestPattern(req))
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	})
+}
+
+func (r *Redirector) newProxy(target *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	// flush every write, so that streamed responses such as server-sent events and dev server updates aren't held
+	// back in a buffer
+	proxy.FlushInterval = -1
+	director := proxy.Director
+	proxy.Director = func(out *http.Request) {
+		director(out)
+		r.setForwarded(out)
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		r.metrics.ProxyError(err)
+		r.log().Error("proxying request", "pattern", r.requestPattern(req), "upstream", target.String(), "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return proxy
+}
+
+// proxy returns the reverse proxy for upstream, which is shared by all the routes that proxy to it
+func (r *Redirector) proxy(upstream *url.URL) http.Handler {
+	key := upstream.String()
+	if p, ok := r.proxies.Load(key); ok {
+		return p.(http.Handler)
+	}
+	p, _ := r.proxies.LoadOrStore(key, r.newProxy(upstream))
+	return p.(http.Handler)
+}
@@ -1,43 +1,186 @@
 package redirector
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
+	"os"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/fanyang01/radix"
 	"github.com/kballard/go-shellquote"
 )
 
-// Route ...
+// Route redirects requests that match its pattern to its destination. Routes are shared by concurrent requests, so
+// they must not be modified once they have been added to a Redirector; every request builds its own destination URL
+// from a copy of the route's (see Resolve). Use UpdateRoute to change a route that is in use.
 type Route struct {
-	Pattern     string
-	Destination *url.URL
-	Code        int
-	CarryPath   bool
-	CarryQuery  bool
+	Pattern     string   `json:"-" yaml:"-"`
+	Destination *url.URL `json:"-" yaml:"-"`
+	Code        int      `json:"code,omitempty" yaml:"code,omitempty"`
+	CarryPath   bool     `json:"path,omitempty" yaml:"path,omitempty"`
+	CarryQuery  bool     `json:"query,omitempty" yaml:"query,omitempty"`
+	// MergeQuery merges the request's query parameters into the destination's, replacing any with the same name
+	MergeQuery bool `json:"merge_query,omitempty" yaml:"merge_query,omitempty"`
+	// AddQuery are query parameters to set on every redirect, replacing any with the same name
+	AddQuery url.Values `json:"add_query,omitempty" yaml:"add_query,omitempty"`
+	// DropQuery are the names of query parameters to remove from redirects, such as tracking parameters
+	DropQuery []string `json:"drop_query,omitempty" yaml:"drop_query,omitempty"`
+	// Headers are extra headers to set on redirect responses, such as Cache-Control
+	Headers http.Header `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// StripPrefix is removed from the start of the request's path before it's carried over, if the path starts with
+	// it. It's matched by whole segments, so /docs is removed from /docs and /docs/intro but not from /docsearch.
+	StripPrefix string `json:"strip,omitempty" yaml:"strip,omitempty"`
+	// KeepTrailingSlash keeps the trailing slash of the request's path when it's carried, which path.Join would
+	// otherwise drop
+	KeepTrailingSlash bool `json:"keep_trailing_slash,omitempty" yaml:"keep_trailing_slash,omitempty"`
+	// Split, if set, are the destinations that requests are split between by weight, instead of Destination
+	Split []SplitDestination `json:"-" yaml:"-"`
+	// Sticky keeps sending clients to the same split destination with a cookie
+	Sticky bool `json:"sticky,omitempty" yaml:"sticky,omitempty"`
+	// StickyCookie names the cookie of a sticky route, which holds the position of the client's destination counting
+	// from 0, so that other routes can match on it with MatchCookies. It's derived from the pattern by default.
+	StickyCookie string `json:"sticky_cookie,omitempty" yaml:"sticky_cookie,omitempty"`
+	// Refresh adds an HTML body with a meta refresh and a link to the destination to redirect responses, for clients
+	// that don't follow Location headers. See WithRefreshBody.
+	Refresh bool `json:"refresh,omitempty" yaml:"refresh,omitempty"`
+	// Resolver, if set, decides the destination per request instead of Destination. The path and query are still
+	// carried over according to CarryPath and CarryQuery.
+	Resolver Resolver `json:"-" yaml:"-"`
+	// Response, if set, is answered with Code instead of a redirect. Such routes have no Destination.
+	Response *Response `json:"-" yaml:"-"`
+	// Methods, if set, are the only request methods that the route matches. Other requests fall through to the routes
+	// with the same pattern, or to less precise patterns.
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	// MatchHeaders, if set, are request headers that the route requires. A request must have one of the values listed
+	// for each header, and an empty value matches any value, so it only requires the header to be set.
+	MatchHeaders http.Header `json:"match_headers,omitempty" yaml:"match_headers,omitempty"`
+	// MatchQuery, if set, are query parameters that the route requires, matched the same way as MatchHeaders
+	MatchQuery url.Values `json:"match_query,omitempty" yaml:"match_query,omitempty"`
+	// MatchCookies, if set, are cookies that the route requires, matched the same way as MatchHeaders
+	MatchCookies url.Values `json:"match_cookies,omitempty" yaml:"match_cookies,omitempty"`
+	// UserAgents, if set, are the classes of user agents that the route matches, out of UAMobile, UADesktop, and UABot.
+	// See ClassifyUserAgent.
+	UserAgents []string `json:"ua,omitempty" yaml:"ua,omitempty"`
+	// Countries, if set, are the countries that the route matches requests from, as ISO 3166-1 alpha-2 codes. See
+	// WithCountry.
+	Countries []string `json:"country,omitempty" yaml:"country,omitempty"`
+	// ActiveFrom and ActiveUntil, if set, limit the route to requests made in that window of time. Outside of it,
+	// requests fall through as if the route didn't exist.
+	ActiveFrom  *time.Time `json:"from,omitempty" yaml:"from,omitempty"`
+	ActiveUntil *time.Time `json:"until,omitempty" yaml:"until,omitempty"`
+	// Scheme, if set, restricts the route to requests made over http or https, as found by Scheme
+	Scheme string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	// Priority makes the route take precedence over routes with a lower priority that also match, regardless of how
+	// precise their patterns are. It defaults to 0.
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty"`
+	// LogOnly makes the route only log where it would have redirected requests to. The requests are handled as if they
+	// didn't match any route, so that a route can be tried against real traffic before it's turned on.
+	LogOnly bool `json:"log_only,omitempty" yaml:"log_only,omitempty"`
+	// Allow and Deny, if set, are the CIDRs or ip addresses that clients must and must not come from for the route to
+	// serve them. Other clients are answered with 403 Forbidden rather than falling through to other routes.
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+	// Auth, if set, makes the route require basic auth credentials, as <user>:<password>, or a signature made with
+	// SignURL, as hmac:<secret>. Requests without them are answered with 401 Unauthorized rather than falling through
+	// to other routes.
+	Auth string `json:"auth,omitempty" yaml:"auth,omitempty"`
+	// CORS, if set, answers the CORS preflights of requests for the route, and allows their origins on its responses,
+	// instead of the CORS set with WithCORS
+	CORS *CORS `json:"cors,omitempty" yaml:"cors,omitempty"`
+	// Files, if set, is a directory that requests are served from instead of being redirected. Such routes have no
+	// Destination or Code, and StripPrefix is removed from the request's path before it's looked up in the directory.
+	Files string `json:"-" yaml:"-"`
+	// Upstream, if set, is a server that requests are proxied to instead of being redirected. Such routes have no
+	// Destination or Code.
+	Upstream *url.URL `json:"-" yaml:"-"`
+	// Source optionally describes where the route was configured, such as a file and line. It's only used for
+	// debugging; see WithDebugHeaders.
+	Source string `json:"-" yaml:"-"`
 }
 
-// Redirector ...
+// Response is a fixed response that a route answers requests with instead of redirecting them
+type Response struct {
+	// Body is the response's body. It defaults to the status text of the route's code.
+	Body string
+	// BodyFile is the file that Body was read from, if any, so that the route can be written back the same way
+	BodyFile string
+	// ContentType defaults to text/plain
+	ContentType string
+}
+
+// readBody sets the response's body from s, which is either the body itself or @ followed by the path of a file to
+// read it from
+func (res *Response) readBody(s string) error {
+	if !strings.HasPrefix(s, "@") {
+		res.Body, res.BodyFile = s, ""
+		return nil
+	}
+	data, err := os.ReadFile(s[1:])
+	if err != nil {
+		return fmt.Errorf("reading body: %v", err)
+	}
+	res.Body, res.BodyFile = string(data), s[1:]
+	return nil
+}
+
+// Redirector matches requests against its routes and redirects them. It is safe for concurrent use: routes can be
+// changed while requests are being served, and each change swaps in a complete new route table atomically.
 type Redirector struct {
-	matcher        *radix.PatternTrie
+	table atomic.Pointer[table]
+	// mu serializes changes to the routes
+	mu             sync.Mutex
+	cacheSize      int
 	defaultHandler http.Handler
+	metrics        Collector
+	patternFunc    func(*http.Request) string
+	countryFunc    func(*http.Request) string
+	schemeFunc     func(*http.Request) string
+	paths          PathNormalization
+	debugHeaders   bool
+	preview        bool
+	refresh        RefreshBody
+	access         *Access
+	destinations   DestinationHosts
+	cors           *CORS
+	txt            *txtCache
+	logger         Logger
+	forwarded      *ForwardedHeaders
+	// middleware wraps the handling of matched and missed requests, in the order it was added
+	middleware []func(http.Handler) http.Handler
+	// chain is the middleware wrapped around serveMatched
+	chain   http.Handler
+	onMatch []func(*Route, *http.Request) bool
+	onMiss  []func(*http.Request)
+	// proxies are the reverse proxies of routes with an Upstream, by upstream url
+	proxies sync.Map
 }
 
 // New creates a new Redirector
 func New(routes []*Route, opts ...Option) *Redirector {
 	r := &Redirector{
-		matcher: radix.NewPatternTrie(),
+		metrics: nopCollector{},
 	}
 
 	for _, opt := range opts {
 		opt(r)
 	}
+	r.table.Store(&table{matcher: newMatcher()})
+	if len(r.middleware) > 0 {
+		r.chain = http.HandlerFunc(r.serveMatched)
+		for i := len(r.middleware) - 1; i >= 0; i-- {
+			r.chain = r.middleware[i](r.chain)
+		}
+	}
 
 	return r
 }
@@ -53,93 +196,897 @@ func WithDefaultHandler(h http.Handler) Option {
 	}
 }
 
+// WithDefaultRedirect redirects requests that don't match any of the configured routes to dest with code, such as a
+// landing page. code defaults to 302 if it's 0.
+func WithDefaultRedirect(dest *url.URL, code int) Option {
+	if code == 0 {
+		code = http.StatusFound
+	}
+	return WithDefaultHandler(http.RedirectHandler(dest.String(), code))
+}
+
+// WithCountry sets the function that finds the country of a request, as an ISO 3166-1 alpha-2 code such as US, for
+// matching routes with Countries. It's only called for requests that such routes could match. Without it, routes with
+// Countries never match.
+func WithCountry(f func(*http.Request) string) Option {
+	return func(r *Redirector) {
+		r.countryFunc = f
+	}
+}
+
+// WithScheme sets the function that finds the scheme a request was made with, http or https, for matching routes with
+// a Scheme, such as one that trusts the X-Forwarded-Proto header of a load balancer that terminates tls. By default,
+// requests over tls connections are https and others are http.
+func WithScheme(f func(*http.Request) string) Option {
+	return func(r *Redirector) {
+		r.schemeFunc = f
+	}
+}
+
+// WithPathNormalization sets how request and destination paths are normalized. By default, paths are matched as they
+// are and carried paths lose their trailing slash.
+func WithPathNormalization(n PathNormalization) Option {
+	return func(r *Redirector) {
+		r.paths = n
+	}
+}
+
+// WithDebugHeaders sets X-Redirector-Route and X-Redirector-Route-Source headers on the responses to requests that
+// match a route, with the route's pattern and Source
+func WithDebugHeaders() Option {
+	return func(r *Redirector) {
+		r.debugHeaders = true
+	}
+}
+
+// WithCache caches the results of the last size route lookups, which avoids walking the route table for requests that
+// hit the same host and path repeatedly. The cache is emptied whenever the routes change. It's bypassed while any
+// routes have conditions, such as methods, headers, query parameters, user agents, countries, time windows, or schemes,
+// since their lookups depend on more than the host and path.
+func WithCache(size int) Option {
+	return func(r *Redirector) {
+		r.cacheSize = size
+	}
+}
+
+// WithPatternFunc sets the function that derives the {hostname}/{path} pattern that a request is matched against. It
+// defaults to RequestPattern. This can be used to match on X-Forwarded-Host, for example.
+func WithPatternFunc(f func(*http.Request) string) Option {
+	return func(r *Redirector) {
+		r.patternFunc = f
+	}
+}
+
 // NewRoute creates a new route from its string representation
-// syntax: <pattern> <destination> [path: bool; default=false] [query: bool; default=false] [code: int; default=302]
+// syntax: <pattern> <destination|gone|respond|proxy <upstream>|files <dir>|split <weight>:<destination>...
+// [sticky[=<cookie>]]> [path: bool; default=false]
+// [query: bool; default=false] [merge_query: bool; default=false] [add_query=<name>=<value>]... [drop_query=<name>]...
+// [strip: string] [keep-trailing-slash: bool; default=false] [refresh: bool; default=false]
+// [header="<name>: <value>"]... [method=<method>,...] [match_header="<name>[: <value>]"]...
+// [match_query=<name>[=<value>]]... [cookie=<name>[=<value>]]... [ua=<mobile|desktop|bot>,...] [country=<code>,...]
+// [from=<time>] [until=<time>] [scheme=<http|https>] [allow=<cidr>,...] [deny=<cidr>,...]
+// [auth=<user>:<password>|auth=hmac:<secret>] [cors[=<origin>,...]] [priority: int; default=0]
+// [log_only: bool; default=false] [code: int; default=302]
+//
+// The code may also be one of the names permanent (301), temporary (302), permanent-preserve (308), or
+// temporary-preserve (307), the last two of which preserve the request's method and body.
+//
+// A destination of gone makes the route answer with 410 Gone, or another 4xx code, instead of redirecting. A
+// destination of respond answers with 200 OK or another code and a static body set with body=<text> or
+// body=@<file>, and content-type=<type>. A destination of proxy followed by an upstream url proxies requests to the
+// upstream, and takes no options. A destination of files followed by a directory serves the directory's files, and
+// takes only the strip and header options. A destination of split followed by one or more <weight>:<destination>
+// splits requests between the destinations by weight, and sticky keeps sending each client to the same one, with a
+// cookie that sticky=<cookie> names so that routes with cookie=<cookie>=<n> can match the clients sent to the nth one,
+// counting from 0.
+//
+// Of the routes that match a request, the one with the highest priority wins, and the most precise pattern breaks
+// ties. A log-only route only logs where it would have redirected the requests that it wins, which are handled as if
+// nothing matched.
+//
+// Regular expression patterns, which start with ~, are taken literally up to the first whitespace so that their
+// backslashes don't need to be escaped.
 func NewRoute(s string) (*Route, error) {
+	return newRoute(s, nil)
+}
+
+// newRoute parses a route like NewRoute, and sets codeSet, if it isn't nil, to whether the route has a code option
+func newRoute(s string, codeSet *bool) (*Route, error) {
+	s = strings.TrimSpace(s)
+	var pattern []string
+	if isRegexpPattern(s) {
+		i := strings.IndexAny(s, " \t\r\n")
+		if i == -1 {
+			i = len(s)
+		}
+		pattern, s = []string{s[:i]}, s[i:]
+	}
 	parts, err := shellquote.Split(s)
 	if err != nil {
 		return nil, err
 	}
+	parts = append(pattern, parts...)
 	if len(parts) < 2 {
 		return nil, errors.New("route must have at least a source and a destination")
 	}
-	dest := parts[1]
-	u, err := url.Parse(dest)
-	if err != nil {
-		return nil, fmt.Errorf("parsing %q: %v", dest, err)
-	}
-	if u.Scheme == "" {
-		u.Scheme = "https"
-	}
-	if u.Host == "" {
-		u.Host = u.Path
-		u.Path = ""
+	r := &Route{Pattern: parts[0], Code: 302}
+	opts := parts[2:]
+	switch parts[1] {
+	case "gone":
+		r.Response, r.Code = &Response{}, http.StatusGone
+	case "respond":
+		r.Response, r.Code = &Response{}, http.StatusOK
+	case "proxy":
+		if len(parts) != 3 {
+			return nil, errors.New("proxy routes must have exactly one upstream and no options")
+		}
+		if r.Upstream, err = parseDestination(parts[2]); err != nil {
+			return nil, err
+		}
+		r.Code = 0
+		return r, nil
+	case "split":
+		for len(opts) > 0 {
+			split, ok, err := parseSplit(opts[0])
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			r.Split, opts = append(r.Split, split), opts[1:]
+		}
+		if len(r.Split) == 0 {
+			return nil, errors.New("split routes must have at least one <weight>:<destination>")
+		}
+	case "files":
+		if len(parts) < 3 {
+			return nil, errors.New("files routes must have a directory")
+		}
+		r.Files, r.Code = parts[2], 0
+		opts = parts[3:]
+	default:
+		if r.Destination, err = parseDestination(parts[1]); err != nil {
+			return nil, err
+		}
 	}
-
-	r := &Route{Pattern: parts[0], Destination: u, Code: 302}
-	for _, part := range parts[2:] {
+	for _, part := range opts {
 		if part == "path" {
 			r.CarryPath = true
 		} else if part == "query" {
 			r.CarryQuery = true
+		} else if part == "keep-trailing-slash" {
+			r.KeepTrailingSlash = true
+		} else if part == "sticky" {
+			r.Sticky = true
+		} else if strings.HasPrefix(part, "sticky=") {
+			r.Sticky, r.StickyCookie = true, strings.TrimPrefix(part, "sticky=")
+		} else if part == "refresh" {
+			r.Refresh = true
+		} else if part == "log_only" {
+			r.LogOnly = true
+		} else if part == "cors" {
+			r.CORS = &CORS{}
+		} else if strings.HasPrefix(part, "cors=") {
+			r.CORS = &CORS{Origins: strings.Split(strings.TrimPrefix(part, "cors="), ",")}
+		} else if part == "merge_query" {
+			r.MergeQuery = true
+		} else if strings.HasPrefix(part, "add_query=") {
+			param, err := url.ParseQuery(strings.TrimPrefix(part, "add_query="))
+			if err != nil {
+				return nil, fmt.Errorf("parsing add_query: %v", err)
+			}
+			if r.AddQuery == nil {
+				r.AddQuery = make(url.Values)
+			}
+			for k, v := range param {
+				r.AddQuery[k] = append(r.AddQuery[k], v...)
+			}
+		} else if strings.HasPrefix(part, "drop_query=") {
+			r.DropQuery = append(r.DropQuery, strings.TrimPrefix(part, "drop_query="))
+		} else if strings.HasPrefix(part, "allow=") {
+			r.Allow = append(r.Allow, strings.Split(strings.TrimPrefix(part, "allow="), ",")...)
+		} else if strings.HasPrefix(part, "deny=") {
+			r.Deny = append(r.Deny, strings.Split(strings.TrimPrefix(part, "deny="), ",")...)
+		} else if strings.HasPrefix(part, "auth=") {
+			r.Auth = strings.TrimPrefix(part, "auth=")
+		} else if strings.HasPrefix(part, "strip=") {
+			r.StripPrefix = strings.TrimPrefix(part, "strip=")
+		} else if strings.HasPrefix(part, "header=") {
+			name, value, ok := strings.Cut(strings.TrimPrefix(part, "header="), ":")
+			if !ok {
+				return nil, fmt.Errorf("parsing header %q: must be \"Name: value\"", strings.TrimPrefix(part, "header="))
+			}
+			if r.Headers == nil {
+				r.Headers = make(http.Header)
+			}
+			r.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		} else if strings.HasPrefix(part, "body=") || strings.HasPrefix(part, "content-type=") {
+			if r.Response == nil {
+				return nil, fmt.Errorf("%s only applies to routes that respond", part[:strings.IndexByte(part, '=')])
+			}
+			if strings.HasPrefix(part, "content-type=") {
+				r.Response.ContentType = strings.TrimPrefix(part, "content-type=")
+			} else if err := r.Response.readBody(strings.TrimPrefix(part, "body=")); err != nil {
+				return nil, err
+			}
+		} else if strings.HasPrefix(part, "method=") {
+			for _, m := range strings.Split(strings.TrimPrefix(part, "method="), ",") {
+				if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+					r.Methods = append(r.Methods, m)
+				}
+			}
+		} else if strings.HasPrefix(part, "match_header=") {
+			name, value, _ := strings.Cut(strings.TrimPrefix(part, "match_header="), ":")
+			if r.MatchHeaders == nil {
+				r.MatchHeaders = make(http.Header)
+			}
+			r.MatchHeaders.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		} else if strings.HasPrefix(part, "match_query=") {
+			param, err := url.ParseQuery(strings.TrimPrefix(part, "match_query="))
+			if err != nil {
+				return nil, fmt.Errorf("parsing match_query: %v", err)
+			}
+			if r.MatchQuery == nil {
+				r.MatchQuery = make(url.Values)
+			}
+			for k, v := range param {
+				r.MatchQuery[k] = append(r.MatchQuery[k], v...)
+			}
+		} else if strings.HasPrefix(part, "cookie=") {
+			name, value, _ := strings.Cut(strings.TrimPrefix(part, "cookie="), "=")
+			if r.MatchCookies == nil {
+				r.MatchCookies = make(url.Values)
+			}
+			r.MatchCookies.Add(name, value)
+		} else if strings.HasPrefix(part, "ua=") {
+			for _, ua := range strings.Split(strings.TrimPrefix(part, "ua="), ",") {
+				if ua = strings.ToLower(strings.TrimSpace(ua)); ua != "" {
+					r.UserAgents = append(r.UserAgents, ua)
+				}
+			}
+		} else if strings.HasPrefix(part, "country=") {
+			for _, c := range strings.Split(strings.TrimPrefix(part, "country="), ",") {
+				if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+					r.Countries = append(r.Countries, c)
+				}
+			}
+		} else if strings.HasPrefix(part, "from=") || strings.HasPrefix(part, "until=") {
+			name, value, _ := strings.Cut(part, "=")
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %v", name, err)
+			}
+			if name == "from" {
+				r.ActiveFrom = &t
+			} else {
+				r.ActiveUntil = &t
+			}
+		} else if strings.HasPrefix(part, "scheme=") {
+			r.Scheme = strings.ToLower(strings.TrimPrefix(part, "scheme="))
+		} else if strings.HasPrefix(part, "priority=") {
+			priority, err := strconv.Atoi(strings.TrimPrefix(part, "priority="))
+			if err != nil {
+				return nil, fmt.Errorf("parsing priority: %v", err)
+			}
+			r.Priority = priority
 		} else if strings.HasPrefix(part, "code=") {
-			code, err := strconv.Atoi(strings.TrimPrefix(part, "code="))
+			code, err := parseCode(strings.TrimPrefix(part, "code="))
 			if err != nil {
-				return nil, fmt.Errorf("parsing code: %v", err)
+				return nil, err
 			}
 			r.Code = code
+			if codeSet != nil {
+				*codeSet = true
+			}
 		}
 	}
+	if err := r.validateCode(); err != nil {
+		return nil, err
+	}
 
 	return r, nil
 }
 
-// AddRoute configures a new route
+// codeNames are the names that may be used instead of redirect codes in the route syntax
+var codeNames = map[string]int{
+	"permanent":          http.StatusMovedPermanently,
+	"temporary":          http.StatusFound,
+	"permanent-preserve": http.StatusPermanentRedirect,
+	"temporary-preserve": http.StatusTemporaryRedirect,
+}
+
+// parseCode parses a redirect code, either as a number or as one of codeNames
+func parseCode(s string) (int, error) {
+	if code, ok := codeNames[s]; ok {
+		return code, nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("parsing code: %q is not a number or one of permanent, temporary, permanent-preserve, or temporary-preserve", s)
+	}
+	return code, nil
+}
+
+// parseDestination parses a route destination, defaulting to https and treating scheme-less destinations as
+// {hostname}/{path}
+func parseDestination(dest string) (*url.URL, error) {
+	if !strings.Contains(dest, "://") {
+		dest = "https://" + dest
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %v", dest, err)
+	}
+	return u, nil
+}
+
+// AddRoute configures a new route. The route is validated first, and it's rejected if it would make clients redirect
+// in a loop along with the configured routes. Each call rebuilds the table and checks all of its routes for loops, so
+// adding n routes one at a time takes time quadratic in n; configure many routes at once with SetRoutes instead.
 func (r *Redirector) AddRoute(route *Route) error {
-	_, has := r.matcher.Add(route.Pattern, route)
-	if has {
-		return fmt.Errorf("route already exists")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, err := r.table.Load().with(route, r.cacheSize)
+	if err != nil {
+		return err
+	}
+	r.table.Store(t)
+	return nil
+}
+
+// UpdateRoute replaces the configured route that has the same pattern and conditions as route. The route is validated
+// first, and ErrNotFound is returned if no route has its pattern and conditions.
+func (r *Redirector) UpdateRoute(route *Route) error {
+	if err := route.Validate(); err != nil {
+		return err
+	}
+	return r.replaceRoute(route.Pattern, route)
+}
+
+// RemoveRoute removes the configured routes with the given pattern, whatever their conditions. ErrNotFound is returned
+// if no route has it.
+func (r *Redirector) RemoveRoute(pattern string) error {
+	return r.replaceRoute(pattern, nil)
+}
+
+func (r *Redirector) replaceRoute(pattern string, route *Route) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, err := r.table.Load().replacing(pattern, route, r.cacheSize)
+	if err != nil {
+		return err
 	}
+	r.table.Store(t)
 	return nil
 }
 
+// SetRoutes replaces all configured routes with routes. The whole set is validated and checked for redirect loops
+// before any change is made, and the new routes are swapped in atomically so that requests are never matched against a
+// partially updated set.
+func (r *Redirector) SetRoutes(routes []*Route) error {
+	t, err := newTable(append([]*Route(nil), routes...), r.cacheSize)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.table.Store(t)
+	r.mu.Unlock()
+	return nil
+}
+
+// Routes returns the configured routes, in the order they were added
+func (r *Redirector) Routes() []*Route {
+	return append([]*Route(nil), r.table.Load().routes...)
+}
+
 // Handler returns an http request handler
 func (r *Redirector) Handler(w http.ResponseWriter, req *http.Request) {
-	pattern := requestToRoutePattern(req)
-	v, ok := r.matcher.Lookup(pattern)
+	start := time.Now()
+	if r.access != nil && !r.access.allows(req) {
+		forbid(w)
+		return
+	}
+	t := r.table.Load()
+	if isPreflight(req) && r.servePreflight(w, req, t) {
+		return
+	}
+	route, captures, vary := r.matchIn(t, req)
+	if route == nil && r.txt != nil {
+		// the result is cached for other requests, so it shouldn't depend on this one being canceled
+		if ht := r.txt.table(context.Background(), r.txtHost(req), r.log()); ht != nil {
+			var txtVary string
+			if route, captures, txtVary = r.matchIn(ht, req); route != nil {
+				t = ht
+			}
+			vary = joinVary(vary, txtVary)
+		}
+	}
+	if route != nil && route.LogOnly {
+		r.logOnly(route, captures, req)
+		route = nil
+	}
+	if r.chain != nil {
+		r.chain.ServeHTTP(w, withMatch(req, &match{table: t, route: route, captures: captures, vary: vary, start: start}))
+		return
+	}
+	r.serve(w, req, t, route, captures, vary, start)
+}
+
+// serveMatched serves a request with the match found by Handler, at the end of the middleware chain
+func (r *Redirector) serveMatched(w http.ResponseWriter, req *http.Request) {
+	m, ok := req.Context().Value(matchKey{}).(*match)
 	if !ok {
+		// the middleware replaced the request's context, so it has to be matched again
+		t := r.table.Load()
+		route, captures, vary := r.matchIn(t, req)
+		m = &match{table: t, route: route, captures: captures, vary: vary, start: time.Now()}
+	}
+	r.serve(w, req, m.table, m.route, m.captures, m.vary, m.start)
+}
+
+// serve handles a request that matched route in t, or none if route is nil, after trying patterns whose routes depend
+// on the request headers in vary
+func (r *Redirector) serve(w http.ResponseWriter, req *http.Request, t *table, route *Route, captures []string, vary string, start time.Time) {
+	if vary != "" {
+		// the route, or the lack of one, was picked based on these headers
+		w.Header().Add("Vary", vary)
+	}
+	for i := 0; route != nil && i < len(r.onMatch); i++ {
+		if !r.onMatch[i](route, req) {
+			route = nil
+		}
+	}
+	if route == nil {
+		for _, f := range r.onMiss {
+			f(req)
+		}
 		// this request doesn't match any of the configured routes
+		pattern := r.requestPattern(req)
+		r.metrics.Missed(pattern)
 		if r.defaultHandler != nil {
 			r.defaultHandler.ServeHTTP(w, req)
 		} else {
-			log.Printf("request for %q did not match any configured routes", pattern)
+			r.log().Info("request did not match any configured routes", "pattern", pattern)
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		}
 		return
 	}
-	route, ok := v.(*Route)
-	if !ok {
-		// this should never happen
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+	if info := requestInfo(req); info != nil {
+		info.Route = route
+	}
+	if r.logger != nil {
+		r.logger.Debug("request matched a route", "pattern", r.requestPattern(req), "route", route.Pattern, "source", route.Source)
+		req = withLogger(req, r.logger)
+	}
+	if a, ok := t.matcher.access[route]; ok && !a.allows(req) {
+		forbid(w)
+		r.metrics.Matched(route, time.Since(start))
 		return
 	}
+	if route.Auth != "" {
+		if !route.authorizes(req) {
+			unauthorized(w, route)
+			r.metrics.Matched(route, time.Since(start))
+			return
+		}
+		if route.signed() {
+			req = withoutSignature(req)
+		}
+		// a cached redirect would skip the check
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if len(captures) > 0 {
+		req = withCaptures(req, captures)
+	}
+	if r.paths != (PathNormalization{}) {
+		req = withPathNormalization(req, r.paths)
+	}
+	if r.refresh != (RefreshBody{}) {
+		req = withRefreshBody(req, r.refresh)
+	}
+	if r.destinations != nil {
+		req = withDestinationHosts(req, r.destinations)
+	}
+	if r.debugHeaders {
+		w.Header().Set("X-Redirector-Route", route.Pattern)
+		if route.Source != "" {
+			w.Header().Set("X-Redirector-Route-Source", route.Source)
+		}
+	}
+	if c := r.corsFor(route); c != nil {
+		c.allowOrigin(w, req)
+	}
+	if t.matcher.private[route] && r.countryFunc != nil {
+		// the route was picked based on where the request came from, which shared caches can't tell apart
+		w.Header().Set("Cache-Control", "private")
+	}
+	if route.Upstream != nil {
+		r.proxy(route.Upstream).ServeHTTP(w, req)
+	} else if r.preview && route.Response == nil && route.Files == "" && wantsPreview(req) {
+		route.servePreview(w, req)
+	} else {
+		route.Execute(w, req)
+	}
+	r.metrics.Matched(route, time.Since(start))
+}
 
-	route.Execute(w, req)
+// logOnly logs where the log-only route would have redirected req to
+func (r *Redirector) logOnly(route *Route, captures []string, req *http.Request) {
+	if len(captures) > 0 {
+		req = withCaptures(req, captures)
+	}
+	if r.paths != (PathNormalization{}) {
+		req = withPathNormalization(req, r.paths)
+	}
+	pattern := r.requestPattern(req)
+	dest, code, err := route.Resolve(req)
+	if err != nil {
+		r.log().Error("log-only route would have failed to redirect", "route", route.Pattern, "pattern", pattern, "error", err)
+		return
+	}
+	r.log().Info("log-only route would have redirected", "route", route.Pattern, "pattern", pattern, "destination", dest.String(), "code", code)
 }
 
-// Execute executes a route according to its redirect rules
-func (r *Route) Execute(w http.ResponseWriter, req *http.Request) {
-	dest := r.Destination
+// Match returns the route that matches req, if any
+func (r *Redirector) Match(req *http.Request) (*Route, bool) {
+	route, _ := r.match(req)
+	return route, route != nil
+}
+
+func (r *Redirector) match(req *http.Request) (*Route, []string) {
+	route, captures, _ := r.matchIn(r.table.Load(), req)
+	return route, captures
+}
+
+// matchIn returns the route in t that matches req, along with what its wildcards captured and the Vary header of the
+// patterns that were tried
+func (r *Redirector) matchIn(t *table, req *http.Request) (*Route, []string, string) {
+	host, path := req.Host, req.URL.Path
+	if r.patternFunc != nil {
+		// patterns are {hostname}/{path}
+		host, path = r.patternFunc(req), ""
+		if i := strings.IndexByte(host, '/'); i != -1 {
+			host, path = host[:i], host[i:]
+		}
+	}
+	if t.matcher.countries && r.countryFunc != nil {
+		req = withCountry(req, r.countryFunc(req))
+	}
+	if t.matcher.schemes && r.schemeFunc != nil {
+		req = withScheme(req, r.schemeFunc(req))
+	}
+	if r.paths.CollapseSlashes {
+		path = collapseSlashes(path)
+	}
+	host = normalizeHost(host)
+	var portVary string
+	if i, ok := portIndex(host); ok {
+		if t.matcher.ports {
+			route, captures, vary := t.lookup(req, host, path)
+			if route != nil {
+				return route, captures, vary
+			}
+			portVary = vary
+		}
+		host = host[:i]
+	}
+	route, captures, vary := t.lookup(req, host, path)
+	return route, captures, joinVary(portVary, vary)
+}
+
+// Resolve computes the destination and status code that the route would redirect req to, without modifying the
+// route. It implements Resolver. Routes with a Response, an Upstream, or Files don't redirect, so it returns an error
+// for them.
+func (r *Route) Resolve(req *http.Request) (*url.URL, int, error) {
+	split, _ := r.pickSplit(req)
+	return r.resolve(req, split)
+}
+
+// resolve resolves the destination of req, using the split destination split for routes with Split
+func (r *Route) resolve(req *http.Request, split int) (*url.URL, int, error) {
+	if r.Response != nil || r.Upstream != nil || r.Files != "" {
+		return nil, 0, errors.New("route responds without redirecting")
+	}
+	base, code := r.Destination, r.Code
+	if len(r.Split) > 0 {
+		base = r.Split[split].Destination
+	}
+	if r.Resolver != nil {
+		u, c, err := r.Resolver.Resolve(req)
+		if err != nil {
+			return nil, 0, err
+		}
+		base = u
+		if c != 0 {
+			code = c
+		}
+	}
+
+	dest := *base
+	if r.Resolver == nil {
+		if p := r.expand(dest.Path, req, true); p != dest.Path {
+			setEscapedPath(&dest, p)
+		}
+		dest.RawQuery = r.expand(dest.RawQuery, req, false)
+	}
 	if r.CarryPath {
-		dest.Path = path.Join(dest.Path, req.URL.Path)
+		// join the escaped paths so that the request's escaping, such as an escaped slash, is kept
+		prefix := (&url.URL{Path: r.StripPrefix}).EscapedPath()
+		setEscapedPath(&dest, path.Join(dest.EscapedPath(), stripPrefix(req.URL.EscapedPath(), prefix)))
 	}
+	r.normalizePath(&dest, req)
 	if r.CarryQuery {
 		dest.RawQuery = req.URL.RawQuery
 	}
+	if r.MergeQuery || len(r.AddQuery) > 0 || len(r.DropQuery) > 0 {
+		q := dest.Query()
+		if r.MergeQuery {
+			for k, v := range req.URL.Query() {
+				q[k] = v
+			}
+		}
+		for k, v := range r.AddQuery {
+			q[k] = v
+		}
+		for _, k := range r.DropQuery {
+			q.Del(k)
+		}
+		dest.RawQuery = q.Encode()
+	}
 
-	http.Redirect(w, req, dest.String(), r.Code)
+	return &dest, code, nil
 }
 
-func requestToRoutePattern(r *http.Request) string {
-	return r.Host + "/" + strings.Trim(r.URL.Path, "/")
+// setEscapedPath sets the path of u from its escaped form, keeping that escaping when u is turned back into a string
+func setEscapedPath(u *url.URL, escaped string) {
+	p, err := url.PathUnescape(escaped)
+	if err != nil {
+		u.Path, u.RawPath = escaped, ""
+		return
+	}
+	u.Path, u.RawPath = p, escaped
+}
+
+// stripPrefix removes prefix from the start of p if p starts with it, matching whole segments
+func stripPrefix(p, prefix string) string {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" || !strings.HasPrefix(p, prefix) {
+		return p
+	}
+	if rest := p[len(prefix):]; rest == "" || rest[0] == '/' {
+		return "/" + strings.TrimPrefix(rest, "/")
+	}
+	return p
+}
+
+// placeholders are the placeholders that can be used in the path and query of a destination, in their escaped form
+var placeholders = map[string]string{
+	"{host}":  "%7Bhost%7D",
+	"{path}":  "%7Bpath%7D",
+	"{query}": "%7Bquery%7D",
+	"{*}":     "%7B%2A%7D",
+	"{1}":     "%7B1%7D",
+	"{2}":     "%7B2%7D",
+	"{3}":     "%7B3%7D",
+	"{4}":     "%7B4%7D",
+	"{5}":     "%7B5%7D",
+	"{6}":     "%7B6%7D",
+	"{7}":     "%7B7%7D",
+	"{8}":     "%7B8%7D",
+	"{9}":     "%7B9%7D",
+}
+
+// expand fills in s, the path or query of the route's destination, for req: {host}, {path}, and {query} with the
+// request's hostname, path, and raw query, {*} with what the route's last wildcard captured, {1} through {9} with what
+// each of its wildcards or capture groups captured, {name} with what the wildcard {name} captured, and, for regular
+// expression patterns, $1 through $9 with the expression's capture groups and $$ with $. References to groups that
+// don't exist or didn't match are replaced with nothing. Text that was filled in isn't expanded again. If inPath is
+// set, s is a decoded path and the result is escaped, with {path} filled in with the request's path as it was
+// escaped, so that escaped slashes and other characters in it are kept; s is returned as it is if it has nothing to
+// fill in. Otherwise s is a raw query, and what's filled in is query-escaped, except for {query}, so that it can't add
+// parameters or end the query.
+func (r *Route) expand(s string, req *http.Request, inPath bool) string {
+	regexp := isRegexpPattern(r.Pattern)
+	if !strings.Contains(s, "{") && !(regexp && strings.Contains(s, "$")) {
+		return s
+	}
+
+	captures := Captures(req)
+	var b strings.Builder
+	// write writes text from s into the result, escaping it if s is a path
+	write := func(text string) {
+		if inPath {
+			text = (&url.URL{Path: text}).EscapedPath()
+		}
+		b.WriteString(text)
+	}
+	// fill writes what a placeholder is filled in with into the result, escaped for the path or the query
+	fill := func(value string) {
+		if inPath {
+			value = (&url.URL{Path: value}).EscapedPath()
+		} else {
+			value = url.QueryEscape(value)
+		}
+		b.WriteString(value)
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				break
+			}
+			switch s[i : i+end+1] {
+			case "{host}":
+				host := req.Host
+				if h, _, err := net.SplitHostPort(host); err == nil {
+					host = h
+				}
+				fill(host)
+			case "{path}":
+				if inPath {
+					b.WriteString(req.URL.EscapedPath())
+				} else {
+					fill(req.URL.Path)
+				}
+			case "{query}":
+				if inPath {
+					write(req.URL.RawQuery)
+				} else {
+					b.WriteString(req.URL.RawQuery)
+				}
+			case "{*}":
+				if len(captures) > 0 {
+					fill(captures[len(captures)-1])
+				}
+			case "{1}", "{2}", "{3}", "{4}", "{5}", "{6}", "{7}", "{8}", "{9}":
+				if k := int(s[i+1] - '0'); k <= len(captures) {
+					fill(captures[k-1])
+				}
+			default:
+				k := r.wildcardIndex(s[i+1 : i+end])
+				if k == -1 {
+					write("{")
+					continue
+				}
+				if k < len(captures) {
+					fill(captures[k])
+				}
+			}
+			i += end
+			continue
+		case c == '$' && regexp && i < len(s)-1:
+			switch n := s[i+1]; {
+			case n == '$':
+				write("$")
+				i++
+				continue
+			case n >= '1' && n <= '9':
+				if k := int(n - '0'); k <= len(captures) {
+					fill(captures[k-1])
+				}
+				i++
+				continue
+			}
+		}
+		// write the text up to the next placeholder at once
+		j := i + 1
+		for j < len(s) && s[j] != '{' && s[j] != '$' {
+			j++
+		}
+		write(s[i:j])
+		i = j - 1
+	}
+	return b.String()
+}
+
+// wildcardIndex returns the index in the route's captures of its wildcard called name, or -1 if it has none
+func (r *Route) wildcardIndex(name string) int {
+	if isRegexpPattern(r.Pattern) || !validWildcardName(name) {
+		return -1
+	}
+	// every host of a route has the same wildcards
+	p, err := parsePattern(r.Patterns()[0])
+	if err != nil {
+		return -1
+	}
+	for i, n := range p.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Execute executes a route according to its redirect rules, or writes its Response if it has one. Routes with an
+// Upstream are proxied without the error reporting of a Redirector.
+func (r *Route) Execute(w http.ResponseWriter, req *http.Request) {
+	if r.Upstream != nil {
+		httputil.NewSingleHostReverseProxy(r.Upstream).ServeHTTP(w, req)
+		return
+	}
+	if r.Files != "" {
+		r.serveFiles(w, req)
+		return
+	}
+	if r.Response != nil {
+		r.respond(w)
+		return
+	}
+	split, fresh := r.pickSplit(req)
+	dest, code, err := r.resolve(req, split)
+	if err != nil {
+		requestLogger(req).Error("resolving the destination", "route", r.Pattern, "pattern", RequestPattern(req), "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !r.allowsDestination(w, req, dest) {
+		return
+	}
+	r.setHeaders(w)
+	if fresh && r.Sticky {
+		http.SetCookie(w, r.splitCookie(split))
+	}
+	r.redirect(w, req, dest, code)
+}
+
+// setHeaders sets the route's Headers on w, replacing what's set for them already except for Vary, which they're added
+// to so that the headers that the route was picked based on are kept
+func (r *Route) setHeaders(w http.ResponseWriter) {
+	for name, values := range r.Headers {
+		name = http.CanonicalHeaderKey(name)
+		if name == "Vary" {
+			for _, v := range values {
+				w.Header().Add(name, v)
+			}
+			continue
+		}
+		// the values are copied so that changes to the response's headers can't change the route
+		w.Header()[name] = append([]string(nil), values...)
+	}
+}
+
+// serveFiles serves the file from the route's directory that the request's path points to
+func (r *Route) serveFiles(w http.ResponseWriter, req *http.Request) {
+	r.setHeaders(w)
+	if r.StripPrefix != "" {
+		u := *req.URL
+		u.Path, u.RawPath = stripPrefix(u.Path, r.StripPrefix), ""
+		req = req.Clone(req.Context())
+		req.URL = &u
+	}
+	http.FileServer(http.Dir(r.Files)).ServeHTTP(w, req)
+}
+
+// respond writes the route's fixed response
+func (r *Route) respond(w http.ResponseWriter) {
+	body := r.Response.Body
+	if body == "" {
+		body = http.StatusText(r.Code) + "\n"
+	}
+	r.setHeaders(w)
+	if r.Response.ContentType != "" {
+		w.Header().Set("Content-Type", r.Response.ContentType)
+	} else if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(r.Code)
+	io.WriteString(w, body)
+}
+
+// RequestPattern returns the pattern that requests are matched against by default: the request's host followed by its
+// path, without leading or trailing slashes
+func RequestPattern(req *http.Request) string {
+	return req.Host + "/" + strings.Trim(req.URL.Path, "/")
+}
+
+func (r *Redirector) requestPattern(req *http.Request) string {
+	if r.patternFunc != nil {
+		return r.patternFunc(req)
+	}
+	return RequestPattern(req)
 }
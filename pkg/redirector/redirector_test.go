@@ -0,0 +1,232 @@
+package redirector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestRedirector returns a Redirector with routes, which are given in the -route syntax
+func newTestRedirector(t testing.TB, routes ...string) *Redirector {
+	t.Helper()
+	var rs []*Route
+	for _, s := range routes {
+		r, err := NewRoute(s)
+		if err != nil {
+			t.Fatalf("parsing route %q: %v", s, err)
+		}
+		rs = append(rs, r)
+	}
+	re := New(nil)
+	if err := re.SetRoutes(rs); err != nil {
+		t.Fatalf("setting routes: %v", err)
+	}
+	return re
+}
+
+// location returns the Location header that re answers a GET request for target with. It's safe to call from other
+// goroutines than the test's.
+func location(t testing.TB, re *Redirector, target string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	re.Handler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code < 300 || w.Code > 399 {
+		t.Errorf("GET %s: got status %d, want a redirect", target, w.Code)
+	}
+	return w.Header().Get("Location")
+}
+
+func TestEscapedPaths(t *testing.T) {
+	re := newTestRedirector(t,
+		"carry.example.com/* dest.example.com/base path",
+		"placeholder.example.com/* dest.example.com/base{path}",
+	)
+	tests := []struct {
+		name, path, want string
+	}{
+		{"escaped slash", "/a%2Fb", "/base/a%2Fb"},
+		{"space", "/hello%20world", "/base/hello%20world"},
+		{"plus", "/a+b", "/base/a+b"},
+		{"escaped plus", "/a%2Bb", "/base/a%2Bb"},
+		{"unicode", "/caf%C3%A9", "/base/caf%C3%A9"},
+		{"escaped segments", "/docs/v1%2F2/read%20me", "/base/docs/v1%2F2/read%20me"},
+	}
+	for _, tt := range tests {
+		for _, host := range []string{"carry.example.com", "placeholder.example.com"} {
+			t.Run(tt.name+" "+host, func(t *testing.T) {
+				got := location(t, re, "http://"+host+tt.path)
+				if want := "https://dest.example.com" + tt.want; got != want {
+					t.Errorf("got Location %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestPlaceholdersEscapeCaptures(t *testing.T) {
+	re := newTestRedirector(t, "example.com/files/* dest.example.com/get/{*}/download")
+	got := location(t, re, "http://example.com/files/my%20file+v2")
+	if want := "https://dest.example.com/get/my%20file+v2/download"; got != want {
+		t.Errorf("got Location %q, want %q", got, want)
+	}
+}
+
+// TestConcurrentRequests runs a route from many goroutines at once, which go test -race checks for writes to the
+// route's shared Destination
+func TestConcurrentRequests(t *testing.T) {
+	re := newTestRedirector(t, "example.com/* dest.example.com/base path query add_query=ref=old")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				path := fmt.Sprintf("/page/%d/%d", i, j)
+				got := location(t, re, "http://example.com"+path+"?n="+strconv.Itoa(j))
+				want := fmt.Sprintf("https://dest.example.com/base%s?n=%d&ref=old", path, j)
+				if got != want {
+					t.Errorf("got Location %q, want %q", got, want)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPlaceholdersEscapeQueries(t *testing.T) {
+	re := newTestRedirector(t,
+		"path.example.com/* dest.example.com/x?from={path}",
+		"host.example.com/* dest.example.com/x?host={host}",
+		"named.example.com/n/{name} dest.example.com/x?n={name}",
+		"last.example.com/* dest.example.com/x?n={*}&keep=1",
+		"numbered.example.com/*/* dest.example.com/x?a={1}&b={2}",
+		"query.example.com/* dest.example.com/x?{query}",
+	)
+	tests := []struct {
+		name, target, want string
+	}{
+		{"ampersand in path", "http://path.example.com/foo&admin=1", "?from=%2Ffoo%26admin%3D1"},
+		{"hash in path", "http://path.example.com/a%23b", "?from=%2Fa%23b"},
+		{"plus in path", "http://path.example.com/a+b", "?from=%2Fa%2Bb"},
+		{"percent in path", "http://path.example.com/100%25", "?from=%2F100%25"},
+		{"space in path", "http://path.example.com/a%20b", "?from=%2Fa+b"},
+		{"ampersand in named capture", "http://named.example.com/n/x%26y=1", "?n=x%26y%3D1"},
+		{"hash in last capture", "http://last.example.com/a%23b", "?n=a%23b&keep=1"},
+		{"plus and percent in captures", "http://numbered.example.com/a+b/50%25", "?a=a%2Bb&b=50%25"},
+		{"host", "http://host.example.com:8080/", "?host=host.example.com"},
+		{"query is kept", "http://query.example.com/?a=1&b=%26", "?a=1&b=%26"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := location(t, re, tt.target)
+			if want := "https://dest.example.com/x" + tt.want; got != want {
+				t.Errorf("got Location %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// serveGet returns the response that re answers a GET request for target with
+func serveGet(re *Redirector, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	re.Handler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestHeadersKeepVary(t *testing.T) {
+	re := newTestRedirector(t,
+		"example.com/x dest.example.com/a match_header='X-Env: prod'",
+		"example.com/x dest.example.com/b header='Vary: Accept' header='X-Extra: 1'",
+	)
+	w := serveGet(re, "http://example.com/x")
+	if got, want := strings.Join(w.Header().Values("Vary"), ", "), "X-Env, Accept"; got != want {
+		t.Errorf("got Vary %q, want %q", got, want)
+	}
+
+	// changing the response's headers mustn't change the route's
+	w.Header()["X-Extra"][0] = "changed"
+	if got := serveGet(re, "http://example.com/x").Header().Get("X-Extra"); got != "1" {
+		t.Errorf("got X-Extra %q after changing an earlier response, want 1", got)
+	}
+}
+
+func TestVaryOnTriedPatterns(t *testing.T) {
+	re := newTestRedirector(t,
+		"example.com/x dest.example.com/a match_header='X-Env: prod'",
+		"example.com/* dest.example.com/b",
+		"example.com/ua/* dest.example.com/mobile ua=mobile",
+		"only.example.com/x dest.example.com/c match_header=X-Beta",
+		"other.example.com/* dest.example.com/d",
+	)
+	tests := []struct {
+		name, target, header, want string
+		code                       int
+	}{
+		{"conditional route", "http://example.com/x", "prod", "X-Env", http.StatusFound},
+		{"falling through", "http://example.com/x", "", "X-Env", http.StatusFound},
+		{"falling through from a user agent", "http://example.com/ua/page", "", "User-Agent", http.StatusFound},
+		{"another path", "http://example.com/y", "", "", http.StatusFound},
+		{"no match", "http://only.example.com/x", "", "X-Beta", http.StatusNotFound},
+		{"no conditions", "http://other.example.com/x", "", "", http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Env", tt.header)
+			}
+			w := httptest.NewRecorder()
+			re.Handler(w, req)
+			if w.Code != tt.code {
+				t.Errorf("got status %d, want %d", w.Code, tt.code)
+			}
+			if got := strings.Join(w.Header().Values("Vary"), ", "); got != tt.want {
+				t.Errorf("got Vary %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrailingDotHosts(t *testing.T) {
+	re := newTestRedirector(t,
+		"example.com/* dest.example.com/plain path",
+		"ported.example.com:8080/* dest.example.com/ported path",
+	)
+	tests := []struct {
+		host, want string
+	}{
+		{"example.com.", "/plain/x"},
+		{"example.com.:8080", "/plain/x"},
+		{"EXAMPLE.com.:8080", "/plain/x"},
+		{"ported.example.com.:8080", "/ported/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/x", nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			re.Handler(w, req)
+			if want := "https://dest.example.com" + tt.want; w.Header().Get("Location") != want {
+				t.Errorf("got status %d and Location %q, want %q", w.Code, w.Header().Get("Location"), want)
+			}
+		})
+	}
+	for host, want := range map[string]string{
+		"example.com":     "example.com",
+		"example.com.":    "example.com",
+		"example.com.:80": "example.com:80",
+		"EXAMPLE.COM.:80": "example.com:80",
+		"[::1]:8080":      "[::1]:8080",
+		"::1":             "::1",
+		"bücher.example.": "xn--bcher-kva.example",
+	} {
+		if got := normalizeHost(host); got != want {
+			t.Errorf("normalizeHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
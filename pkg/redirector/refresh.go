@@ -0,0 +1,80 @@
+package redirector
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+// RefreshBody is how a Redirector adds HTML bodies with a meta refresh to redirect responses, for clients and
+// link-preview bots that don't follow Location headers
+type RefreshBody struct {
+	// All adds the body to every redirect, not only to those of routes with Refresh
+	All bool
+	// Template renders the body from a RefreshData. It defaults to a page with a meta refresh and a link to the
+	// destination.
+	Template *template.Template
+}
+
+// RefreshData is what RefreshBody templates can use
+type RefreshData struct {
+	// Destination is the url that the request is redirected to
+	Destination string
+	// Code is the redirect's status code, and Status its text
+	Code   int
+	Status string
+}
+
+var defaultRefreshPage = template.Must(template.New("refresh").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url={{.Destination}}">
+<title>{{.Status}}</title>
+</head>
+<body>
+<p>redirecting to <a href="{{.Destination}}">{{.Destination}}</a>…</p>
+</body>
+</html>
+`))
+
+// WithRefreshBody adds HTML bodies with a meta refresh to the redirects of routes with Refresh, or to every redirect
+// with All
+func WithRefreshBody(rb RefreshBody) Option {
+	return func(r *Redirector) {
+		r.refresh = rb
+	}
+}
+
+type refreshKey struct{}
+
+func withRefreshBody(req *http.Request, rb RefreshBody) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), refreshKey{}, rb))
+}
+
+// redirect redirects req to dest with code, with a refresh body if the route or the Redirector handling req asks for
+// one. Like http.Redirect, only the responses to GET and HEAD requests have a body.
+func (r *Route) redirect(w http.ResponseWriter, req *http.Request, dest *url.URL, code int) {
+	rb, _ := req.Context().Value(refreshKey{}).(RefreshBody)
+	if !(r.Refresh || rb.All) || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		http.Redirect(w, req, dest.String(), code)
+		return
+	}
+
+	tmpl := rb.Template
+	if tmpl == nil {
+		tmpl = defaultRefreshPage
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, RefreshData{Destination: dest.String(), Code: code, Status: http.StatusText(code)}); err != nil {
+		requestLogger(req).Error("rendering the refresh body", "route", r.Pattern, "error", err)
+		http.Redirect(w, req, dest.String(), code)
+		return
+	}
+	w.Header().Set("Location", dest.String())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	buf.WriteTo(w)
+}
@@ -0,0 +1,69 @@
+package redirector
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestInfo describes how a Redirector handled a request, for middleware such as access logs that run around it
+type RequestInfo struct {
+	// Route is the route that matched the request, or nil if none did
+	Route *Route
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of req with an empty RequestInfo attached, which the Redirector fills in once it has
+// handled the request
+func WithRequestInfo(req *http.Request) (*http.Request, *RequestInfo) {
+	info := &RequestInfo{}
+	return req.WithContext(context.WithValue(req.Context(), requestInfoKey{}, info)), info
+}
+
+func requestInfo(req *http.Request) *RequestInfo {
+	info, _ := req.Context().Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+type capturesKey struct{}
+
+// Captures returns what the route that matched req captured: the labels and segments matched by its wildcards, or the
+// groups of its regular expression. Resolvers can use them to compute destinations.
+func Captures(req *http.Request) []string {
+	captures, _ := req.Context().Value(capturesKey{}).([]string)
+	return captures
+}
+
+func withCaptures(req *http.Request, captures []string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), capturesKey{}, captures))
+}
+
+type countryKey struct{}
+
+// Country returns the country of req as found by the function set with WithCountry, if routes needed it to be matched
+func Country(req *http.Request) string {
+	country, _ := req.Context().Value(countryKey{}).(string)
+	return country
+}
+
+func withCountry(req *http.Request, country string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), countryKey{}, country))
+}
+
+type schemeKey struct{}
+
+// Scheme returns the scheme that req was made with, http or https, as found by the function set with WithScheme if
+// routes needed it to be matched, or from the request's connection otherwise
+func Scheme(req *http.Request) string {
+	if scheme, ok := req.Context().Value(schemeKey{}).(string); ok {
+		return scheme
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func withScheme(req *http.Request, scheme string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), schemeKey{}, scheme))
+}
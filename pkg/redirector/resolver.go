@@ -0,0 +1,23 @@
+package redirector
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Resolver decides where a request is redirected to. It returns the destination and the status code to redirect with;
+// a zero code means the route's code. A Resolver can be set on a Route to pick the destination per request, e.g. from
+// a database or a feature flag, while reusing the route's matching and path/query carrying.
+type Resolver interface {
+	Resolve(req *http.Request) (*url.URL, int, error)
+}
+
+// ResolverFunc adapts a function to the Resolver interface
+type ResolverFunc func(req *http.Request) (*url.URL, int, error)
+
+// Resolve implements Resolver
+func (f ResolverFunc) Resolve(req *http.Request) (*url.URL, int, error) {
+	return f(req)
+}
+
+var _ Resolver = new(Route)
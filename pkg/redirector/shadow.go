@@ -0,0 +1,122 @@
+package redirector
+
+import (
+	"fmt"
+)
+
+// Shadow is a route that never matches because another route takes precedence for every request that it would match
+type Shadow struct {
+	Route *Route
+	By    *Route
+}
+
+// String returns a description of the shadowed route
+func (s Shadow) String() string {
+	return fmt.Sprintf("%q is shadowed by %q, which has a higher priority and matches everything it does", s.Route.Pattern, s.By.Pattern)
+}
+
+// FindShadowed finds routes that never match because a route without conditions and with a higher priority matches
+// every request they would. Routes with a regular expression pattern are neither checked nor considered to shadow
+// others, and invalid routes are ignored.
+func FindShadowed(routes []*Route) []Shadow {
+	// only routes with a higher priority than the lowest one can shadow others, and most tables have few of them, so
+	// they're collected first to avoid comparing every pair of routes
+	lowest := 0
+	for i, r := range routes {
+		if i == 0 || r.Priority < lowest {
+			lowest = r.Priority
+		}
+	}
+	var candidates []*Route
+	for _, r := range routes {
+		if r.Priority > lowest && !r.conditional() && !r.LogOnly {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	patterns := make(map[*Route][]*pattern, len(routes))
+	for _, r := range routes {
+		if r.Validate() != nil || isRegexpPattern(r.Pattern) {
+			continue
+		}
+		for _, s := range r.Patterns() {
+			p, _ := parsePattern(s)
+			patterns[r] = append(patterns[r], p)
+		}
+	}
+
+	var shadows []Shadow
+	for _, r := range routes {
+		ps, ok := patterns[r]
+		if !ok {
+			continue
+		}
+		for _, by := range candidates {
+			if by.Priority <= r.Priority || by.Pattern == r.Pattern {
+				continue
+			}
+			if qs, ok := patterns[by]; ok && coversAll(qs, ps) {
+				shadows = append(shadows, Shadow{Route: r, By: by})
+				break
+			}
+		}
+	}
+	return shadows
+}
+
+// coversAll reports whether each of ps is covered by one of qs
+func coversAll(qs, ps []*pattern) bool {
+	for _, p := range ps {
+		covered := false
+		for _, q := range qs {
+			if q.covers(p) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// covers reports whether every host and path that o matches is matched by p too. Patterns ending with ** never cover
+// others, since they only match when nothing else does.
+func (p *pattern) covers(o *pattern) bool {
+	if p.fallback {
+		return false
+	}
+	if p.hostWildcard {
+		if len(o.labels) < len(p.labels) || (len(o.labels) == len(p.labels) && !o.hostWildcard) {
+			return false
+		}
+	} else if o.hostWildcard || len(o.labels) != len(p.labels) {
+		return false
+	}
+	if !coversPrefix(p.labels, o.labels) {
+		return false
+	}
+
+	if p.pathWildcard {
+		return coversPrefix(p.segments, o.segments)
+	}
+	return !o.pathWildcard && len(o.segments) == len(p.segments) && coversPrefix(p.segments, o.segments)
+}
+
+// coversPrefix reports whether the labels or segments of prefix match the start of those of s, where a * in prefix
+// matches anything, including a * in s
+func coversPrefix(prefix, s []string) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != "*" && s[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
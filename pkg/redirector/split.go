@@ -0,0 +1,90 @@
+package redirector
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SplitDestination is one of the destinations of a route that splits requests between several of them
+type SplitDestination struct {
+	// Weight is the share of requests sent to Destination, relative to the weights of the route's other destinations
+	Weight      int
+	Destination *url.URL
+}
+
+// stickyMaxAge is how long sticky split routes keep sending a client to the same destination
+const stickyMaxAge = 30 * 24 * time.Hour
+
+// parseSplit parses a split destination in the route syntax: <weight>:<destination>. ok is false if s isn't one.
+func parseSplit(s string) (SplitDestination, bool, error) {
+	weight, dest, found := strings.Cut(s, ":")
+	if !found || weight == "" || strings.Trim(weight, "0123456789") != "" {
+		return SplitDestination{}, false, nil
+	}
+	w, err := strconv.Atoi(weight)
+	if err != nil {
+		return SplitDestination{}, false, fmt.Errorf("parsing weight %q: %v", weight, err)
+	}
+	u, err := parseDestination(dest)
+	if err != nil {
+		return SplitDestination{}, false, err
+	}
+	return SplitDestination{Weight: w, Destination: u}, true, nil
+}
+
+// pickSplit picks which of the route's split destinations req goes to. Sticky routes send clients back to the
+// destination in their cookie, if they have one, and fresh is whether the pick is new and should be stored in one.
+func (r *Route) pickSplit(req *http.Request) (i int, fresh bool) {
+	if len(r.Split) == 0 {
+		return 0, false
+	}
+	if r.Sticky {
+		if c, err := req.Cookie(r.splitCookieName()); err == nil {
+			if i, err := strconv.Atoi(c.Value); err == nil && i >= 0 && i < len(r.Split) {
+				return i, false
+			}
+		}
+	}
+
+	total := 0
+	for _, s := range r.Split {
+		total += s.Weight
+	}
+	n := rand.Intn(total)
+	for i, s := range r.Split {
+		if n < s.Weight {
+			return i, true
+		}
+		n -= s.Weight
+	}
+	return len(r.Split) - 1, true
+}
+
+// splitCookie returns the cookie that keeps a client on the split destination i of a sticky route
+func (r *Route) splitCookie(i int) *http.Cookie {
+	return &http.Cookie{
+		Name:     r.splitCookieName(),
+		Value:    strconv.Itoa(i),
+		Path:     "/",
+		MaxAge:   int(stickyMaxAge / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// splitCookieName is derived from the route's pattern so that sticky routes don't share cookies, unless the route
+// names its own
+func (r *Route) splitCookieName() string {
+	if r.StickyCookie != "" {
+		return r.StickyCookie
+	}
+	h := fnv.New32a()
+	h.Write([]byte(r.Pattern))
+	return fmt.Sprintf("redirector_split_%08x", h.Sum32())
+}
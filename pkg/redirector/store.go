@@ -0,0 +1,189 @@
+package redirector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// RouteSource is somewhere that routes are read from, such as a file, a database, or a key-value store shared by a
+// fleet of redirectors. Implement it to add a backend; see Sync for keeping a Redirector up to date with sources.
+type RouteSource interface {
+	// List returns all of the routes in the source
+	List(ctx context.Context) ([]*Route, error)
+	// Watch returns a channel that receives a value whenever the routes in the source change. The channel is closed
+	// once ctx is done. Sources whose routes never change may return a channel that never receives anything.
+	Watch(ctx context.Context) (<-chan struct{}, error)
+}
+
+// RouteStore is a RouteSource whose routes can be changed, such as through the admin api. Stores that can't be
+// modified return ErrReadOnly from Put and Delete.
+type RouteStore interface {
+	RouteSource
+	// Put adds route to the store, replacing any route with the same pattern and conditions
+	Put(ctx context.Context, route *Route) error
+	// Delete removes the routes with the given pattern from the store, whatever their conditions
+	Delete(ctx context.Context, pattern string) error
+}
+
+var (
+	// ErrReadOnly is returned when modifying a store that doesn't support it
+	ErrReadOnly = errors.New("route store is read-only")
+	// ErrNotFound is returned when deleting a route that doesn't exist
+	ErrNotFound = errors.New("route not found")
+)
+
+// Load replaces r's routes with the routes from all of the sources
+func Load(ctx context.Context, r *Redirector, sources ...RouteSource) error {
+	var routes []*Route
+	for _, source := range sources {
+		rs, err := source.List(ctx)
+		if err != nil {
+			return fmt.Errorf("listing routes: %v", err)
+		}
+		routes = append(routes, rs...)
+	}
+	return r.SetRoutes(routes)
+}
+
+// Sync loads the routes from all of the sources into r, then keeps r up to date in the background as the sources
+// change until ctx is done. Errors that occur after the initial load are logged, and r keeps its previous routes.
+func Sync(ctx context.Context, r *Redirector, sources ...RouteSource) error {
+	if err := Load(ctx, r, sources...); err != nil {
+		return err
+	}
+
+	changed := make(chan struct{}, 1)
+	for _, source := range sources {
+		ch, err := source.Watch(ctx)
+		if err != nil {
+			return fmt.Errorf("watching routes: %v", err)
+		}
+		go func() {
+			for range ch {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				if err := Load(ctx, r, sources...); err != nil {
+					r.log().Error("reloading routes", "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Broadcaster notifies watchers of changes. RouteSource implementations can embed it to implement Watch.
+type Broadcaster struct {
+	mu       sync.Mutex
+	watchers map[chan struct{}]struct{}
+}
+
+// Watch implements RouteSource.Watch
+func (b *Broadcaster) Watch(ctx context.Context) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	if b.watchers == nil {
+		b.watchers = make(map[chan struct{}]struct{})
+	}
+	b.watchers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.watchers, ch)
+		close(ch)
+		b.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+// Notify notifies all watchers. Notifications are coalesced for watchers that haven't received the previous one yet.
+func (b *Broadcaster) Notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// MemoryStore is a RouteStore that keeps its routes in memory
+type MemoryStore struct {
+	Broadcaster
+	mu     sync.Mutex
+	routes []*Route
+}
+
+var _ RouteStore = new(MemoryStore)
+
+// NewMemoryStore creates a MemoryStore containing routes
+func NewMemoryStore(routes ...*Route) *MemoryStore {
+	return &MemoryStore{routes: routes}
+}
+
+// List implements RouteStore.List
+func (s *MemoryStore) List(ctx context.Context) ([]*Route, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Route(nil), s.routes...), nil
+}
+
+// Put implements RouteStore.Put
+func (s *MemoryStore) Put(ctx context.Context, route *Route) error {
+	if err := route.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	replaced := false
+	for i, existing := range s.routes {
+		if existing.Pattern == route.Pattern && existing.Conditions() == route.Conditions() {
+			s.routes[i] = route
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		s.routes = append(s.routes, route)
+	}
+	s.mu.Unlock()
+
+	s.Notify()
+	return nil
+}
+
+// Delete implements RouteStore.Delete
+func (s *MemoryStore) Delete(ctx context.Context, pattern string) error {
+	s.mu.Lock()
+	kept := make([]*Route, 0, len(s.routes))
+	for _, existing := range s.routes {
+		if existing.Pattern != pattern {
+			kept = append(kept, existing)
+		}
+	}
+	deleted := len(kept) < len(s.routes)
+	s.routes = kept
+	s.mu.Unlock()
+
+	if !deleted {
+		return ErrNotFound
+	}
+	s.Notify()
+	return nil
+}
@@ -0,0 +1,110 @@
+package redirector
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// table is a snapshot of the configured routes. Tables are never modified once they are in use; changing the routes
+// builds a new table that replaces the old one, so that requests can be matched without taking any locks.
+type table struct {
+	routes  []*Route
+	matcher *matcher
+	cache   *cache
+}
+
+// newTable builds a table from routes after validating all of them and making sure that they don't redirect in a loop
+func newTable(routes []*Route, cacheSize int) (*table, error) {
+	t := &table{
+		routes:  routes,
+		matcher: newMatcher(),
+	}
+	for i, route := range routes {
+		if err := route.Validate(); err != nil {
+			return nil, fmt.Errorf("route #%d: %v", i+1, err)
+		}
+		if err := t.matcher.add(route); err != nil {
+			return nil, fmt.Errorf("route %q: %v", route.Pattern, err)
+		}
+	}
+	if err := t.checkLoops(); err != nil {
+		return nil, err
+	}
+	if cacheSize > 0 {
+		t.cache = newCache(cacheSize)
+	}
+	return t, nil
+}
+
+// with returns a copy of the table with route added to it
+func (t *table) with(route *Route, cacheSize int) (*table, error) {
+	if err := route.Validate(); err != nil {
+		return nil, err
+	}
+
+	nt := &table{
+		routes:  append(t.routes[:len(t.routes):len(t.routes)], route),
+		matcher: newMatcher(),
+	}
+	for _, existing := range t.routes {
+		// existing routes have already been validated and added once
+		_ = nt.matcher.add(existing)
+	}
+	if err := nt.matcher.add(route); err != nil {
+		return nil, err
+	}
+	if err := nt.checkLoops(); err != nil {
+		return nil, err
+	}
+	if cacheSize > 0 {
+		nt.cache = newCache(cacheSize)
+	}
+	return nt, nil
+}
+
+// replacing returns a copy of the table with the route whose pattern is pattern and whose conditions are route's
+// replaced by route, or with every route whose pattern is pattern removed if route is nil. route must already have
+// been validated.
+func (t *table) replacing(pattern string, route *Route, cacheSize int) (*table, error) {
+	routes := make([]*Route, 0, len(t.routes))
+	found := false
+	for _, existing := range t.routes {
+		if existing.Pattern != pattern || (route != nil && existing.Conditions() != route.Conditions()) {
+			routes = append(routes, existing)
+			continue
+		}
+		found = true
+		if route != nil {
+			routes = append(routes, route)
+		}
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return newTable(routes, cacheSize)
+}
+
+// checkLoops returns an error naming the first chain of the table's routes that redirect in a cycle, if any
+func (t *table) checkLoops() error {
+	if loops := findLoops(t.matcher, t.routes); len(loops) > 0 {
+		return fmt.Errorf("redirect loop: %s", loops[0])
+	}
+	return nil
+}
+
+// lookup looks up the route for req like matcher.lookupVary, through the cache if the table has one
+func (t *table) lookup(req *http.Request, host, path string) (*Route, []string, string) {
+	if t.cache == nil || t.matcher.conditional {
+		// lookups of conditional routes depend on more than the cache key
+		return t.matcher.lookupVary(req, host, path)
+	}
+
+	// without conditions, no lookups vary on the request's headers
+	key := cacheKey{host, path}
+	if e, ok := t.cache.get(key); ok {
+		return e.route, e.captures, ""
+	}
+	route, captures := t.matcher.lookup(req, host, path)
+	t.cache.add(key, route, captures)
+	return route, captures, ""
+}
@@ -0,0 +1,317 @@
+package redirector
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// TXTRoutes looks up routes for the hosts of requests that don't match any of the configured routes in DNS TXT
+// records, so that domain owners can configure their own redirects on a shared redirector. A host's records are found
+// under Prefix, e.g. _redirect.example.com for example.com, and each one is a route in the NewRoute syntax:
+//
+//	_redirect.example.com. 300 IN TXT "example.com/* www.example.com path query code=301"
+//
+// Only routes that redirect and whose patterns are on the host itself are used, so a record can't take over other
+// hosts or make redirector proxy requests or serve files. Records are cached for their TTL.
+type TXTRoutes struct {
+	// Prefix is the label that hosts' records are found under. It defaults to _redirect.
+	Prefix string
+	// Lookup looks up the TXT records of a name and how long they may be cached for. It defaults to LookupTXT.
+	Lookup func(ctx context.Context, name string) ([]string, time.Duration, error)
+	// CacheSize is how many hosts' records are cached. It defaults to 10000.
+	CacheSize int
+}
+
+const (
+	// txtMinTTL and txtMaxTTL bound how long records are cached for, so that records with a TTL of 0 don't cause a
+	// lookup for every request
+	txtMinTTL = 30 * time.Second
+	txtMaxTTL = 24 * time.Hour
+	// txtErrorTTL is how long hosts without records, or whose lookups failed, are cached for
+	txtErrorTTL = time.Minute
+)
+
+// WithTXTRoutes looks up routes in DNS TXT records for requests that don't match any of the configured routes, before
+// falling back to the default handler
+func WithTXTRoutes(t TXTRoutes) Option {
+	if t.Prefix == "" {
+		t.Prefix = "_redirect"
+	}
+	if t.Lookup == nil {
+		t.Lookup = LookupTXT
+	}
+	if t.CacheSize <= 0 {
+		t.CacheSize = 10000
+	}
+	return func(r *Redirector) {
+		r.txt = &txtCache{cfg: t, ll: list.New(), items: make(map[string]*list.Element)}
+	}
+}
+
+// txtCache is an LRU cache of the route tables built from hosts' TXT records
+type txtCache struct {
+	cfg TXTRoutes
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type txtEntry struct {
+	host    string
+	table   *table
+	expires time.Time
+}
+
+// table returns the route table of host's records, looking them up if they aren't cached. It returns nil if host has
+// no usable routes.
+func (c *txtCache) table(ctx context.Context, host string, logger Logger) *table {
+	now := time.Now()
+	c.mu.Lock()
+	if e, ok := c.items[host]; ok {
+		entry := e.Value.(*txtEntry)
+		if now.Before(entry.expires) {
+			c.ll.MoveToFront(e)
+			c.mu.Unlock()
+			return entry.table
+		}
+	}
+	c.mu.Unlock()
+
+	t, ttl := c.lookup(ctx, host, logger)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &txtEntry{host: host, table: t, expires: now.Add(ttl)}
+	if e, ok := c.items[host]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return t
+	}
+	c.items[host] = c.ll.PushFront(entry)
+	if c.ll.Len() > c.cfg.CacheSize {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*txtEntry).host)
+	}
+	return t
+}
+
+// lookup builds a route table from host's records, returning it and how long it may be cached for. Invalid routes
+// are logged and skipped.
+func (c *txtCache) lookup(ctx context.Context, host string, logger Logger) (*table, time.Duration) {
+	if host == "" || net.ParseIP(host) != nil {
+		return nil, txtErrorTTL
+	}
+	name := c.cfg.Prefix + "." + host
+	records, ttl, err := c.cfg.Lookup(ctx, name)
+	if err != nil {
+		logger.Error("looking up dns routes", "name", name, "error", err)
+		return nil, txtErrorTTL
+	}
+	if len(records) == 0 {
+		return nil, txtErrorTTL
+	}
+
+	var routes []*Route
+	for _, s := range records {
+		route, err := txtRoute(host, s)
+		if err != nil {
+			logger.Error("invalid dns route", "name", name, "route", s, "error", err)
+			continue
+		}
+		route.Source = "dns " + name
+		routes = append(routes, route)
+	}
+	if len(routes) == 0 {
+		return nil, txtErrorTTL
+	}
+	t, err := newTable(routes, 0)
+	if err != nil {
+		logger.Error("invalid dns routes", "name", name, "error", err)
+		return nil, txtErrorTTL
+	}
+
+	if ttl < txtMinTTL {
+		ttl = txtMinTTL
+	} else if ttl > txtMaxTTL {
+		ttl = txtMaxTTL
+	}
+	return t, ttl
+}
+
+// txtRoute parses and validates a route from a TXT record of host
+func txtRoute(host, s string) (*Route, error) {
+	route, err := NewRoute(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := route.Validate(); err != nil {
+		return nil, err
+	}
+	if route.Destination == nil && len(route.Split) == 0 {
+		return nil, errors.New("only routes that redirect can be set in dns")
+	}
+	if isRegexpPattern(route.Pattern) {
+		return nil, errors.New("regular expression patterns can't be set in dns")
+	}
+	for _, p := range route.Patterns() {
+		if i := strings.IndexByte(p, '/'); i == -1 || normalizeHost(p[:i]) != host {
+			return nil, fmt.Errorf("the pattern must be on %s", host)
+		}
+	}
+	return route, nil
+}
+
+// txtHost returns the hostname of req that TXT records are looked up for, without any port
+func (r *Redirector) txtHost(req *http.Request) string {
+	host := r.requestPattern(req)
+	if i := strings.IndexByte(host, '/'); i != -1 {
+		host = host[:i]
+	}
+	host = normalizeHost(host)
+	if i, ok := portIndex(host); ok {
+		host = host[:i]
+	}
+	return strings.Trim(host, "[]")
+}
+
+// LookupTXT looks up the TXT records of name with the first nameserver in /etc/resolv.conf, or 127.0.0.1 if there
+// isn't one, returning them along with their lowest TTL. Unlike net.LookupTXT, it reports the TTL so that records can
+// be cached for as long as their owner intended. The strings of each record are joined. Names that don't exist have
+// no records.
+func LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(time.Now().UnixNano())
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: qname, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET})
+	b.StartAdditionals()
+	// advertise a larger udp payload so that most responses don't need to be retried over tcp
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, 0, err
+	}
+	b.OPTResource(opt, dnsmessage.OPTResource{})
+	query, err := b.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
+	server := nameserver()
+	res, err := exchange(ctx, "udp", server, query)
+	if err == nil && res.Truncated {
+		res, err = exchange(ctx, "tcp", server, query)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.ID != id {
+		return nil, 0, errors.New("mismatched dns response id")
+	}
+	switch res.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("dns response: %v", res.RCode)
+	}
+
+	var (
+		records []string
+		ttl     uint32
+	)
+	for _, a := range res.Answers {
+		txt, ok := a.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		records = append(records, strings.Join(txt.TXT, ""))
+		if len(records) == 1 || a.Header.TTL < ttl {
+			ttl = a.Header.TTL
+		}
+	}
+	return records, time.Duration(ttl) * time.Second, nil
+}
+
+// nameserver returns the address of the first nameserver in /etc/resolv.conf
+func nameserver() string {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if f := strings.Fields(line); len(f) >= 2 && f[0] == "nameserver" {
+				return net.JoinHostPort(f[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// exchange sends a dns query to server over network and parses its response. Messages over tcp are prefixed with
+// their length.
+func exchange(ctx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		msg := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(msg, uint16(len(query)))
+		copy(msg[2:], query)
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		var n [2]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(n[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+
+	var res dnsmessage.Message
+	if err := res.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("parsing dns response: %v", err)
+	}
+	return &res, nil
+}
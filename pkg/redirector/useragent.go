@@ -0,0 +1,41 @@
+package redirector
+
+import "strings"
+
+// user agent classes that routes can match with UserAgents
+const (
+	UAMobile  = "mobile"
+	UADesktop = "desktop"
+	UABot     = "bot"
+)
+
+// botTokens and mobileTokens are lowercase substrings of the user agents of bots and mobile browsers
+var (
+	botTokens = []string{
+		"bot", "crawl", "spider", "slurp", "facebookexternalhit", "embedly", "preview", "curl/", "wget/",
+		"python-requests", "go-http-client", "okhttp", "headless",
+	}
+	mobileTokens = []string{
+		"mobi", "android", "iphone", "ipad", "ipod", "windows phone", "blackberry", "opera mini", "silk/",
+	}
+)
+
+// ClassifyUserAgent sorts a User-Agent header into one of UAMobile, UADesktop, or UABot. Empty user agents are treated
+// as bots, and tablets as mobile. It's a heuristic based on well-known substrings rather than a full parser.
+func ClassifyUserAgent(ua string) string {
+	if ua == "" {
+		return UABot
+	}
+	ua = strings.ToLower(ua)
+	for _, t := range botTokens {
+		if strings.Contains(ua, t) {
+			return UABot
+		}
+	}
+	for _, t := range mobileTokens {
+		if strings.Contains(ua, t) {
+			return UAMobile
+		}
+	}
+	return UADesktop
+}
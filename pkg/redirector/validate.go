@@ -0,0 +1,241 @@
+package redirector
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Validate checks that the route is well-formed: the pattern must be {hostname}/{path} or a regular expression, the
+// destination (or upstream, or each split destination) must be an absolute http(s) URL, the code must be a 3xx redirect
+// code (or a 2xx, 4xx, or 5xx code for routes that respond), and its options must not contradict each other.
+func (r *Route) Validate() error {
+	if r == nil {
+		return errors.New("route is nil")
+	}
+	if isRegexpPattern(r.Pattern) {
+		if _, err := compileRegexpPattern(r.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", r.Pattern, err)
+		}
+	} else {
+		var names string
+		patterns := r.Patterns()
+		for i, pattern := range patterns {
+			p, err := parsePattern(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", r.Pattern, err)
+			}
+			for _, other := range patterns[:i] {
+				if other == pattern {
+					return fmt.Errorf("invalid pattern %q: %s is listed more than once", r.Pattern, pattern)
+				}
+			}
+			if i == 0 {
+				names = strings.Join(p.names, ",")
+			} else if strings.Join(p.names, ",") != names {
+				return fmt.Errorf("invalid pattern %q: every host must have the same wildcards", r.Pattern)
+			}
+		}
+	}
+
+	for _, m := range r.Methods {
+		if !validHeaderName(m) {
+			return fmt.Errorf("invalid method %q", m)
+		}
+	}
+	for name := range r.MatchHeaders {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	for name := range r.MatchCookies {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid cookie name %q", name)
+		}
+	}
+	for _, c := range r.Countries {
+		if len(c) != 2 || c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+			return fmt.Errorf("invalid country %q: must be a two-letter country code", c)
+		}
+	}
+	if r.ActiveFrom != nil && r.ActiveUntil != nil && !r.ActiveFrom.Before(*r.ActiveUntil) {
+		return fmt.Errorf("route is never active: from=%s isn't before until=%s", r.ActiveFrom.Format(time.RFC3339), r.ActiveUntil.Format(time.RFC3339))
+	}
+	if r.Scheme != "" && r.Scheme != "http" && r.Scheme != "https" {
+		return fmt.Errorf("invalid scheme %q: must be http or https", r.Scheme)
+	}
+	for _, ua := range r.UserAgents {
+		if ua != UAMobile && ua != UADesktop && ua != UABot {
+			return fmt.Errorf("invalid user agent class %q: must be mobile, desktop, or bot", ua)
+		}
+	}
+
+	if _, err := r.routeAccess(); err != nil {
+		return err
+	}
+	if err := r.validateAuth(); err != nil {
+		return err
+	}
+	if r.LogOnly && (r.Upstream != nil || r.Files != "" || r.Response != nil) {
+		return errors.New("log_only only applies to routes that redirect")
+	}
+	if r.Refresh && (r.Upstream != nil || r.Files != "" || r.Response != nil) {
+		return errors.New("refresh only applies to routes that redirect")
+	}
+
+	if r.Upstream != nil {
+		if r.Destination != nil || r.Resolver != nil || r.Response != nil || r.Files != "" {
+			return errors.New("route can't have both an upstream and a destination")
+		}
+		if r.Upstream.Scheme != "http" && r.Upstream.Scheme != "https" {
+			return fmt.Errorf("invalid upstream %q: scheme must be http or https", r.Upstream)
+		}
+		if r.Upstream.Host == "" {
+			return fmt.Errorf("invalid upstream %q: missing hostname", r.Upstream)
+		}
+		if r.Code != 0 || r.CarryPath || r.KeepTrailingSlash || r.CarryQuery || r.MergeQuery || len(r.AddQuery) > 0 ||
+			len(r.DropQuery) > 0 || len(r.Headers) > 0 || r.StripPrefix != "" || r.CORS != nil {
+			return errors.New("proxy routes don't take any options")
+		}
+		return nil
+	}
+
+	if r.Files != "" {
+		if r.Destination != nil || r.Resolver != nil || r.Response != nil {
+			return errors.New("route can't have both a directory and a destination")
+		}
+		if r.Code != 0 || r.CarryPath || r.KeepTrailingSlash || r.CarryQuery || r.MergeQuery || len(r.AddQuery) > 0 ||
+			len(r.DropQuery) > 0 {
+			return errors.New("files routes only take the strip and header options")
+		}
+		return r.validateHeaders()
+	}
+
+	u := r.Destination
+	switch {
+	case r.Response != nil:
+		if u != nil || r.Resolver != nil {
+			return errors.New("route can't have both a response and a destination")
+		}
+		if r.CarryPath || r.CarryQuery || r.MergeQuery || len(r.AddQuery) > 0 || len(r.DropQuery) > 0 {
+			return errors.New("path and query options only apply to routes that redirect")
+		}
+		if ct := r.Response.ContentType; ct != "" {
+			if _, _, err := mime.ParseMediaType(ct); err != nil {
+				return fmt.Errorf("invalid content type %q: %v", ct, err)
+			}
+		}
+	case len(r.Split) > 0:
+		if u != nil || r.Resolver != nil {
+			return errors.New("route can't have both split destinations and a destination")
+		}
+		for _, split := range r.Split {
+			if split.Weight <= 0 {
+				return fmt.Errorf("invalid weight %d for %q: must be positive", split.Weight, split.Destination)
+			}
+			if err := validateDestination(split.Destination); err != nil {
+				return err
+			}
+			if r.CarryQuery && split.Destination.RawQuery != "" {
+				return fmt.Errorf("destination %q has a query string that would be replaced by the request's query", split.Destination)
+			}
+		}
+	case u == nil && r.Resolver == nil:
+		return errors.New("route must have a destination")
+	case u == nil:
+		// the destination is resolved per request
+	default:
+		if err := validateDestination(u); err != nil {
+			return err
+		}
+	}
+	if r.Sticky && len(r.Split) == 0 {
+		return errors.New("sticky only applies to split routes")
+	}
+	if r.StickyCookie != "" && (!r.Sticky || !validHeaderName(r.StickyCookie)) {
+		return fmt.Errorf("invalid sticky cookie name %q", r.StickyCookie)
+	}
+
+	if err := r.validateCode(); err != nil {
+		return err
+	}
+
+	if r.StripPrefix != "" && !r.CarryPath {
+		return errors.New("strip only applies to routes that carry the path")
+	}
+	if r.KeepTrailingSlash && !r.CarryPath {
+		return errors.New("keep-trailing-slash only applies to routes that carry the path")
+	}
+
+	if err := r.validateHeaders(); err != nil {
+		return err
+	}
+
+	if r.CarryQuery && r.MergeQuery {
+		return errors.New("query replaces the destination's query while merge_query merges into it; use one of them")
+	}
+	if r.CarryQuery && u != nil && u.RawQuery != "" {
+		return fmt.Errorf("destination %q has a query string that would be replaced by the request's query", u)
+	}
+
+	return nil
+}
+
+// validateDestination checks that u is an absolute http(s) URL
+func validateDestination(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid destination %q: scheme must be http or https", u)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid destination %q: missing hostname", u)
+	}
+	return nil
+}
+
+// validateCode checks that the route's code suits the kind of route it is
+func (r *Route) validateCode() error {
+	if r.Upstream != nil || r.Files != "" {
+		// checked with the rest of their options
+		return nil
+	}
+	if r.Response != nil {
+		if !(r.Code >= 200 && r.Code <= 299) && !(r.Code >= 400 && r.Code <= 599) {
+			return fmt.Errorf("invalid code %d: routes that respond must have a 2xx, 4xx, or 5xx code", r.Code)
+		}
+		return nil
+	}
+	if r.Code < 300 || r.Code > 399 {
+		return fmt.Errorf("invalid code %d: must be a 3xx redirect code", r.Code)
+	}
+	return nil
+}
+
+// validateHeaders checks the names of the route's extra headers
+func (r *Route) validateHeaders() error {
+	for name := range r.Headers {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if http.CanonicalHeaderKey(name) == "Location" {
+			return errors.New("the Location header is set by the redirect and can't be overridden")
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is a valid http header field name
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > '~' || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+// Package redirectortest provides helpers for testing redirector route configurations.
+//
+//	func TestRoutes(t *testing.T) {
+//		re := redirectortest.NewRedirector(t, "www.example.com/* example.com path query code=301")
+//		redirectortest.Run(t, http.HandlerFunc(re.Handler), []redirectortest.Case{
+//			{URL: "https://www.example.com/foo?x=1", Location: "https://example.com/foo?x=1", Code: 301},
+//			{URL: "https://example.com/", Code: 404},
+//		})
+//	}
+package redirectortest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// NewRedirector creates a Redirector configured with routes, given in the syntax accepted by redirector.NewRoute. It
+// fails the test if any of the routes is invalid.
+func NewRedirector(t testing.TB, routes ...string) *redirector.Redirector {
+	t.Helper()
+	rs := make([]*redirector.Route, 0, len(routes))
+	for _, s := range routes {
+		r, err := redirector.NewRoute(s)
+		if err != nil {
+			t.Fatalf("parsing route %q: %v", s, err)
+		}
+		rs = append(rs, r)
+	}
+
+	re := redirector.New(nil)
+	if err := re.SetRoutes(rs); err != nil {
+		t.Fatalf("setting routes: %v", err)
+	}
+	return re
+}
+
+// Server is a Redirector served over HTTP by an httptest.Server
+type Server struct {
+	*httptest.Server
+	Redirector *redirector.Redirector
+}
+
+// NewServer starts a Server for a Redirector configured with routes. The server is closed when the test finishes.
+func NewServer(t testing.TB, routes ...string) *Server {
+	t.Helper()
+	re := NewRedirector(t, routes...)
+	s := &Server{
+		Server:     httptest.NewServer(http.HandlerFunc(re.Handler)),
+		Redirector: re,
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Get sends a GET request for rawurl to the server, using rawurl's host as the Host header. Redirects are not
+// followed.
+func (s *Server) Get(rawurl string) (*http.Response, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, s.URL+u.RequestURI(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Host = u.Host
+
+	client := *s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client.Do(req)
+}
+
+// Case is a request and the response that it is expected to receive
+type Case struct {
+	// Name is the name of the subtest. It defaults to URL.
+	Name string
+	// URL is the requested URL
+	URL string
+	// Location is the expected Location header. Leave empty to expect no Location header.
+	Location string
+	// Code is the expected status code
+	Code int
+}
+
+// AssertRedirect asserts that h responds to a GET request for rawurl with code and the given Location header, which
+// is expected to be absent if location is empty. It reports whether the assertion passed.
+func AssertRedirect(t testing.TB, h http.Handler, rawurl, location string, code int) bool {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, rawurl, nil))
+
+	ok := true
+	if w.Code != code {
+		t.Errorf("GET %s: got status code %d, want %d", rawurl, w.Code, code)
+		ok = false
+	}
+	if got := w.Header().Get("Location"); got != location {
+		t.Errorf("GET %s: got Location %q, want %q", rawurl, got, location)
+		ok = false
+	}
+	return ok
+}
+
+// Run runs each case against h in its own subtest
+func Run(t *testing.T, h http.Handler, cases []Case) {
+	t.Helper()
+	for _, c := range cases {
+		c := c
+		name := c.Name
+		if name == "" {
+			name = c.URL
+		}
+		t.Run(name, func(t *testing.T) {
+			AssertRedirect(t, h, c.URL, c.Location, c.Code)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package redirectortest_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kamaln7/redirector/pkg/redirectortest"
+)
+
+func TestRun(t *testing.T) {
+	re := redirectortest.NewRedirector(t,
+		"www.example.com/* example.com path query code=301",
+		"example.com/old example.com/new",
+	)
+	redirectortest.Run(t, http.HandlerFunc(re.Handler), []redirectortest.Case{
+		{URL: "https://www.example.com/foo?x=1", Location: "https://example.com/foo?x=1", Code: 301},
+		{Name: "exact", URL: "https://example.com/old", Location: "https://example.com/new", Code: 302},
+		{URL: "https://example.com/", Code: 404},
+	})
+}
+
+func TestServer(t *testing.T) {
+	s := redirectortest.NewServer(t, "www.example.com/* example.com path code=308")
+	res, err := s.Get("http://www.example.com/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusPermanentRedirect {
+		t.Errorf("got status %d, want 308", res.StatusCode)
+	}
+	if got, want := res.Header.Get("Location"), "https://example.com/docs"; got != want {
+		t.Errorf("got Location %q, want %q", got, want)
+	}
+	if _, ok := s.Redirector.Match(res.Request); !ok {
+		t.Errorf("the server's Redirector doesn't match %s", res.Request.URL)
+	}
+}
+
+// recorder is a testing.TB that records the errors reported to it instead of failing the test
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertRedirectFails(t *testing.T) {
+	re := redirectortest.NewRedirector(t, "example.com/old example.com/new")
+	tests := []struct {
+		name, location string
+		code, errors   int
+	}{
+		{"passes", "https://example.com/new", 302, 0},
+		{"wrong location", "https://example.com/other", 302, 1},
+		{"wrong code", "https://example.com/new", 301, 1},
+		{"both wrong", "", 404, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{TB: t}
+			ok := redirectortest.AssertRedirect(r, http.HandlerFunc(re.Handler), "https://example.com/old", tt.location, tt.code)
+			if ok != (tt.errors == 0) || len(r.errors) != tt.errors {
+				t.Errorf("got %v with errors %q, want %d errors", ok, r.errors, tt.errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// proxyCacheHits and proxyCacheMisses count the requests that wrap -cache served from the cache, and the ones that it
+// looked up but had to forward, for the metrics
+var proxyCacheHits, proxyCacheMisses atomic.Uint64
+
+// cacheableStatuses are the status codes whose responses may be cached, when their headers allow it
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// proxyCache is a small in-memory cache, in front of a wrapped command, of the responses that say they may be cached
+// by shared caches for some time with Cache-Control or Expires. The least recently used responses are evicted once
+// the cache is full.
+type proxyCache struct {
+	// size is how many bytes of bodies the cache holds, and ttl is the longest a response is kept, however long its
+	// headers say it's fresh for
+	size int64
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	bytes   int64
+}
+
+// cachedResponse is a response in the cache
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func newProxyCache(size int64, ttl time.Duration) *proxyCache {
+	return &proxyCache{size: size, ttl: ttl, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// middleware serves the requests that h would handle from the cache when it can, and caches h's responses
+func (c *proxyCache) middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !cacheableRequest(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		key := req.Host + req.URL.RequestURI() + "\n" + req.Header.Get("Accept-Encoding")
+		if res := c.get(key); res != nil {
+			proxyCacheHits.Add(1)
+			for name, values := range res.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Age", strconv.Itoa(int(time.Since(res.stored).Seconds())))
+			w.WriteHeader(res.status)
+			w.Write(res.body)
+			return
+		}
+		proxyCacheMisses.Add(1)
+
+		rec := &cacheRecorder{ResponseWriter: w, limit: c.size / 8}
+		h.ServeHTTP(rec, req)
+		if req.Context().Err() != nil || rec.overflow {
+			// the body may not have been proxied in full
+			return
+		}
+		if ttl := c.freshFor(rec); ttl > 0 {
+			now := time.Now()
+			c.put(&cachedResponse{
+				key:     key,
+				status:  rec.status,
+				header:  rec.Header().Clone(),
+				body:    rec.body,
+				stored:  now,
+				expires: now.Add(ttl),
+			})
+		}
+	})
+}
+
+// cacheableRequest returns whether the response to req may come from the cache. Requests with credentials, ranges,
+// or upgrades always go to the command, and so do the ones that ask to skip caches.
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Authorization") != "" || req.Header.Get("Range") != "" ||
+		req.Header.Get("Upgrade") != "" {
+		return false
+	}
+	cc := cacheControl(req.Header)
+	_, noCache := cc["no-cache"]
+	_, noStore := cc["no-store"]
+	return !noCache && !noStore && req.Header.Get("Pragma") != "no-cache"
+}
+
+// freshFor returns how long the response recorded by rec may be served from the cache, or 0 if it may not be cached
+func (c *proxyCache) freshFor(rec *cacheRecorder) time.Duration {
+	h := rec.Header()
+	if !cacheableStatuses[rec.status] || h.Get("Set-Cookie") != "" {
+		return 0
+	}
+	if n := h.Get("Content-Length"); n != "" && n != strconv.Itoa(len(rec.body)) {
+		return 0
+	}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			// Accept-Encoding is part of the key
+			if !strings.EqualFold(strings.TrimSpace(name), "Accept-Encoding") {
+				return 0
+			}
+		}
+	}
+	cc := cacheControl(h)
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			return 0
+		}
+	}
+
+	var fresh time.Duration
+	if v, ok := cc["s-maxage"]; ok {
+		fresh = parseSeconds(v)
+	} else if v, ok := cc["max-age"]; ok {
+		fresh = parseSeconds(v)
+	} else if v := h.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		fresh = expires.Sub(date)
+	}
+	fresh -= parseSeconds(h.Get("Age"))
+	if fresh > c.ttl {
+		fresh = c.ttl
+	}
+	return fresh
+}
+
+// cacheControl parses the Cache-Control directives in h, by their lowercased names
+func cacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// parseSeconds parses a number of seconds, like those of max-age and Age, or returns 0 if s isn't one
+func parseSeconds(s string) time.Duration {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// get returns the fresh cached response for key, or nil if there isn't one
+func (c *proxyCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	res := e.Value.(*cachedResponse)
+	if time.Now().After(res.expires) {
+		c.remove(e)
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return res
+}
+
+// put caches res, evicting the least recently used responses to make room for it
+func (c *proxyCache) put(res *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[res.key]; ok {
+		c.remove(e)
+	}
+	c.entries[res.key] = c.lru.PushFront(res)
+	c.bytes += int64(len(res.body))
+	for c.bytes > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *proxyCache) remove(e *list.Element) {
+	res := c.lru.Remove(e).(*cachedResponse)
+	delete(c.entries, res.key)
+	c.bytes -= int64(len(res.body))
+}
+
+// cacheRecorder passes a response on while keeping a copy of it, up to limit bytes of its body
+type cacheRecorder struct {
+	http.ResponseWriter
+	limit    int64
+	status   int
+	body     []byte
+	overflow bool
+}
+
+func (r *cacheRecorder) WriteHeader(code int) {
+	if r.status == 0 && code >= http.StatusOK {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *cacheRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.overflow {
+		if int64(len(r.body)+len(b)) > r.limit {
+			// responses that would take up too much of the cache aren't kept, so that they don't evict everything else
+			r.overflow, r.body = true, nil
+		} else {
+			r.body = append(r.body, b...)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher so that proxied responses can still be streamed
+func (r *cacheRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController
+func (r *cacheRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// ttySupported is whether wrapped commands can be run under a pseudo-terminal with wrap -tty
+const ttySupported = false
+
+func startTTY(cmd *exec.Cmd) (*os.File, error) {
+	return nil, errors.New("-tty is only supported on linux")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"rsc.io/qr"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// qrParam is the query parameter that asks for a QR code of a route's url instead of following it
+const qrParam = "qr"
+
+// qrHandler serves QR codes that point at the urls of routes, e.g. for short links, for requests with ?qr=png or
+// ?qr=svg, and passes every other request to next. Codes are only served for urls that match a route, and always
+// point at https since that's how links are shared.
+func qrHandler(re *redirector.Redirector, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if !q.Has(qrParam) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		format := q.Get(qrParam)
+		if format != "" && format != "1" && format != "png" && format != "svg" {
+			http.Error(w, "qr must be png or svg", http.StatusBadRequest)
+			return
+		}
+		q.Del(qrParam)
+		u := *req.URL
+		u.RawQuery = q.Encode()
+		link := req.Clone(req.Context())
+		link.URL = &u
+		if _, ok := re.Match(link); !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		code, err := qr.Encode("https://"+req.Host+u.RequestURI(), qr.M)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestURITooLong)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		if format == "svg" {
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(qrSVG(code)))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(code.PNG())
+	})
+}
+
+// qrSVG draws code as an svg with a module per unit and a quiet zone of 4 modules around it, like the png
+func qrSVG(code *qr.Code) string {
+	size := code.Size + 8
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// qrURL returns the url of the QR code of link, for showing next to short links
+func qrURL(link string) string {
+	return link + "?" + url.Values{qrParam: {"png"}}.Encode()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// reloadStore is a redirector.RouteStore of the routes from the flags and the config file, which are loaded again
+// whenever it's reloaded
+type reloadStore struct {
+	redirector.Broadcaster
+	rf *routeFlags
+	// reloading serializes reloads, which share rf
+	reloading sync.Mutex
+
+	mu     sync.Mutex
+	routes []*redirector.Route
+}
+
+var _ redirector.RouteStore = new(reloadStore)
+
+// newReloadStore creates a reloadStore with routes, which were already loaded from rf
+func newReloadStore(rf *routeFlags, routes []*redirector.Route) *reloadStore {
+	return &reloadStore{rf: rf, routes: routes}
+}
+
+// List implements redirector.RouteStore.List
+func (s *reloadStore) List(context.Context) ([]*redirector.Route, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.routes, nil
+}
+
+// Put implements redirector.RouteStore.Put
+func (s *reloadStore) Put(context.Context, *redirector.Route) error {
+	return redirector.ErrReadOnly
+}
+
+// Delete implements redirector.RouteStore.Delete
+func (s *reloadStore) Delete(context.Context, string) error {
+	return redirector.ErrReadOnly
+}
+
+// reload loads the routes again. If any of them are invalid, the previous routes are kept.
+func (s *reloadStore) reload() (int, []error) {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	s.rf.config = nil
+	routes, errs := s.rf.load()
+	if len(errs) > 0 {
+		return 0, errs
+	}
+	// make sure that the routes can be used together before replacing the old ones
+	if err := redirector.New(nil).SetRoutes(routes); err != nil {
+		return 0, []error{err}
+	}
+
+	s.rf.reportFlattened()
+
+	s.mu.Lock()
+	s.routes = routes
+	s.mu.Unlock()
+	s.Notify()
+	return len(routes), nil
+}
+
+// reloadOnSignal reloads the store whenever redirector receives a SIGHUP
+func reloadOnSignal(s *reloadStore) {
+	chanSig := make(chan os.Signal, 1)
+	signal.Notify(chanSig, syscall.SIGHUP)
+	for range chanSig {
+		fmt.Printf("❗ got SIGHUP, reloading routes...\n")
+		logReload(s.reload())
+	}
+}
+
+// reloadOnChange reloads the store whenever any of the files at paths change. It watches the files' directories rather
+// than the files themselves so that it keeps working when editors replace a file instead of writing to it.
+func reloadOnChange(s *reloadStore, paths []string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, path := range paths {
+		name := filepath.Clean(path)
+		if err := w.Add(filepath.Dir(name)); err != nil {
+			w.Close()
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		names[name] = true
+	}
+
+	go func() {
+		var (
+			changed  string
+			debounce = time.NewTimer(0)
+		)
+		<-debounce.C
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if names[filepath.Clean(event.Name)] && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					// editors often write a file in several steps, so wait for them to finish
+					changed = event.Name
+					debounce.Reset(100 * time.Millisecond)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				fmt.Printf("🚨 watching routes: %v\n", err)
+			case <-debounce.C:
+				fmt.Printf("❗ %s changed, reloading routes...\n", changed)
+				logReload(s.reload())
+			}
+		}
+	}()
+	return nil
+}
+
+func logReload(n int, errs []error) {
+	for _, err := range errs {
+		fmt.Printf("❌ %v\n", err)
+	}
+	if len(errs) > 0 {
+		fmt.Printf("🚨 keeping the previous routes\n")
+		return
+	}
+	fmt.Printf("✅ reloaded %d route(s)\n", n)
+}
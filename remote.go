@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// remoteStore is a redirector.RouteSource of the routes in a file served over http(s), which is polled for changes.
+// Files ending in .yaml, .yml, .toml, or .json are parsed as config files, of which only the routes are used, and
+// anything else as a routes file. The server's ETag and Last-Modified headers are sent back when polling, so unchanged
+// files aren't downloaded again. If a new version of the file fails to download or has invalid routes, the previous
+// routes are kept.
+type remoteStore struct {
+	redirector.Broadcaster
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	mu           sync.Mutex
+	loaded       bool
+	routes       []*redirector.Route
+	etag         string
+	lastModified string
+	polling      bool
+}
+
+var _ redirector.RouteSource = new(remoteStore)
+
+func newRemoteStore(rawURL string, interval time.Duration) (*remoteStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%s: must be an http or https url", rawURL)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("the polling interval must be positive, got %v", interval)
+	}
+	return &remoteStore{url: rawURL, interval: interval, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// List implements redirector.RouteSource.List. The file is downloaded the first time.
+func (s *remoteStore) List(ctx context.Context) ([]*redirector.Route, error) {
+	s.mu.Lock()
+	loaded := s.loaded
+	s.mu.Unlock()
+	if !loaded {
+		if _, err := s.fetch(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.routes, nil
+}
+
+// Watch implements redirector.RouteSource.Watch. The first call starts polling the file until ctx is done.
+func (s *remoteStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	s.mu.Lock()
+	if !s.polling {
+		s.polling = true
+		go s.poll(ctx)
+	}
+	s.mu.Unlock()
+	return s.Broadcaster.Watch(ctx)
+}
+
+func (s *remoteStore) poll(ctx context.Context) {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		changed, err := s.fetch(ctx)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			fmt.Printf("🚨 keeping the previous routes\n")
+			continue
+		}
+		if changed {
+			fmt.Printf("❗ %s changed, reloading routes...\n", s.url)
+			s.mu.Lock()
+			n := len(s.routes)
+			s.mu.Unlock()
+			fmt.Printf("✅ reloaded %d route(s)\n", n)
+			s.Notify()
+		}
+	}
+}
+
+// fetch downloads the file unless it hasn't changed since the last time, and replaces the routes if it has. It reports
+// whether the routes were replaced.
+func (s *remoteStore) fetch(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+	s.mu.Unlock()
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s: %s", s.url, res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, 64<<20))
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", s.url, err)
+	}
+
+	routes, err := s.parse(data)
+	if err != nil {
+		return false, err
+	}
+	// make sure that the routes can be used together before replacing the old ones
+	if err := redirector.New(nil).SetRoutes(routes); err != nil {
+		return false, fmt.Errorf("%s: %v", s.url, err)
+	}
+
+	s.mu.Lock()
+	s.routes, s.loaded = routes, true
+	s.etag, s.lastModified = res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	s.mu.Unlock()
+	return true, nil
+}
+
+// parse parses the routes in data, stopping at the first invalid one
+func (s *remoteStore) parse(data []byte) ([]*redirector.Route, error) {
+	u, _ := url.Parse(s.url)
+	switch path.Ext(u.Path) {
+	case ".yaml", ".yml", ".toml", ".json":
+		// the extension decides the format, so leave out the query
+		name := *u
+		name.RawQuery, name.Fragment = "", ""
+		cfg, err := parseConfig(name.String(), data)
+		if err != nil {
+			return nil, err
+		}
+		routes, errs := cfg.routes(s.url)
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+		return routes, nil
+	}
+
+	lines, err := readRoutes(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s.url, err)
+	}
+	var routes []*redirector.Route
+	for _, l := range lines {
+		if l.route == nil && l.err == nil {
+			continue
+		}
+		err := l.err
+		if err == nil {
+			err = l.route.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", s.url, l.n, err)
+		}
+		l.route.Source = fmt.Sprintf("%s:%d", s.url, l.n)
+		routes = append(routes, l.route)
+	}
+	return routes, nil
+}
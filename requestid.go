@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// requestIDHeader carries the id of a request, from clients and to the wrapped command and upstreams
+const requestIDHeader = "X-Request-ID"
+
+// requestIDHandler gives every request an id, or keeps the one that it came with, and sets it on the request that h
+// handles, so that it's logged and forwarded with it, and on the response
+func requestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			req.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, req)
+	})
+}
+
+// validRequestID reports whether id can be kept, which it can if it's up to 128 printable ascii characters without
+// spaces so that it can't break log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random id of 32 hex characters
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
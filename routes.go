@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/consul"
+	"github.com/kamaln7/redirector/pkg/kubernetes"
+	"github.com/kamaln7/redirector/pkg/metadata"
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// routeFlags are the flags that configure which routes are loaded. They can be registered on more than one flag set
+// so that commands accept them both before and after the command name.
+type routeFlags struct {
+	routes strslice
+	files  strslice
+	// netlifyFiles are Netlify _redirects files, whose rules without a hostname are on netlifyHost
+	netlifyFiles strslice
+	netlifyHost  string
+	configPath   string
+	config       *config
+
+	// canonicalHost is the host that the standard routes of -canonical-host send requests to
+	canonicalHost string
+	// flatten is whether internal redirect chains are flattened, and flattened is what the last load flattened
+	flatten   bool
+	flattened []redirector.Flattened
+
+	kubernetes          bool
+	kubernetesServer    string
+	kubernetesNamespace string
+	kubernetesRedirects bool
+
+	consul       string
+	consulPrefix string
+
+	// platform is the cloud whose instance metadata routes are loaded from, or auto to detect it
+	platform string
+
+	configURL         string
+	configURLInterval time.Duration
+}
+
+// register adds the route flags to fs
+func (rf *routeFlags) register(fs *flag.FlagSet) {
+	fs.Var(&rf.routes, "route", `add a route. can be specified multiple times.
+
+syntax: <pattern> <destination|gone|respond|proxy <upstream>|files <dir>|split <weight>:<destination>... [sticky[=<cookie>]]>
+	[path: bool; default=false] [query: bool; default=false] [merge_query: bool; default=false]
+	[add_query=<name>=<value>]... [drop_query=<name>]... [strip=<prefix>] [keep-trailing-slash: bool; default=false]
+	[refresh: bool; default=false]
+	[header="<name>: <value>"]... [method=<method>,...] [match_header="<name>[: <value>]"]...
+	[match_query=<name>[=<value>]]... [cookie=<name>[=<value>]]...
+	[ua=<mobile|desktop|bot>,...] [country=<code>,...] [from=<time>] [until=<time>] [scheme=<http|https>]
+	[allow=<cidr>,...] [deny=<cidr>,...] [auth=<user>:<password>|auth=hmac:<secret>] [cors[=<origin>,...]]
+	[priority: int; default=0] [log_only: bool; default=false] [code: int; default=302]
+	<pattern> - must be {hostname}/{path}. the hostname may start with a * label (*.example.com) and the path may
+	  end with a * segment (example.com/docs/*) to match any subdomains or sub-paths. a * anywhere else matches
+	  exactly one label or segment (api.*.example.com/v1/*/docs), as does a named wildcard such as {tenant}
+	  anywhere, whose match fills in {tenant} in the destination. a path that ends with a ** segment
+	  (example.com/**) matches like *, but only when no other pattern does, as a default route for the host.
+	  several hostnames may be separated by commas (example.com,example.org/*) to match the path on each of them.
+	  patterns that start with ~ are regular expressions matched against the whole {hostname}/{path}, whose capture
+	  groups can be referenced in the destination as $1 to $9.
+	<destination> - the url to redirect to. its path and query may contain the placeholders {host}, {path}, {query},
+	  {*} (what the pattern's last wildcard matched), and {1} to {9} (what each wildcard matched, from left to
+	  right), which are filled in for each request.
+	  gone answers with 410 Gone, or another 4xx code set with code=, instead of redirecting.
+	  respond answers with 200 OK, or another code, and a static body set with body=<text> or body=@<file> and
+	  content-type=<type>.
+	  proxy <upstream> proxies requests to the upstream url, and takes no options.
+	  files <dir> serves the files in the directory, and only takes the strip and header options.
+	  split <weight>:<destination>... splits requests between the destinations by weight. sticky keeps each
+	  client on the same destination with a cookie, which sticky=<cookie> names so that cookie= can match it: its
+	  value is the position of the client's destination, counting from 0.
+	merge_query - merge the request's query parameters into the destination's instead of replacing them.
+	add_query=<name>=<value> - set a query parameter on redirects. drop_query=<name> - remove one.
+	strip=<prefix> - remove this prefix from the start of the path before it's forwarded with path.
+	keep-trailing-slash - keep the trailing slash of the forwarded path. see -trailing-slash.
+	refresh - add an html body with a meta refresh and a link to the destination to redirects. see -refresh-body.
+	header="<name>: <value>" - set a header on redirect responses, e.g. header="Cache-Control: no-store".
+	method=<method>,... - only match requests with one of these methods. routes may share a pattern if their
+	  conditions differ.
+	match_header="<name>[: <value>]" - only match requests with this header set, to this value if there is one.
+	match_query=<name>[=<value>] - only match requests with this query parameter set, to this value if there is one.
+	cookie=<name>[=<value>] - only match requests with this cookie set, to this value if there is one.
+	ua=<mobile|desktop|bot>,... - only match requests from these classes of user agents.
+	country=<code>,... - only match requests from these countries, as found in -geoip-db.
+	from=<time>, until=<time> - only match requests made in this window, as rfc 3339 times.
+	scheme=<http|https> - only match requests made over this scheme. see -trust-forwarded-proto.
+	allow=<cidr>,..., deny=<cidr>,... - refuse clients outside of the allow list, or in the deny list, with 403
+	  Forbidden instead of falling through to other routes. see -allow and -deny.
+	auth=<user>:<password> - require these basic auth credentials, and answer everyone else with 401 Unauthorized.
+	auth=hmac:<secret> - require a sig query parameter with the hex hmac-sha256 of the path, a newline, and the
+	  expires parameter if there is one, a unix time after which the url stops working. both are removed from the
+	  query before it's carried over.
+	cors[=<origin>,...] - answer the CORS preflights of requests for the route, from any origin or only these ones,
+	  e.g. https://app.example.com, and allow their origins on its responses, so that browser fetches see the
+	  redirect. see -cors.
+	priority=<n> - take precedence over matching routes with a lower priority. among routes with the same priority,
+	  the most precise pattern wins.
+	log_only - only log where matching requests would have been redirected to, and handle them as if nothing matched.
+	code=<code> - a 3xx code, or one of permanent (301), temporary (302), permanent-preserve (308), and
+	  temporary-preserve (307).
+	
+example routes:
+	- redirect all requests from www.example.com to example.com, preserving the original path and query parameters.
+	  www.example.com/* example.com path query code=301
+	- redirect blog from subdomain to subpath, appending the original path and preserving query parameters.
+	  blog.example.com/* example.com/blog path query code=301`)
+	fs.Var(&rf.files, "routes-file", "add the routes from a file with one route per line, in the same syntax as -route. empty lines and\nlines starting with # are ignored. can be specified multiple times.")
+	fs.Var(&rf.netlifyFiles, "redirects-file", "add the rules from a netlify _redirects file. can be specified multiple times.")
+	fs.StringVar(&rf.netlifyHost, "redirects-host", rf.netlifyHost, "the hostname of the site that -redirects-file rules without one are on. they match any hostname by\ndefault, and rules that redirect to a path need it.")
+	fs.StringVar(&rf.canonicalHost, "canonical-host", rf.canonicalHost, "add the standard routes for this host, e.g. example.com: www.example.com to it, or example.com if it's\nwww.example.com, and http to https, keeping the path and query, with 301s.")
+	fs.BoolVar(&rf.flatten, "flatten", rf.flatten, "point routes that redirect to a url of another route straight at where that chain of redirects ends,\nso that clients take a single hop.")
+	// the current values are the defaults so that registering on a command's flag set doesn't reset the flags given
+	// before the command
+	fs.StringVar(&rf.configPath, "config", rf.configPath, "load routes and server settings from a yaml, toml, or json file. flags take precedence over the file.")
+	fs.StringVar(&rf.configURL, "config-url", rf.configURL, "load routes from a file served over http(s), and poll it for changes. files ending in .yaml, .yml, .toml, or .json\nare parsed as config files, of which only the routes are used, and anything else as a routes file.")
+	fs.DurationVar(&rf.configURLInterval, "config-url-interval", rf.configURLInterval, "how often to poll -config-url for changes.")
+	fs.BoolVar(&rf.kubernetes, "kubernetes", rf.kubernetes, "load routes from the annotations of Ingress objects in the kubernetes cluster and keep them in sync.")
+	fs.StringVar(&rf.kubernetesServer, "kubernetes-server", rf.kubernetesServer, "the kubernetes api server to use instead of the in-cluster one, e.g. http://127.0.0.1:8001 for kubectl proxy.")
+	fs.StringVar(&rf.kubernetesNamespace, "kubernetes-namespace", rf.kubernetesNamespace, "only watch Ingress objects in this namespace. all namespaces are watched by default.")
+	fs.BoolVar(&rf.kubernetesRedirects, "kubernetes-redirects", rf.kubernetesRedirects, "also load routes from Redirect custom resources in the kubernetes cluster, which requires their\nCustomResourceDefinition to be installed. implies -kubernetes.")
+	fs.StringVar(&rf.consul, "consul", rf.consul, "load routes from consul's key-value store at this address, e.g. http://127.0.0.1:8500, and keep them in sync.\n$CONSUL_HTTP_TOKEN is used as the acl token.")
+	fs.StringVar(&rf.consulPrefix, "consul-prefix", rf.consulPrefix, "the key prefix that routes are stored under in consul. (default \""+consul.DefaultPrefix+"\")")
+	fs.StringVar(&rf.platform, "platform", rf.platform, "load routes from the metadata of the cloud instance that redirector runs on: digitalocean or aws user data,\nor the gce "+metadata.GCEAttribute+" attribute, which is kept in sync. auto detects the cloud.")
+}
+
+// load parses and validates all of the configured routes. It returns every error it encounters rather than stopping
+// at the first one.
+func (rf *routeFlags) load() ([]*redirector.Route, []error) {
+	var (
+		routes []*redirector.Route
+		errs   []error
+	)
+	for i, s := range rf.routes {
+		r, err := redirector.NewRoute(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing route %q: %v", s, err))
+			continue
+		}
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid route %q: %v", s, err))
+			continue
+		}
+		r.Source = fmt.Sprintf("-route #%d", i+1)
+		routes = append(routes, r)
+	}
+
+	for _, store := range rf.routeStores() {
+		rs, err := store.List(context.Background())
+		routes, errs = append(routes, rs...), append(errs, listErrors(err)...)
+	}
+
+	cfg, err := rf.loadConfig()
+	if err != nil {
+		return routes, append(errs, err)
+	}
+	if cfg != nil {
+		cfgRoutes, cfgErrs := cfg.routes(rf.configPath)
+		routes, errs = append(routes, cfgRoutes...), append(errs, cfgErrs...)
+	}
+
+	if rf.canonicalHost != "" {
+		canonical, err := canonicalRoutes(rf.canonicalHost)
+		if err != nil {
+			return routes, append(errs, fmt.Errorf("-canonical-host: %v", err))
+		}
+		routes = append(routes, canonical...)
+	}
+
+	rf.flattened = nil
+	if rf.flatten {
+		rf.flattened = redirector.FlattenChains(routes)
+	}
+	return routes, errs
+}
+
+// reportFlattened prints the routes that the last load flattened
+func (rf *routeFlags) reportFlattened() {
+	for _, f := range rf.flattened {
+		fmt.Printf("💡 %s\n", f)
+	}
+}
+
+// canonicalRoutes returns the routes that send every request for host, and for the www. subdomain of host or the
+// domain that host is the www. subdomain of, to https://host, keeping their paths and queries
+func canonicalRoutes(host string) ([]*redirector.Route, error) {
+	host = strings.ToLower(host)
+	if host == "" || strings.ContainsAny(host, "/*:") {
+		return nil, fmt.Errorf("%q must be a hostname, e.g. example.com", host)
+	}
+	other := "www." + host
+	if apex := strings.TrimPrefix(host, "www."); apex != host {
+		other = apex
+	}
+	var routes []*redirector.Route
+	for _, s := range []string{
+		other + "/* https://" + host + " path query code=301",
+		// only for http, since https requests for host are what every other route leads to
+		host + "/* https://" + host + " path query scheme=http code=301",
+	} {
+		r, err := redirector.NewRoute(s)
+		if err == nil {
+			err = r.Validate()
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Source = "-canonical-host"
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// envStore is a read-only redirector.RouteStore of the routes in the environment: $ROUTES, with one route per line like
+// a routes file, and $ROUTE_1, $ROUTE_2, and so on, in order of their numbers. The environment is read on every List,
+// and it never notifies of changes, since a process's environment doesn't change from outside.
+type envStore struct {
+	redirector.Broadcaster
+}
+
+var _ redirector.RouteStore = new(envStore)
+
+// List implements redirector.RouteStore.List. Invalid routes are skipped, and returned as routeErrors along with the
+// other routes.
+func (s *envStore) List(context.Context) ([]*redirector.Route, error) {
+	var (
+		routes []*redirector.Route
+		errs   routeErrors
+	)
+	if env := os.Getenv("ROUTES"); env != "" {
+		lines, _ := readRoutes(strings.NewReader(env))
+		for _, l := range lines {
+			if l.route == nil && l.err == nil {
+				continue
+			}
+			err := l.err
+			if err == nil {
+				err = l.route.Validate()
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("$ROUTES line %d: %v", l.n, err))
+				continue
+			}
+			l.route.Source = fmt.Sprintf("$ROUTES line %d", l.n)
+			routes = append(routes, l.route)
+		}
+	}
+
+	type numbered struct {
+		n    int
+		name string
+	}
+	var vars []numbered
+	for _, kv := range os.Environ() {
+		name := kv[:strings.IndexByte(kv, '=')]
+		if !strings.HasPrefix(name, "ROUTE_") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(name, "ROUTE_")); err == nil && n > 0 {
+			vars = append(vars, numbered{n, name})
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].n < vars[j].n })
+	for _, v := range vars {
+		name := v.name
+		s := os.Getenv(name)
+		r, err := redirector.NewRoute(s)
+		if err == nil {
+			err = r.Validate()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("$%s: %v", name, err))
+			continue
+		}
+		r.Source = "$" + name
+		routes = append(routes, r)
+	}
+	if len(errs) > 0 {
+		return routes, errs
+	}
+	return routes, nil
+}
+
+// Put implements redirector.RouteStore.Put
+func (s *envStore) Put(context.Context, *redirector.Route) error {
+	return redirector.ErrReadOnly
+}
+
+// Delete implements redirector.RouteStore.Delete
+func (s *envStore) Delete(context.Context, string) error {
+	return redirector.ErrReadOnly
+}
+
+// routeStores returns the stores of the routes from the environment and the routes files, in the order that they're
+// loaded in
+func (rf *routeFlags) routeStores() []redirector.RouteStore {
+	stores := []redirector.RouteStore{new(envStore)}
+	for _, path := range rf.files {
+		stores = append(stores, &fileStore{path: path})
+	}
+	for _, path := range rf.netlifyFiles {
+		stores = append(stores, &fileStore{path: path, netlify: true, netlifyHost: rf.netlifyHost})
+	}
+	return stores
+}
+
+// paths returns the files that routes are loaded from
+func (rf *routeFlags) paths() []string {
+	paths := append(append([]string(nil), rf.files...), rf.netlifyFiles...)
+	if rf.configPath != "" {
+		paths = append(paths, rf.configPath)
+	}
+	return paths
+}
+
+// loadConfig reads the config file, if one is set. The file is only read once, and its route sources are used unless
+// the equivalent flags are set.
+func (rf *routeFlags) loadConfig() (*config, error) {
+	if rf.config != nil || rf.configPath == "" {
+		return rf.config, nil
+	}
+	cfg, err := readConfig(rf.configPath)
+	if err != nil {
+		return nil, err
+	}
+	if k := cfg.Kubernetes; k != nil {
+		rf.kubernetes = true
+		if rf.kubernetesServer == "" {
+			rf.kubernetesServer = k.Server
+		}
+		if rf.kubernetesNamespace == "" {
+			rf.kubernetesNamespace = k.Namespace
+		}
+		rf.kubernetesRedirects = rf.kubernetesRedirects || k.Redirects
+	}
+	if rf.canonicalHost == "" {
+		rf.canonicalHost = cfg.CanonicalHost
+	}
+	rf.flatten = rf.flatten || cfg.Flatten
+	if c := cfg.Consul; c != nil {
+		if rf.consul == "" {
+			rf.consul = c.Address
+		}
+		if rf.consulPrefix == "" {
+			rf.consulPrefix = c.Prefix
+		}
+	}
+	if rf.platform == "" {
+		rf.platform = cfg.Platform
+	}
+	rf.config = cfg
+	return cfg, nil
+}
+
+// stores returns the stores to load routes from: static, which has the routes from the flags and config file,
+// followed by any dynamic sources such as -config-url, kubernetes, and consul
+func (rf *routeFlags) stores(static redirector.RouteSource) ([]redirector.RouteSource, error) {
+	stores := []redirector.RouteSource{static}
+	if rf.configURL != "" {
+		store, err := newRemoteStore(rf.configURL, rf.configURLInterval)
+		if err != nil {
+			return nil, fmt.Errorf("-config-url: %v", err)
+		}
+		stores = append(stores, store)
+	}
+	if rf.kubernetes || rf.kubernetesRedirects {
+		cfg := kubernetes.Config{Server: rf.kubernetesServer}
+		if cfg.Server == "" {
+			var err error
+			if cfg, err = kubernetes.InClusterConfig(); err != nil {
+				return nil, err
+			}
+		}
+		cfg.Namespace = rf.kubernetesNamespace
+		store, err := kubernetes.New(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: %v", err)
+		}
+		stores = append(stores, store)
+		if rf.kubernetesRedirects {
+			store, err := kubernetes.NewRedirects(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("kubernetes: %v", err)
+			}
+			stores = append(stores, store)
+		}
+	}
+	if rf.consul != "" {
+		cfg := consul.EnvConfig()
+		cfg.Address, cfg.Prefix = rf.consul, rf.consulPrefix
+		store, err := consul.New(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("consul: %v", err)
+		}
+		stores = append(stores, store)
+	}
+	if rf.platform != "" {
+		p := metadata.Provider(rf.platform)
+		if p == "auto" {
+			var err error
+			if p, err = metadata.Detect(context.Background()); err != nil {
+				return nil, fmt.Errorf("-platform: %v", err)
+			}
+			fmt.Printf("☁️  loading routes from the %s instance metadata\n", p)
+		}
+		store, err := metadata.New(metadata.Config{Provider: p})
+		if err != nil {
+			return nil, fmt.Errorf("-platform: %v", err)
+		}
+		stores = append(stores, store)
+	}
+	return stores, nil
+}
+
+// dynamic reports whether routes are loaded from any source other than the flags
+func (rf *routeFlags) dynamic() bool {
+	return rf.configURL != "" || rf.kubernetes || rf.kubernetesRedirects || rf.consul != "" || rf.platform != ""
+}
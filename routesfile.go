@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// routeLine is a line in a routes file. Lines that are empty or start with # have no route.
+type routeLine struct {
+	n     int
+	text  string
+	route *redirector.Route
+	err   error
+}
+
+func (l routeLine) isRoute() bool {
+	s := strings.TrimSpace(l.text)
+	return s != "" && !strings.HasPrefix(s, "#")
+}
+
+// fileStore is a read-only redirector.RouteStore of the routes in a routes file, or in a Netlify _redirects file if
+// netlify is set. The file is read again on every List; changes to it are picked up when the routes are reloaded, so
+// it never notifies of changes itself.
+type fileStore struct {
+	redirector.Broadcaster
+	path string
+	// netlify is set for _redirects files, whose rules without a hostname are on netlifyHost
+	netlify     bool
+	netlifyHost string
+}
+
+var _ redirector.RouteStore = new(fileStore)
+
+// List implements redirector.RouteStore.List. Lines with invalid routes are skipped, and returned as routeErrors
+// along with the routes of the other lines.
+func (s *fileStore) List(context.Context) ([]*redirector.Route, error) {
+	scan := scanRoutesFile
+	if s.netlify {
+		scan = func(path string, fn func(routeLine)) error {
+			lines, err := readNetlifyRedirects(path, s.netlifyHost)
+			for _, l := range lines {
+				fn(l)
+			}
+			return err
+		}
+	}
+	var (
+		routes []*redirector.Route
+		errs   routeErrors
+	)
+	err := scan(s.path, func(l routeLine) {
+		if l.route == nil && l.err == nil {
+			return
+		}
+		err := l.err
+		if err == nil {
+			err = l.route.Validate()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %v", s.path, l.n, err))
+			return
+		}
+		l.route.Source = s.path + ":" + strconv.Itoa(l.n)
+		routes = append(routes, l.route)
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return routes, errs
+	}
+	return routes, nil
+}
+
+// Put implements redirector.RouteStore.Put
+func (s *fileStore) Put(context.Context, *redirector.Route) error {
+	return redirector.ErrReadOnly
+}
+
+// Delete implements redirector.RouteStore.Delete
+func (s *fileStore) Delete(context.Context, string) error {
+	return redirector.ErrReadOnly
+}
+
+// routeErrors are the errors of the routes that a store skipped, which it returns along with the rest of its routes
+type routeErrors []error
+
+func (e routeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// listErrors returns the errors of err, which are several if it's routeErrors
+func listErrors(err error) []error {
+	var errs routeErrors
+	if errors.As(err, &errs) {
+		return errs
+	}
+	if err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// readRoutesFile reads a file with one route per line, in the same syntax as the -route flag
+func readRoutesFile(path string) ([]routeLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readRoutes(f)
+}
+
+func readRoutes(r io.Reader) ([]routeLine, error) {
+	var lines []routeLine
+	err := scanRoutes(r, func(l routeLine) { lines = append(lines, l) })
+	return lines, err
+}
+
+// scanRoutesFile is like readRoutesFile, but passes each line to fn as it's read
+func scanRoutesFile(path string, fn func(routeLine)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanRoutes(f, fn)
+}
+
+// scanRoutes parses r line by line, passing each line to fn as it's read so that files with millions of routes aren't
+// held in memory on top of their routes. Routes with identical destinations share one url, since legacy url mappings
+// tend to send many old urls to the same page, and routes are never modified once they're loaded.
+func scanRoutes(r io.Reader, fn func(routeLine)) error {
+	dests := make(map[string]*url.URL)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		l := routeLine{n: n, text: scanner.Text()}
+		if l.isRoute() {
+			l.route, l.err = redirector.NewRoute(l.text)
+			if l.err == nil && l.route.Destination != nil {
+				dest := l.route.Destination
+				key := dest.String()
+				if shared, ok := dests[key]; ok && *shared == *dest {
+					l.route.Destination = shared
+				} else {
+					dests[key] = dest
+				}
+			}
+		}
+		fn(l)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.txt")
+	err := os.WriteFile(path, []byte("# old urls\nold.example.com/a example.com/a\n\nnot a route\nold.example.com/b example.com/a\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &fileStore{path: path}
+	routes, err := store.List(context.Background())
+	if errs := listErrors(err); len(errs) != 1 {
+		t.Errorf("got errors %v, want one for line 4", errs)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("got %d routes, want 2", len(routes))
+	}
+	if want := path + ":5"; routes[1].Source != want {
+		t.Errorf("got source %q, want %q", routes[1].Source, want)
+	}
+	if routes[0].Destination != routes[1].Destination {
+		t.Errorf("routes with the same destination don't share it")
+	}
+	if err := store.Put(context.Background(), routes[0]); !errors.Is(err, redirector.ErrReadOnly) {
+		t.Errorf("got %v from Put, want ErrReadOnly", err)
+	}
+
+	if _, err := (&fileStore{path: filepath.Join(t.TempDir(), "missing.txt")}).List(context.Background()); err == nil {
+		t.Errorf("listing a missing file didn't fail")
+	}
+}
+
+func TestEnvStore(t *testing.T) {
+	t.Setenv("ROUTES", "a.example.com/* example.com\nb.example.com/* example.com")
+	t.Setenv("ROUTE_10", "d.example.com/* example.com")
+	t.Setenv("ROUTE_2", "c.example.com/* example.com")
+	t.Setenv("ROUTE_3", "invalid")
+	routes, err := new(envStore).List(context.Background())
+	if errs := listErrors(err); len(errs) != 1 {
+		t.Errorf("got errors %v, want one for $ROUTE_3", errs)
+	}
+	var sources []string
+	for _, r := range routes {
+		sources = append(sources, r.Source)
+	}
+	want := []string{"$ROUTES line 1", "$ROUTES line 2", "$ROUTE_2", "$ROUTE_10"}
+	if len(sources) != len(want) {
+		t.Fatalf("got routes from %q, want %q", sources, want)
+	}
+	for i := range want {
+		if sources[i] != want[i] {
+			t.Errorf("got routes from %q, want %q", sources, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// selfUpdateCommand is the `self-update` command. It returns the process's exit code.
+func selfUpdateCommand(args []string) int {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	repo := fs.String("repo", "kamaln7/redirector", "the github repository to download releases from.")
+	tag := fs.String("version", "latest", "the release to install.")
+	check := fs.Bool("check", false, "only check whether an update is available.")
+	publicKey := fs.String("public-key", "", "a base64-encoded ed25519 public key. if set, the release's checksums.txt.sig must be a valid signature of checksums.txt.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+⬆️⛳ self-update flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: time.Minute}
+	rel, err := fetchRelease(client, *repo, *tag)
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	current := getBuildInfo().Version
+	if rel.TagName == current {
+		fmt.Printf("✅ redirector %s is up to date\n", current)
+		return 0
+	}
+	fmt.Printf("💡 redirector %s is available (running %s)\n", rel.TagName, current)
+	if *check {
+		return 0
+	}
+
+	if err := selfUpdate(client, rel, *publicKey); err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	fmt.Printf("✅ updated to redirector %s\n", rel.TagName)
+	return 0
+}
+
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *release) asset(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// binaryAsset returns the name and url of the release asset for the current platform
+func (r *release) binaryAsset() (string, string, error) {
+	platform := runtime.GOOS + "_" + runtime.GOARCH
+	for _, a := range r.Assets {
+		name := strings.ToLower(a.Name)
+		if strings.Contains(name, platform) && !strings.HasSuffix(name, ".sig") {
+			return a.Name, a.URL, nil
+		}
+	}
+	return "", "", fmt.Errorf("release %s has no asset for %s", r.TagName, platform)
+}
+
+func fetchRelease(client *http.Client, repo, tag string) (*release, error) {
+	u := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo)
+	if tag != "latest" {
+		u = fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", repo, tag)
+	}
+	body, err := download(client, u)
+	if err != nil {
+		return nil, fmt.Errorf("fetching release: %v", err)
+	}
+	var rel release
+	if err := json.Unmarshal(body, &rel); err != nil {
+		return nil, fmt.Errorf("parsing release: %v", err)
+	}
+	return &rel, nil
+}
+
+func download(client *http.Client, u string) ([]byte, error) {
+	res, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// selfUpdate downloads the release's binary for the current platform, verifies it against the release's checksums,
+// and replaces the running executable with it
+func selfUpdate(client *http.Client, rel *release, publicKey string) error {
+	name, u, err := rel.binaryAsset()
+	if err != nil {
+		return err
+	}
+	checksumsURL, ok := rel.asset("checksums.txt")
+	if !ok {
+		return fmt.Errorf("release %s has no checksums.txt", rel.TagName)
+	}
+	checksums, err := download(client, checksumsURL)
+	if err != nil {
+		return fmt.Errorf("downloading checksums: %v", err)
+	}
+
+	if publicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return errors.New("invalid public key")
+		}
+		sigURL, ok := rel.asset("checksums.txt.sig")
+		if !ok {
+			return fmt.Errorf("release %s has no checksums.txt.sig", rel.TagName)
+		}
+		sig, err := download(client, sigURL)
+		if err != nil {
+			return fmt.Errorf("downloading signature: %v", err)
+		}
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+			sig = decoded
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key), checksums, sig) {
+			return errors.New("checksums.txt has an invalid signature")
+		}
+	}
+
+	want, err := findChecksum(checksums, name)
+	if err != nil {
+		return err
+	}
+	asset, err := download(client, u)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %v", name, err)
+	}
+	sum := sha256.Sum256(asset)
+	if hex.EncodeToString(sum[:]) != want {
+		return fmt.Errorf("checksum mismatch for %s", name)
+	}
+
+	bin := asset
+	if strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") {
+		if bin, err = extractBinary(asset); err != nil {
+			return fmt.Errorf("extracting %s: %v", name, err)
+		}
+	}
+	return replaceExecutable(bin)
+}
+
+// findChecksum finds the sha256 checksum of name in a checksums file in the format written by sha256sum
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt has no checksum for %s", name)
+}
+
+// extractBinary returns the redirector binary from a .tar.gz archive
+func extractBinary(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("archive doesn't contain a redirector binary")
+		}
+		if err != nil {
+			return nil, err
+		}
+		base := filepath.Base(h.Name)
+		if h.Typeflag == tar.TypeReg && (base == "redirector" || base == "redirector.exe") {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// replaceExecutable atomically replaces the running executable with bin
+func replaceExecutable(bin []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".redirector-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), exe)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// setupPage is served to local requests when redirector is started without any routes
+const setupPage = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>🔄 redirector</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 42rem; margin: 4rem auto; padding: 0 1rem; line-height: 1.5; color: #222; }
+code, pre { background: #f3f3f3; border-radius: 4px; }
+code { padding: 0.1rem 0.3rem; }
+pre { padding: 0.75rem 1rem; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>🔄 redirector is running</h1>
+<p>no routes are configured yet, so every request receives a 404 response. this page is only shown to requests from this machine.</p>
+<p>add routes with the <code>-route</code> flag, which can be specified multiple times:</p>
+<pre>redirector -route "www.example.com/* example.com path query code=301"</pre>
+<p>the syntax is <code>&lt;pattern&gt; &lt;destination&gt; [path] [query] [code=&lt;code&gt;]</code>:</p>
+<ul>
+<li><code>&lt;pattern&gt;</code> is <code>{hostname}/{path}</code>, optionally starting with a <code>*</code> label or ending with a <code>*</code> segment.</li>
+<li><code>path</code> and <code>query</code> carry the original request's path and query parameters over to the destination.</li>
+<li><code>code</code> is the redirect's status code, 302 by default.</li>
+</ul>
+<p>run <code>redirector -help</code> for all commands and flags.</p>
+</body>
+</html>
+`
+
+// setupHandler serves setupPage to local requests and a 404 to everyone else
+func setupHandler(w http.ResponseWriter, req *http.Request) {
+	if !isLoopback(req.RemoteAddr) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, setupPage)
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
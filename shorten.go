@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+const (
+	// shortKeyAlphabet has no uppercase letters so that short links survive being typed or read out
+	shortKeyAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	shortKeyLength   = 6
+)
+
+// validShortKey matches the keys that can be picked for short links
+var validShortKey = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// errShortKeyTaken is returned when the key picked for a short link is already used by another route
+var errShortKeyTaken = errors.New("the key is already taken")
+
+// shortener adds short links on host to a store, as routes from host/<key> to their targets
+type shortener struct {
+	host  string
+	store redirector.RouteStore
+	// qr adds the url of the QR code of short links to responses, with -qr
+	qr bool
+	// mu makes checking that a key is free and adding its route atomic
+	mu sync.Mutex
+}
+
+// shortLink is the request and response of the admin api's /shorten
+type shortLink struct {
+	URL      string `json:"url"`
+	Key      string `json:"key,omitempty"`
+	Code     int    `json:"code,omitempty"`
+	ShortURL string `json:"short_url,omitempty"`
+	QRURL    string `json:"qr_url,omitempty"`
+}
+
+// shorten adds a short link to target with key, or a random key if it's empty, redirecting with code
+func (s *shortener) shorten(ctx context.Context, target, key string, code int) (*shortLink, error) {
+	if key != "" && !validShortKey.MatchString(key) {
+		return nil, fmt.Errorf("invalid key %q: must be letters, digits, _, and -", key)
+	}
+	if code == 0 {
+		code = http.StatusFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	routes, err := s.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		taken[r.Pattern] = true
+	}
+	if key == "" {
+		for key == "" || taken[s.host+"/"+key] {
+			if key, err = randomShortKey(); err != nil {
+				return nil, err
+			}
+		}
+	} else if taken[s.host+"/"+key] {
+		return nil, errShortKeyTaken
+	}
+
+	route, err := redirector.To(target).From(s.host + "/" + key).Code(code).Build()
+	if err != nil {
+		return nil, err
+	}
+	route.Source = "shorten"
+	if err := s.store.Put(ctx, route); err != nil {
+		return nil, err
+	}
+	link := &shortLink{URL: route.Destination.String(), Key: key, Code: code, ShortURL: "https://" + s.host + "/" + key}
+	if s.qr {
+		link.QRURL = qrURL(link.ShortURL)
+	}
+	return link, nil
+}
+
+func randomShortKey() (string, error) {
+	b := make([]byte, shortKeyLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(shortKeyAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = shortKeyAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// handler serves POST /shorten in the admin api
+func (s *shortener) handler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		adminError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	var link shortLink
+	dec := json.NewDecoder(io.LimitReader(req.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&link); err != nil {
+		adminError(w, http.StatusBadRequest, fmt.Errorf("parsing request: %v", err))
+		return
+	}
+	if link.URL == "" {
+		adminError(w, http.StatusBadRequest, errors.New("the url must be set"))
+		return
+	}
+	created, err := s.shorten(req.Context(), link.URL, link.Key, link.Code)
+	switch {
+	case errors.Is(err, errShortKeyTaken):
+		adminError(w, http.StatusConflict, err)
+	case err != nil:
+		adminError(w, http.StatusUnprocessableEntity, err)
+	default:
+		adminJSON(w, http.StatusOK, created)
+	}
+}
+
+// shortenCommand is the `shorten` command, which adds a short link through the admin api of a running redirector.
+// It returns the process's exit code.
+func shortenCommand(args []string) int {
+	fs := flag.NewFlagSet("shorten", flag.ExitOnError)
+	admin := fs.String("admin", "http://127.0.0.1:9000", "the url of the admin api of the redirector to add the short link to. $REDIRECTOR_ADMIN_TOKEN is sent as\nits bearer token.")
+	key := fs.String("key", "", "the key of the short link. a random one is picked by default.")
+	code := fs.Int("code", http.StatusFound, "the http status code to redirect with.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+✂️⛳ shorten flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Printf("🚨 usage: redirector shorten [flags] <url>\n")
+		return 1
+	}
+
+	body, _ := json.Marshal(shortLink{URL: fs.Arg(0), Key: *key, Code: *code})
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*admin, "/")+"/shorten", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("REDIRECTOR_ADMIN_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	defer res.Body.Close()
+	var out struct {
+		shortLink
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		fmt.Printf("🚨 admin api: %s: %v\n", res.Status, err)
+		return 1
+	}
+	if res.StatusCode != http.StatusOK {
+		fmt.Printf("❌ %s\n", out.Error)
+		return 1
+	}
+	fmt.Println(out.ShortURL)
+	return 0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// signCommand is the `sign` command, which prints urls signed for routes with auth=hmac:<secret>. It returns the
+// process's exit code.
+func signCommand(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	secret := fs.String("secret", os.Getenv("REDIRECTOR_SIGN_SECRET"), "the secret of the route's auth=hmac:<secret>. defaults to $REDIRECTOR_SIGN_SECRET.")
+	expires := fs.Duration("expires", 0, "how long the signed urls work for. they don't expire by default.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+✍️⛳ sign flags
+
+  redirector sign [flags] <url>...
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *secret == "" {
+		fmt.Printf("🚨 -secret or $REDIRECTOR_SIGN_SECRET must be set\n")
+		return 1
+	}
+	if fs.NArg() == 0 {
+		fmt.Printf("🚨 at least one url to sign must be given\n")
+		return 1
+	}
+
+	var at time.Time
+	if *expires > 0 {
+		at = time.Now().Add(*expires)
+	}
+	for _, arg := range fs.Args() {
+		u, err := url.Parse(arg)
+		if err != nil {
+			fmt.Printf("🚨 parsing %q: %v\n", arg, err)
+			return 1
+		}
+		fmt.Println(redirector.SignURL(u, *secret, at))
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// signalNames are the signals that can be forwarded to a wrapped command, by name
+var signalNames = map[string]os.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"TERM":  syscall.SIGTERM,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// commandSysProcAttr puts wrapped commands in their own process group, so that signals sent to redirector's, e.g. by a
+// supervisor stopping it, only reach them through redirector once it has drained its requests. When redirector's input
+// is a terminal, commands stay in its foreground group instead, so that they can still read from it.
+func commandSysProcAttr() *syscall.SysProcAttr {
+	if _, err := unix.IoctlGetWinsize(int(os.Stdin.Fd()), unix.TIOCGWINSZ); err == nil {
+		return nil
+	}
+	return &syscall.SysProcAttr{Setpgid: true}
+}
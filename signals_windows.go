@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// signalNames are the signals that can be forwarded to a wrapped command, by name. windows only delivers a few of
+// them.
+var signalNames = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// commandSysProcAttr leaves wrapped commands in redirector's console process group, since windows can't signal them
+// otherwise
+func commandSysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
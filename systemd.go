@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// systemdCommand is the `systemd` command. globalArgs are the global flags that redirector was run with, which are
+// passed on to the service. It returns the process's exit code.
+func systemdCommand(globalArgs, args []string) int {
+	if len(args) == 0 || args[0] != "install" {
+		fmt.Printf("🚨 usage: redirector [global flags] systemd install [flags]\n")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("systemd install", flag.ExitOnError)
+	name := fs.String("name", "redirector", "the name of the unit.")
+	dir := fs.String("dir", "/etc/systemd/system", "the directory to write the unit file to.")
+	enable := fs.Bool("enable", false, "reload systemd, then enable and start the unit.")
+	print := fs.Bool("print", false, "print the unit file instead of writing it.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+⚙️⛳ systemd install flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Printf("🚨 finding the redirector binary: %v\n", err)
+		return 1
+	}
+	unit := systemdUnit(append([]string{exe}, globalArgs...), os.Getenv("PORT"))
+	if *print {
+		fmt.Print(unit)
+		return 0
+	}
+
+	path := filepath.Join(*dir, *name+".service")
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	fmt.Printf("✅ wrote %s\n", path)
+	if !*enable {
+		fmt.Printf("💡 run \"systemctl daemon-reload && systemctl enable --now %s\" to start it\n", *name)
+		return 0
+	}
+	for _, cmdArgs := range [][]string{{"daemon-reload"}, {"enable", "--now", *name}} {
+		cmd := exec.Command("systemctl", cmdArgs...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("🚨 systemctl %s: %v\n", strings.Join(cmdArgs, " "), err)
+			return 1
+		}
+	}
+	fmt.Printf("🚀 %s is enabled and running\n", *name)
+	return 0
+}
+
+// systemdUnit returns a hardened unit file that runs cmdLine
+func systemdUnit(cmdLine []string, port string) string {
+	quoted := make([]string, len(cmdLine))
+	for i, arg := range cmdLine {
+		quoted[i] = systemdQuote(arg)
+	}
+	env := ""
+	if port != "" {
+		env = "Environment=" + systemdQuote("PORT="+port) + "\n"
+	}
+
+	return fmt.Sprintf(`[Unit]
+Description=redirector
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+%sRestart=on-failure
+RestartSec=5
+
+# sandboxing
+DynamicUser=yes
+AmbientCapabilities=CAP_NET_BIND_SERVICE
+CapabilityBoundingSet=CAP_NET_BIND_SERVICE
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=yes
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectControlGroups=yes
+RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX
+RestrictNamespaces=yes
+RestrictRealtime=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+
+[Install]
+WantedBy=multi-user.target
+`, strings.Join(quoted, " "), env)
+}
+
+// systemdQuote quotes s for use in a unit file's command line, escaping specifiers and variables
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	s = strings.ReplaceAll(s, "$", "$$")
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\;") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// testCommand is the `test` command. It returns the process's exit code.
+func testCommand(rf *routeFlags, opts []redirector.Option, args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	rf.register(fs)
+	expectations := fs.String("f", "", `read expectations from a file instead of testing urls given as arguments. each line must be
+"<url> <expected destination> <expected code>", with "-" as the destination for responses without a Location header.
+empty lines and lines starting with # are ignored.`)
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+🔎⛳ test flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 && *expectations == "" {
+		fmt.Printf("🚨 at least one url must be set\n")
+		return 1
+	}
+
+	re, ok := newTestRedirector(rf, opts)
+	if !ok {
+		return 1
+	}
+	if *expectations != "" {
+		return checkExpectations(re, *expectations)
+	}
+	for i, rawurl := range fs.Args() {
+		if i > 0 {
+			fmt.Println("")
+		}
+		if err := simulate(re, rawurl); err != nil {
+			fmt.Printf("🚨 %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// newTestRedirector creates a redirector with the configured routes, printing any errors
+func newTestRedirector(rf *routeFlags, opts []redirector.Option) (*redirector.Redirector, bool) {
+	routes, errs := rf.load()
+	for _, err := range errs {
+		fmt.Printf("❌ %v\n", err)
+	}
+	if len(errs) > 0 {
+		return nil, false
+	}
+	re := redirector.New(nil, opts...)
+	if err := re.SetRoutes(routes); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return nil, false
+	}
+	return re, true
+}
+
+// simulate prints what the redirector would do with a GET request for rawurl
+func simulate(re *redirector.Redirector, rawurl string) error {
+	w, req, err := serveTestRequest(re, rawurl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🔎 %s\n", req.URL)
+	if route, ok := re.Match(req); ok {
+		dest, opts := routeSyntax(route)
+		fmt.Printf("   route:    %s → %s\n", route.Pattern, dest)
+		if len(opts) > 0 {
+			fmt.Printf("   options:  %s\n", strings.Join(opts, " "))
+		}
+		if route.Source != "" {
+			fmt.Printf("   source:   %s\n", route.Source)
+		}
+	} else {
+		fmt.Printf("   route:    (no match)\n")
+	}
+	fmt.Printf("   status:   %d %s\n", w.Code, http.StatusText(w.Code))
+	if loc := w.Header().Get("Location"); loc != "" {
+		fmt.Printf("   location: %s\n", loc)
+	}
+	return nil
+}
+
+// serveTestRequest serves a GET request for rawurl without going over the network
+func serveTestRequest(re *redirector.Redirector, rawurl string) (*httptest.ResponseRecorder, *http.Request, error) {
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "https://" + rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %q: %v", rawurl, err)
+	}
+	if u.Host == "" {
+		return nil, nil, fmt.Errorf("%q is missing a hostname", rawurl)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, u.String(), nil)
+	w := httptest.NewRecorder()
+	re.Handler(w, req)
+	return w, req, nil
+}
+
+// routeSyntax returns the destination and options of a route as they would be written in the route syntax
+func routeSyntax(r *redirector.Route) (string, []string) {
+	if r.Upstream != nil {
+		return "proxy " + r.Upstream.String(), nil
+	}
+	s := r.String()
+	if strings.HasPrefix(r.Pattern, "~") {
+		// regular expression patterns are written literally
+		s = strings.TrimPrefix(s, r.Pattern)
+	} else if i := strings.IndexByte(s, ' '); i != -1 {
+		s = s[i:]
+	}
+	parts, err := shellquote.Split(s)
+	if err != nil || len(parts) < 1 {
+		return "", nil
+	}
+	if r.Files != "" && len(parts) > 1 {
+		return parts[0] + " " + parts[1], parts[2:]
+	}
+	if n := len(r.Split) + 1; n > 1 && len(parts) >= n {
+		return strings.Join(parts[:n], " "), parts[n:]
+	}
+	return parts[0], parts[1:]
+}
+
+// checkExpectations tests each expectation in the file at path and prints a pass/fail report. It returns the
+// process's exit code.
+func checkExpectations(re *redirector.Redirector, path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	var passed, failed int
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			fmt.Printf("❌ %s:%d: expected \"<url> <expected destination> <expected code>\"\n", path, n)
+			failed++
+			continue
+		}
+		wantLocation, wantCode := fields[1], fields[2]
+		if wantLocation == "-" {
+			wantLocation = ""
+		}
+		if _, err := strconv.Atoi(wantCode); err != nil {
+			fmt.Printf("❌ %s:%d: invalid code %q\n", path, n, wantCode)
+			failed++
+			continue
+		}
+
+		w, _, err := serveTestRequest(re, fields[0])
+		if err != nil {
+			fmt.Printf("❌ %s:%d: %v\n", path, n, err)
+			failed++
+			continue
+		}
+		gotLocation, gotCode := w.Header().Get("Location"), strconv.Itoa(w.Code)
+		if gotLocation != wantLocation || gotCode != wantCode {
+			fmt.Printf("❌ %s:%d: %s: got %s %s, want %s %s\n", path, n, fields[0], gotCode, orDash(gotLocation), wantCode, orDash(wantLocation))
+			failed++
+			continue
+		}
+		fmt.Printf("✅ %s → %s %s\n", fields[0], gotCode, orDash(gotLocation))
+		passed++
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("🚨 reading %s: %v\n", path, err)
+		return 1
+	}
+
+	fmt.Printf("\n%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// newTLSConfig returns the settings for serving HTTPS: TLS 1.2 or later, with only forward-secret AEAD cipher suites
+// for TLS 1.2. TLS 1.3's suites aren't configurable and are all fine.
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// autoTLSConfig returns the settings for serving HTTPS with certificates that m obtains automatically
+func autoTLSConfig(m *autocert.Manager) *tls.Config {
+	cfg := newTLSConfig()
+	cfg.GetCertificate = m.GetCertificate
+	// serve tls-alpn-01 challenges, so that certificates can be obtained without listening on port 80
+	cfg.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	return cfg
+}
+
+// certificates are the certificates to serve https with, picked by the hostname that each client asks for through sni
+type certificates struct {
+	certs []*tls.Certificate
+	// byName has the certificates by the lowercase names they're valid for, which may be wildcards like *.example.com
+	byName map[string]*tls.Certificate
+}
+
+// loadCertificates loads each of the certFiles with the keyFiles at the same index, followed by every pair in dir if
+// it's set. When several certificates are valid for a name, the first one loaded is used.
+func loadCertificates(certFiles, keyFiles []string, dir string) (*certificates, error) {
+	certFiles, keyFiles = append([]string(nil), certFiles...), append([]string(nil), keyFiles...)
+	if dir != "" {
+		pairs, err := dirCertificates(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(pairs) == 0 {
+			return nil, fmt.Errorf("%s doesn't have any certificates with keys", dir)
+		}
+		for _, pair := range pairs {
+			certFiles, keyFiles = append(certFiles, pair[0]), append(keyFiles, pair[1])
+		}
+	}
+
+	c := &certificates{byName: make(map[string]*tls.Certificate)}
+	for i := range certFiles {
+		cert, err := tls.LoadX509KeyPair(certFiles[i], keyFiles[i])
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %v", certFiles[i], err)
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("loading %s: %v", certFiles[i], err)
+		}
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := c.byName[name]; !ok {
+				c.byName[name] = &cert
+			}
+		}
+		c.certs = append(c.certs, &cert)
+	}
+	return c, nil
+}
+
+// dirCertificates finds the certificate and key pairs in dir: <name>.crt or <name>.pem files with a <name>.key file
+// next to them, sorted by name
+func dirCertificates(dir string) ([][2]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var pairs [][2]string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".crt" && ext != ".pem") {
+			continue
+		}
+		key := filepath.Join(dir, strings.TrimSuffix(e.Name(), ext)+".key")
+		if _, err := os.Stat(key); err != nil {
+			continue
+		}
+		pairs = append(pairs, [2]string{filepath.Join(dir, e.Name()), key})
+	}
+	return pairs, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. It picks the certificate for the exact hostname, then for a
+// wildcard covering it, and falls back to the first certificate for clients that don't send sni or ask for a name
+// that no certificate is valid for.
+func (c *certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := c.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i != -1 {
+		if cert, ok := c.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return c.certs[0], nil
+}
+
+// String describes the certificates, for the dry run
+func (c *certificates) String() string {
+	if len(c.certs) == 1 {
+		return "1 certificate"
+	}
+	return fmt.Sprintf("%d certificates, picked by sni", len(c.certs))
+}
+
+// routeHostPolicy allows certificates for the hostnames of re's routes. Hostnames are checked against the current
+// routes whenever a new certificate is needed, so routes that are added later get certificates too. Wildcard
+// hostnames are skipped since they can't be verified through http or tls-alpn challenges.
+func routeHostPolicy(re *redirector.Redirector) autocert.HostPolicy {
+	return func(_ context.Context, host string) error {
+		for _, r := range re.Routes() {
+			for _, pattern := range r.Patterns() {
+				if i := strings.IndexByte(pattern, '/'); i != -1 && strings.EqualFold(pattern[:i], host) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%q isn't the hostname of any routes", host)
+	}
+}
+
+// defaultAutoTLSCache returns the default directory to cache certificates in
+func defaultAutoTLSCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "redirector-certs"
+	}
+	return filepath.Join(dir, "redirector", "certs")
+}
+
+// httpsRedirectHandler redirects every request to its https equivalent on httpsPort
+func httpsRedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		u := url.URL{Scheme: "https", Host: host, Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+		http.Redirect(w, req, u.String(), http.StatusMovedPermanently)
+	})
+}
+
+// serveHTTPRedirect serves h on port, exiting if it fails
+func serveHTTPRedirect(port string, h http.Handler) {
+	fmt.Printf("↪️  redirecting http on :%s to https\n", port)
+	serve("http-redirect", ":"+port, h)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// traceCommand is the `trace` command. It returns the process's exit code.
+func traceCommand(args []string) int {
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	maxHops := fs.Int("max-hops", 10, "the maximum number of redirects to follow.")
+	method := fs.String("method", http.MethodGet, "the http method to use for each request.")
+	timeout := fs.Duration("timeout", 10*time.Second, "the timeout for each request.")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+🧭⛳ trace flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Printf("🚨 exactly one url must be set\n")
+		return 1
+	}
+
+	rawurl := fs.Arg(0)
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "https://" + rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		fmt.Printf("🚨 parsing %q: %v\n", rawurl, err)
+		return 1
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	seen := make(map[string]bool)
+	for hop := 1; ; hop++ {
+		if seen[u.String()] {
+			fmt.Printf("🔁 redirect loop: %s was already visited\n", u)
+			return 1
+		}
+		seen[u.String()] = true
+
+		req, err := http.NewRequest(*method, u.String(), nil)
+		if err != nil {
+			fmt.Printf("🚨 %v\n", err)
+			return 1
+		}
+		start := time.Now()
+		res, err := client.Do(req)
+		if err != nil {
+			fmt.Printf("%2d. 🚨 %v\n", hop, err)
+			return 1
+		}
+		io.Copy(io.Discard, io.LimitReader(res.Body, 1<<20))
+		res.Body.Close()
+		elapsed := time.Since(start).Round(time.Millisecond)
+
+		loc := res.Header.Get("Location")
+		if res.StatusCode < 300 || res.StatusCode > 399 || loc == "" {
+			fmt.Printf("%2d. %d %s (%s)\n", hop, res.StatusCode, u, elapsed)
+			return 0
+		}
+		next, err := u.Parse(loc)
+		if err != nil {
+			fmt.Printf("%2d. %d %s → 🚨 invalid Location %q: %v\n", hop, res.StatusCode, u, loc, err)
+			return 1
+		}
+		fmt.Printf("%2d. %d %s → %s (%s)\n", hop, res.StatusCode, u, next, elapsed)
+
+		if hop >= *maxHops {
+			fmt.Printf("🚨 stopped after %d redirects\n", *maxHops)
+			return 1
+		}
+		u = next
+	}
+}
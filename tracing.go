@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// tracer records a span per request handled by the redirector and exports them to an OpenTelemetry collector over
+// OTLP/HTTP with JSON encoding. It's configured with the standard OTEL_* environment variables.
+type tracer struct {
+	endpoint string
+	headers  map[string]string
+	resource []otlpAttribute
+	// sampler decides whether to record a new trace with the given id, or one continued from a parent that was or
+	// wasn't sampled
+	sampler func(traceID [16]byte, parent *spanContext) bool
+	spans   chan otlpSpan
+	console bool
+}
+
+// spanContext identifies a span, as propagated in the W3C traceparent header
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+	state   string
+}
+
+const (
+	otlpBatchSize     = 512
+	otlpFlushInterval = 5 * time.Second
+)
+
+// newTracerFromEnv returns a tracer configured by the OTEL_* environment variables, or nil if tracing isn't enabled.
+// Tracing is enabled by setting $OTEL_EXPORTER_OTLP_ENDPOINT, $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or
+// $OTEL_TRACES_EXPORTER to otlp or console.
+func newTracerFromEnv() (*tracer, error) {
+	exporter := os.Getenv("OTEL_TRACES_EXPORTER")
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") || exporter == "none" || (exporter == "" && endpoint == "") {
+		return nil, nil
+	}
+
+	t := &tracer{spans: make(chan otlpSpan, 4*otlpBatchSize)}
+	switch exporter {
+	case "", "otlp":
+		if endpoint == "" {
+			endpoint = "http://localhost:4318/v1/traces"
+		}
+		if p := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"); p != "" && p != "http/json" {
+			return nil, fmt.Errorf("$OTEL_EXPORTER_OTLP_TRACES_PROTOCOL: only http/json is supported, not %q", p)
+		}
+		if p := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); p != "" && p != "http/json" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL") == "" {
+			return nil, fmt.Errorf("$OTEL_EXPORTER_OTLP_PROTOCOL: only http/json is supported, not %q", p)
+		}
+		t.endpoint = endpoint
+	case "console":
+		t.console = true
+	default:
+		return nil, fmt.Errorf("$OTEL_TRACES_EXPORTER: unsupported exporter %q", exporter)
+	}
+
+	t.headers = make(map[string]string)
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		pairs, err := parseOTELList(os.Getenv(name))
+		if err != nil {
+			return nil, fmt.Errorf("$%s: %v", name, err)
+		}
+		for _, p := range pairs {
+			t.headers[p[0]] = p[1]
+		}
+	}
+
+	pairs, err := parseOTELList(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		return nil, fmt.Errorf("$OTEL_RESOURCE_ATTRIBUTES: %v", err)
+	}
+	service := "redirector"
+	for _, p := range pairs {
+		if p[0] == "service.name" {
+			service = p[1]
+			continue
+		}
+		t.resource = append(t.resource, stringAttribute(p[0], p[1]))
+	}
+	if s := os.Getenv("OTEL_SERVICE_NAME"); s != "" {
+		service = s
+	}
+	t.resource = append([]otlpAttribute{stringAttribute("service.name", service), stringAttribute("service.version", getBuildInfo().Version)}, t.resource...)
+
+	if t.sampler, err = otelSampler(os.Getenv("OTEL_TRACES_SAMPLER"), os.Getenv("OTEL_TRACES_SAMPLER_ARG")); err != nil {
+		return nil, err
+	}
+	go t.export()
+	return t, nil
+}
+
+// parseOTELList parses a list of percent-encoded key=value pairs separated by commas, as used by
+// $OTEL_RESOURCE_ATTRIBUTES and $OTEL_EXPORTER_OTLP_HEADERS
+func parseOTELList(s string) ([][2]string, error) {
+	var pairs [][2]string
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		i := strings.IndexByte(item, '=')
+		if i == -1 {
+			return nil, fmt.Errorf("%q is not a key=value pair", item)
+		}
+		key, err := url.PathUnescape(strings.TrimSpace(item[:i]))
+		if err != nil {
+			return nil, err
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(item[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, nil
+}
+
+// otelSampler returns the sampler named by $OTEL_TRACES_SAMPLER, which defaults to parentbased_always_on
+func otelSampler(name, arg string) (func([16]byte, *spanContext) bool, error) {
+	ratio := 1.0
+	if strings.HasSuffix(name, "traceidratio") && arg != "" {
+		var err error
+		if ratio, err = strconv.ParseFloat(arg, 64); err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("$OTEL_TRACES_SAMPLER_ARG: invalid ratio %q", arg)
+		}
+	}
+	var root func([16]byte) bool
+	switch strings.TrimPrefix(name, "parentbased_") {
+	case "", "always_on":
+		root = func([16]byte) bool { return true }
+	case "always_off":
+		root = func([16]byte) bool { return false }
+	case "traceidratio":
+		// the lower 8 bytes of trace ids are random, so comparing them to the ratio samples that share of traces
+		bound := uint64(ratio * (1 << 63))
+		root = func(id [16]byte) bool { return binary.BigEndian.Uint64(id[8:])>>1 < bound }
+	default:
+		return nil, fmt.Errorf("$OTEL_TRACES_SAMPLER: unsupported sampler %q", name)
+	}
+	if name != "" && !strings.HasPrefix(name, "parentbased_") {
+		return func(id [16]byte, _ *spanContext) bool { return root(id) }, nil
+	}
+	return func(id [16]byte, parent *spanContext) bool {
+		if parent != nil {
+			return parent.sampled
+		}
+		return root(id)
+	}, nil
+}
+
+// parseTraceparent parses a W3C traceparent header
+func parseTraceparent(h string) (*spanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil, false
+	}
+	var sc spanContext
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return nil, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return nil, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return nil, false
+	}
+	if sc.traceID == ([16]byte{}) || sc.spanID == ([8]byte{}) {
+		return nil, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return &sc, true
+}
+
+func (sc *spanContext) traceparent() string {
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" + hex.EncodeToString(sc.spanID[:]) + "-" + flags
+}
+
+// middleware records a span for every request that the redirector handles, and passes the trace on to upstreams and
+// the wrapped command in the traceparent header
+func (t *tracer) middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		parent, _ := parseTraceparent(req.Header.Get("traceparent"))
+		var sc spanContext
+		if parent != nil {
+			sc.traceID, sc.state = parent.traceID, req.Header.Get("tracestate")
+		} else {
+			rand.Read(sc.traceID[:])
+		}
+		rand.Read(sc.spanID[:])
+		sc.sampled = t.sampler(sc.traceID, parent)
+
+		// upstreams continue the trace from this span
+		req = req.Clone(req.Context())
+		req.Header.Set("traceparent", sc.traceparent())
+		if sc.state == "" {
+			req.Header.Del("tracestate")
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, req)
+		if !sc.sampled {
+			return
+		}
+
+		span := otlpSpan{
+			TraceID:    hex.EncodeToString(sc.traceID[:]),
+			SpanID:     hex.EncodeToString(sc.spanID[:]),
+			Name:       req.Method,
+			Kind:       2, // server
+			Start:      strconv.FormatInt(start.UnixNano(), 10),
+			End:        strconv.FormatInt(time.Now().UnixNano(), 10),
+			TraceState: sc.state,
+			Attributes: []otlpAttribute{
+				stringAttribute("http.request.method", req.Method),
+				stringAttribute("server.address", req.Host),
+				stringAttribute("url.path", req.URL.Path),
+				stringAttribute("url.scheme", redirector.Scheme(req)),
+				intAttribute("http.response.status_code", rec.status),
+			},
+		}
+		if parent != nil {
+			span.ParentSpanID = hex.EncodeToString(parent.spanID[:])
+		}
+		if ua := req.UserAgent(); ua != "" {
+			span.Attributes = append(span.Attributes, stringAttribute("user_agent.original", ua))
+		}
+		if route := redirector.MatchedRoute(req); route != nil {
+			span.Name += " " + route.Pattern
+			span.Attributes = append(span.Attributes, stringAttribute("http.route", route.Pattern))
+			if route.Source != "" {
+				span.Attributes = append(span.Attributes, stringAttribute("redirector.route.source", route.Source))
+			}
+		}
+		if dest := rec.Header().Get("Location"); dest != "" {
+			span.Attributes = append(span.Attributes, stringAttribute("redirector.destination", dest))
+		}
+		if rec.status >= 500 {
+			span.Status = &otlpStatus{Code: 2} // error
+		}
+		select {
+		case t.spans <- span:
+		default:
+			// the exporter is falling behind, so drop the span rather than slow down requests
+		}
+	})
+}
+
+// export sends spans to the collector in batches
+func (t *tracer) export() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := t.send(batch); err != nil {
+			fmt.Printf("❌ exporting %d span(s): %v\n", len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+func (t *tracer) send(spans []otlpSpan) error {
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: t.resource},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/kamaln7/redirector", Version: getBuildInfo().Version},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	if t.console {
+		fmt.Printf("%s\n", body)
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", t.endpoint, res.Status)
+	}
+	return nil
+}
+
+// the OTLP/JSON encoding of spans, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	TraceState   string          `json:"traceState,omitempty"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes"`
+	Status       *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	// IntValue is a string since it's an int64
+	IntValue string `json:"intValue,omitempty"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func intAttribute(key string, value int) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: strconv.Itoa(value)}}
+}
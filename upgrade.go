@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// listenersEnv passes the listeners of a process to the one replacing it, as name:fd pairs separated by commas
+	listenersEnv = "REDIRECTOR_LISTENERS"
+	// readyEnv is the fd of a pipe that the new process closes once it's serving
+	readyEnv = "REDIRECTOR_UPGRADE_READY"
+	// upgradeTimeout is how long the new process has to start serving before the upgrade is abandoned
+	upgradeTimeout = 30 * time.Second
+)
+
+// listeners are the listeners that redirector serves on, by name, so that they can be handed to the process replacing
+// it on upgrade
+var listeners = struct {
+	sync.Mutex
+	byName map[string]net.Listener
+	names  []string
+}{byName: make(map[string]net.Listener)}
+
+// listen listens on addr, or takes over the listener called name from the process that started this one on upgrade
+func listen(name, network, addr string) (net.Listener, error) {
+	l, err := inheritedListener(name)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		if l, err = net.Listen(network, addr); err != nil {
+			return nil, err
+		}
+	}
+	listeners.Lock()
+	defer listeners.Unlock()
+	if _, ok := listeners.byName[name]; !ok {
+		listeners.names = append(listeners.names, name)
+	}
+	listeners.byName[name] = l
+	return l, nil
+}
+
+// listenOn listens on addr, which is either host:port or unix: followed by the path of a unix socket, with listen.
+// Sockets get mode as their permissions, and any stale one at the path is replaced.
+func listenOn(name, addr string, mode os.FileMode) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return listen(name, "tcp", addr)
+	}
+	if l, err := inheritedListener(name); l != nil || err != nil {
+		// the socket is already set up
+		return l, err
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := listen(name, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serve listens on addr with listen, and serves h in the background, exiting if either fails
+func serve(name, addr string, h http.Handler) {
+	l, err := listen(name, "tcp", addr)
+	if err != nil {
+		fmt.Printf("🚨 %v\n", err)
+		os.Exit(1)
+	}
+	go func() {
+		if err := http.Serve(l, h); err != nil {
+			fmt.Printf("🚨 %v\n", err)
+			os.Exit(1)
+		}
+	}()
+}
+
+// serveAll serves on each of ls with serve, and returns the first error
+func serveAll(ls []net.Listener, serve func(net.Listener) error) error {
+	errs := make(chan error, len(ls))
+	for _, l := range ls {
+		go func(l net.Listener) { errs <- serve(l) }(l)
+	}
+	return <-errs
+}
+
+// inheritedListener returns the listener called name that was passed on by the process that started this one, or
+// nil if there isn't one
+func inheritedListener(name string) (net.Listener, error) {
+	for _, pair := range strings.Split(os.Getenv(listenersEnv), ",") {
+		i := strings.LastIndexByte(pair, ':')
+		if i == -1 || pair[:i] != name {
+			continue
+		}
+		fd, err := strconv.Atoi(pair[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("$%s: invalid fd %q", listenersEnv, pair[i+1:])
+		}
+		f := os.NewFile(uintptr(fd), name)
+		defer f.Close()
+		l, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("inheriting the %s listener: %v", name, err)
+		}
+		return l, nil
+	}
+	return nil, nil
+}
+
+// upgradeReady tells the process that started this one on upgrade that it's serving, so that it can shut down
+func upgradeReady() {
+	fd, err := strconv.Atoi(os.Getenv(readyEnv))
+	if err != nil {
+		return
+	}
+	os.NewFile(uintptr(fd), "ready").Close()
+	os.Unsetenv(readyEnv)
+	os.Unsetenv(listenersEnv)
+}
+
+// upgradeOnSignal starts a new redirector process from the executable on disk, with the same flags, when the upgrade
+// signal is received. The new process takes over the listeners, so no connection is refused, and once it's serving,
+// srv drains its requests like on shutdown and this process exits. If the new process fails to start, this one keeps
+// serving.
+func upgradeOnSignal(srv *http.Server, timeout time.Duration, wc wrapped) {
+	if upgradeSignal == nil {
+		return
+	}
+	chanSig := make(chan os.Signal, 1)
+	signal.Notify(chanSig, upgradeSignal)
+	for range chanSig {
+		if wc != nil {
+			fmt.Printf("⚠️  can't upgrade while wrapping a command, since it would be started twice\n")
+			continue
+		}
+		fmt.Printf("❗ got %s, upgrading...\n", upgradeSignal)
+		if err := upgrade(); err != nil {
+			fmt.Printf("❌ upgrading: %v. still serving\n", err)
+			continue
+		}
+		fmt.Printf("✅ the new process is serving, shutting down...\n")
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Printf("⚠️  requests were still in flight after %s: %v\n", timeout, err)
+		}
+		cancel()
+		os.Exit(0)
+	}
+}
+
+// upgrade starts the new process and waits for it to be serving
+func upgrade() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	listeners.Lock()
+	var (
+		files []*os.File
+		pairs []string
+	)
+	for _, name := range listeners.names {
+		l, ok := listeners.byName[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		if ul, ok := l.(*net.UnixListener); ok {
+			// the new process serves on the socket now, so it must be left behind
+			ul.SetUnlinkOnClose(false)
+		}
+		f, err := l.File()
+		if err != nil {
+			listeners.Unlock()
+			return fmt.Errorf("the %s listener: %v", name, err)
+		}
+		defer f.Close()
+		// ExtraFiles start at fd 3, and the pipe for readiness comes first
+		pairs = append(pairs, name+":"+strconv.Itoa(4+len(files)))
+		files = append(files, f)
+	}
+	listeners.Unlock()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, listenersEnv+"=") && !strings.HasPrefix(env, readyEnv+"=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, listenersEnv+"="+strings.Join(pairs, ","), readyEnv+"=3")
+	cmd.ExtraFiles = append([]*os.File{w}, files...)
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return err
+	}
+	w.Close()
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ready := make(chan struct{})
+	go func() {
+		// the read returns once the new process closes its end of the pipe, by being ready or exiting
+		r.Read(make([]byte, 1))
+		close(ready)
+	}()
+	select {
+	case <-ready:
+		select {
+		case err := <-exited:
+			return fmt.Errorf("the new process exited: %v", err)
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	case <-time.After(upgradeTimeout):
+		cmd.Process.Kill()
+		return errors.New("the new process didn't start serving in time")
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// upgradeSignal starts an upgrade. windows has no SIGUSR2, so upgrades aren't supported.
+var upgradeSignal os.Signal
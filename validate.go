@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// validateCommand is the `validate` command. It returns the process's exit code.
+func validateCommand(rf *routeFlags, args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	rf.register(fs)
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+✅⛳ validate flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	routes, problems := rf.load()
+	problems = append(problems, routeProblems(routes)...)
+
+	for _, shadow := range redirector.FindShadowed(routes) {
+		fmt.Printf("⚠️  %s\n", shadow)
+	}
+	rf.reportFlattened()
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Printf("❌ %v\n", p)
+		}
+		fmt.Printf("\n🚨 found %d problem(s) in %d route(s)\n", len(problems), len(rf.routes))
+		return 1
+	}
+	fmt.Printf("✅ %d route(s) are valid\n", len(routes))
+	return 0
+}
+
+// routeProblems finds the conflicts and redirect loops between routes, which have already been validated one by one
+func routeProblems(routes []*redirector.Route) []error {
+	var problems []error
+	patterns := make(map[string]bool)
+	for _, r := range routes {
+		key := r.Pattern + " " + r.Conditions()
+		if patterns[key] {
+			if c := r.Conditions(); c != "" {
+				problems = append(problems, fmt.Errorf("conflict: pattern %q is used by more than one route with %s", r.Pattern, c))
+			} else {
+				problems = append(problems, fmt.Errorf("conflict: pattern %q is used by more than one route", r.Pattern))
+			}
+		}
+		patterns[key] = true
+	}
+	for _, loop := range redirector.FindLoops(routes) {
+		problems = append(problems, fmt.Errorf("redirect loop: %s", loop))
+	}
+	return problems
+}
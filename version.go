@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// build metadata, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+//
+// anything that isn't set is filled in from the module's build info where possible.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+type buildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+}
+
+func getBuildInfo() buildInfo {
+	bi := buildInfo{Version: version, Commit: commit, Date: date}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if bi.Version == "" && info.Main.Version != "(devel)" {
+			bi.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && bi.Commit == "":
+				bi.Commit = s.Value
+			case s.Key == "vcs.time" && bi.Date == "":
+				bi.Date = s.Value
+			}
+		}
+	}
+	if bi.Version == "" {
+		bi.Version = "dev"
+	}
+	return bi
+}
+
+func (bi buildInfo) String() string {
+	s := bi.Version
+	if bi.Commit != "" {
+		s += " (commit " + bi.Commit
+		if bi.Date != "" {
+			s += ", built " + bi.Date
+		}
+		s += ")"
+	} else if bi.Date != "" {
+		s += " (built " + bi.Date + ")"
+	}
+	return s
+}
+
+// versionCommand is the `version` command
+func versionCommand() int {
+	fmt.Printf("🔄 redirector %s\n", getBuildInfo())
+	fmt.Printf("   %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
+
+// withVersionHeader sets an X-Redirector-Version header on every response
+func withVersionHeader(h http.Handler) http.Handler {
+	v := getBuildInfo().Version
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Redirector-Version", v)
+		h.ServeHTTP(w, req)
+	})
+}
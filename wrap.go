@@ -0,0 +1,642 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// WrapCommand is the `wrap` command
+type WrapCommand struct {
+	args []string
+	// match is the route pattern of the requests for the command, or empty for it to get every request that doesn't
+	// match a route or another command
+	match       string
+	label       string
+	env         []string
+	port        uint
+	socket      string
+	h2c         bool
+	tty         bool
+	healthURL   string
+	wait        time.Duration
+	restart     string
+	maxRestarts int
+	// healthInterval is how often the command is checked once it's ready, or 0 not to, and it's killed after
+	// healthFailures failed checks in a row
+	healthInterval time.Duration
+	healthFailures int
+	// forward is the signals passed on to the command, or nil for all of them, and the command is stopped with
+	// stopSignal instead of the signal that redirector got if it's set, and killed if it's still running after
+	// stopTimeout unless that's 0
+	forward     map[os.Signal]bool
+	stopSignal  os.Signal
+	stopTimeout time.Duration
+	// cache is the cache of the command's responses, or nil for them not to be cached
+	cache *proxyCache
+
+	mu sync.Mutex
+	// cmd is the running command, replaced on every restart, and exited is closed once it exits
+	cmd    *exec.Cmd
+	exited chan struct{}
+	// ready is closed once the running command is ready to serve requests
+	ready chan struct{}
+	// stopping is set once the command has been told to exit, so that it isn't restarted, and stop is closed then
+	stopping bool
+	stop     chan struct{}
+}
+
+func NewWrapCommand(args []string) (*WrapCommand, error) {
+	wc := &WrapCommand{label: "the wrapped command", ready: make(chan struct{}), stop: make(chan struct{})}
+
+	fs := flag.NewFlagSet("wrap", flag.ExitOnError)
+	fs.UintVar(&wc.port, "port", 8000, "the port that the wrapped command will listen on, or 0 to pick a free one")
+	fs.StringVar(&wc.socket, "socket", "", "the path of a unix socket for the wrapped command to listen on instead of a port, passed to it as\n$SOCKET")
+	fs.StringVar(&wc.match, "match", "", "a route pattern, e.g. api.example.com/*, to only forward the requests that match it to the command, when\nwrapping several commands")
+	fs.StringVar(&wc.healthURL, "health-url", "", "a url of the command, e.g. http://localhost:8000/healthz, that must respond with a 2xx status for it to be\nready, instead of waiting for it to accept connections on its port. $PORT is replaced with the command's port.")
+	fs.DurationVar(&wc.wait, "wait", 10*time.Second, "how long requests for the command wait for it to be ready after it starts, before getting a 503 response")
+	fs.DurationVar(&wc.healthInterval, "health-interval", 0, "how often to check the command once it's ready, like before it's ready, e.g. 10s. disabled by default.")
+	fs.IntVar(&wc.healthFailures, "health-failures", 3, "how many checks in a row the command must fail with -health-interval for it to be killed, and\nrestarted following -restart")
+	fs.StringVar(&wc.restart, "restart", "no", "restart the command when it exits: \"no\" to exit with its exit code instead, \"on-failure\" to only restart\nit when it fails, or \"always\". restarts back off exponentially from 1s to 30s.")
+	fs.IntVar(&wc.maxRestarts, "max-restarts", 0, "give up and exit after restarting the command this many times in a row without it staying up for a minute.\n0 never gives up.")
+	forward := fs.String("forward-signals", "all", "the signals to pass on to the command, e.g. USR1,USR2, or all or none. INT and TERM are sent once\nredirector has drained its requests, and HUP reloads routes instead.")
+	stopSignal := fs.String("stop-signal", "", "the signal that stops the command on shutdown, e.g. QUIT, instead of the INT or TERM that redirector got")
+	fs.DurationVar(&wc.stopTimeout, "stop-timeout", 0, "how long the command has to exit once it's been signaled to stop before it's killed. 0 waits for it\nforever.")
+	fs.BoolVar(&wc.tty, "tty", false, "run the command under a pseudo-terminal, so that it keeps its colors and progress output when\nredirector's output isn't a terminal")
+	cacheSize := fs.Int64("cache", 0, "cache up to this many megabytes of the command's responses in memory, e.g. for static assets, if their\nCache-Control or Expires headers allow shared caches to. disabled by default.")
+	cacheTTL := fs.Duration("cache-ttl", 5*time.Minute, "the longest that -cache keeps a response for, even if its headers say it's fresh for longer")
+	fs.BoolVar(&wc.h2c, "h2c", false, "speak http/2 over cleartext to the command, e.g. for grpc servers, instead of http/1.1")
+	fs.Usage = func() {
+		cliUsage()
+		fmt.Printf(`
+🌯⛳ wrap flags
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	cmdLine := fs.Args()
+	if len(cmdLine) > 0 && cmdLine[0] == "--" {
+		cmdLine = cmdLine[1:]
+	}
+	if len(cmdLine) == 0 {
+		return nil, fmt.Errorf("a command must be set")
+	}
+	if wc.port == 0 && wc.socket == "" {
+		port, err := freePort()
+		if err != nil {
+			return nil, fmt.Errorf("picking a port for the command: %v", err)
+		}
+		wc.port = port
+	}
+	wc.healthURL = strings.ReplaceAll(wc.healthURL, "$PORT", fmt.Sprint(wc.port))
+	if wc.restart != "no" && wc.restart != "on-failure" && wc.restart != "always" {
+		return nil, fmt.Errorf("-restart must be no, on-failure, or always")
+	}
+	if *forward != "all" && *forward != "none" {
+		wc.forward = make(map[os.Signal]bool)
+		for _, name := range strings.Split(*forward, ",") {
+			sig, err := parseSignal(name)
+			if err != nil {
+				return nil, fmt.Errorf("-forward-signals: %v", err)
+			}
+			wc.forward[sig] = true
+		}
+	} else if *forward == "none" {
+		wc.forward = make(map[os.Signal]bool)
+	}
+	if *stopSignal != "" {
+		sig, err := parseSignal(*stopSignal)
+		if err != nil {
+			return nil, fmt.Errorf("-stop-signal: %v", err)
+		}
+		wc.stopSignal = sig
+	}
+	if wc.tty && !ttySupported {
+		return nil, fmt.Errorf("-tty is only supported on linux")
+	}
+	if *cacheSize < 0 {
+		return nil, fmt.Errorf("-cache can't be negative")
+	}
+	if *cacheSize > 0 {
+		wc.cache = newProxyCache(*cacheSize<<20, *cacheTTL)
+	}
+	if wc.healthFailures < 1 {
+		return nil, fmt.Errorf("-health-failures must be at least 1")
+	}
+	if wc.match != "" {
+		if err := (&redirector.Route{Pattern: wc.match, Upstream: wc.proxy().Target}).Validate(); err != nil {
+			return nil, fmt.Errorf("-match: %v", err)
+		}
+	}
+
+	wc.args = cmdLine
+	wc.env = make([]string, len(os.Environ())+1)
+	copy(wc.env, os.Environ())
+	if wc.socket != "" {
+		// set a SOCKET={wrapped command socket} env
+		wc.env = append(wc.env, "SOCKET="+wc.socket)
+	} else {
+		// set a PORT={wrapped command port} env
+		wc.env = append(wc.env, fmt.Sprintf("PORT=%d", wc.port))
+	}
+
+	return wc, nil
+}
+
+// freePort returns a port that nothing is listening on, picked by the os
+func freePort() (uint, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// Port returns the configured port
+func (wc *WrapCommand) Port() uint {
+	return wc.port
+}
+
+// addr returns where the command listens: unix: followed by the path of its socket, or localhost:port
+func (wc *WrapCommand) addr() string {
+	if wc.socket != "" {
+		return "unix:" + wc.socket
+	}
+	return fmt.Sprintf("localhost:%d", wc.port)
+}
+
+// dial connects to the command, on its socket if it has one or on addr otherwise
+func (wc *WrapCommand) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	if wc.socket != "" {
+		return d.DialContext(ctx, "unix", wc.socket)
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// proxy returns the upstream that forwards the command's requests to it once it's ready
+func (wc *WrapCommand) proxy() redirector.DefaultProxy {
+	u, _ := url.Parse(fmt.Sprintf("http://localhost:%d", wc.port))
+	p := redirector.DefaultProxy{Pattern: wc.match, Target: u, Middleware: wc.gate}
+	if wc.cache != nil {
+		// cached responses are served even while the command is restarting
+		p.Middleware = func(h http.Handler) http.Handler { return wc.cache.middleware(wc.gate(h)) }
+	}
+	if wc.h2c {
+		p.Transport = h2cTransport(wc.dial)
+	} else if wc.socket != "" {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = wc.dial
+		p.Transport = t
+	}
+	return p
+}
+
+// probe checks whether the command is ready to serve requests, by requesting its health url if it has one, over its
+// socket if it has one, or by connecting to it
+func (wc *WrapCommand) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if wc.healthURL == "" {
+		conn, err := wc.dial(ctx, "tcp", wc.addr())
+		if err != nil {
+			return fmt.Errorf("%s isn't accepting connections on %s", wc.label, wc.addr())
+		}
+		return conn.Close()
+	}
+	client := &http.Client{Timeout: time.Second}
+	if wc.socket != "" {
+		client.Transport = &http.Transport{DialContext: wc.dial}
+	}
+	res, err := client.Get(wc.healthURL)
+	if err != nil {
+		return fmt.Errorf("%s's health check failed: %v", wc.label, err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s's health check responded with %d", wc.label, res.StatusCode)
+	}
+	return nil
+}
+
+// waitReady probes the command until it's ready to serve requests and closes ready, or until it exits. With a health
+// interval, it then keeps probing the command, and kills it once it fails too many checks in a row, sending the reason
+// on unhealthy first.
+func (wc *WrapCommand) waitReady(cmd *exec.Cmd, ready, exited chan struct{}, unhealthy chan<- error) {
+	start := time.Now()
+	for wc.probe() != nil {
+		select {
+		case <-exited:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	fmt.Printf("✅ %s is ready after %s\n", wc.label, time.Since(start).Round(time.Millisecond))
+	close(ready)
+	if wc.healthInterval == 0 {
+		return
+	}
+
+	t := time.NewTicker(wc.healthInterval)
+	defer t.Stop()
+	failures := 0
+	for {
+		select {
+		case <-exited:
+			return
+		case <-t.C:
+		}
+		err := wc.probe()
+		if err == nil {
+			failures = 0
+			continue
+		}
+		if failures++; failures < wc.healthFailures {
+			fmt.Printf("⚠️  %v\n", err)
+			continue
+		}
+		wc.mu.Lock()
+		// hold requests, and fail readiness checks, until the command is restarted and ready again
+		wc.ready = make(chan struct{})
+		wc.mu.Unlock()
+		unhealthy <- fmt.Errorf("%s failed %d health checks in a row: %v", wc.label, failures, err)
+		_ = cmd.Process.Kill()
+		return
+	}
+}
+
+// healthy checks whether the command is ready to serve requests. With a health interval, this is the result of the
+// latest checks, otherwise the command is probed.
+func (wc *WrapCommand) healthy() error {
+	if wc.healthInterval == 0 {
+		return wc.probe()
+	}
+	select {
+	case <-wc.readyChan():
+		return nil
+	default:
+		return fmt.Errorf("%s isn't ready", wc.label)
+	}
+}
+
+// readyChan returns the channel that's closed once the running command is ready
+func (wc *WrapCommand) readyChan() chan struct{} {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return wc.ready
+}
+
+// gate holds the requests that h forwards to the command until it's ready, and answers them with 503 if it isn't after
+// wc.wait instead of failing to forward them
+func (wc *WrapCommand) gate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ready := wc.readyChan()
+		select {
+		case <-ready:
+		default:
+			t := time.NewTimer(wc.wait)
+			defer t.Stop()
+			select {
+			case <-ready:
+			case <-t.C:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "starting up, try again in a moment", http.StatusServiceUnavailable)
+				return
+			case <-req.Context().Done():
+				return
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// Run starts the command and waits for it to exit. Requests for it are held until it's ready.
+func (wc *WrapCommand) Run() error {
+	cmd := exec.Command(wc.args[0], wc.args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = wc.env
+	cmd.SysProcAttr = commandSysProcAttr()
+
+	wc.mu.Lock()
+	if wc.stopping {
+		wc.mu.Unlock()
+		return nil
+	}
+	wc.cmd = cmd
+	ready := wc.ready
+	exited := make(chan struct{})
+	wc.exited = exited
+	if wc.socket != "" {
+		if fi, err := os.Lstat(wc.socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			// a stale socket from the command's last run would keep it from listening
+			os.Remove(wc.socket)
+		}
+	}
+	var (
+		tty    *os.File
+		err    error
+		copied = make(chan struct{})
+	)
+	if wc.tty {
+		if tty, err = startTTY(cmd); err == nil {
+			go func() {
+				io.Copy(os.Stdout, tty)
+				close(copied)
+			}()
+		}
+	} else {
+		err = cmd.Start()
+	}
+	wc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	unhealthy := make(chan error, 1)
+	go wc.waitReady(cmd, ready, exited, unhealthy)
+	err = cmd.Wait()
+	close(exited)
+	if tty != nil {
+		select {
+		case <-copied:
+		case <-time.After(time.Second):
+			// something that the command started is still holding on to the terminal
+		}
+		tty.Close()
+	}
+	select {
+	case err = <-unhealthy:
+	default:
+	}
+	wc.mu.Lock()
+	select {
+	case <-wc.ready:
+		// hold requests until the command is restarted and ready again
+		wc.ready = make(chan struct{})
+	default:
+	}
+	wc.mu.Unlock()
+	return err
+}
+
+// Signal sends sig, or the command's stop signal if it has one, to the command to stop it, so that it isn't restarted
+// once it exits. The command is only signaled the first time, and it's killed if it doesn't exit in time.
+func (wc *WrapCommand) Signal(sig os.Signal) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.stopping {
+		return
+	}
+	wc.stopping = true
+	close(wc.stop)
+	if wc.cmd == nil || wc.cmd.Process == nil {
+		return
+	}
+	if wc.stopSignal != nil {
+		sig = wc.stopSignal
+	}
+	_ = wc.cmd.Process.Signal(sig)
+	if wc.stopTimeout > 0 {
+		go wc.killAfter(wc.cmd, wc.exited, wc.stopTimeout)
+	}
+}
+
+// killAfter kills cmd if it hasn't exited after timeout
+func (wc *WrapCommand) killAfter(cmd *exec.Cmd, exited chan struct{}, timeout time.Duration) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-exited:
+	case <-t.C:
+		fmt.Printf("⚠️  %s is still running %s after being told to stop, killing it\n", wc.label, timeout)
+		_ = cmd.Process.Kill()
+	}
+}
+
+// forwards reports whether sig is passed on to the command
+func (wc *WrapCommand) forwards(sig os.Signal) bool {
+	return wc.forward == nil || wc.forward[sig]
+}
+
+// stopped reports whether the command has been told to exit
+func (wc *WrapCommand) stopped() bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return wc.stopping
+}
+
+// shouldRestart reports whether the command should be restarted after exiting with err, following its restart policy
+func (wc *WrapCommand) shouldRestart(err error, restarts int) bool {
+	if wc.stopped() || wc.restart == "no" || (wc.restart == "on-failure" && err == nil) {
+		return false
+	}
+	return wc.maxRestarts == 0 || restarts < wc.maxRestarts
+}
+
+// supervise runs the command, and restarts it when it exits if its restart policy says so. It returns how the command
+// last exited, or nil if it was stopped while waiting to be restarted.
+func (wc *WrapCommand) supervise() error {
+	const minBackoff, maxBackoff = time.Second, 30 * time.Second
+	backoff := minBackoff
+	for restarts := 0; ; restarts++ {
+		fmt.Printf("🤖 starting %s\n\n", wc.label)
+		start := time.Now()
+		err := wc.Run()
+		fmt.Println("") // add a newline after the command's output
+		if time.Since(start) > time.Minute {
+			// it stayed up, so this is a new run of failures
+			restarts, backoff = 0, minBackoff
+		}
+		if !wc.shouldRestart(err, restarts) {
+			return err
+		}
+		if err != nil {
+			fmt.Printf("❌ %s failed: %v. restarting it in %s...\n", wc.label, err, backoff)
+		} else {
+			fmt.Printf("❗ %s exited. restarting it in %s...\n", wc.label, backoff)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-wc.stop:
+			// redirector is shutting down
+			return nil
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// String returns the wrapped command line
+func (wc *WrapCommand) String() string {
+	return strings.Join(wc.args, " ")
+}
+
+// parseSignal parses the name of a signal, such as TERM or SIGTERM
+func parseSignal(name string) (os.Signal, error) {
+	sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")]
+	if !ok {
+		return nil, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// wrapped is the commands that redirector wraps, each the backend for the requests that don't match any routes and
+// match its -match pattern instead
+type wrapped []*WrapCommand
+
+// wrapSeparator separates the commands in the arguments to wrap, like with find -exec
+const wrapSeparator = ";"
+
+// newWrapped parses the arguments to the wrap command, which are those of each command separated by wrapSeparator
+// and the wrap command again, e.g. `-match api.example.com/* -- ./api ; wrap -- npm run serve`
+func newWrapped(args []string) (wrapped, error) {
+	var w wrapped
+	for {
+		i := 0
+		for i < len(args) && args[i] != wrapSeparator {
+			i++
+		}
+		wc, err := NewWrapCommand(args[:i])
+		if err != nil {
+			return nil, err
+		}
+		w = append(w, wc)
+		if i == len(args) {
+			break
+		}
+		if args = args[i+1:]; len(args) == 0 || args[0] != "wrap" {
+			return nil, fmt.Errorf("%q must be followed by another wrap command", wrapSeparator)
+		}
+		args = args[1:]
+	}
+
+	addrs := make(map[string]bool)
+	fallbacks := 0
+	for _, wc := range w {
+		if addrs[wc.addr()] {
+			return nil, fmt.Errorf("the wrapped commands must listen on different ports and sockets, and %s is taken", wc.addr())
+		}
+		addrs[wc.addr()] = true
+		if wc.match == "" {
+			fallbacks++
+		}
+		if len(w) > 1 {
+			wc.label = fmt.Sprintf("the wrapped command %q", wc)
+		}
+	}
+	if fallbacks > 1 {
+		return nil, errors.New("only one of the wrapped commands can go without -match")
+	}
+	return w, nil
+}
+
+// RedirectorDefaultHandler returns a redirector.WithDefaultProxies option that forwards requests to the wrapped
+// commands once they're ready
+func (w wrapped) RedirectorDefaultHandler() redirector.Option {
+	proxies := make([]redirector.DefaultProxy, len(w))
+	for i, wc := range w {
+		proxies[i] = wc.proxy()
+	}
+	return redirector.WithDefaultProxies(proxies...)
+}
+
+// healthy checks whether all of the commands are ready to serve requests
+func (w wrapped) healthy() error {
+	for _, wc := range w {
+		if err := wc.healthy(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Signal sends sig to each of the commands to stop them
+func (w wrapped) Signal(sig os.Signal) {
+	for _, wc := range w {
+		wc.Signal(sig)
+	}
+}
+
+// forwardSignals passes the signals from chanSig on to the running commands
+func (w wrapped) forwardSignals(chanSig chan os.Signal) {
+	for sig := range chanSig {
+		if sig == syscall.SIGHUP {
+			// redirector reloads its routes on SIGHUP
+			continue
+		}
+		if sig == os.Interrupt || sig == syscall.SIGTERM {
+			// passed on with Signal once redirector has drained its requests
+			continue
+		}
+		for _, wc := range w {
+			if !wc.forwards(sig) {
+				continue
+			}
+			wc.mu.Lock()
+			if wc.cmd != nil && wc.cmd.Process != nil {
+				_ = wc.cmd.Process.Signal(sig)
+			}
+			wc.mu.Unlock()
+		}
+	}
+}
+
+// runWrapped runs the wrapped commands, forwarding signals to them. Once one of them exits for good, the others are
+// stopped, and redirector exits, mirroring the exit code of the first one.
+func runWrapped(w wrapped) {
+	chanSig := make(chan os.Signal, 1)
+	signal.Notify(chanSig)
+	go w.forwardSignals(chanSig)
+
+	type exit struct {
+		wc  *WrapCommand
+		err error
+	}
+	exits := make(chan exit, len(w))
+	for _, wc := range w {
+		go func(wc *WrapCommand) { exits <- exit{wc, wc.supervise()} }(wc)
+	}
+	first := <-exits
+	err := first.err
+	if len(w) > 1 {
+		if !first.wc.stopped() {
+			fmt.Printf("❗ %s exited, stopping the others...\n", first.wc.label)
+		}
+		w.Signal(syscall.SIGTERM)
+		for i := 1; i < len(w); i++ {
+			if e := <-exits; err == nil {
+				err = e.err
+			}
+		}
+	}
+	if err == nil {
+		fmt.Printf("✅ command exited cleanly. Shutting down...\n")
+		os.Exit(0)
+	}
+	fmt.Printf("🚨 %v\n", err)
+	exitCode := 1
+	var exErr *exec.ExitError
+	if errors.As(err, &exErr) {
+		exitCode = exErr.ExitCode() // mirror the command's exit code
+	}
+	os.Exit(exitCode)
+}
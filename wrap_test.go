@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamaln7/redirector/pkg/redirector"
+)
+
+// newTestWrapped returns a server that forwards every request to backend like a wrapped command that's ready, with
+// the access log on if logged is set
+func newTestWrapped(t *testing.T, backend http.Handler, logged bool) *httptest.Server {
+	t.Helper()
+	cmd := httptest.NewServer(backend)
+	t.Cleanup(cmd.Close)
+	u, err := url.Parse(cmd.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a short wait so that a timer left running by the gate would cut the connections off during the tests
+	wc := &WrapCommand{label: "the test command", port: uint(port), wait: 50 * time.Millisecond,
+		ready: make(chan struct{}), stop: make(chan struct{})}
+	close(wc.ready)
+
+	var handler http.Handler = http.HandlerFunc(redirector.New(nil, wrapped{wc}.RedirectorDefaultHandler()).Handler)
+	if logged {
+		handler = (&accessLog{format: "json", w: io.Discard}).handler(handler)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// echoUpgrade switches the connection to the "echo" protocol and writes back every line it reads
+func echoUpgrade(w http.ResponseWriter, req *http.Request) {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "echo") {
+		http.Error(w, "expected an upgrade", http.StatusBadRequest)
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	rw.Flush()
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		rw.WriteString(line)
+		rw.Flush()
+	}
+}
+
+func TestWrapUpgrade(t *testing.T) {
+	for _, logged := range []bool{false, true} {
+		t.Run(fmt.Sprintf("access log %v", logged), func(t *testing.T) {
+			srv := newTestWrapped(t, http.HandlerFunc(echoUpgrade), logged)
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			fmt.Fprintf(conn, "GET /socket HTTP/1.1\r\nHost: app.example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+			r := bufio.NewReader(conn)
+			res, err := http.ReadResponse(r, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("got status %d, want 101", res.StatusCode)
+			}
+
+			for i := 0; i < 3; i++ {
+				// outlast the command's wait between messages
+				time.Sleep(100 * time.Millisecond)
+				msg := fmt.Sprintf("message %d\n", i)
+				if _, err := io.WriteString(conn, msg); err != nil {
+					t.Fatalf("writing after %d messages: %v", i, err)
+				}
+				got, err := r.ReadString('\n')
+				if err != nil {
+					t.Fatalf("reading after %d messages: %v", i, err)
+				}
+				if got != msg {
+					t.Errorf("got %q back, want %q", got, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapEventStream(t *testing.T) {
+	next := make(chan struct{})
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: event %d\n\n", i)
+			w.(http.Flusher).Flush()
+			// the next event is only written once the client has read this one, so the test hangs if it's held back
+			select {
+			case <-next:
+			case <-req.Context().Done():
+				return
+			}
+		}
+	})
+
+	for _, logged := range []bool{false, true} {
+		t.Run(fmt.Sprintf("access log %v", logged), func(t *testing.T) {
+			srv := newTestWrapped(t, backend, logged)
+			client := &http.Client{Timeout: 5 * time.Second}
+			res, err := client.Get(srv.URL + "/events")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("got Content-Type %q, want text/event-stream", ct)
+			}
+
+			r := bufio.NewReader(res.Body)
+			for i := 0; i < 3; i++ {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					t.Fatalf("reading event %d: %v", i, err)
+				}
+				if want := fmt.Sprintf("data: event %d\n", i); line != want {
+					t.Errorf("got %q, want %q", line, want)
+				}
+				r.ReadString('\n')
+				// outlast the command's wait before asking for the next event
+				time.Sleep(100 * time.Millisecond)
+				next <- struct{}{}
+			}
+		})
+	}
+}